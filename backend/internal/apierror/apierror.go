@@ -0,0 +1,43 @@
+package apierror
+
+import "github.com/gin-gonic/gin"
+
+// Códigos estáveis do campo "code" do envelope de erro (documentados no README). Ficam fora de handlers
+// para o middleware responder com o mesmo envelope
+const (
+	ErrCodeUnauthorized           = "unauthorized"
+	ErrCodeInvalidRequest         = "invalid_request"
+	ErrCodeNotFound               = "not_found"
+	ErrCodeDuplicate              = "duplicate"
+	ErrCodeAmbiguousMatch         = "ambiguous_match"
+	ErrCodeSpotifyTokenRequired   = "spotify_token_required"
+	ErrCodeSpotifyTokenInvalid    = "spotify_token_invalid"
+	ErrCodeSpotifyTokenExpired    = "spotify_token_expired"
+	ErrCodeSpotifyAccountMismatch = "spotify_account_mismatch"
+	ErrCodeSpotifyAuthFailed      = "spotify_auth_failed"
+	ErrCodeSpotifyScopeRequired   = "spotify_scope_required"
+	ErrCodeSpotifyError           = "spotify_error"
+	ErrCodeRateLimited            = "rate_limited"
+	ErrCodeImportInProgress       = "import_in_progress"
+	ErrCodeNotTracked             = "not_tracked"
+	ErrCodeAudioFeaturesMissing   = "audio_features_missing"
+	ErrCodeNoData                 = "no_data"
+	ErrCodeServiceUnavailable     = "service_unavailable"
+	ErrCodeQueryTimeout           = "query_timeout"
+	ErrCodeInternal               = "internal_error"
+)
+
+// Envelope de erro: "error" é a mensagem para humanos, "code" é o que o cliente deve tratar
+type ErrorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+func Respond(c *gin.Context, status int, code string, message string) {
+	c.JSON(status, ErrorResponse{Error: message, Code: code})
+}
+
+// Como Respond, mas interrompe a cadeia de handlers (para middlewares)
+func Abort(c *gin.Context, status int, code string, message string) {
+	c.AbortWithStatusJSON(status, ErrorResponse{Error: message, Code: code})
+}
@@ -0,0 +1,15 @@
+package config
+
+import "testing"
+
+func TestPlaylistScopeFollowsPlaylistsFeature(t *testing.T) {
+	cfg := &Config{SpotifyPlaylistScope: "playlist-modify-private", Features: map[string]bool{"playlists": true}}
+	if got := cfg.PlaylistScope(); got != "playlist-modify-private" {
+		t.Errorf("PlaylistScope() = %q with playlists enabled, want playlist-modify-private", got)
+	}
+
+	cfg.Features["playlists"] = false
+	if got := cfg.PlaylistScope(); got != "" {
+		t.Errorf("PlaylistScope() = %q with playlists disabled, want empty", got)
+	}
+}
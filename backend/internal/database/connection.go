@@ -2,22 +2,66 @@ package database
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"log"
+	"net"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"musike-backend/internal/config"
 )
 
+// Teto do intervalo entre tentativas; o backoff dobra a partir de DB_CONNECT_BACKOFF
+const maxConnectBackoff = 30 * time.Second
+
+// Conecta e faz ping, tentando de novo com backoff enquanto o Postgres parece estar subindo (conexão recusada,
+// timeout, "the database system is starting up"), como na corrida de startup do docker-compose. Erros que
+// não melhoram esperando (senha errada, banco inexistente, URL inválida) falham na hora
 func Connect(cfg *config.Config) (*sql.DB, error) {
 	db, err := sql.Open("postgres", cfg.DatabaseURL)
 	if err != nil {
 		return nil, err
 	}
 
-	if err = db.Ping(); err != nil {
-		return nil, err
+	attempts := max(cfg.DBConnectAttempts, 1)
+	backoff := cfg.DBConnectBackoff
+	for attempt := 1; ; attempt++ {
+		err = db.Ping()
+		if err == nil {
+			break
+		}
+
+		if !isStartupError(err) {
+			db.Close()
+			return nil, fmt.Errorf("database unavailable (not retrying): %w", err)
+		}
+		if attempt >= attempts {
+			db.Close()
+			return nil, fmt.Errorf("database not ready after %d attempts: %w", attempts, err)
+		}
+
+		log.Printf("Database not ready (attempt %d/%d): %v; retrying in %v", attempt, attempts, err, backoff)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, maxConnectBackoff)
 	}
 
 	log.Println("Connected to PostgreSQL database")
 	return db, nil
 }
+
+// Erros de um Postgres que ainda está subindo ou ainda não aceita conexões
+func isStartupError(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// 57P03 cannot_connect_now: "the database system is starting up"
+		return pqErr.Code == "57P03"
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr)
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"musike-backend/internal/services"
+)
+
+type AdminHandler struct {
+	recomputeJob *services.StatsRecomputeJob
+}
+
+func NewAdminHandler(recomputeJob *services.StatsRecomputeJob) *AdminHandler {
+	return &AdminHandler{recomputeJob: recomputeJob}
+}
+
+func (h *AdminHandler) StartStatsRecompute(c *gin.Context) {
+	err := h.recomputeJob.Start()
+	if errors.Is(err, services.ErrRecomputeRunning) {
+		respondError(c, http.StatusConflict, ErrCodeDuplicate, "Stats recompute already running")
+		return
+	}
+	if err != nil {
+		log.Printf("Error starting stats recompute: %v", err)
+		respondError(c, http.StatusServiceUnavailable, ErrCodeServiceUnavailable, "Stats recompute unavailable")
+		return
+	}
+
+	c.JSON(http.StatusAccepted, h.recomputeJob.Progress())
+}
+
+func (h *AdminHandler) GetStatsRecomputeProgress(c *gin.Context) {
+	c.JSON(http.StatusOK, h.recomputeJob.Progress())
+}
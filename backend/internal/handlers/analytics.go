@@ -3,37 +3,35 @@ package handlers
 import (
 	"log"
 	"net/http"
-	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"golang.org/x/oauth2"
 	"musike-backend/internal/services"
 )
 
 type AnalyticsHandler struct {
 	analyticsService *services.AnalyticsService
 	spotifyService   *services.SpotifyService
+	tokenStore       *services.SpotifyTokenStore
 }
 
-func NewAnalyticsHandler(analyticsService *services.AnalyticsService, spotifyService *services.SpotifyService) *AnalyticsHandler {
+func NewAnalyticsHandler(analyticsService *services.AnalyticsService, spotifyService *services.SpotifyService, tokenStore *services.SpotifyTokenStore) *AnalyticsHandler {
 	return &AnalyticsHandler{
 		analyticsService: analyticsService,
 		spotifyService:   spotifyService,
+		tokenStore:       tokenStore,
 	}
 }
 
 func (h *AnalyticsHandler) GetUserProfile(c *gin.Context) {
-	spotifyToken := c.GetHeader("Spotify-Token")
-	if spotifyToken == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Spotify token required"})
+	token, ok := h.spotifyToken(c)
+	if !ok {
 		return
 	}
 
-	token := &oauth2.Token{AccessToken: spotifyToken}
-
 	user, err := h.spotifyService.GetUserProfile(token)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user profile"})
+		respondSpotifyError(c, err, "Failed to get user profile")
 		return
 	}
 
@@ -41,25 +39,17 @@ func (h *AnalyticsHandler) GetUserProfile(c *gin.Context) {
 }
 
 func (h *AnalyticsHandler) GetTopTracks(c *gin.Context) {
-	spotifyToken := c.GetHeader("Spotify-Token")
-	if spotifyToken == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Spotify token required"})
+	token, ok := h.spotifyToken(c)
+	if !ok {
 		return
 	}
 
 	timeRange := c.DefaultQuery("time_range", "medium_term")
-	limitStr := c.DefaultQuery("limit", "20")
-
-	limit, err := strconv.Atoi(limitStr)
-	if err != nil {
-		limit = 20
-	}
+	limit := parseLimit(c, 20)
 
-	token := &oauth2.Token{AccessToken: spotifyToken}
-
-	tracks, err := h.spotifyService.GetTopTracks(token, timeRange, limit)
+	tracks, err := h.spotifyService.GetTopTracks(c.Request.Context(), token, c.GetString("userID"), timeRange, limit)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get top tracks"})
+		respondSpotifyError(c, err, "Failed to get top tracks")
 		return
 	}
 
@@ -67,25 +57,17 @@ func (h *AnalyticsHandler) GetTopTracks(c *gin.Context) {
 }
 
 func (h *AnalyticsHandler) GetTopArtists(c *gin.Context) {
-	spotifyToken := c.GetHeader("Spotify-Token")
-	if spotifyToken == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Spotify token required"})
+	token, ok := h.spotifyToken(c)
+	if !ok {
 		return
 	}
 
 	timeRange := c.DefaultQuery("time_range", "medium_term")
-	limitStr := c.DefaultQuery("limit", "20")
+	limit := parseLimit(c, 20)
 
-	limit, err := strconv.Atoi(limitStr)
+	artists, err := h.spotifyService.GetTopArtists(c.Request.Context(), token, c.GetString("userID"), timeRange, limit)
 	if err != nil {
-		limit = 20
-	}
-
-	token := &oauth2.Token{AccessToken: spotifyToken}
-
-	artists, err := h.spotifyService.GetTopArtists(token, timeRange, limit)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get top artists"})
+		respondSpotifyError(c, err, "Failed to get top artists")
 		return
 	}
 
@@ -93,24 +75,16 @@ func (h *AnalyticsHandler) GetTopArtists(c *gin.Context) {
 }
 
 func (h *AnalyticsHandler) GetListeningHistory(c *gin.Context) {
-	spotifyToken := c.GetHeader("Spotify-Token")
-	if spotifyToken == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Spotify token required"})
+	token, ok := h.spotifyToken(c)
+	if !ok {
 		return
 	}
 
-	limitStr := c.DefaultQuery("limit", "50")
+	limit := parseLimit(c, 50)
 
-	limit, err := strconv.Atoi(limitStr)
+	history, err := h.spotifyService.GetRecentlyPlayed(c.Request.Context(), token, limit, 0, 0)
 	if err != nil {
-		limit = 50
-	}
-
-	token := &oauth2.Token{AccessToken: spotifyToken}
-
-	history, err := h.spotifyService.GetRecentlyPlayed(token, limit)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get listening history"})
+		respondSpotifyError(c, err, "Failed to get listening history")
 		return
 	}
 
@@ -120,72 +94,186 @@ func (h *AnalyticsHandler) GetListeningHistory(c *gin.Context) {
 func (h *AnalyticsHandler) GetUserAnalytics(c *gin.Context) {
 	userID, exists := c.Get("userID")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		respondUnauthorized(c)
 		return
 	}
 
-	spotifyToken := c.GetHeader("Spotify-Token")
-	if spotifyToken == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Spotify token required"})
+	// Parâmetros de filtro de tempo
+	timeFilter := parseTimeFilter(c, "6months") // 6months, 1year, alltime
+
+	// Analytics só das sessões marcadas com a tag: calculado do banco, sem Spotify nem cache
+	if rawTag, filtered := c.GetQuery("tag"); filtered {
+		tag, err := services.NormalizeSessionTag(rawTag)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid tag. Use 1-32 letters, digits, spaces, '-' or '_'")
+			return
+		}
+
+		analytics, err := h.analyticsService.GetTaggedAnalytics(c.Request.Context(), userID.(string), tag, timeFilter)
+		if err != nil {
+			log.Printf("Error getting analytics for tag %q for user %s: %v", tag, userID, err)
+			respondQueryError(c, err, "Failed to generate analytics")
+			return
+		}
+		c.JSON(http.StatusOK, analytics)
 		return
 	}
 
-	// Parâmetros de filtro de tempo
-	timeFilter := c.DefaultQuery("time_filter", "6months") // 6months, 1year, alltime
+	// Analytics só das escutas de uma origem: também calculado do banco, sem Spotify nem cache
+	source, ok := parseSource(c)
+	if !ok {
+		return
+	}
+	if source != "" {
+		analytics, err := h.analyticsService.GetSourceAnalytics(c.Request.Context(), userID.(string), source, timeFilter)
+		if err != nil {
+			log.Printf("Error getting analytics for source %s for user %s: %v", source, userID, err)
+			respondQueryError(c, err, "Failed to generate analytics")
+			return
+		}
+		c.JSON(http.StatusOK, analytics)
+		return
+	}
 
-	token := &oauth2.Token{AccessToken: spotifyToken}
+	token, ok := h.spotifyToken(c)
+	if !ok {
+		return
+	}
+
+	// Servir o resultado pré-calculado, a menos que o cliente peça recálculo (?refresh=true)
+	if c.Query("refresh") != "true" {
+		cached, err := h.analyticsService.GetCachedAnalytics(c.Request.Context(), userID.(string), timeFilter)
+		if err != nil {
+			log.Printf("Error reading cached analytics for user %s: %v", userID, err)
+		} else if cached != nil {
+			c.JSON(http.StatusOK, cached)
+			return
+		}
+	}
 
-	analytics, err := h.analyticsService.GenerateUserAnalytics(userID.(string), timeFilter, h.spotifyService, token)
+	analytics, err := h.analyticsService.GenerateUserAnalytics(c.Request.Context(), userID.(string), timeFilter, h.spotifyService, token)
+	if services.IsSpotifyUnauthorized(err) {
+		respondSpotifyError(c, err, "Failed to generate analytics")
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate analytics"})
+		respondQueryError(c, err, "Failed to generate analytics")
 		return
 	}
 
+	if err := h.analyticsService.SaveCachedAnalytics(c.Request.Context(), analytics, timeFilter); err != nil {
+		log.Printf("Error caching analytics for user %s: %v", userID, err)
+	}
+
 	c.JSON(http.StatusOK, analytics)
 }
 
 func (h *AnalyticsHandler) GetRecommendations(c *gin.Context) {
-	spotifyToken := c.GetHeader("Spotify-Token")
-	if spotifyToken == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Spotify token required"})
+	token, ok := h.spotifyToken(c)
+	if !ok {
 		return
 	}
 
 	seedArtists := []string{"4NHQUGzhtTLFvgF5SZesLK"} // Tame Impala
 	seedTracks := []string{"5ghIJDpPoe3CfHMGu71E6T"}  // Blinding Lights
 
-	token := &oauth2.Token{AccessToken: spotifyToken}
-
 	recommendations, err := h.spotifyService.GetRecommendations(token, seedArtists, seedTracks)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get recommendations"})
+		respondSpotifyError(c, err, "Failed to get recommendations")
 		return
 	}
 
+	if userID, exists := c.Get("userID"); exists {
+		if err := h.annotateRecommendations(c, userID.(string), recommendations); err != nil {
+			log.Printf("Error annotating recommendations with history for user %s: %v", userID, err)
+		}
+	}
+
 	c.JSON(http.StatusOK, recommendations)
 }
 
+// Marca cada faixa recomendada com in_history e play_count, para o frontend separar o que é novo de
+// favoritas antigas. As faixas continuam no formato do Spotify, só com esses dois campos a mais
+func (h *AnalyticsHandler) annotateRecommendations(c *gin.Context, userID string, recommendations map[string]interface{}) error {
+	tracks, _ := recommendations["tracks"].([]interface{})
+
+	trackIDs := []string{}
+	for _, item := range tracks {
+		if track, ok := item.(map[string]interface{}); ok {
+			if id, ok := track["id"].(string); ok && id != "" {
+				trackIDs = append(trackIDs, id)
+			}
+		}
+	}
+
+	counts, err := h.analyticsService.GetTrackPlayCounts(c.Request.Context(), userID, trackIDs)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range tracks {
+		track, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		id, _ := track["id"].(string)
+		track["play_count"] = counts[id]
+		track["in_history"] = counts[id] > 0
+	}
+	return nil
+}
+
 func (h *AnalyticsHandler) GetRecentlyPlayed(c *gin.Context) {
-	spotifyToken := c.GetHeader("Spotify-Token")
-	if spotifyToken == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Spotify token required"})
+	token, ok := h.spotifyToken(c)
+	if !ok {
 		return
 	}
 
-	limitStr := c.DefaultQuery("limit", "5")
-	limit, err := strconv.Atoi(limitStr)
-	if err != nil || limit < 1 || limit > 50 {
-		limit = 5
-	}
+	limit := parseLimit(c, 5)
 
-	token := &oauth2.Token{AccessToken: spotifyToken}
+	after, err := parseUnixMillis(c, "after")
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid after parameter. Use a unix timestamp in milliseconds")
+		return
+	}
+	before, err := parseUnixMillis(c, "before")
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid before parameter. Use a unix timestamp in milliseconds")
+		return
+	}
+	if after > 0 && before > 0 {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Use either after or before, not both")
+		return
+	}
+	if after > time.Now().UnixMilli() {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "after cannot be in the future")
+		return
+	}
 
-	recentTracks, err := h.spotifyService.GetRecentlyPlayed(token, limit)
+	recentTracks, err := h.spotifyService.GetRecentlyPlayed(c.Request.Context(), token, limit, after, before)
 	if err != nil {
 		log.Printf("Error getting recently played tracks: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get recently played tracks"})
+		respondSpotifyError(c, err, "Failed to get recently played tracks")
 		return
 	}
 
 	c.JSON(http.StatusOK, recentTracks)
 }
+
+// Totais de todo o histórico a partir dos agregados mantidos a cada escuta (?limit= gêneros)
+func (h *AnalyticsHandler) GetStatsSummary(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	summary, err := h.analyticsService.GetStatsSummary(c.Request.Context(), userID.(string), parseLimit(c, 10))
+	if err != nil {
+		log.Printf("Error getting stats summary for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get stats summary")
+		return
+	}
+
+	c.JSON(http.StatusOK, summary)
+}
@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"musike-backend/internal/services"
+)
+
+func (h *AnalyticsHandler) GetArtistTopTracks(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	artistID := c.Param("id")
+	timeFilter := parseTimeFilter(c, "6months") // 6months, 1year, alltime
+
+	limit := parseLimit(c, 10)
+
+	topTracks, err := h.analyticsService.GetArtistTopTracks(c.Request.Context(), userID.(string), artistID, timeFilter, limit)
+	if err != nil {
+		log.Printf("Error getting top tracks for artist %s: %v", artistID, err)
+		respondQueryError(c, err, "Failed to get artist top tracks")
+		return
+	}
+
+	if topTracks == nil {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Artist not found")
+		return
+	}
+
+	c.JSON(http.StatusOK, topTracks)
+}
+
+func (h *AnalyticsHandler) GetNeglectedFavorites(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	// Janela de "recente" em dias e mínimo de escutas para contar como favorito
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil || days < 1 || days > 3650 {
+		days = 30
+	}
+
+	minPlays, err := strconv.Atoi(c.DefaultQuery("min_plays", "5"))
+	if err != nil || minPlays < 1 {
+		minPlays = 5
+	}
+
+	limit := parseLimit(c, 20)
+
+	neglected, err := h.analyticsService.GetNeglectedFavorites(c.Request.Context(), userID.(string), days, minPlays, limit)
+	if err != nil {
+		log.Printf("Error getting neglected favorites for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get neglected favorites")
+		return
+	}
+
+	c.JSON(http.StatusOK, neglected)
+}
+
+// Artistas dominados por uma faixa (?threshold=80 em %, ?min_plays=5), com a faixa dominante
+func (h *AnalyticsHandler) GetOneHitArtists(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	threshold, err := strconv.Atoi(c.DefaultQuery("threshold", "80"))
+	if err != nil || threshold < 50 || threshold > 100 {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid threshold. Use a percentage between 50 and 100")
+		return
+	}
+
+	minPlays, err := strconv.Atoi(c.DefaultQuery("min_plays", "5"))
+	if err != nil || minPlays < 1 {
+		minPlays = 5
+	}
+
+	timeFilter := parseTimeFilter(c, "alltime") // 6months, 1year, alltime
+
+	report, err := h.analyticsService.GetOneHitArtists(c.Request.Context(), userID.(string), timeFilter, threshold, minPlays, parseLimit(c, 20))
+	if err != nil {
+		log.Printf("Error getting one-hit artists for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get one-hit artists")
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+func (h *AnalyticsHandler) GetEnrichedTopArtists(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	timeFilter := parseTimeFilter(c, "6months") // 6months, 1year, alltime
+	limit := parseLimit(c, 20)
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	artists, total, err := h.analyticsService.GetEnrichedTopArtists(c.Request.Context(), userID.(string), timeFilter, limit, offset)
+	if err != nil {
+		log.Printf("Error getting enriched top artists for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get top artists")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"time_filter": timeFilter,
+		"artists":     artists,
+		"total":       total,
+		"limit":       limit,
+		"offset":      offset,
+	})
+}
+
+func (h *AnalyticsHandler) GetUnenrichedArtists(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	limit := parseLimit(c, 50)
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	artists, total, err := h.analyticsService.GetUnenrichedArtists(c.Request.Context(), userID.(string), limit, offset)
+	if err != nil {
+		log.Printf("Error getting unenriched artists for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get unenriched artists")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"artists": artists,
+		"total":   total,
+		"limit":   limit,
+		"offset":  offset,
+	})
+}
+
+// Corrige os gêneros de um artista só para o usuário; os analytics passam a usar esses gêneros
+func (h *AnalyticsHandler) SetArtistGenres(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	var request struct {
+		Genres *[]string `json:"genres"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	if request.Genres == nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "genres is required (use [] for an artist without genre)")
+		return
+	}
+
+	genres, err := services.NormalizeGenres(*request.Genres)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+
+	artistID := c.Param("id")
+	err = h.analyticsService.SetGenreOverride(c.Request.Context(), userID.(string), artistID, genres)
+	if errors.Is(err, services.ErrArtistNotFound) {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Artist not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error overriding genres of artist %s for user %s: %v", artistID, userID, err)
+		respondQueryError(c, err, "Failed to save artist genres")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"artist_id": artistID,
+		"genres":    genres,
+		"override":  true,
+	})
+}
+
+// Remove a correção e volta aos gêneros do Spotify
+func (h *AnalyticsHandler) DeleteArtistGenres(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	artistID := c.Param("id")
+	err := h.analyticsService.DeleteGenreOverride(c.Request.Context(), userID.(string), artistID)
+	if errors.Is(err, services.ErrArtistNotFound) {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "No genre override for this artist")
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting genre override of artist %s for user %s: %v", artistID, userID, err)
+		respondQueryError(c, err, "Failed to delete artist genres")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"artist_id": artistID,
+		"override":  false,
+	})
+}
@@ -2,15 +2,19 @@ package handlers
 
 import (
 	"context"
+	"crypto/subtle"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
 	"musike-backend/internal/services"
 )
 
@@ -18,22 +22,46 @@ type AuthHandler struct {
 	authService     *services.AuthService
 	spotifyService  *services.SpotifyService
 	trackingService *services.TrackingService
+	tokenStore      *services.SpotifyTokenStore
+	preferences     *services.PreferencesService
+	linkedAccounts  *services.LinkedAccountsService
 	db              *sql.DB
-	processedCodes  map[string]bool
+	processedCodes  map[string]time.Time // código OAuth -> quando foi usado
 	codesMutex      sync.RWMutex
 }
 
-func NewAuthHandler(authService *services.AuthService, spotifyService *services.SpotifyService, db *sql.DB, trackingService *services.TrackingService) *AuthHandler {
+// Códigos de autorização do Spotify valem 10 minutos; depois disso não há duplicata a barrar
+const processedCodeTTL = 10 * time.Minute
+
+func NewAuthHandler(authService *services.AuthService, spotifyService *services.SpotifyService, db *sql.DB, trackingService *services.TrackingService, tokenStore *services.SpotifyTokenStore, preferences *services.PreferencesService, linkedAccounts *services.LinkedAccountsService) *AuthHandler {
 	return &AuthHandler{
 		authService:     authService,
 		spotifyService:  spotifyService,
 		trackingService: trackingService,
+		tokenStore:      tokenStore,
+		preferences:     preferences,
+		linkedAccounts:  linkedAccounts,
 		db:              db,
-		processedCodes:  make(map[string]bool),
+		processedCodes:  make(map[string]time.Time),
 		codesMutex:      sync.RWMutex{},
 	}
 }
 
+// Varredura do janitor: esquece os códigos já expirados. Devolve quantos foram removidos
+func (h *AuthHandler) SweepProcessedCodes(now time.Time) int {
+	h.codesMutex.Lock()
+	defer h.codesMutex.Unlock()
+
+	swept := 0
+	for code, processedAt := range h.processedCodes {
+		if now.Sub(processedAt) >= processedCodeTTL {
+			delete(h.processedCodes, code)
+			swept++
+		}
+	}
+	return swept
+}
+
 func (h *AuthHandler) SpotifyAuth(c *gin.Context) {
 	state := c.Query("state")
 	if state == "" {
@@ -55,12 +83,12 @@ func (h *AuthHandler) SpotifyCallback(c *gin.Context) {
 	error := c.Query("error")
 	state := c.Query("state")
 
-	log.Printf("Spotify callback received - Code: %s, Error: %s, State: %s",
+	log.Printf("Spotify callback received - Code: %t, Error: %s, State: %s",
 		code != "", error, state)
 
 	if code != "" {
 		h.codesMutex.Lock()
-		if h.processedCodes[code] {
+		if _, processed := h.processedCodes[code]; processed {
 			h.codesMutex.Unlock()
 			log.Printf("Code already processed, ignoring duplicate request")
 			c.JSON(http.StatusOK, gin.H{
@@ -69,7 +97,7 @@ func (h *AuthHandler) SpotifyCallback(c *gin.Context) {
 			})
 			return
 		}
-		h.processedCodes[code] = true
+		h.processedCodes[code] = time.Now()
 		h.codesMutex.Unlock()
 	}
 
@@ -77,6 +105,7 @@ func (h *AuthHandler) SpotifyCallback(c *gin.Context) {
 		log.Printf("Spotify auth error: %s", error)
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error":   "Spotify authorization failed: " + error,
+			"code":    ErrCodeSpotifyAuthFailed,
 			"details": "User denied access or authorization failed",
 		})
 		return
@@ -86,17 +115,33 @@ func (h *AuthHandler) SpotifyCallback(c *gin.Context) {
 		log.Printf("No authorization code received from Spotify")
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error":   "Authorization code not provided",
+			"code":    ErrCodeInvalidRequest,
 			"details": "The callback did not include a valid authorization code",
 		})
 		return
 	}
 
+	// Vinculação só vale no navegador que a pediu (cookie de POST /user/spotify-accounts/link); sem ele
+	// o código nem é trocado, para um link repassado não prender a conta de outra pessoa
+	linking := services.IsLinkState(state)
+	if linking {
+		cookieState, _ := c.Cookie(linkStateCookie)
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(linkStateCookie, "", -1, "/", "", c.Request.TLS != nil, true)
+		if subtle.ConstantTimeCompare([]byte(cookieState), []byte(state)) != 1 {
+			log.Printf("Rejected account link callback: state not issued to this browser")
+			respondError(c, http.StatusForbidden, ErrCodeInvalidRequest, "This account link was not started from this browser")
+			return
+		}
+	}
+
 	log.Printf("Exchanging code for token...")
 	token, err := h.authService.ExchangeCode(code)
 	if err != nil {
 		log.Printf("Failed to exchange code for token: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "Failed to exchange code for token",
+			"code":    ErrCodeSpotifyAuthFailed,
 			"details": err.Error(),
 		})
 		return
@@ -108,28 +153,57 @@ func (h *AuthHandler) SpotifyCallback(c *gin.Context) {
 		log.Printf("Failed to get user profile: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "Failed to get user profile",
+			"code":    ErrCodeSpotifyError,
 			"details": err.Error(),
 		})
 		return
 	}
 
+	// Login feito a partir de POST /user/spotify-accounts/link: vincula a conta ao usuário que pediu
+	if linking {
+		linkUserID, ok := h.linkedAccounts.ConsumeLinkState(state)
+		if !ok {
+			respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Account link request expired, start it again")
+			return
+		}
+		h.completeAccountLink(c, linkUserID, user, token)
+		return
+	}
+
+	// Uma conta vinculada já pertence a outro usuário; virar usuário próprio duplicaria as escutas
+	if linked, err := h.linkedAccounts.IsLinked(c.Request.Context(), user.ID); err != nil {
+		log.Printf("Warning: Failed to check linked accounts for %s: %v", user.ID, err)
+	} else if linked {
+		respondError(c, http.StatusConflict, ErrCodeDuplicate, "This Spotify account is linked to another Musike user, log in with the main account")
+		return
+	}
+
 	// Create or get user from database
 	dbUserID, err := h.createOrGetUser(user)
 	if err != nil {
 		log.Printf("Failed to create/get user in database: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "Failed to save user data",
+			"code":    ErrCodeInternal,
 			"details": err.Error(),
 		})
 		return
 	}
 
+	// Guardar o token (com refresh token) para os handlers renovarem sozinhos quando expirar
+	if h.tokenStore != nil {
+		if err := h.tokenStore.Save(c.Request.Context(), dbUserID, token); err != nil {
+			log.Printf("Warning: Failed to store Spotify token for user %s: %v", dbUserID, err)
+		}
+	}
+
 	log.Printf("Generating JWT token for user: %s (DB ID: %s)", user.ID, dbUserID)
 	jwtToken, err := h.authService.GenerateJWT(dbUserID)
 	if err != nil {
 		log.Printf("Failed to generate JWT token: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error":   "Failed to generate JWT token",
+			"code":    ErrCodeInternal,
 			"details": err.Error(),
 		})
 		return
@@ -137,14 +211,15 @@ func (h *AuthHandler) SpotifyCallback(c *gin.Context) {
 
 	log.Printf("Authentication successful for user: %s (%s)", user.DisplayName, user.ID)
 
-	// Auto-start tracking for the user
-	if h.trackingService != nil {
+	// Auto-start tracking for the user, unless they turned it off in their preferences
+	if h.trackingService != nil && h.preferences.AutoStartTracking(c.Request.Context(), dbUserID) {
 		err = h.trackingService.StartTracking(dbUserID, token.AccessToken)
 		if err != nil {
 			log.Printf("Warning: Failed to auto-start tracking for user %s: %v", dbUserID, err)
 		} else {
 			log.Printf("Auto-started tracking for user: %s", dbUserID)
 		}
+		h.linkedAccounts.StartTracking(c.Request.Context(), dbUserID, h.trackingService)
 	}
 
 	frontendURL := "http://localhost:3001/callback"
@@ -159,13 +234,13 @@ func (h *AuthHandler) RefreshToken(c *gin.Context) {
 	}
 
 	if err := c.ShouldBindJSON(&request); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
 		return
 	}
 
 	token, err := h.authService.RefreshSpotifyToken(request.RefreshToken)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+		respondSpotifyError(c, err, "Failed to refresh token")
 		return
 	}
 
@@ -182,37 +257,59 @@ func (h *AuthHandler) createOrGetUser(spotifyUser *services.SpotifyUser) (string
 
 	ctx := context.Background()
 
-	// Try to get existing user first
+	var imageURL string
+	if len(spotifyUser.Images) > 0 {
+		imageURL = spotifyUser.Images[0].URL
+	}
+
+	// Upsert atômico: logins simultâneos do mesmo usuário não criam duplicatas nem falham na UNIQUE
 	var dbUserID string
+	var created bool
 	err := h.db.QueryRowContext(ctx, `
-		SELECT id FROM users WHERE spotify_id = $1
-	`, spotifyUser.ID).Scan(&dbUserID)
-
-	if err == sql.ErrNoRows {
-		// User doesn't exist, create new one
-		followers := spotifyUser.Followers.Total
-
-		var imageURL string
-		if len(spotifyUser.Images) > 0 {
-			imageURL = spotifyUser.Images[0].URL
-		}
-
-		err = h.db.QueryRowContext(ctx, `
-			INSERT INTO users (spotify_id, display_name, email, country, followers_count, profile_image_url) 
-			VALUES ($1, $2, $3, $4, $5, $6) 
-			RETURNING id
-		`, spotifyUser.ID, spotifyUser.DisplayName, spotifyUser.Email, spotifyUser.Country, followers, imageURL).Scan(&dbUserID)
-
-		if err != nil {
-			return "", fmt.Errorf("failed to create user: %v", err)
-		}
+		INSERT INTO users (spotify_id, display_name, email, country, followers_count, profile_image_url)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (spotify_id) DO UPDATE SET
+			display_name = EXCLUDED.display_name,
+			email = EXCLUDED.email,
+			country = EXCLUDED.country,
+			followers_count = EXCLUDED.followers_count,
+			profile_image_url = EXCLUDED.profile_image_url
+		RETURNING id, (xmax = 0)
+	`, spotifyUser.ID, spotifyUser.DisplayName, spotifyUser.Email, spotifyUser.Country, spotifyUser.Followers.Total, imageURL).Scan(&dbUserID, &created)
+	if err != nil {
+		return "", fmt.Errorf("failed to upsert user: %v", err)
+	}
 
+	if created {
 		log.Printf("Created new user in database: %s -> %s", spotifyUser.ID, dbUserID)
-	} else if err != nil {
-		return "", fmt.Errorf("failed to query user: %v", err)
 	} else {
 		log.Printf("Found existing user in database: %s -> %s", spotifyUser.ID, dbUserID)
 	}
 
 	return dbUserID, nil
 }
+
+func (h *AuthHandler) completeAccountLink(c *gin.Context, userID string, user *services.SpotifyUser, token *oauth2.Token) {
+	err := h.linkedAccounts.Link(c.Request.Context(), userID, user, token)
+	if errors.Is(err, services.ErrAccountAlreadyLinked) {
+		respondError(c, http.StatusConflict, ErrCodeDuplicate, "This Spotify account already belongs to a Musike user")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to link spotify account %s to user %s: %v", user.ID, userID, err)
+		respondQueryError(c, err, "Failed to link Spotify account")
+		return
+	}
+
+	log.Printf("Linked spotify account %s to user %s", user.ID, userID)
+
+	if h.trackingService != nil && h.preferences.AutoStartTracking(c.Request.Context(), userID) {
+		tokens := h.linkedAccounts.TokenSource(userID, user.ID, token)
+		if err := h.trackingService.StartLinkedTracking(userID, user.ID, tokens); err != nil {
+			log.Printf("Warning: Failed to start tracking for linked account %s: %v", user.ID, err)
+		}
+	}
+
+	frontendURL := "http://localhost:3001/callback"
+	c.Redirect(http.StatusFound, frontendURL+"?linked_account="+url.QueryEscape(user.ID)+"&linked_to="+url.QueryEscape(userID))
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Callback de vinculação sem o cookie do navegador que a pediu: recusa antes de trocar o código
+func TestSpotifyCallbackRejectsLinkFromAnotherBrowser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	state := "musike-link-0123456789abcdef"
+
+	for name, cookie := range map[string]string{
+		"missing":  "",
+		"mismatch": "musike-link-fedcba9876543210",
+	} {
+		t.Run(name, func(t *testing.T) {
+			// authService nil: se o handler tentasse trocar o código, o teste quebraria
+			h := NewAuthHandler(nil, nil, nil, nil, nil, nil, nil)
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, "/callback?code=abc&state="+state, nil)
+			if cookie != "" {
+				c.Request.AddCookie(&http.Cookie{Name: linkStateCookie, Value: cookie})
+			}
+
+			h.SpotifyCallback(c)
+
+			if w.Code != http.StatusForbidden {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusForbidden)
+			}
+			// o cookie é descartado mesmo na recusa
+			if got := w.Header().Get("Set-Cookie"); got == "" {
+				t.Fatal("expected the link cookie to be cleared")
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *AnalyticsHandler) GetDiversityTimeline(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	loc, err := parseTimezone(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid timezone")
+		return
+	}
+
+	timeFilter := parseTimeFilter(c, "1year") // 6months, 1year, alltime
+
+	timeline, err := h.analyticsService.GetDiversityTimeline(c.Request.Context(), userID.(string), timeFilter, loc)
+	if err != nil {
+		log.Printf("Error getting diversity timeline for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get diversity timeline")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"timeline": timeline,
+	})
+}
+
+func (h *AnalyticsHandler) GetDailyDiversity(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	loc, err := parseTimezone(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid timezone")
+		return
+	}
+
+	timeFilter := parseTimeFilter(c, "6months") // 6months, 1year, alltime
+
+	days, err := h.analyticsService.GetDailyDiversity(c.Request.Context(), userID.(string), timeFilter, loc)
+	if err != nil {
+		log.Printf("Error getting daily diversity for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get daily diversity")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"days":        days,
+		"time_filter": timeFilter,
+		"timezone":    loc.String(),
+	})
+}
+
+func (h *AnalyticsHandler) GetListeningEntropy(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	timeFilter := parseTimeFilter(c, "6months") // 6months, 1year, alltime
+
+	entropy, err := h.analyticsService.GetListeningEntropy(c.Request.Context(), userID.(string), timeFilter)
+	if err != nil {
+		log.Printf("Error getting listening entropy for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get listening entropy")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"artists":     entropy.Artists,
+		"genres":      entropy.Genres,
+		"time_filter": timeFilter,
+	})
+}
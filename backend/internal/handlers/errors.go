@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"musike-backend/internal/apierror"
+	"musike-backend/internal/services"
+)
+
+// Códigos do envelope de erro, definidos em apierror para o middleware usar os mesmos
+const (
+	ErrCodeUnauthorized           = apierror.ErrCodeUnauthorized
+	ErrCodeInvalidRequest         = apierror.ErrCodeInvalidRequest
+	ErrCodeNotFound               = apierror.ErrCodeNotFound
+	ErrCodeDuplicate              = apierror.ErrCodeDuplicate
+	ErrCodeAmbiguousMatch         = apierror.ErrCodeAmbiguousMatch
+	ErrCodeSpotifyTokenRequired   = apierror.ErrCodeSpotifyTokenRequired
+	ErrCodeSpotifyTokenInvalid    = apierror.ErrCodeSpotifyTokenInvalid
+	ErrCodeSpotifyTokenExpired    = apierror.ErrCodeSpotifyTokenExpired
+	ErrCodeSpotifyAccountMismatch = apierror.ErrCodeSpotifyAccountMismatch
+	ErrCodeSpotifyAuthFailed      = apierror.ErrCodeSpotifyAuthFailed
+	ErrCodeSpotifyScopeRequired   = apierror.ErrCodeSpotifyScopeRequired
+	ErrCodeSpotifyError           = apierror.ErrCodeSpotifyError
+	ErrCodeRateLimited            = apierror.ErrCodeRateLimited
+	ErrCodeImportInProgress       = apierror.ErrCodeImportInProgress
+	ErrCodeNotTracked             = apierror.ErrCodeNotTracked
+	ErrCodeAudioFeaturesMissing   = apierror.ErrCodeAudioFeaturesMissing
+	ErrCodeNoData                 = apierror.ErrCodeNoData
+	ErrCodeServiceUnavailable     = apierror.ErrCodeServiceUnavailable
+	ErrCodeQueryTimeout           = apierror.ErrCodeQueryTimeout
+	ErrCodeInternal               = apierror.ErrCodeInternal
+)
+
+type ErrorResponse = apierror.ErrorResponse
+
+func respondError(c *gin.Context, status int, code string, message string) {
+	apierror.Respond(c, status, code, message)
+}
+
+func respondUnauthorized(c *gin.Context) {
+	respondError(c, http.StatusUnauthorized, ErrCodeUnauthorized, "User not authenticated")
+}
+
+// Rota de funcionalidade desligada via FEATURES: responde como se o endpoint não existisse
+func FeatureDisabled(c *gin.Context) {
+	respondError(c, http.StatusNotFound, ErrCodeNotFound, "Endpoint not found")
+}
+
+// 404 quando não há escutas no período pedido. Só para os insights (top5-card, fun-facts), que não têm o que
+// montar sem escutas; os demais analytics respondem 200 com listas vazias e totais zerados
+func respondNoData(c *gin.Context) {
+	respondError(c, http.StatusNotFound, ErrCodeNoData, "No listening history in this period")
+}
+
+func respondSpotifyTokenRequired(c *gin.Context) {
+	respondError(c, http.StatusBadRequest, ErrCodeSpotifyTokenRequired, "Spotify token required")
+}
+
+// 503 quando a consulta ao banco excedeu o timeout, 500 nos demais casos
+func respondQueryError(c *gin.Context, err error, message string) {
+	if services.IsQueryTimeout(err) {
+		respondError(c, http.StatusServiceUnavailable, ErrCodeQueryTimeout, "Database query timed out, please try again")
+		return
+	}
+
+	respondError(c, http.StatusInternalServerError, ErrCodeInternal, message)
+}
+
+// Traduz erros da API do Spotify: 401 vira token expirado (o cliente deve fazer refresh), 429 vira rate limit
+func respondSpotifyError(c *gin.Context, err error, message string) {
+	if services.IsSpotifyUnauthorized(err) {
+		respondError(c, http.StatusUnauthorized, ErrCodeSpotifyTokenExpired, "Spotify token expired, refresh it and retry")
+		return
+	}
+
+	var apiErr *services.SpotifyAPIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusTooManyRequests:
+			respondError(c, http.StatusTooManyRequests, ErrCodeRateLimited, "Spotify rate limit reached, please try again later")
+			return
+		}
+	}
+
+	respondError(c, http.StatusBadGateway, ErrCodeSpotifyError, message)
+}
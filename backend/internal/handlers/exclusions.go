@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"musike-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *AnalyticsHandler) GetExclusions(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	exclusions, err := h.analyticsService.GetExclusions(c.Request.Context(), userID.(string))
+	if err != nil {
+		log.Printf("Error getting exclusions for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get exclusions")
+		return
+	}
+
+	c.JSON(http.StatusOK, exclusions)
+}
+
+// Substitui artistas e gêneros excluídos dos analytics
+func (h *AnalyticsHandler) UpdateExclusions(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	var request services.Exclusions
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+
+	exclusions, err := h.analyticsService.SetExclusions(c.Request.Context(), userID.(string), request)
+	if errors.Is(err, services.ErrInvalidGenres) || errors.Is(err, services.ErrInvalidExclusions) {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Error saving exclusions for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to save exclusions")
+		return
+	}
+
+	c.JSON(http.StatusOK, exclusions)
+}
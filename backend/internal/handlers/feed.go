@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"musike-backend/internal/services"
+)
+
+const icsTimeFormat = "20060102T150405Z"
+
+func (h *AnalyticsHandler) RotateFeedToken(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	token, err := h.analyticsService.RotateFeedToken(c.Request.Context(), userID.(string))
+	if err != nil {
+		log.Printf("Error rotating feed token for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to create feed token")
+		return
+	}
+
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":    token,
+		"feed_url": fmt.Sprintf("%s://%s/api/v1/user/history.ics?token=%s", scheme, c.Request.Host, token),
+		"message":  "Feed token created. Any previous feed URL stops working.",
+	})
+}
+
+// Feed público (autenticado pelo token do feed, não pelo JWT) para assinar em apps de calendário
+func (h *AnalyticsHandler) GetHistoryFeed(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		respondError(c, http.StatusUnauthorized, ErrCodeUnauthorized, "Feed token required")
+		return
+	}
+
+	userID, err := h.analyticsService.GetUserIDByFeedToken(c.Request.Context(), token)
+	if errors.Is(err, services.ErrInvalidFeedToken) {
+		respondError(c, http.StatusUnauthorized, ErrCodeUnauthorized, "Invalid feed token")
+		return
+	}
+	if err != nil {
+		log.Printf("Error validating feed token: %v", err)
+		respondQueryError(c, err, "Failed to load feed")
+		return
+	}
+
+	sessions, err := h.analyticsService.GetFeedSessions(c.Request.Context(), userID)
+	if err != nil {
+		log.Printf("Error getting feed sessions for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to load feed")
+		return
+	}
+
+	c.Data(http.StatusOK, "text/calendar; charset=utf-8", []byte(buildHistoryCalendar(sessions, time.Now())))
+}
+
+// Um evento por sessão: começa na primeira escuta e termina no fim da última. O resumo é a primeira faixa e a
+// descrição lista todas
+func buildHistoryCalendar(sessions []services.FeedSession, now time.Time) string {
+	var b strings.Builder
+	writeICSLine(&b, "BEGIN:VCALENDAR")
+	writeICSLine(&b, "VERSION:2.0")
+	writeICSLine(&b, "PRODID:-//Musike//Listening History//EN")
+	writeICSLine(&b, "CALSCALE:GREGORIAN")
+	writeICSLine(&b, "X-WR-CALNAME:Musike - Histórico de escuta")
+
+	stamp := now.UTC().Format(icsTimeFormat)
+	for _, session := range sessions {
+		if len(session.Plays) == 0 {
+			continue
+		}
+
+		start := session.StartedAt.UTC()
+		end := start
+		tracks := make([]string, 0, len(session.Plays))
+		for _, entry := range session.Plays {
+			// Sem o tempo escutado, usa a duração da faixa
+			durationMs := entry.ListenedDurationMs
+			if durationMs <= 0 {
+				durationMs = entry.DurationMs
+			}
+			if playEnd := entry.PlayedAt.UTC().Add(time.Duration(durationMs) * time.Millisecond); playEnd.After(end) {
+				end = playEnd
+			}
+			tracks = append(tracks, feedTrackTitle(entry))
+		}
+
+		summary := tracks[0]
+		if len(tracks) > 1 {
+			summary += fmt.Sprintf(" (+%d)", len(tracks)-1)
+		}
+
+		writeICSLine(&b, "BEGIN:VEVENT")
+		writeICSLine(&b, "UID:"+session.ID+"@musike")
+		writeICSLine(&b, "DTSTAMP:"+stamp)
+		writeICSLine(&b, "DTSTART:"+start.Format(icsTimeFormat))
+		writeICSLine(&b, "DTEND:"+end.Format(icsTimeFormat))
+		writeICSLine(&b, "SUMMARY:"+escapeICSText(summary))
+		writeICSLine(&b, "DESCRIPTION:"+escapeICSText(strings.Join(tracks, "\n")))
+		writeICSLine(&b, "END:VEVENT")
+	}
+
+	writeICSLine(&b, "END:VCALENDAR")
+	return b.String()
+}
+
+func feedTrackTitle(entry services.HistoryEntry) string {
+	title := entry.TrackName
+	if len(entry.Artists) > 0 {
+		title += " - " + strings.Join(entry.Artists, ", ")
+	}
+	return title
+}
+
+func escapeICSText(text string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+	return replacer.Replace(text)
+}
+
+// RFC 5545: linhas terminam em CRLF e são quebradas a cada 75 octetos, continuando com um espaço
+func writeICSLine(b *strings.Builder, line string) {
+	const maxOctets = 75
+	for len(line) > maxOctets {
+		cut := maxOctets
+		// Não cortar no meio de um caractere UTF-8
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"musike-backend/internal/services"
+)
+
+func TestBuildHistoryCalendarOneEventPerSession(t *testing.T) {
+	start := time.Date(2026, 3, 2, 21, 0, 0, 0, time.UTC)
+	sessions := []services.FeedSession{
+		{
+			ID:        "session-1",
+			StartedAt: start,
+			Plays: []services.HistoryEntry{
+				{TrackName: "Alvorada", Artists: []string{"Cartola"}, PlayedAt: start, ListenedDurationMs: 180000},
+				// sem o tempo escutado, conta a duração da faixa
+				{TrackName: "Preciso Me Encontrar", Artists: []string{"Cartola"}, PlayedAt: start.Add(4 * time.Minute), DurationMs: 150000},
+			},
+		},
+		{
+			ID:        "session-2",
+			StartedAt: start.Add(-24 * time.Hour),
+			Plays: []services.HistoryEntry{
+				{TrackName: "Construção", Artists: []string{"Chico Buarque"}, PlayedAt: start.Add(-24 * time.Hour), ListenedDurationMs: 60000},
+			},
+		},
+	}
+
+	ics := buildHistoryCalendar(sessions, start)
+
+	if got := strings.Count(ics, "BEGIN:VEVENT"); got != 2 {
+		t.Fatalf("VEVENT count = %d, want 2", got)
+	}
+	for _, line := range []string{
+		"UID:session-1@musike",
+		"DTSTART:20260302T210000Z",
+		"DTEND:20260302T210630Z",
+		"SUMMARY:Alvorada - Cartola (+1)",
+		`DESCRIPTION:Alvorada - Cartola\nPreciso Me Encontrar - Cartola`,
+		"UID:session-2@musike",
+		"DTEND:20260301T210100Z",
+		"SUMMARY:Construção - Chico Buarque",
+	} {
+		if !strings.Contains(ics, line+"\r\n") {
+			t.Errorf("calendar is missing line %q:\n%s", line, ics)
+		}
+	}
+}
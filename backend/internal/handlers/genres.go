@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"musike-backend/internal/services"
+)
+
+func (h *AnalyticsHandler) GetAllGenres(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	sort := c.DefaultQuery("sort", "plays") // plays, minutes, name, recent
+	if !services.IsValidGenreSort(sort) {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid sort, expected plays, minutes, name or recent")
+		return
+	}
+
+	timeFilter := parseTimeFilter(c, "alltime") // 6months, 1year, alltime
+	search := c.Query("q")
+
+	genres, err := h.analyticsService.GetAllGenres(c.Request.Context(), userID.(string), timeFilter, search, sort)
+	if err != nil {
+		log.Printf("Error getting genres for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get genres")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"genres": genres,
+		"total":  len(genres),
+	})
+}
+
+// Gêneros que andam juntos nos artistas escutados, como arestas ponderadas (?min_weight=5 escutas, ?limit=50 arestas)
+func (h *AnalyticsHandler) GetGenreNetwork(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	minWeight, err := strconv.Atoi(c.DefaultQuery("min_weight", "5"))
+	if err != nil || minWeight < 1 {
+		minWeight = 5
+	}
+
+	timeFilter := parseTimeFilter(c, "6months") // 6months, 1year, alltime
+
+	network, err := h.analyticsService.GetGenreNetwork(c.Request.Context(), userID.(string), timeFilter, minWeight, parseLimit(c, 50))
+	if err != nil {
+		log.Printf("Error getting genre network for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get genre network")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"nodes":       network.Nodes,
+		"edges":       network.Edges,
+		"min_weight":  minWeight,
+		"time_filter": timeFilter,
+	})
+}
@@ -0,0 +1,434 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"musike-backend/internal/services"
+)
+
+func (h *AnalyticsHandler) GetHistoryByDate(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	dateStr := c.Param("date")
+	date, err := time.Parse("2006-01-02", dateStr)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid date format, expected YYYY-MM-DD")
+		return
+	}
+
+	loc, err := parseTimezone(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid timezone")
+		return
+	}
+
+	source, ok := parseSource(c)
+	if !ok {
+		return
+	}
+
+	history, err := h.analyticsService.GetHistoryForDate(c.Request.Context(), userID.(string), date, loc, source)
+	if err != nil {
+		log.Printf("Error getting history for date %s: %v", dateStr, err)
+		respondQueryError(c, err, "Failed to get listening history for date")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"date":     dateStr,
+		"timezone": loc.String(),
+		"plays":    history,
+		"total":    len(history),
+	})
+}
+
+func (h *AnalyticsHandler) GetCalendar(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	loc, err := parseTimezone(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid timezone")
+		return
+	}
+
+	now := time.Now().In(loc)
+
+	year, err := strconv.Atoi(c.DefaultQuery("year", strconv.Itoa(now.Year())))
+	if err != nil || year < 1900 || year > 9999 {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid year")
+		return
+	}
+
+	month, err := strconv.Atoi(c.DefaultQuery("month", strconv.Itoa(int(now.Month()))))
+	if err != nil || month < 1 || month > 12 {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid month, expected 1-12")
+		return
+	}
+
+	source, ok := parseSource(c)
+	if !ok {
+		return
+	}
+
+	calendar, err := h.analyticsService.GetMonthlyCalendar(c.Request.Context(), userID.(string), year, time.Month(month), loc, source)
+	if err != nil {
+		log.Printf("Error getting calendar for %d-%02d: %v", year, month, err)
+		respondQueryError(c, err, "Failed to get listening calendar")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"year":     year,
+		"month":    month,
+		"timezone": loc.String(),
+		"days":     calendar,
+	})
+}
+
+func (h *AnalyticsHandler) GetHistoryByGenre(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	// Gêneros do Spotify são gravados em minúsculas
+	genre := strings.ToLower(strings.TrimSpace(c.Param("genre")))
+	if genre == "" {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Genre is required")
+		return
+	}
+
+	timeFilter := parseTimeFilter(c, "alltime") // 6months, 1year, alltime
+	limit := parseLimit(c, 50)
+
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	cursor, ok := parseHistoryCursor(c)
+	if !ok {
+		return
+	}
+
+	source, ok := parseSource(c)
+	if !ok {
+		return
+	}
+
+	history, total, nextCursor, err := h.analyticsService.GetHistoryByGenre(c.Request.Context(), userID.(string), genre, source, timeFilter, limit, offset, cursor)
+	if err != nil {
+		log.Printf("Error getting history for genre %s: %v", genre, err)
+		respondQueryError(c, err, "Failed to get listening history for genre")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"genre":       genre,
+		"plays":       history,
+		"total":       total,
+		"limit":       limit,
+		"offset":      offset,
+		"next_cursor": nextCursor,
+	})
+}
+
+func (h *AnalyticsHandler) GetHistory(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	limit := parseLimit(c, 50)
+
+	cursor, ok := parseHistoryCursor(c)
+	if !ok {
+		return
+	}
+
+	source, ok := parseSource(c)
+	if !ok {
+		return
+	}
+
+	history, nextCursor, err := h.analyticsService.GetHistoryPage(c.Request.Context(), userID.(string), source, cursor, limit)
+	if err != nil {
+		log.Printf("Error getting history page for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get listening history")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"plays":       history,
+		"limit":       limit,
+		"next_cursor": nextCursor,
+	})
+}
+
+// Sincronização incremental: mudanças desde ?since= (o sync_token da rodada anterior) ou, na primeira
+// sincronização, escutas gravadas depois de ?ts= (RFC 3339). Enquanto next_cursor vier preenchido, pedir a
+// continuação com ?cursor= e o mesmo since/ts
+func (h *AnalyticsHandler) GetHistorySince(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	limit := 200
+	if raw := c.Query("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid limit")
+			return
+		}
+		limit = min(max(parsed, 1), services.MaxHistoryDeltaLimit)
+	}
+
+	var start services.HistorySyncStart
+	switch {
+	case c.Query("since") != "":
+		token, err := strconv.ParseUint(c.Query("since"), 10, 64)
+		if err != nil || token == 0 {
+			respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid since. Use the sync_token returned by the previous sync")
+			return
+		}
+		start.SyncToken = token
+	case c.Query("ts") != "":
+		ts, err := time.Parse(time.RFC3339Nano, c.Query("ts"))
+		if err != nil {
+			respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid ts, expected RFC 3339")
+			return
+		}
+		start.Since = ts.UTC()
+	default:
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "since (the sync_token of the previous sync) or ts (RFC 3339, first sync) is required")
+		return
+	}
+
+	var cursor *services.HistoryChangeCursor
+	if token := c.Query("cursor"); token != "" {
+		decoded, err := services.DecodeHistoryChangeCursor(token)
+		if err != nil || (start.SyncToken > 0 && decoded.ChangeXID < start.SyncToken) {
+			respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid cursor. Use the next_cursor returned by the previous page")
+			return
+		}
+		cursor = decoded
+	}
+
+	delta, err := h.analyticsService.GetHistorySince(c.Request.Context(), userID.(string), start, cursor, limit)
+	if err != nil {
+		log.Printf("Error getting history changes for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get listening history")
+		return
+	}
+
+	c.JSON(http.StatusOK, delta)
+}
+
+// ?cursor= vindo do next_cursor de uma página anterior; nil quando ausente
+func parseHistoryCursor(c *gin.Context) (*services.HistoryCursor, bool) {
+	token := c.Query("cursor")
+	if token == "" {
+		return nil, true
+	}
+
+	cursor, err := services.DecodeHistoryCursor(token)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid cursor. Use the next_cursor returned by the previous page")
+		return nil, false
+	}
+	return cursor, true
+}
+
+func (h *AnalyticsHandler) DeleteHistoryEntry(c *gin.Context) {
+	h.setHistoryEntryDeleted(c, true)
+}
+
+func (h *AnalyticsHandler) RestoreHistoryEntry(c *gin.Context) {
+	h.setHistoryEntryDeleted(c, false)
+}
+
+func (h *AnalyticsHandler) setHistoryEntryDeleted(c *gin.Context, deleted bool) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	historyID := c.Param("historyID")
+	if !services.IsUUID(historyID) {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid history entry ID")
+		return
+	}
+
+	var err error
+	if deleted {
+		err = h.analyticsService.DeleteHistoryEntry(c.Request.Context(), userID.(string), historyID)
+	} else {
+		err = h.analyticsService.RestoreHistoryEntry(c.Request.Context(), userID.(string), historyID)
+	}
+
+	if errors.Is(err, services.ErrHistoryEntryNotFound) {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "History entry not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating history entry %s for user %s: %v", historyID, userID, err)
+		respondQueryError(c, err, "Failed to update history entry")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":      historyID,
+		"deleted": deleted,
+	})
+}
+
+func (h *AnalyticsHandler) GetOnThisDay(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	loc, err := parseTimezone(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid timezone")
+		return
+	}
+
+	date := time.Now().In(loc)
+	if dateStr := c.Query("date"); dateStr != "" {
+		date, err = time.ParseInLocation("2006-01-02", dateStr, loc)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid date format, expected YYYY-MM-DD")
+			return
+		}
+	}
+
+	years, err := h.analyticsService.GetOnThisDay(c.Request.Context(), userID.(string), date, loc)
+	if err != nil {
+		log.Printf("Error getting on this day for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get on this day listening")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"date":     date.Format("2006-01-02"),
+		"timezone": loc.String(),
+		"years":    years,
+	})
+}
+
+func (h *AnalyticsHandler) GetTimeSeries(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	loc, err := parseTimezone(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid timezone")
+		return
+	}
+
+	granularity := c.DefaultQuery("granularity", "day") // hour, day, week
+
+	to := time.Now().In(loc)
+	if toStr := c.Query("to"); toStr != "" {
+		to, err = time.ParseInLocation("2006-01-02", toStr, loc)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid to date, expected YYYY-MM-DD")
+			return
+		}
+	}
+	from := to.AddDate(0, 0, -29)
+	if fromStr := c.Query("from"); fromStr != "" {
+		from, err = time.ParseInLocation("2006-01-02", fromStr, loc)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid from date, expected YYYY-MM-DD")
+			return
+		}
+	}
+	if from.After(to) {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "from must not be after to")
+		return
+	}
+
+	source, ok := parseSource(c)
+	if !ok {
+		return
+	}
+
+	points, err := h.analyticsService.GetTimeSeries(c.Request.Context(), userID.(string), granularity, from, to, loc, source)
+	if errors.Is(err, services.ErrInvalidGranularity) {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid granularity. Use hour, day or week")
+		return
+	}
+	if errors.Is(err, services.ErrTimeSeriesTooLarge) {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Date range too large for this granularity, narrow it or use a coarser granularity")
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting time series for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get time series")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"granularity": granularity,
+		"timezone":    loc.String(),
+		"from":        from.Format("2006-01-02"),
+		"to":          to.Format("2006-01-02"),
+		"points":      points,
+	})
+}
+
+// Maiores intervalos sem escuta (?limit=, padrão 10), incluindo o intervalo em aberto até agora
+func (h *AnalyticsHandler) GetListeningGaps(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	loc, err := parseTimezone(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid timezone")
+		return
+	}
+
+	timeFilter := parseTimeFilter(c, "alltime") // 6months, 1year, alltime
+	limit := parseLimit(c, 10)
+
+	gaps, err := h.analyticsService.GetListeningGaps(c.Request.Context(), userID.(string), timeFilter, loc, limit)
+	if err != nil {
+		log.Printf("Error getting listening gaps for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get listening gaps")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"gaps":        gaps,
+		"time_filter": timeFilter,
+		"timezone":    loc.String(),
+	})
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IDs que não são UUID são recusados antes da consulta, em vez de virarem um 404 ou um erro do cast
+func TestHistoryEntryRejectsMalformedID(t *testing.T) {
+	h := NewAnalyticsHandler(nil, nil, nil)
+
+	for _, id := range []string{"123", "not-a-uuid", "6f1c0e2a-0000-0000-0000-00000000000g"} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodDelete, "/user/history/"+id, nil)
+		c.Params = gin.Params{{Key: "historyID", Value: id}}
+		c.Set("userID", "user-1")
+
+		h.DeleteHistoryEntry(c)
+
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), ErrCodeInvalidRequest) {
+			t.Errorf("id %q: response = %d %s, want 400 %s", id, w.Code, w.Body.String(), ErrCodeInvalidRequest)
+		}
+	}
+}
+
+func TestNowPlayingGroupRejectsMalformedUserIDs(t *testing.T) {
+	h := NewTrackingHandler(nil, nil, nil, nil, nil)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/tracking/now-playing/group?user_ids=6f1c0e2a-1b2c-4d3e-8f90-123456789abc,bob", nil)
+	c.Set("userID", "6f1c0e2a-1b2c-4d3e-8f90-123456789abc")
+
+	h.GetNowPlayingGroup(c)
+
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), ErrCodeInvalidRequest) {
+		t.Errorf("response = %d %s, want 400 %s", w.Code, w.Body.String(), ErrCodeInvalidRequest)
+	}
+}
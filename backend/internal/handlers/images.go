@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"container/list"
+	"database/sql"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"musike-backend/internal/config"
+)
+
+const placeholderImageSVG = `<svg xmlns="http://www.w3.org/2000/svg" width="300" height="300" viewBox="0 0 300 300">` +
+	`<rect width="300" height="300" fill="#282828"/>` +
+	`<circle cx="150" cy="150" r="60" fill="none" stroke="#535353" stroke-width="12"/>` +
+	`<circle cx="150" cy="150" r="12" fill="#535353"/></svg>`
+
+const (
+	imageCacheEntries = 512     // imagens guardadas em memória no modo proxy
+	maxProxiedImage   = 2 << 20 // imagens maiores que isso não passam pelo proxy
+)
+
+type ImageHandler struct {
+	db     *sql.DB
+	config *config.Config
+	client *http.Client
+	cache  *imageCache
+}
+
+func NewImageHandler(db *sql.DB, cfg *config.Config) *ImageHandler {
+	return &ImageHandler{
+		db:     db,
+		config: cfg,
+		client: &http.Client{
+			Timeout: 15 * time.Second,
+			// Um redirect levaria o proxy para fora do CDN liberado
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		cache: newImageCache(imageCacheEntries),
+	}
+}
+
+// Só imagens do CDN do Spotify (i.scdn.co, mosaic.scdn.co, ...) são servidas; qualquer outra URL gravada no
+// banco vira placeholder, para o proxy não buscar endereços arbitrários (SSRF)
+func allowedImageURL(raw string) bool {
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Scheme != "https" || parsed.User != nil || parsed.Port() != "" {
+		return false
+	}
+	host := strings.ToLower(parsed.Hostname())
+	return host == "scdn.co" || strings.HasSuffix(host, ".scdn.co")
+}
+
+func (h *ImageHandler) GetArtistImage(c *gin.Context) {
+	h.serveImage(c, `SELECT COALESCE(image_url, '') FROM artists WHERE id = $1`)
+}
+
+func (h *ImageHandler) GetAlbumImage(c *gin.Context) {
+	h.serveImage(c, `SELECT COALESCE(image_url, '') FROM albums WHERE id = $1`)
+}
+
+// Imagem para itens sem entidade com imagem (ex.: faixa sem álbum)
+func (h *ImageHandler) GetPlaceholderImage(c *gin.Context) {
+	h.servePlaceholder(c)
+}
+
+func (h *ImageHandler) serveImage(c *gin.Context, query string) {
+	if h.db == nil {
+		respondError(c, http.StatusServiceUnavailable, ErrCodeServiceUnavailable, "Database not available")
+		return
+	}
+
+	var imageURL string
+	err := h.db.QueryRowContext(c.Request.Context(), query, c.Param("id")).Scan(&imageURL)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error loading image URL for %s: %v", c.Param("id"), err)
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to load image")
+		return
+	}
+
+	// Entidades importadas costumam não ter imagem: servir o placeholder
+	if imageURL == "" || !allowedImageURL(imageURL) {
+		h.servePlaceholder(c)
+		return
+	}
+
+	// As URLs do CDN apontam para um arquivo que não muda; o navegador pode guardar por um dia
+	c.Header("Cache-Control", "public, max-age=86400")
+
+	if h.config.ImageProxyMode != "proxy" {
+		c.Redirect(http.StatusFound, imageURL)
+		return
+	}
+
+	image, ok := h.cache.get(imageURL)
+	if !ok {
+		var err error
+		image, err = h.fetchImage(imageURL)
+		if err != nil {
+			log.Printf("Error proxying image %s: %v", imageURL, err)
+			c.Header("Cache-Control", "public, max-age=3600")
+			h.servePlaceholder(c)
+			return
+		}
+		h.cache.add(imageURL, image)
+	}
+
+	c.Data(http.StatusOK, image.contentType, image.body)
+}
+
+var errImageTooLarge = errors.New("image too large to proxy")
+
+func (h *ImageHandler) fetchImage(imageURL string) (cachedImage, error) {
+	resp, err := h.client.Get(imageURL)
+	if err != nil {
+		return cachedImage{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return cachedImage{}, errors.New("image CDN returned " + resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxProxiedImage+1))
+	if err != nil {
+		return cachedImage{}, err
+	}
+	if len(body) > maxProxiedImage {
+		return cachedImage{}, errImageTooLarge
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, "image/") {
+		contentType = http.DetectContentType(body)
+	}
+	return cachedImage{contentType: contentType, body: body}, nil
+}
+
+type cachedImage struct {
+	contentType string
+	body        []byte
+}
+
+type imageCacheEntry struct {
+	url   string
+	image cachedImage
+}
+
+// LRU das imagens buscadas no modo proxy, pela URL do CDN
+type imageCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // mais recente na frente
+	entries  map[string]*list.Element
+}
+
+func newImageCache(capacity int) *imageCache {
+	return &imageCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element, capacity),
+	}
+}
+
+func (c *imageCache) get(url string) (cachedImage, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[url]
+	if !ok {
+		return cachedImage{}, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*imageCacheEntry).image, true
+}
+
+func (c *imageCache) add(url string, image cachedImage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[url]; ok {
+		element.Value.(*imageCacheEntry).image = image
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[url] = c.order.PushFront(&imageCacheEntry{url: url, image: image})
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*imageCacheEntry).url)
+	}
+}
+
+func (h *ImageHandler) servePlaceholder(c *gin.Context) {
+	if h.config.ImagePlaceholderURL != "" {
+		c.Redirect(http.StatusFound, h.config.ImagePlaceholderURL)
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.Data(http.StatusOK, "image/svg+xml", []byte(placeholderImageSVG))
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"musike-backend/internal/config"
+)
+
+func TestAllowedImageURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://i.scdn.co/image/ab67616d0000b273", true},
+		{"https://mosaic.scdn.co/640/ab67616d0000b273", true},
+		{"https://I.SCDN.CO/image/ab67616d0000b273", true},
+		{"http://i.scdn.co/image/ab67616d0000b273", false},
+		{"https://i.scdn.co:8443/image/ab67616d0000b273", false},
+		{"https://user@i.scdn.co/image/ab67616d0000b273", false},
+		{"https://i.scdn.co.evil.example/image", false},
+		{"https://evilscdn.co/image", false},
+		{"https://169.254.169.254/latest/meta-data", false},
+		{"https://localhost/image", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := allowedImageURL(tt.url); got != tt.want {
+			t.Errorf("allowedImageURL(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestImageCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newImageCache(2)
+	cache.add("a", cachedImage{contentType: "image/jpeg", body: []byte("a")})
+	cache.add("b", cachedImage{contentType: "image/jpeg", body: []byte("b")})
+
+	// "a" passa a ser o mais recente, então "b" sai quando "c" entra
+	if _, ok := cache.get("a"); !ok {
+		t.Fatal("a missing before eviction")
+	}
+	cache.add("c", cachedImage{contentType: "image/jpeg", body: []byte("c")})
+
+	if _, ok := cache.get("b"); ok {
+		t.Error("b still cached, want it evicted")
+	}
+	for _, url := range []string{"a", "c"} {
+		if image, ok := cache.get(url); !ok || string(image.body) != url {
+			t.Errorf("get(%q) = %q, %v; want cached", url, image.body, ok)
+		}
+	}
+}
+
+func TestGetPlaceholderImage(t *testing.T) {
+	for _, tt := range []struct {
+		name           string
+		placeholderURL string
+		wantStatus     int
+	}{
+		{"embedded svg", "", http.StatusOK},
+		{"configured url", "https://cdn.example.com/placeholder.png", http.StatusFound},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewImageHandler(nil, &config.Config{ImagePlaceholderURL: tt.placeholderURL})
+			r := gin.New()
+			r.GET("/images/placeholder", h.GetPlaceholderImage)
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/images/placeholder", nil))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.placeholderURL == "" && w.Header().Get("Content-Type") != "image/svg+xml" {
+				t.Errorf("Content-Type = %q, want image/svg+xml", w.Header().Get("Content-Type"))
+			}
+			if tt.placeholderURL != "" && w.Header().Get("Location") != tt.placeholderURL {
+				t.Errorf("Location = %q, want %q", w.Header().Get("Location"), tt.placeholderURL)
+			}
+		})
+	}
+}
@@ -2,24 +2,51 @@ package handlers
 
 import (
 	"archive/zip"
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"mime/multipart"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/lib/pq"
+	"musike-backend/internal/services"
 )
 
 type ImportHandler struct {
-	db *sql.DB
+	db              *sql.DB
+	spotifyService  *services.SpotifyService
+	trackingService *services.TrackingService // nil sem banco; usado só para o enriquecimento pós-import
+
+	// Imports em andamento por usuário, para vários uploads grandes não esgotarem as conexões do banco
+	maxConcurrent int
+	activeMu      sync.Mutex
+	active        map[string]int
 }
 
+// Cada fonte converte seus arquivos para o formato do histórico estendido do Spotify,
+// que é o que o saveToDatabase grava. Para adicionar uma fonte basta implementar esta interface
+type StreamImporter interface {
+	Source() string
+	HistorySource() string // valor gravado em listening_history.source
+	// Registros descartados na leitura (ex.: linha sem data) entram em failures em vez de derrubar o arquivo
+	ParseFile(fileHeader *multipart.FileHeader, failures *ImportFailures) ([]SpotifyStreamingData, error)
+}
+
+// Importadores cujas faixas precisam ser casadas com IDs do Spotify antes de salvar
+type TrackResolver interface {
+	ResolveTracks(data []SpotifyStreamingData) *UnmatchedReport
+}
+
+var errUnsupportedImportFormat = errors.New("unsupported file format")
+
 type SpotifyStreamingData struct {
 	Timestamp        string `json:"ts"`
 	Username         string `json:"username"`
@@ -38,15 +65,90 @@ type SpotifyStreamingData struct {
 	Offline          bool   `json:"offline"`
 	OfflineTimestamp *int64 `json:"offline_timestamp"`
 	IncognitoMode    bool   `json:"incognito_mode"`
+
+	// ISRC, quando a fonte informa; usado para casar faixas sem SpotifyTrackURI
+	ISRC string `json:"isrc,omitempty"`
+
+	// ID da faixa já resolvido pelo importador; quando vazio, é extraído de SpotifyTrackURI
+	TrackID string `json:"-"`
 }
 
 type ImportResult struct {
-	ProcessedFiles  int           `json:"processed_files"`
-	ProcessedTracks int           `json:"processed_tracks"`
-	Errors          []string      `json:"errors"`
-	Status          string        `json:"status"`
-	ProcessingTime  time.Duration `json:"processing_time_ms"`
-	ImportSummary   ImportSummary `json:"summary"`
+	ProcessedFiles  int              `json:"processed_files"`
+	ProcessedTracks int              `json:"processed_tracks"`
+	Errors          []string         `json:"errors"`
+	Status          string           `json:"status"`
+	ProcessingTime  time.Duration    `json:"processing_time_ms"`
+	ImportSummary   ImportSummary    `json:"summary"`
+	Failures        ImportFailures   `json:"failures"`
+	Unmatched       *UnmatchedReport `json:"unmatched,omitempty"`
+	Enrichment      string           `json:"enrichment"` // skipped, scheduled, unavailable
+
+	ArtistRelationsCorrected int64 `json:"artist_relations_corrected"` // vínculos artist_<nome> removidos de faixas com artistas reais
+}
+
+// Falhas por registro na leitura dos arquivos e durante o saveToDatabase; a importação continua mesmo com elas
+type ImportFailures struct {
+	Rows         int      `json:"rows"` // linhas descartadas na leitura
+	Timestamps   int      `json:"timestamps"`
+	Artists      int      `json:"artists"`
+	Albums       int      `json:"albums"`
+	Tracks       int      `json:"tracks"`
+	TrackArtists int      `json:"track_artists"`
+	History      int      `json:"history"`
+	Reasons      []string `json:"reasons"`
+}
+
+const maxImportFailureReasons = 20
+
+func (f *ImportFailures) record(kind, name string, err error) {
+	switch kind {
+	case "row":
+		f.Rows++
+	case "timestamp":
+		f.Timestamps++
+	case "artist":
+		f.Artists++
+	case "album":
+		f.Albums++
+	case "track":
+		f.Tracks++
+	case "track_artist":
+		f.TrackArtists++
+	case "history":
+		f.History++
+	}
+
+	if len(f.Reasons) < maxImportFailureReasons {
+		f.Reasons = append(f.Reasons, fmt.Sprintf("%s %s: %v", kind, name, err))
+	}
+}
+
+func (f *ImportFailures) Total() int {
+	return f.Rows + f.Timestamps + f.Artists + f.Albums + f.Tracks + f.TrackArtists + f.History
+}
+
+func (f *ImportFailures) summaries() []string {
+	counts := []struct {
+		count int
+		label string
+	}{
+		{f.Rows, "rows skipped while reading the files"},
+		{f.Timestamps, "timestamps failed to parse"},
+		{f.Artists, "artists failed to insert"},
+		{f.Albums, "albums failed to insert"},
+		{f.Tracks, "tracks failed to insert"},
+		{f.TrackArtists, "track-artist links failed to insert"},
+		{f.History, "history records failed to insert"},
+	}
+
+	var summaries []string
+	for _, c := range counts {
+		if c.count > 0 {
+			summaries = append(summaries, fmt.Sprintf("%d %s", c.count, c.label))
+		}
+	}
+	return summaries
 }
 
 type ImportSummary struct {
@@ -75,35 +177,74 @@ type TrackCount struct {
 	Count  int    `json:"count"`
 }
 
-func NewImportHandler(db *sql.DB) *ImportHandler {
+func NewImportHandler(db *sql.DB, spotifyService *services.SpotifyService, trackingService *services.TrackingService, maxConcurrent int) *ImportHandler {
 	return &ImportHandler{
-		db: db,
+		db:              db,
+		spotifyService:  spotifyService,
+		trackingService: trackingService,
+		maxConcurrent:   max(maxConcurrent, 1),
+		active:          make(map[string]int),
+	}
+}
+
+// Reserva uma vaga de import para o usuário; false quando ele já está no limite
+func (h *ImportHandler) acquireImport(userID string) bool {
+	h.activeMu.Lock()
+	defer h.activeMu.Unlock()
+
+	if h.active[userID] >= h.maxConcurrent {
+		return false
 	}
+	h.active[userID]++
+	return true
+}
+
+func (h *ImportHandler) releaseImport(userID string) {
+	h.activeMu.Lock()
+	defer h.activeMu.Unlock()
+
+	if h.active[userID] <= 1 {
+		delete(h.active, userID)
+		return
+	}
+	h.active[userID]--
 }
 
 func (h *ImportHandler) ImportSpotifyData(c *gin.Context) {
+	h.runImport(c, &spotifyExportImporter{handler: h})
+}
+
+func (h *ImportHandler) runImport(c *gin.Context, importer StreamImporter) {
 	startTime := time.Now()
 
+	// O import sempre pertence ao usuário autenticado; sem ele não há a quem atribuir as escutas
 	userID, exists := c.Get("userID")
 	if !exists {
-		// Para o import final, usar um userID fixo
-		userID = "11d57948-a20e-4ecb-b9fe-5f628347e3a7"
-		log.Printf("Using fixed userID for final import: %s", userID)
+		respondUnauthorized(c)
+		return
+	}
+
+	// Liberado no fim da requisição, com sucesso, erro ou panic; o enriquecimento em background não conta
+	if !h.acquireImport(userID.(string)) {
+		log.Printf("Rejecting %s import for user %s: %d import(s) already in progress", importer.Source(), userID, h.maxConcurrent)
+		respondError(c, http.StatusTooManyRequests, ErrCodeImportInProgress, "An import is already in progress for this user, wait for it to finish")
+		return
 	}
+	defer h.releaseImport(userID.(string))
 
-	log.Printf("Starting Spotify data import for user: %s", userID)
+	log.Printf("Starting %s data import for user: %s", importer.Source(), userID)
 
 	form, err := c.MultipartForm()
 	if err != nil {
 		log.Printf("Failed to parse multipart form: %v", err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse form data"})
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Failed to parse form data")
 		return
 	}
 
 	files := form.File["files"]
 	if len(files) == 0 {
 		log.Printf("No files found in form")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No files provided"})
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "No files provided")
 		return
 	}
 
@@ -114,9 +255,14 @@ func (h *ImportHandler) ImportSpotifyData(c *gin.Context) {
 		log.Printf("Form field found: %s with %d files", key, len(form.File[key]))
 	}
 
+	// Enriquecer no Spotify custa uma chamada a cada 50 faixas/artistas; por padrão fica para o POST /user/enrich
+	enrich := c.DefaultQuery("enrich", c.PostForm("enrich")) == "true"
+
 	result := &ImportResult{
-		Status: "processing",
-		Errors: make([]string, 0),
+		Status:     "processing",
+		Enrichment: "skipped",
+		Errors:     make([]string, 0),
+		Failures:   ImportFailures{Reasons: make([]string, 0)},
 	}
 
 	var allStreamingData []SpotifyStreamingData
@@ -124,30 +270,12 @@ func (h *ImportHandler) ImportSpotifyData(c *gin.Context) {
 	for _, fileHeader := range files {
 		log.Printf("Processing file: %s (%.2f MB)", fileHeader.Filename, float64(fileHeader.Size)/1024/1024)
 
-		file, err := fileHeader.Open()
-		if err != nil {
-			log.Printf("Failed to open file %s: %v", fileHeader.Filename, err)
-			result.Errors = append(result.Errors, fmt.Sprintf("Failed to open file %s: %v", fileHeader.Filename, err))
-			continue
-		}
-		defer file.Close()
-
-		log.Printf("File opened successfully: %s", fileHeader.Filename)
-
-		var fileData []SpotifyStreamingData
-
-		if strings.HasSuffix(fileHeader.Filename, ".zip") {
-			log.Printf("Processing as ZIP file")
-			fileData, err = h.processZipFile(file, fileHeader.Size)
-		} else if strings.HasSuffix(fileHeader.Filename, ".json") {
-			log.Printf("Processing as JSON file")
-			fileData, err = h.processJSONFile(file)
-		} else {
+		fileData, err := importer.ParseFile(fileHeader, &result.Failures)
+		if errors.Is(err, errUnsupportedImportFormat) {
 			log.Printf("Unsupported file format: %s", fileHeader.Filename)
 			result.Errors = append(result.Errors, fmt.Sprintf("Unsupported file format: %s", fileHeader.Filename))
 			continue
 		}
-
 		if err != nil {
 			log.Printf("Failed to process file %s: %v", fileHeader.Filename, err)
 			result.Errors = append(result.Errors, fmt.Sprintf("Failed to process file %s: %v", fileHeader.Filename, err))
@@ -160,17 +288,44 @@ func (h *ImportHandler) ImportSpotifyData(c *gin.Context) {
 		result.ProcessedFiles++
 	}
 
+	if resolver, ok := importer.(TrackResolver); ok && len(allStreamingData) > 0 {
+		result.Unmatched = resolver.ResolveTracks(allStreamingData)
+	}
+
 	result.ProcessedTracks = len(allStreamingData)
 	result.ImportSummary = h.generateSummary(allStreamingData)
 
 	// Salvar dados no banco de dados
 	if len(allStreamingData) > 0 {
-		err := h.saveToDatabase(userID.(string), allStreamingData)
+		err := h.saveToDatabase(userID.(string), importer.HistorySource(), allStreamingData, &result.Failures)
 		if err != nil {
 			log.Printf("Failed to save data to database for user %s: %v", userID, err)
 			result.Errors = append(result.Errors, fmt.Sprintf("Failed to save data to database: %v", err))
 		} else {
-			log.Printf("Successfully saved %d streaming records to database for user %s", len(allStreamingData), userID)
+			log.Printf("Successfully saved %d streaming records to database for user %s (%d record failures)",
+				len(allStreamingData), userID, result.Failures.Total())
+			result.Errors = append(result.Errors, result.Failures.summaries()...)
+
+			corrected, err := services.ReconcileTrackArtists(c.Request.Context(), h.db, userID.(string))
+			if err != nil {
+				log.Printf("Failed to reconcile track artists for user %s: %v", userID, err)
+				result.Errors = append(result.Errors, fmt.Sprintf("Failed to reconcile track artists: %v", err))
+			} else if corrected > 0 {
+				log.Printf("Corrected %d track-artist relations for user %s", corrected, userID)
+			}
+			result.ArtistRelationsCorrected = corrected
+
+			// Import grava em lote: mais barato recontar do que incrementar escuta a escuta
+			if err := services.RebuildListeningAggregates(c.Request.Context(), h.db, userID.(string)); err != nil {
+				log.Printf("Failed to rebuild listening aggregates for user %s: %v", userID, err)
+			}
+			if err := services.InvalidateAnalyticsCache(c.Request.Context(), h.db, userID.(string)); err != nil {
+				log.Printf("Failed to invalidate analytics cache for user %s: %v", userID, err)
+			}
+
+			if enrich {
+				result.Enrichment = h.scheduleEnrichment(userID.(string), c.GetHeader("Spotify-Token"))
+			}
 		}
 	}
 
@@ -188,6 +343,55 @@ func (h *ImportHandler) ImportSpotifyData(c *gin.Context) {
 	c.JSON(http.StatusOK, result)
 }
 
+// Dispara o enriquecimento em background; precisa do Spotify-Token da requisição
+func (h *ImportHandler) scheduleEnrichment(userID, spotifyToken string) string {
+	if h.trackingService == nil || spotifyToken == "" {
+		log.Printf("Skipping post-import enrichment for user %s: tracking service or Spotify token unavailable", userID)
+		return "unavailable"
+	}
+
+	go func() {
+		if _, err := h.trackingService.EnrichUserCatalog(context.Background(), userID, spotifyToken); err != nil {
+			log.Printf("Post-import enrichment failed for user %s: %v", userID, err)
+		}
+	}()
+	return "scheduled"
+}
+
+// Export do "Extended streaming history" do Spotify (.json ou .zip com os .json)
+type spotifyExportImporter struct {
+	handler *ImportHandler
+}
+
+func (i *spotifyExportImporter) Source() string {
+	return "spotify"
+}
+
+func (i *spotifyExportImporter) HistorySource() string {
+	return "import"
+}
+
+func (i *spotifyExportImporter) ParseFile(fileHeader *multipart.FileHeader, _ *ImportFailures) ([]SpotifyStreamingData, error) {
+	isZip := strings.HasSuffix(fileHeader.Filename, ".zip")
+	if !isZip && !strings.HasSuffix(fileHeader.Filename, ".json") {
+		return nil, errUnsupportedImportFormat
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %v", err)
+	}
+	defer file.Close()
+
+	if isZip {
+		log.Printf("Processing as ZIP file")
+		return i.handler.processZipFile(file, fileHeader.Size)
+	}
+
+	log.Printf("Processing as JSON file")
+	return i.handler.processJSONFile(file)
+}
+
 func (h *ImportHandler) processZipFile(file multipart.File, size int64) ([]SpotifyStreamingData, error) {
 	var allData []SpotifyStreamingData
 
@@ -370,13 +574,15 @@ func (h *ImportHandler) generateSummary(data []SpotifyStreamingData) ImportSumma
 	}
 }
 
-func (h *ImportHandler) saveToDatabase(userID string, data []SpotifyStreamingData) error {
+func (h *ImportHandler) saveToDatabase(userID, source string, data []SpotifyStreamingData, failures *ImportFailures) error {
 	if h.db == nil {
 		return fmt.Errorf("database connection is nil")
 	}
 
 	log.Printf("Starting database save for user %s with %d records", userID, len(data))
 
+	h.resolveTracksWithoutURI(context.Background(), data)
+
 	tx, err := h.db.Begin()
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %v", err)
@@ -418,8 +624,11 @@ func (h *ImportHandler) saveToDatabase(userID string, data []SpotifyStreamingDat
 	defer insertTrackStmt.Close()
 
 	insertTrackArtistStmt, err := tx.Prepare(`
-		INSERT INTO track_artists (track_id, artist_id) 
-		VALUES ($1, $2) 
+		INSERT INTO track_artists (track_id, artist_id)
+		SELECT $1, $2
+		WHERE NOT EXISTS (
+			SELECT 1 FROM track_artists resolved WHERE resolved.track_id = $1 AND ` + services.ResolvedArtistCondition("resolved") + `
+		)
 		ON CONFLICT (track_id, artist_id) DO NOTHING
 	`)
 	if err != nil {
@@ -428,8 +637,8 @@ func (h *ImportHandler) saveToDatabase(userID string, data []SpotifyStreamingDat
 	defer insertTrackArtistStmt.Close()
 
 	insertListeningHistoryStmt, err := tx.Prepare(`
-		INSERT INTO listening_history (user_id, track_id, played_at, listened_duration_ms, listening_percentage, context_type, context_uri, platform, country, shuffle, skipped, offline, incognito_mode, reason_start, reason_end) 
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		INSERT INTO listening_history (user_id, track_id, played_at, listened_duration_ms, listening_percentage, context_type, context_uri, platform, country, shuffle, skipped, offline, incognito_mode, reason_start, reason_end, source) 
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
 		ON CONFLICT (user_id, track_id, played_at) DO NOTHING
 	`)
 	if err != nil {
@@ -438,29 +647,35 @@ func (h *ImportHandler) saveToDatabase(userID string, data []SpotifyStreamingDat
 	defer insertListeningHistoryStmt.Close()
 
 	for _, stream := range data {
-		trackID := h.extractTrackIDFromURI(stream.SpotifyTrackURI)
+		trackID := stream.TrackID
+		if trackID == "" {
+			trackID = h.extractTrackIDFromURI(stream.SpotifyTrackURI)
+		}
 		artistID := h.generateArtistID(stream.ArtistName)
 		albumID := h.generateAlbumID(stream.AlbumName)
 
 		playedAt, err := time.Parse("2006-01-02T15:04:05Z", stream.Timestamp)
 		if err != nil {
 			log.Printf("Failed to parse timestamp %s: %v", stream.Timestamp, err)
+			failures.record("timestamp", stream.Timestamp, err)
 			continue
 		}
 
 		if !artistsInserted[artistID] && stream.ArtistName != "" {
-			_, err = insertArtistStmt.Exec(artistID, stream.ArtistName, pq.Array([]string{}), 0, nil)
+			err = execWithSavepoint(tx, insertArtistStmt, artistID, stream.ArtistName, pq.Array([]string{}), 0, nil)
 			if err != nil {
 				log.Printf("Failed to insert artist %s: %v", stream.ArtistName, err)
+				failures.record("artist", stream.ArtistName, err)
 			} else {
 				artistsInserted[artistID] = true
 			}
 		}
 
 		if !albumsInserted[albumID] && stream.AlbumName != "" {
-			_, err = insertAlbumStmt.Exec(albumID, stream.AlbumName, nil, nil)
+			err = execWithSavepoint(tx, insertAlbumStmt, albumID, stream.AlbumName, nil, nil)
 			if err != nil {
 				log.Printf("Failed to insert album %s: %v", stream.AlbumName, err)
+				failures.record("album", stream.AlbumName, err)
 			} else {
 				albumsInserted[albumID] = true
 			}
@@ -472,18 +687,27 @@ func (h *ImportHandler) saveToDatabase(userID string, data []SpotifyStreamingDat
 				albumIDForTrack = &albumID
 			}
 
-			_, err = insertTrackStmt.Exec(trackID, stream.TrackName, albumIDForTrack, 0, 0, nil, nil)
+			var isrc *string
+			if stream.ISRC != "" {
+				normalized := strings.ToUpper(strings.TrimSpace(stream.ISRC))
+				isrc = &normalized
+			}
+
+			err = execWithSavepoint(tx, insertTrackStmt, trackID, stream.TrackName, albumIDForTrack, 0, 0, nil, isrc)
 			if err != nil {
 				log.Printf("Failed to insert track %s: %v", stream.TrackName, err)
+				failures.record("track", stream.TrackName, err)
 				continue // Pula este registro se falhar ao inserir a track
 			}
 			tracksInserted[trackID] = true
 		}
 
 		if trackID != "" && artistID != "" {
-			_, err = insertTrackArtistStmt.Exec(trackID, artistID)
+			// Faixas já enriquecidas têm os artistas reais; o artist_<nome> do import só poluiria a lista
+			err = execWithSavepoint(tx, insertTrackArtistStmt, trackID, artistID)
 			if err != nil {
 				log.Printf("Failed to insert track-artist relationship: %v", err)
+				failures.record("track_artist", trackID+"/"+artistID, err)
 			}
 		}
 
@@ -503,7 +727,7 @@ func (h *ImportHandler) saveToDatabase(userID string, data []SpotifyStreamingDat
 				skipped = *stream.Skipped
 			}
 
-			_, err = insertListeningHistoryStmt.Exec(
+			err = execWithSavepoint(tx, insertListeningHistoryStmt,
 				userID,
 				trackID,
 				playedAt,
@@ -519,9 +743,11 @@ func (h *ImportHandler) saveToDatabase(userID string, data []SpotifyStreamingDat
 				stream.IncognitoMode,
 				stream.ReasonStart,
 				stream.ReasonEnd,
+				source,
 			)
 			if err != nil {
 				log.Printf("Failed to insert listening history: %v", err)
+				failures.record("history", stream.TrackName+" @ "+stream.Timestamp, err)
 			}
 		}
 	}
@@ -549,6 +775,7 @@ func (h *ImportHandler) extractTrackIDFromURI(uri string) string {
 	return ""
 }
 
+// Mantém o esquema original (sem normalizeTrackKey): mudar o ID separaria os artistas já importados dos novos
 func (h *ImportHandler) generateArtistID(artistName string) string {
 	if artistName == "" {
 		return ""
@@ -565,6 +792,36 @@ func (h *ImportHandler) generateAlbumID(albumName string) string {
 	return fmt.Sprintf("album_%s", strings.ReplaceAll(strings.ToLower(albumName), " ", "_"))
 }
 
+// No Postgres um erro aborta a transação inteira; o savepoint isola a falha na linha.
+// Erros transitórios (deadlock, serialização) são tentados mais uma vez
+func execWithSavepoint(tx *sql.Tx, stmt *sql.Stmt, args ...interface{}) error {
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if _, err := tx.Exec("SAVEPOINT import_row"); err != nil {
+			return err
+		}
+
+		if _, err = stmt.Exec(args...); err == nil {
+			_, err = tx.Exec("RELEASE SAVEPOINT import_row")
+			return err
+		}
+
+		if _, rbErr := tx.Exec("ROLLBACK TO SAVEPOINT import_row"); rbErr != nil {
+			return rbErr
+		}
+
+		if !isTransientImportError(err) {
+			break
+		}
+	}
+	return err
+}
+
+func isTransientImportError(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code.Class() == "40"
+}
+
 func trackExists(trackID string, tx *sql.Tx) bool {
 	var exists bool
 	err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM tracks WHERE id = $1)", trackID).Scan(&exists)
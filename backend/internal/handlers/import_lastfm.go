@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+	"musike-backend/internal/services"
+)
+
+// Limite de buscas no Spotify por importação, para não estourar o rate limit em históricos grandes
+const maxLastfmSearches = 2000
+
+const maxUnmatchedReported = 100
+
+type UnmatchedTrack struct {
+	Artist string `json:"artist"`
+	Track  string `json:"track"`
+	Plays  int    `json:"plays"`
+}
+
+type UnmatchedReport struct {
+	Tracks      int              `json:"tracks"`
+	Plays       int              `json:"plays"`
+	TopTracks   []UnmatchedTrack `json:"top_tracks"`
+	SearchLimit bool             `json:"search_limit_reached"`
+}
+
+// Scrobbles do Last.fm exportados em CSV (com cabeçalho, ou no formato artist,album,track,date)
+type lastfmImporter struct {
+	handler *ImportHandler
+	token   *oauth2.Token // opcional; sem ele nenhuma faixa é casada com o Spotify
+	userID  string        // chave das buscas no cache do Spotify
+}
+
+func (h *ImportHandler) ImportLastfmData(c *gin.Context) {
+	importer := &lastfmImporter{handler: h, userID: c.GetString("userID")}
+	if spotifyToken := c.GetHeader("Spotify-Token"); spotifyToken != "" {
+		importer.token = &oauth2.Token{AccessToken: spotifyToken}
+	}
+
+	h.runImport(c, importer)
+}
+
+func (i *lastfmImporter) Source() string {
+	return "lastfm"
+}
+
+func (i *lastfmImporter) HistorySource() string {
+	return "scrobble"
+}
+
+type lastfmColumns struct {
+	artist, album, track, time int
+}
+
+func (i *lastfmImporter) ParseFile(fileHeader *multipart.FileHeader, failures *ImportFailures) ([]SpotifyStreamingData, error) {
+	if !strings.HasSuffix(strings.ToLower(fileHeader.Filename), ".csv") {
+		return nil, errUnsupportedImportFormat
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %v", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV: %v", err)
+	}
+
+	columns := lastfmColumns{artist: 0, album: 1, track: 2, time: 3}
+	start := 0
+	if len(records) > 0 {
+		if header, ok := parseLastfmHeader(records[0]); ok {
+			columns = header
+			start = 1
+		}
+	}
+
+	var data []SpotifyStreamingData
+	for idx, record := range records[start:] {
+		line := fmt.Sprintf("%s:%d", fileHeader.Filename, start+idx+1)
+		artist := csvField(record, columns.artist)
+		track := csvField(record, columns.track)
+		if artist == "" || track == "" {
+			failures.record("row", line, errors.New("missing artist or track"))
+			continue
+		}
+		playedAt, err := parseScrobbleTime(csvField(record, columns.time))
+		if err != nil {
+			failures.record("row", line, err)
+			continue
+		}
+
+		data = append(data, SpotifyStreamingData{
+			Timestamp:  playedAt.UTC().Format("2006-01-02T15:04:05Z"),
+			ArtistName: artist,
+			AlbumName:  csvField(record, columns.album),
+			TrackName:  track,
+		})
+	}
+
+	return data, nil
+}
+
+func parseLastfmHeader(record []string) (lastfmColumns, bool) {
+	columns := lastfmColumns{artist: -1, album: -1, track: -1, time: -1}
+	for idx, name := range record {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "artist", "artist_name", "artist name":
+			columns.artist = idx
+		case "album", "album_name", "album name":
+			columns.album = idx
+		case "track", "track_name", "track name", "name", "title":
+			columns.track = idx
+		case "uts":
+			columns.time = idx
+		case "timestamp", "utc_time", "date", "played_at":
+			if columns.time == -1 {
+				columns.time = idx
+			}
+		}
+	}
+
+	return columns, columns.artist >= 0 && columns.track >= 0 && columns.time >= 0
+}
+
+func csvField(record []string, idx int) string {
+	if idx < 0 || idx >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[idx])
+}
+
+var scrobbleTimeLayouts = []string{
+	"02 Jan 2006 15:04",
+	"2 Jan 2006 15:04",
+	"02 Jan 2006, 15:04",
+	"2 Jan 2006, 15:04",
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+}
+
+// Aceita unix timestamp (segundos ou ms) ou as datas legíveis dos exportadores mais comuns, sempre em UTC
+func parseScrobbleTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, errors.New("empty date")
+	}
+
+	if unix, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if unix > 1e12 {
+			return time.UnixMilli(unix).UTC(), nil
+		}
+		return time.Unix(unix, 0).UTC(), nil
+	}
+
+	for _, layout := range scrobbleTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.UTC); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("unrecognized date format: %s", value)
+}
+
+func (i *lastfmImporter) ResolveTracks(data []SpotifyStreamingData) *UnmatchedReport {
+	type trackKey struct{ artist, track string }
+
+	resolved := make(map[trackKey]*services.SearchResult)
+	unmatched := make(map[trackKey]*UnmatchedTrack)
+	var unmatchedOrder []trackKey
+	searches := 0
+	searchEnabled := i.token != nil && i.handler.spotifyService != nil
+	report := &UnmatchedReport{TopTracks: make([]UnmatchedTrack, 0)}
+
+	for idx := range data {
+		stream := &data[idx]
+		key := trackKey{normalizeTrackKey(stream.ArtistName), normalizeTrackKey(stream.TrackName)}
+
+		track, seen := resolved[key]
+		if !seen {
+			if searchEnabled && searches < maxLastfmSearches {
+				searches++
+				found, err := i.handler.spotifyService.SearchTrack(i.token, i.userID, stream.ArtistName, stream.TrackName)
+				if err != nil {
+					log.Printf("Spotify search failed for %s - %s: %v", stream.ArtistName, stream.TrackName, err)
+
+					// Token inválido ou rate limit: não adianta continuar buscando
+					var apiErr *services.SpotifyAPIError
+					if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusUnauthorized || apiErr.StatusCode == http.StatusTooManyRequests) {
+						searchEnabled = false
+					}
+				}
+				track = found
+			} else if searchEnabled {
+				report.SearchLimit = true
+			}
+			resolved[key] = track
+		}
+
+		if track != nil {
+			stream.TrackID = track.ID
+			stream.SpotifyTrackURI = "spotify:track:" + track.ID
+			// O Last.fm não informa quanto foi escutado; assume a faixa inteira
+			stream.MsPlayed = track.DurationMs
+			continue
+		}
+
+		// Sem correspondência: grava com um ID sintético estável para não perder a escuta
+		stream.TrackID = generateScrobbleTrackID(stream.ArtistName, stream.TrackName)
+		if entry, exists := unmatched[key]; exists {
+			entry.Plays++
+		} else {
+			unmatched[key] = &UnmatchedTrack{Artist: stream.ArtistName, Track: stream.TrackName, Plays: 1}
+			unmatchedOrder = append(unmatchedOrder, key)
+		}
+		report.Plays++
+	}
+
+	report.Tracks = len(unmatched)
+	for _, key := range unmatchedOrder {
+		report.TopTracks = append(report.TopTracks, *unmatched[key])
+	}
+	sort.SliceStable(report.TopTracks, func(a, b int) bool {
+		return report.TopTracks[a].Plays > report.TopTracks[b].Plays
+	})
+	if len(report.TopTracks) > maxUnmatchedReported {
+		report.TopTracks = report.TopTracks[:maxUnmatchedReported]
+	}
+
+	log.Printf("Last.fm import: %d Spotify searches, %d tracks unmatched (%d plays)", searches, report.Tracks, report.Plays)
+	return report
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Arquivo enviado num multipart, como o runImport recebe
+func newTestFileHeader(t *testing.T, name, content string) *multipart.FileHeader {
+	t.Helper()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("files", name)
+	if err != nil {
+		t.Fatalf("CreateFormFile: %v", err)
+	}
+	part.Write([]byte(content))
+	writer.Close()
+
+	req := httptest.NewRequest("POST", "/", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	if err := req.ParseMultipartForm(1 << 20); err != nil {
+		t.Fatalf("ParseMultipartForm: %v", err)
+	}
+	return req.MultipartForm.File["files"][0]
+}
+
+func TestLastfmParseFileReportsSkippedRows(t *testing.T) {
+	csv := "artist,album,track,uts\n" +
+		"Cartola,Cartola,Alvorada,1700000000\n" +
+		",Cartola,Sem Artista,1700000100\n" +
+		"Cartola,Cartola,Data Ruim,ontem\n"
+
+	failures := ImportFailures{Reasons: make([]string, 0)}
+	data, err := (&lastfmImporter{}).ParseFile(newTestFileHeader(t, "scrobbles.csv", csv), &failures)
+	if err != nil {
+		t.Fatalf("ParseFile: %v", err)
+	}
+
+	if len(data) != 1 || data[0].TrackName != "Alvorada" {
+		t.Errorf("data = %+v, want only Alvorada", data)
+	}
+	if failures.Rows != 2 || failures.Total() != 2 {
+		t.Fatalf("failures = %+v, want 2 skipped rows", failures)
+	}
+	for i, line := range []string{"scrobbles.csv:3", "scrobbles.csv:4"} {
+		if !strings.Contains(failures.Reasons[i], line) {
+			t.Errorf("reason %q does not name %s", failures.Reasons[i], line)
+		}
+	}
+}
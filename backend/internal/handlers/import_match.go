@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Limite de cada busca de faixa no banco durante o import; sem resposta a escuta fica com o ID sintético
+const trackLookupTimeout = 5 * time.Second
+
+// Normalização usada para casar faixas sem URI do Spotify:
+//   - espaços nas pontas são removidos
+//   - qualquer sequência de espaços/tabs/quebras vira um único espaço
+//   - tudo em minúsculas (Unicode)
+//
+// Pontuação, acentos e sufixos como "- Remastered" são preservados: versões diferentes continuam separadas
+func normalizeTrackKey(value string) string {
+	return strings.ToLower(strings.Join(strings.Fields(value), " "))
+}
+
+// ID sintético estável para (artista, faixa) normalizados; o mesmo usado pelo import do Last.fm
+func generateScrobbleTrackID(artist, track string) string {
+	sum := sha256.Sum256([]byte(normalizeTrackKey(artist) + "|" + normalizeTrackKey(track)))
+	return fmt.Sprintf("track_%x", sum[:12])
+}
+
+// Atribui um ID às escutas sem SpotifyTrackURI, para que a mesma música vinda de arquivos diferentes
+// não vire várias faixas. Ordem: ISRC já conhecido no banco, faixa existente com mesmo nome e artista
+// normalizados, e por fim o ID sintético de generateScrobbleTrackID
+func (h *ImportHandler) resolveTracksWithoutURI(ctx context.Context, data []SpotifyStreamingData) {
+	type trackKey struct{ artist, track string }
+	resolved := make(map[trackKey]string)
+	resolvedISRC := make(map[string]string)
+	lookups := 0
+
+	for idx := range data {
+		stream := &data[idx]
+		if stream.TrackID != "" || stream.SpotifyTrackURI != "" || stream.TrackName == "" {
+			continue
+		}
+
+		if stream.ISRC != "" {
+			isrc := strings.ToUpper(strings.TrimSpace(stream.ISRC))
+			trackID, seen := resolvedISRC[isrc]
+			if !seen {
+				trackID = h.findTrackByISRC(ctx, isrc)
+				resolvedISRC[isrc] = trackID
+				lookups++
+			}
+			if trackID != "" {
+				stream.TrackID = trackID
+				continue
+			}
+		}
+
+		key := trackKey{normalizeTrackKey(stream.ArtistName), normalizeTrackKey(stream.TrackName)}
+		trackID, seen := resolved[key]
+		if !seen {
+			trackID = h.findTrackByName(ctx, key.artist, key.track)
+			if trackID == "" {
+				trackID = generateScrobbleTrackID(stream.ArtistName, stream.TrackName)
+			}
+			resolved[key] = trackID
+			lookups++
+		}
+		stream.TrackID = trackID
+	}
+
+	if lookups > 0 {
+		log.Printf("Resolved %d distinct tracks without Spotify URI", lookups)
+	}
+}
+
+func (h *ImportHandler) findTrackByISRC(ctx context.Context, isrc string) string {
+	if h.db == nil {
+		return ""
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, trackLookupTimeout)
+	defer cancel()
+
+	var trackID string
+	err := h.db.QueryRowContext(ctx, `SELECT id FROM tracks WHERE UPPER(isrc) = $1 ORDER BY id LIMIT 1`, isrc).Scan(&trackID)
+	if err != nil {
+		return ""
+	}
+	return trackID
+}
+
+// Mesma normalização de normalizeTrackKey, feita no banco. As expressões são as dos índices
+// idx_tracks_normalized_name e idx_artists_normalized_name; mudar uma exige mudar o índice junto
+func (h *ImportHandler) findTrackByName(ctx context.Context, artist, track string) string {
+	if h.db == nil {
+		return ""
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, trackLookupTimeout)
+	defer cancel()
+
+	var trackID string
+	err := h.db.QueryRowContext(ctx, `
+		SELECT t.id
+		FROM tracks t
+		JOIN track_artists ta ON ta.track_id = t.id
+		JOIN artists ar ON ar.id = ta.artist_id
+		WHERE LOWER(BTRIM(REGEXP_REPLACE(t.name, '\s+', ' ', 'g'))) = $2
+			AND LOWER(BTRIM(REGEXP_REPLACE(ar.name, '\s+', ' ', 'g'))) = $1
+		ORDER BY t.id
+		LIMIT 1
+	`, artist, track).Scan(&trackID)
+	if err != nil {
+		return ""
+	}
+	return trackID
+}
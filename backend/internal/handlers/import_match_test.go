@@ -0,0 +1,77 @@
+package handlers
+
+import "testing"
+
+func TestNormalizeTrackKey(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"case", "Bohemian RHAPSODY", "bohemian rhapsody"},
+		{"unicode case", "ÁGUAS DE MARÇO", "águas de março"},
+		{"surrounding whitespace", "  Yesterday\t", "yesterday"},
+		{"inner whitespace", "Hey   Jude\n(Live)", "hey jude (live)"},
+		{"accents preserved", "Café", "café"},
+		{"punctuation preserved", "Don't Stop Me Now!", "don't stop me now!"},
+		{"feat. suffix preserved", "Numb (feat. Jay-Z)", "numb (feat. jay-z)"},
+		{"remaster suffix preserved", "Help! - Remastered 2009", "help! - remastered 2009"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := normalizeTrackKey(tt.value); got != tt.want {
+				t.Errorf("normalizeTrackKey(%q) = %q, want %q", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+// Variações só de caixa e espaço são a mesma faixa; acento, pontuação e sufixo de versão não
+func TestNormalizeTrackKeyGrouping(t *testing.T) {
+	same := [][2]string{
+		{"Bohemian Rhapsody", "bohemian  rhapsody "},
+		{"ÁGUAS DE MARÇO", "Águas de Março"},
+	}
+	for _, pair := range same {
+		if normalizeTrackKey(pair[0]) != normalizeTrackKey(pair[1]) {
+			t.Errorf("%q and %q should share a key", pair[0], pair[1])
+		}
+	}
+
+	different := [][2]string{
+		{"Cafe", "Café"},
+		{"Dont Stop Me Now", "Don't Stop Me Now"},
+		{"Numb", "Numb (feat. Jay-Z)"},
+		{"Help!", "Help! - Remastered 2009"},
+	}
+	for _, pair := range different {
+		if normalizeTrackKey(pair[0]) == normalizeTrackKey(pair[1]) {
+			t.Errorf("%q and %q should not share a key", pair[0], pair[1])
+		}
+	}
+
+	if generateScrobbleTrackID("Queen", "Bohemian Rhapsody") != generateScrobbleTrackID(" QUEEN", "bohemian  rhapsody") {
+		t.Error("scrobble track ID should ignore case and whitespace variants")
+	}
+}
+
+// O ID dos artistas importados não pode mudar, senão os artistas já gravados ficam separados dos novos
+func TestGenerateArtistIDKeepsOriginalScheme(t *testing.T) {
+	h := &ImportHandler{}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Daft Punk", "artist_daft_punk"},
+		{"Beyoncé", "artist_beyoncé"},
+		{"  The  Beatles ", "artist___the__beatles_"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := h.generateArtistID(tt.name); got != tt.want {
+			t.Errorf("generateArtistID(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
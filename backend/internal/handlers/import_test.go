@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Sem o middleware de auth não há userID no contexto: o import não pode seguir sem saber de quem são as escutas
+func TestImportWithoutUserReturnsUnauthorized(t *testing.T) {
+	h := NewImportHandler(nil, nil, nil, 1)
+
+	r := gin.New()
+	r.POST("/import/spotify", h.ImportSpotifyData)
+	r.POST("/import/lastfm", h.ImportLastfmData)
+
+	for _, path := range []string{"/import/spotify", "/import/lastfm"} {
+		t.Run(path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, nil))
+
+			if w.Code != http.StatusUnauthorized {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusUnauthorized)
+			}
+
+			var body ErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("invalid error body %q: %v", w.Body.String(), err)
+			}
+			if body.Code != ErrCodeUnauthorized || body.Error != "User not authenticated" {
+				t.Errorf("body = %+v, want code %q", body, ErrCodeUnauthorized)
+			}
+			if len(h.active) != 0 {
+				t.Errorf("import slot reserved without a user: %v", h.active)
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Erros de parse listados por arquivo; os demais só entram na contagem
+const maxValidationErrors = 20
+
+// Resultado da validação de um arquivo enviado para import, sem gravar nada
+type ImportFileValidation struct {
+	File         string     `json:"file"`
+	Container    string     `json:"container"` // json, zip, gz, csv ou unknown
+	Schema       string     `json:"schema"`    // extended, simple, lastfm, mixed ou unknown
+	Records      int        `json:"records"`
+	ValidRecords int        `json:"valid_records"` // registros que o import de fato gravaria
+	DateRange    *DateRange `json:"date_range"`
+	Importable   bool       `json:"importable"`
+	Endpoint     string     `json:"endpoint,omitempty"` // rota de import que aceita o arquivo
+	Errors       []string   `json:"errors"`
+	ErrorCount   int        `json:"error_count"`
+}
+
+func (v *ImportFileValidation) addError(format string, args ...interface{}) {
+	v.ErrorCount++
+	if len(v.Errors) < maxValidationErrors {
+		v.Errors = append(v.Errors, fmt.Sprintf(format, args...))
+	}
+}
+
+func (v *ImportFileValidation) addSchema(schema string) {
+	if v.Schema == "unknown" || v.Schema == schema {
+		v.Schema = schema
+		return
+	}
+	v.Schema = "mixed"
+}
+
+func (v *ImportFileValidation) addPlayedAt(playedAt time.Time) {
+	day := playedAt.UTC().Format("2006-01-02")
+	if v.DateRange == nil {
+		v.DateRange = &DateRange{From: day, To: day}
+		return
+	}
+	if day < v.DateRange.From {
+		v.DateRange.From = day
+	}
+	if day > v.DateRange.To {
+		v.DateRange.To = day
+	}
+}
+
+// Valida os arquivos como o import leria (formato, contagem, período e erros de parse) sem tocar no banco
+func (h *ImportHandler) ValidateImportFiles(c *gin.Context) {
+	if _, exists := c.Get("userID"); !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	form, err := c.MultipartForm()
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Failed to parse form data")
+		return
+	}
+
+	files := form.File["files"]
+	if len(files) == 0 {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "No files provided")
+		return
+	}
+
+	results := make([]ImportFileValidation, 0, len(files))
+	allImportable := true
+	for _, fileHeader := range files {
+		result := validateImportFile(fileHeader)
+		allImportable = allImportable && result.Importable
+		results = append(results, result)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"files": results,
+		"valid": allImportable,
+	})
+}
+
+func validateImportFile(fileHeader *multipart.FileHeader) ImportFileValidation {
+	result := ImportFileValidation{
+		File:      fileHeader.Filename,
+		Container: "unknown",
+		Schema:    "unknown",
+		Errors:    []string{},
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		result.addError("failed to open file: %v", err)
+		return result
+	}
+	defer file.Close()
+
+	name := strings.ToLower(fileHeader.Filename)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		result.Container = "zip"
+		validateZip(file, fileHeader.Size, &result)
+	case strings.HasSuffix(name, ".gz"):
+		result.Container = "gz"
+		reader, err := gzip.NewReader(file)
+		if err != nil {
+			result.addError("failed to open gzip: %v", err)
+			break
+		}
+		validateJSON(reader, "", &result)
+		reader.Close()
+	case strings.HasSuffix(name, ".json"):
+		result.Container = "json"
+		validateJSON(file, "", &result)
+	case strings.HasSuffix(name, ".csv"):
+		result.Container = "csv"
+		validateCSV(file, &result)
+	default:
+		result.addError("unsupported file format, expected .json, .zip, .gz or .csv")
+	}
+
+	// Só o histórico estendido (.json/.zip) e CSV do Last.fm têm rota de import hoje
+	switch {
+	case result.Schema == "extended" && (result.Container == "json" || result.Container == "zip"):
+		result.Endpoint = "/api/v1/import/spotify"
+	case result.Schema == "lastfm":
+		result.Endpoint = "/api/v1/import/lastfm"
+	case result.Schema == "simple":
+		result.addError("simple streaming history (StreamingHistory*.json) is not supported, request the extended streaming history")
+	case result.Container == "gz" && result.Schema == "extended":
+		result.addError("gzip files are not supported by the import, decompress or zip them first")
+	}
+	result.Importable = result.Endpoint != "" && result.ValidRecords > 0
+
+	return result
+}
+
+func validateZip(file multipart.File, size int64, result *ImportFileValidation) {
+	zipReader, err := zip.NewReader(file, size)
+	if err != nil {
+		result.addError("failed to open zip file: %v", err)
+		return
+	}
+
+	jsonFiles := 0
+	for _, zipFile := range zipReader.File {
+		if !strings.HasSuffix(strings.ToLower(zipFile.Name), ".json") {
+			continue
+		}
+		jsonFiles++
+
+		reader, err := zipFile.Open()
+		if err != nil {
+			result.addError("%s: failed to open: %v", zipFile.Name, err)
+			continue
+		}
+		validateJSON(reader, path.Base(zipFile.Name)+": ", result)
+		reader.Close()
+	}
+
+	if jsonFiles == 0 {
+		result.addError("zip has no .json files")
+	}
+}
+
+// Registro com os campos dos dois formatos de export do Spotify: o estendido (ts, master_metadata_*) e o
+// simples (endTime, artistName, trackName)
+type streamingRecord struct {
+	SpotifyStreamingData
+	EndTime        *string `json:"endTime"`
+	SimpleArtist   string  `json:"artistName"`
+	SimpleTrack    string  `json:"trackName"`
+	SimpleMsPlayed int     `json:"msPlayed"`
+}
+
+func validateJSON(reader io.Reader, prefix string, result *ImportFileValidation) {
+	var records []streamingRecord
+	if err := json.NewDecoder(reader).Decode(&records); err != nil {
+		result.addError("%sfailed to decode JSON array: %v", prefix, err)
+		return
+	}
+
+	for idx, record := range records {
+		result.Records++
+
+		if record.EndTime != nil {
+			result.addSchema("simple")
+			playedAt, err := time.Parse("2006-01-02 15:04", *record.EndTime)
+			if err != nil {
+				result.addError("%srecord %d: invalid endTime %q", prefix, idx, *record.EndTime)
+				continue
+			}
+			result.addPlayedAt(playedAt)
+			if record.SimpleMsPlayed >= 5000 && record.SimpleTrack != "" && record.SimpleArtist != "" {
+				result.ValidRecords++
+			}
+			continue
+		}
+
+		if record.Timestamp == "" {
+			result.addError("%srecord %d: missing ts/endTime", prefix, idx)
+			continue
+		}
+		result.addSchema("extended")
+		playedAt, err := time.Parse("2006-01-02T15:04:05Z", record.Timestamp)
+		if err != nil {
+			result.addError("%srecord %d: invalid ts %q", prefix, idx, record.Timestamp)
+			continue
+		}
+		result.addPlayedAt(playedAt)
+		// Mesmo critério do processJSONFile; podcasts e escutas curtas ficam de fora
+		if record.MsPlayed >= 5000 && record.TrackName != "" && record.ArtistName != "" {
+			result.ValidRecords++
+		}
+	}
+}
+
+func validateCSV(reader io.Reader, result *ImportFileValidation) {
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = -1
+	csvReader.LazyQuotes = true
+
+	records, err := csvReader.ReadAll()
+	if err != nil {
+		result.addError("failed to read CSV: %v", err)
+		return
+	}
+
+	columns := lastfmColumns{artist: 0, album: 1, track: 2, time: 3}
+	start := 0
+	if len(records) > 0 {
+		if header, ok := parseLastfmHeader(records[0]); ok {
+			columns = header
+			start = 1
+		}
+	}
+
+	result.Schema = "lastfm"
+	for idx, record := range records[start:] {
+		result.Records++
+		playedAt, err := parseScrobbleTime(csvField(record, columns.time))
+		if err != nil {
+			result.addError("row %d: %v", idx+start+1, err)
+			continue
+		}
+		result.addPlayedAt(playedAt)
+		if csvField(record, columns.artist) != "" && csvField(record, columns.track) != "" {
+			result.ValidRecords++
+		}
+	}
+}
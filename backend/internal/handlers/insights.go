@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"musike-backend/internal/services"
+)
+
+// Público: só agregados anônimos de todos os usuários
+func (h *AnalyticsHandler) GetGlobalInsights(c *gin.Context) {
+	insights, err := h.analyticsService.GetGlobalInsights(c.Request.Context())
+	if err != nil {
+		log.Printf("Error getting global insights: %v", err)
+		respondQueryError(c, err, "Failed to get global insights")
+		return
+	}
+
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(h.analyticsService.GlobalInsightsCacheTTL().Seconds())))
+	c.JSON(http.StatusOK, insights)
+}
+
+// Top 5 faixas e artistas com imagens, total de minutos e período, no formato do card compartilhável
+func (h *AnalyticsHandler) GetTop5Card(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	loc, err := parseTimezone(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid timezone")
+		return
+	}
+
+	timeFilter := parseTimeFilter(c, "6months") // 6months, 1year, alltime
+
+	card, err := h.analyticsService.GetTop5Card(c.Request.Context(), userID.(string), timeFilter, loc)
+	if errors.Is(err, services.ErrNoListeningHistory) {
+		respondNoData(c)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting top 5 card for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get top 5 card")
+		return
+	}
+
+	c.JSON(http.StatusOK, card)
+}
+
+// Curiosidades a partir do total de minutos e escutas do período (?time_filter=alltime)
+func (h *AnalyticsHandler) GetFunFacts(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	timeFilter := parseTimeFilter(c, "alltime") // 6months, 1year, alltime
+
+	facts, err := h.analyticsService.GetFunFacts(c.Request.Context(), userID.(string), timeFilter)
+	if errors.Is(err, services.ErrNoListeningHistory) {
+		respondNoData(c)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting fun facts for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get fun facts")
+		return
+	}
+
+	c.JSON(http.StatusOK, facts)
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"musike-backend/internal/services"
+)
+
+type LinkedAccountsHandler struct {
+	linkedAccounts  *services.LinkedAccountsService
+	trackingService *services.TrackingService
+}
+
+func NewLinkedAccountsHandler(linkedAccounts *services.LinkedAccountsService, trackingService *services.TrackingService) *LinkedAccountsHandler {
+	return &LinkedAccountsHandler{
+		linkedAccounts:  linkedAccounts,
+		trackingService: trackingService,
+	}
+}
+
+func (h *LinkedAccountsHandler) ListAccounts(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	accounts, err := h.linkedAccounts.List(c.Request.Context(), userID.(string))
+	if err != nil {
+		log.Printf("Error listing linked accounts for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to list linked Spotify accounts")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"accounts": accounts})
+}
+
+// Cookie que amarra a vinculação ao navegador que a pediu: o callback só vincula se ele vier junto,
+// então um link de vinculação repassado a outra pessoa não prende a conta dela ao usuário errado
+const linkStateCookie = "musike_link_state"
+
+// Devolve a URL de login do Spotify; ao concluir o login com a outra conta, o callback faz a vinculação
+func (h *LinkedAccountsHandler) StartLink(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	link, err := h.linkedAccounts.StartLink(c.Request.Context(), userID.(string))
+	if err != nil {
+		log.Printf("Error starting account link for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to start account link")
+		return
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(linkStateCookie, link.State, int(time.Until(link.ExpiresAt).Seconds()), "/", "", c.Request.TLS != nil, true)
+
+	c.JSON(http.StatusOK, gin.H{
+		"auth_url": link.AuthURL,
+		// Conta do Musike que vai receber a vinculação, para o cliente mostrar antes do login no Spotify
+		"link_to": gin.H{
+			"user_id":      link.UserID,
+			"display_name": link.DisplayName,
+		},
+		"expires_at": link.ExpiresAt,
+	})
+}
+
+func (h *LinkedAccountsHandler) Unlink(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	spotifyID := c.Param("spotifyID")
+	err := h.linkedAccounts.Unlink(c.Request.Context(), userID.(string), spotifyID)
+	if errors.Is(err, services.ErrLinkedAccountNotFound) {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Linked Spotify account not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error unlinking account %s for user %s: %v", spotifyID, userID, err)
+		respondQueryError(c, err, "Failed to unlink Spotify account")
+		return
+	}
+
+	if h.trackingService != nil {
+		h.trackingService.StopLinkedTracking(userID.(string), spotifyID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Spotify account unlinked",
+		"spotify_id": spotifyID,
+	})
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"musike-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Minutos por dia e média móvel de 7 dias no período, para ver a tendência sem o ruído diário
+func (h *AnalyticsHandler) GetMomentum(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	loc, err := parseTimezone(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid timezone")
+		return
+	}
+
+	timeFilter := parseTimeFilter(c, "6months") // 6months, 1year, alltime
+
+	points, err := h.analyticsService.GetMomentum(c.Request.Context(), userID.(string), timeFilter, loc)
+	if errors.Is(err, services.ErrTimeSeriesTooLarge) {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "History too long for a daily series, use a shorter time_filter")
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting momentum for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get listening momentum")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"days":        points,
+		"window_days": 7,
+		"time_filter": timeFilter,
+		"timezone":    loc.String(),
+	})
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *AnalyticsHandler) GetMonthlyFavorites(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	loc, err := parseTimezone(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid timezone")
+		return
+	}
+
+	months, err := strconv.Atoi(c.DefaultQuery("months", "12"))
+	if err != nil || months < 1 || months > 60 {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid months. Use a value between 1 and 60")
+		return
+	}
+
+	favorites, err := h.analyticsService.GetMonthlyFavorites(c.Request.Context(), userID.(string), months, loc)
+	if err != nil {
+		log.Printf("Error getting monthly favorites for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get monthly favorites")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"months": favorites,
+	})
+}
+
+func (h *AnalyticsHandler) GetDominantGenreTimeline(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	loc, err := parseTimezone(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid timezone")
+		return
+	}
+
+	months, err := strconv.Atoi(c.DefaultQuery("months", "12"))
+	if err != nil || months < 1 || months > 60 {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid months. Use a value between 1 and 60")
+		return
+	}
+
+	timeline, err := h.analyticsService.GetDominantGenreTimeline(c.Request.Context(), userID.(string), months, loc)
+	if err != nil {
+		log.Printf("Error getting dominant genre timeline for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get dominant genre timeline")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"months": timeline,
+	})
+}
+
+// Fidelidade dos artistas mais escutados (?months=12, ?limit=20): fatia das escutas combinada com a
+// constância mês a mês, com os componentes do score
+func (h *AnalyticsHandler) GetLoyalty(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	loc, err := parseTimezone(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid timezone")
+		return
+	}
+
+	months, err := strconv.Atoi(c.DefaultQuery("months", "12"))
+	if err != nil || months < 1 || months > 60 {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid months. Use a value between 1 and 60")
+		return
+	}
+
+	report, err := h.analyticsService.GetLoyalty(c.Request.Context(), userID.(string), months, loc, parseLimit(c, 20))
+	if err != nil {
+		log.Printf("Error getting loyalty for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get artist loyalty")
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
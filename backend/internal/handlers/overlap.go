@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"musike-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Lê um período no fuso do usuário: YYYY-MM-DD..YYYY-MM-DD (dias inclusivos), YYYY-MM (mês) ou YYYY (ano)
+func parsePeriod(value string, loc *time.Location) (services.Period, error) {
+	if from, to, ok := strings.Cut(value, ".."); ok {
+		start, err := time.ParseInLocation("2006-01-02", from, loc)
+		if err != nil {
+			return services.Period{}, err
+		}
+		end, err := time.ParseInLocation("2006-01-02", to, loc)
+		if err != nil {
+			return services.Period{}, err
+		}
+		if start.After(end) {
+			return services.Period{}, fmt.Errorf("start %s is after end %s", from, to)
+		}
+		return services.Period{From: start, To: end.AddDate(0, 0, 1)}, nil
+	}
+
+	if month, err := time.ParseInLocation("2006-01", value, loc); err == nil {
+		return services.Period{From: month, To: month.AddDate(0, 1, 0)}, nil
+	}
+	year, err := time.ParseInLocation("2006", value, loc)
+	if err != nil {
+		return services.Period{}, err
+	}
+	return services.Period{From: year, To: year.AddDate(1, 0, 0)}, nil
+}
+
+// Retenção de faixas e artistas entre dois períodos (?a= e ?b=), nos dois sentidos
+func (h *AnalyticsHandler) GetPeriodOverlap(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	loc, err := parseTimezone(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid timezone")
+		return
+	}
+
+	periods := make([]services.Period, 2)
+	for i, name := range []string{"a", "b"} {
+		periods[i], err = parsePeriod(c.Query(name), loc)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest,
+				fmt.Sprintf("Invalid period %s, expected YYYY-MM-DD..YYYY-MM-DD, YYYY-MM or YYYY", name))
+			return
+		}
+	}
+
+	overlap, err := h.analyticsService.GetPeriodOverlap(c.Request.Context(), userID.(string), periods[0], periods[1])
+	if err != nil {
+		log.Printf("Error getting period overlap for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get period overlap")
+		return
+	}
+
+	// Fim exibido inclusivo, como foi pedido
+	describe := func(period services.Period) gin.H {
+		return gin.H{
+			"from": period.From.Format("2006-01-02"),
+			"to":   period.To.AddDate(0, 0, -1).Format("2006-01-02"),
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"a":        describe(periods[0]),
+		"b":        describe(periods[1]),
+		"tracks":   overlap.Tracks,
+		"artists":  overlap.Artists,
+		"timezone": loc.String(),
+	})
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"musike-backend/internal/middleware"
+	"musike-backend/internal/services"
+)
+
+// Fuso horário do usuário via ?tz= (nome IANA, ex.: America/Sao_Paulo). Sem o parâmetro vale a preferência
+// timezone do usuário; sem ela, UTC
+func parseTimezone(c *gin.Context) (*time.Location, error) {
+	tz := c.Query("tz")
+	if tz == "" {
+		tz = middleware.StoredDefaults(c).Timezone
+	}
+	if tz == "" {
+		tz = "UTC"
+	}
+	return time.LoadLocation(tz)
+}
+
+// ?time_filter=; sem o parâmetro vale a preferência default_time_filter do usuário e, sem ela, o padrão do endpoint
+func parseTimeFilter(c *gin.Context, endpointDefault string) string {
+	if timeFilter := c.Query("time_filter"); timeFilter != "" {
+		return timeFilter
+	}
+	if timeFilter := middleware.StoredDefaults(c).TimeFilter; timeFilter != "" {
+		return timeFilter
+	}
+	return endpointDefault
+}
+
+// Limites aceitos pela API do Spotify
+const (
+	minLimit = 1
+	maxLimit = 50
+)
+
+// Lê ?limit=, usando o padrão quando ausente ou inválido e limitando a [1, 50]
+func parseLimit(c *gin.Context, defaultLimit int) int {
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil {
+		return defaultLimit
+	}
+	return min(max(limit, minLimit), maxLimit)
+}
+
+// Lê um cursor em unix ms (?after=/?before=); 0 quando ausente
+func parseUnixMillis(c *gin.Context, name string) (int64, error) {
+	raw := c.Query(name)
+	if raw == "" {
+		return 0, nil
+	}
+	ms, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if ms <= 0 {
+		return 0, fmt.Errorf("%s must be a positive unix timestamp in milliseconds", name)
+	}
+	return ms, nil
+}
+
+// Origem das escutas via ?source= (import, tracking, manual ou scrobble); vazio quando ausente
+func parseSource(c *gin.Context) (string, bool) {
+	source := c.Query("source")
+	if err := services.ValidateHistorySource(source); err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid source. Use "+strings.Join(services.HistorySources, ", "))
+		return "", false
+	}
+	return source, true
+}
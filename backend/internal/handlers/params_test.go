@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"musike-backend/internal/services"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// Contexto do gin com a query string informada
+func newQueryContext(query url.Values) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/?"+query.Encode(), nil)
+	return c
+}
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit string
+		want  int
+	}{
+		{"default when absent", "", 20},
+		{"non-numeric", "abc", 20},
+		{"negative", "-5", minLimit},
+		{"zero", "0", minLimit},
+		{"within range", "30", 30},
+		{"max", "50", maxLimit},
+		{"over max", "500", maxLimit},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := url.Values{}
+			if tt.limit != "" {
+				query.Set("limit", tt.limit)
+			}
+			if got := parseLimit(newQueryContext(query), 20); got != tt.want {
+				t.Errorf("parseLimit(%q) = %d, want %d", tt.limit, got, tt.want)
+			}
+		})
+	}
+}
+
+// Contexto com as preferências do usuário como o middleware.RequestDefaults deixaria
+func withStoredDefaults(c *gin.Context, defaults services.RequestDefaults) *gin.Context {
+	c.Set("requestDefaults", func() *services.RequestDefaults { return &defaults })
+	return c
+}
+
+func TestParseTimezoneFallsBackToPreference(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		stored string
+		want   string
+	}{
+		{"default", "", "", "UTC"},
+		{"preference", "", "America/Sao_Paulo", "America/Sao_Paulo"},
+		{"query wins", "Europe/Lisbon", "America/Sao_Paulo", "Europe/Lisbon"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := url.Values{}
+			if tt.query != "" {
+				query.Set("tz", tt.query)
+			}
+			c := withStoredDefaults(newQueryContext(query), services.RequestDefaults{Timezone: tt.stored})
+
+			loc, err := parseTimezone(c)
+			if err != nil || loc.String() != tt.want {
+				t.Errorf("parseTimezone = %v, %v; want %s", loc, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTimeFilterFallsBackToPreference(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		stored string
+		want   string
+	}{
+		{"endpoint default", "", "", "alltime"},
+		{"preference", "", "1year", "1year"},
+		{"query wins", "6months", "1year", "6months"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := url.Values{}
+			if tt.query != "" {
+				query.Set("time_filter", tt.query)
+			}
+			c := withStoredDefaults(newQueryContext(query), services.RequestDefaults{TimeFilter: tt.stored})
+
+			if got := parseTimeFilter(c, "alltime"); got != tt.want {
+				t.Errorf("parseTimeFilter = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	// Fora das rotas com preferências vale o padrão do endpoint
+	if got := parseTimeFilter(newQueryContext(url.Values{}), "6months"); got != "6months" {
+		t.Errorf("parseTimeFilter without preferences = %s, want 6months", got)
+	}
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"musike-backend/internal/services"
+)
+
+const (
+	maxPlaylistTracks         = 500
+	maxPlaylistNameRunes      = 100
+	maxPlaylistDescriptionLen = 300
+)
+
+type PlaylistHandler struct {
+	spotifyService *services.SpotifyService
+	authService    *services.AuthService
+	tokenStore     *services.SpotifyTokenStore
+	scope          string // scope de escrita configurado; vazio desativa a criação
+}
+
+func NewPlaylistHandler(spotifyService *services.SpotifyService, authService *services.AuthService, tokenStore *services.SpotifyTokenStore, scope string) *PlaylistHandler {
+	return &PlaylistHandler{
+		spotifyService: spotifyService,
+		authService:    authService,
+		tokenStore:     tokenStore,
+		scope:          scope,
+	}
+}
+
+// Cria uma playlist no Spotify com as faixas enviadas (ex.: top tracks ou /user/soundtrack). Usa o token salvo
+// no servidor; sem o scope de escrita devolve 403 com a URL para o usuário consentir de novo
+func (h *PlaylistHandler) CreatePlaylist(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	if h.scope == "" {
+		respondError(c, http.StatusServiceUnavailable, ErrCodeServiceUnavailable, "Playlist creation is disabled")
+		return
+	}
+	if h.tokenStore == nil {
+		respondError(c, http.StatusServiceUnavailable, ErrCodeServiceUnavailable, "Database not available")
+		return
+	}
+
+	var request struct {
+		Name        string   `json:"name" binding:"required"`
+		Description string   `json:"description"`
+		TrackIDs    []string `json:"track_ids" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	if utf8.RuneCountInString(request.Name) > maxPlaylistNameRunes || utf8.RuneCountInString(request.Description) > maxPlaylistDescriptionLen {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest,
+			fmt.Sprintf("name must have at most %d characters and description at most %d", maxPlaylistNameRunes, maxPlaylistDescriptionLen))
+		return
+	}
+	if len(request.TrackIDs) == 0 || len(request.TrackIDs) > maxPlaylistTracks {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("track_ids must have 1-%d tracks", maxPlaylistTracks))
+		return
+	}
+	for _, trackID := range request.TrackIDs {
+		if !services.IsSpotifyID(trackID) {
+			respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("%q is not a Spotify track ID", trackID))
+			return
+		}
+	}
+
+	ctx := c.Request.Context()
+	granted, err := h.tokenStore.HasScope(ctx, userID.(string), h.scope)
+	if err != nil && !errors.Is(err, services.ErrNoStoredToken) {
+		log.Printf("Error checking spotify scopes for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to check Spotify permissions")
+		return
+	}
+	if !granted {
+		h.respondScopeRequired(c)
+		return
+	}
+
+	token, err := h.tokenStore.Token(ctx, userID.(string))
+	if err != nil {
+		log.Printf("Error getting stored spotify token for user %s: %v", userID, err)
+		respondError(c, http.StatusUnauthorized, ErrCodeSpotifyTokenExpired, "Spotify session expired, log in with Spotify again")
+		return
+	}
+
+	playlist, err := h.spotifyService.CreatePlaylist(token, request.Name, request.Description, h.scope == "playlist-modify-public")
+	if services.IsSpotifyForbidden(err) {
+		// O consentimento foi revogado no Spotify depois do login
+		h.respondScopeRequired(c)
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating playlist for user %s: %v", userID, err)
+		respondSpotifyError(c, err, "Failed to create playlist")
+		return
+	}
+
+	if err := h.spotifyService.AddTracksToPlaylist(token, playlist.ID, request.TrackIDs); err != nil {
+		log.Printf("Error adding tracks to playlist %s of user %s: %v", playlist.ID, userID, err)
+		respondSpotifyError(c, err, "Playlist created but failed to add tracks")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"playlist_id": playlist.ID,
+		"name":        playlist.Name,
+		"uri":         playlist.URI,
+		"url":         playlist.ExternalURLs.Spotify,
+		"public":      playlist.Public,
+		"tracks":      len(request.TrackIDs),
+	})
+}
+
+// 403 com a URL de login que mostra de novo a tela de consentimento; depois do callback o token salvo
+// passa a ter o scope e a chamada pode ser repetida
+func (h *PlaylistHandler) respondScopeRequired(c *gin.Context) {
+	state := "musike-" + strconv.FormatInt(time.Now().Unix(), 10)
+	c.JSON(http.StatusForbidden, gin.H{
+		"error":    "Spotify permission to modify playlists not granted, log in again to grant it",
+		"code":     ErrCodeSpotifyScopeRequired,
+		"scope":    h.scope,
+		"auth_url": h.authService.GetReconsentURL(state),
+		"state":    state,
+	})
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"musike-backend/internal/services"
+)
+
+type PreferencesHandler struct {
+	preferencesService *services.PreferencesService
+}
+
+func NewPreferencesHandler(preferencesService *services.PreferencesService) *PreferencesHandler {
+	return &PreferencesHandler{preferencesService: preferencesService}
+}
+
+func (h *PreferencesHandler) GetPreferences(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	preferences, err := h.preferencesService.Get(c.Request.Context(), userID.(string))
+	if err != nil {
+		log.Printf("Error getting preferences for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get preferences")
+		return
+	}
+
+	c.JSON(http.StatusOK, preferences)
+}
+
+func (h *PreferencesHandler) UpdatePreferences(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	var update services.UserPreferencesUpdate
+	if err := c.ShouldBindJSON(&update); err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+
+	preferences, err := h.preferencesService.Update(c.Request.Context(), userID.(string), update)
+	if errors.Is(err, services.ErrInvalidPreference) {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating preferences for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to update preferences")
+		return
+	}
+
+	c.JSON(http.StatusOK, preferences)
+}
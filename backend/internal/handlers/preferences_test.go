@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// weekly_report só aceita booleano; o valor inválido é recusado antes de chegar ao banco
+func TestUpdatePreferencesRejectsInvalidWeeklyReport(t *testing.T) {
+	h := NewPreferencesHandler(nil)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPut, "/user/preferences", strings.NewReader(`{"weekly_report": "yes"}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Set("userID", "user-1")
+
+	h.UpdatePreferences(c)
+
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), ErrCodeInvalidRequest) {
+		t.Errorf("response = %d %s, want 400 %s", w.Code, w.Body.String(), ErrCodeInvalidRequest)
+	}
+}
@@ -0,0 +1,450 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (h *AnalyticsHandler) GetListeningRecords(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	loc, err := parseTimezone(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid timezone")
+		return
+	}
+
+	timeFilter := parseTimeFilter(c, "alltime") // 6months, 1year, alltime
+
+	records, err := h.analyticsService.GetListeningRecords(c.Request.Context(), userID.(string), timeFilter, loc)
+	if err != nil {
+		log.Printf("Error getting listening records for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get listening records")
+		return
+	}
+
+	c.JSON(http.StatusOK, records)
+}
+
+func (h *AnalyticsHandler) GetMusicEras(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	timeFilter := parseTimeFilter(c, "6months") // 6months, 1year, alltime
+
+	eras, err := h.analyticsService.GetMusicEras(c.Request.Context(), userID.(string), timeFilter)
+	if err != nil {
+		log.Printf("Error getting music eras for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get music eras")
+		return
+	}
+
+	c.JSON(http.StatusOK, eras)
+}
+
+func (h *AnalyticsHandler) GetReleaseYears(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	timeFilter := parseTimeFilter(c, "6months") // 6months, 1year, alltime
+
+	timeline, err := h.analyticsService.GetReleaseYears(c.Request.Context(), userID.(string), timeFilter)
+	if err != nil {
+		log.Printf("Error getting release years for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get release years")
+		return
+	}
+
+	c.JSON(http.StatusOK, timeline)
+}
+
+func (h *AnalyticsHandler) GetFullAlbumListens(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	loc, err := parseTimezone(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid timezone")
+		return
+	}
+
+	// Percentual mínimo do álbum escutado; sem o parâmetro vale FULL_ALBUM_MIN_COMPLETION
+	minCompletion := 0
+	if raw := c.Query("min_completion"); raw != "" {
+		minCompletion, err = strconv.Atoi(raw)
+		if err != nil || minCompletion < 1 || minCompletion > 100 {
+			respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid min_completion. Use a percentage between 1 and 100")
+			return
+		}
+	}
+
+	timeFilter := parseTimeFilter(c, "6months") // 6months, 1year, alltime
+	limit := parseLimit(c, 20)
+
+	listens, err := h.analyticsService.GetFullAlbumListens(c.Request.Context(), userID.(string), timeFilter, minCompletion, limit, loc)
+	if err != nil {
+		log.Printf("Error getting full album listens for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get full album listens")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"listens": listens,
+	})
+}
+
+func (h *AnalyticsHandler) GetMilestones(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	milestones, err := h.analyticsService.GetMilestones(c.Request.Context(), userID.(string))
+	if err != nil {
+		log.Printf("Error getting milestones for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get milestones")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"milestones": milestones,
+	})
+}
+
+func (h *AnalyticsHandler) GetListeningRoutine(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	loc, err := parseTimezone(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid timezone")
+		return
+	}
+
+	timeFilter := parseTimeFilter(c, "6months") // 6months, 1year, alltime
+	source, ok := parseSource(c)
+	if !ok {
+		return
+	}
+
+	routine, err := h.analyticsService.GetListeningRoutine(c.Request.Context(), userID.(string), timeFilter, loc, source)
+	if err != nil {
+		log.Printf("Error getting listening routine for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get listening routine")
+		return
+	}
+
+	c.JSON(http.StatusOK, routine)
+}
+
+// Hora de pico de cada dia da semana no fuso ?tz=; dias sem escuta vêm com has_data false e hour nulo
+func (h *AnalyticsHandler) GetPeakByWeekday(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	loc, err := parseTimezone(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid timezone")
+		return
+	}
+
+	timeFilter := parseTimeFilter(c, "6months") // 6months, 1year, alltime
+	source, ok := parseSource(c)
+	if !ok {
+		return
+	}
+
+	peaks, err := h.analyticsService.GetPeakByWeekday(c.Request.Context(), userID.(string), timeFilter, loc, source)
+	if err != nil {
+		log.Printf("Error getting peak hour by weekday for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get peak hour by weekday")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"weekdays":    peaks,
+		"time_filter": timeFilter,
+		"timezone":    loc.String(),
+	})
+}
+
+func (h *AnalyticsHandler) GetShuffleSummary(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	timeFilter := parseTimeFilter(c, "6months") // 6months, 1year, alltime
+
+	summary, err := h.analyticsService.GetShuffleSummary(c.Request.Context(), userID.(string), timeFilter)
+	if err != nil {
+		log.Printf("Error getting shuffle summary for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get shuffle summary")
+		return
+	}
+
+	c.JSON(http.StatusOK, summary)
+}
+
+// Shuffle x em ordem em escutas e minutos, com a cobertura e a tendência mensal (?months=12, ?tz=)
+func (h *AnalyticsHandler) GetShuffleStats(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	loc, err := parseTimezone(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid timezone")
+		return
+	}
+
+	months, err := strconv.Atoi(c.DefaultQuery("months", "12"))
+	if err != nil || months < 1 || months > 60 {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid months. Use a value between 1 and 60")
+		return
+	}
+
+	timeFilter := parseTimeFilter(c, "6months") // 6months, 1year, alltime
+
+	stats, err := h.analyticsService.GetShuffleStats(c.Request.Context(), userID.(string), timeFilter, months, loc)
+	if err != nil {
+		log.Printf("Error getting shuffle stats for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get shuffle stats")
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+// Escutas e minutos em faixas explícitas x limpas, com a cobertura da flag (?time_filter=6months)
+func (h *AnalyticsHandler) GetExplicitRatio(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	timeFilter := parseTimeFilter(c, "6months") // 6months, 1year, alltime
+
+	ratio, err := h.analyticsService.GetExplicitRatio(c.Request.Context(), userID.(string), timeFilter)
+	if err != nil {
+		log.Printf("Error getting explicit ratio for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get explicit ratio")
+		return
+	}
+
+	c.JSON(http.StatusOK, ratio)
+}
+
+func (h *AnalyticsHandler) GetMainstreamScore(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	timeFilter := parseTimeFilter(c, "6months") // 6months, 1year, alltime
+
+	score, err := h.analyticsService.GetMainstreamScore(c.Request.Context(), userID.(string), timeFilter)
+	if err != nil {
+		log.Printf("Error getting mainstream score for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get mainstream score")
+		return
+	}
+
+	c.JSON(http.StatusOK, score)
+}
+
+func (h *AnalyticsHandler) GetWeeklyComparison(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	loc, err := parseTimezone(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid timezone")
+		return
+	}
+
+	comparison, err := h.analyticsService.GetWeeklyComparison(c.Request.Context(), userID.(string), loc)
+	if err != nil {
+		log.Printf("Error getting weekly comparison for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get weekly comparison")
+		return
+	}
+
+	c.JSON(http.StatusOK, comparison)
+}
+
+func (h *AnalyticsHandler) GetCompletionFunnel(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	timeFilter := parseTimeFilter(c, "6months") // 6months, 1year, alltime
+	source, ok := parseSource(c)
+	if !ok {
+		return
+	}
+
+	funnel, err := h.analyticsService.GetCompletionFunnel(c.Request.Context(), userID.(string), timeFilter, source)
+	if err != nil {
+		log.Printf("Error getting completion funnel for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get completion funnel")
+		return
+	}
+
+	c.JSON(http.StatusOK, funnel)
+}
+
+func (h *AnalyticsHandler) GetArtistAffinity(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	timeFilter := parseTimeFilter(c, "6months") // 6months, 1year, alltime
+	limit := parseLimit(c, 50)
+
+	affinity, err := h.analyticsService.GetArtistAffinity(c.Request.Context(), userID.(string), timeFilter, limit)
+	if err != nil {
+		log.Printf("Error getting artist affinity for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get artist affinity")
+		return
+	}
+
+	c.JSON(http.StatusOK, affinity)
+}
+
+func (h *AnalyticsHandler) GetDurationDistribution(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	timeFilter := parseTimeFilter(c, "6months") // 6months, 1year, alltime
+	source, ok := parseSource(c)
+	if !ok {
+		return
+	}
+
+	distribution, err := h.analyticsService.GetDurationDistribution(c.Request.Context(), userID.(string), timeFilter, source)
+	if err != nil {
+		log.Printf("Error getting duration distribution for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get duration distribution")
+		return
+	}
+
+	c.JSON(http.StatusOK, distribution)
+}
+
+func (h *AnalyticsHandler) GetListeningEstimate(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	loc, err := parseTimezone(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid timezone")
+		return
+	}
+
+	estimate, err := h.analyticsService.GetListeningEstimate(c.Request.Context(), userID.(string), loc)
+	if err != nil {
+		log.Printf("Error estimating listening for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to estimate listening time")
+		return
+	}
+
+	c.JSON(http.StatusOK, estimate)
+}
+
+func (h *AnalyticsHandler) GetListeningConsistency(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	loc, err := parseTimezone(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid timezone")
+		return
+	}
+
+	timeFilter := parseTimeFilter(c, "6months") // 6months, 1year, alltime
+	top := min(parseLimit(c, 3), 24)
+
+	consistency, err := h.analyticsService.GetListeningConsistency(c.Request.Context(), userID.(string), timeFilter, top, loc)
+	if err != nil {
+		log.Printf("Error getting listening consistency for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get listening consistency")
+		return
+	}
+
+	c.JSON(http.StatusOK, consistency)
+}
+
+// Faixas descobertas no período e quão rápido engrenaram (?time_filter=6months, ?min_plays=3, ?limit=20)
+func (h *AnalyticsHandler) GetTrackVelocity(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	minPlays, err := strconv.Atoi(c.DefaultQuery("min_plays", "3"))
+	if err != nil || minPlays < 1 {
+		minPlays = 3
+	}
+
+	timeFilter := parseTimeFilter(c, "6months") // 6months, 1year, alltime
+
+	tracks, err := h.analyticsService.GetTrackVelocity(c.Request.Context(), userID.(string), timeFilter, minPlays, parseLimit(c, 20))
+	if err != nil {
+		log.Printf("Error getting track velocity for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get track velocity")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tracks":      tracks,
+		"time_filter": timeFilter,
+		"min_plays":   minPlays,
+		"window_days": 7,
+	})
+}
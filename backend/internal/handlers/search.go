@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"musike-backend/internal/services"
+)
+
+func (h *AnalyticsHandler) Search(c *gin.Context) {
+	token, ok := h.spotifyToken(c)
+	if !ok {
+		return
+	}
+
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Search query (q) is required")
+		return
+	}
+
+	searchType := c.DefaultQuery("type", "track") // track, artist, album
+	if !services.IsValidSearchType(searchType) {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid type, expected track, artist or album")
+		return
+	}
+
+	limit := parseLimit(c, 10)
+
+	results, err := h.spotifyService.Search(token, c.GetString("userID"), query, searchType, limit)
+	if err != nil {
+		log.Printf("Error searching Spotify for %q: %v", query, err)
+		respondSpotifyError(c, err, "Failed to search Spotify")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"query":   query,
+		"type":    searchType,
+		"results": results,
+	})
+}
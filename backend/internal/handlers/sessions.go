@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"musike-backend/internal/services"
+)
+
+func (h *AnalyticsHandler) GetListeningSessions(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	timeFilter := parseTimeFilter(c, "6months")
+	limit := parseLimit(c, 20)
+
+	sessions, err := h.analyticsService.GetListeningSessions(c.Request.Context(), userID.(string), timeFilter, limit)
+	if err != nil {
+		log.Printf("Error getting listening sessions for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get listening sessions")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sessions":    sessions,
+		"time_filter": timeFilter,
+	})
+}
+
+func (h *AnalyticsHandler) GetTopBinges(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	timeFilter := parseTimeFilter(c, "alltime")
+	limit := parseLimit(c, 10)
+
+	binges, err := h.analyticsService.GetTopBinges(c.Request.Context(), userID.(string), timeFilter, limit)
+	if err != nil {
+		log.Printf("Error getting binges for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get binges")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"binges":      binges,
+		"time_filter": timeFilter,
+	})
+}
+
+func (h *AnalyticsHandler) TagListeningSession(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	var request struct {
+		Tag string `json:"tag" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+
+	tag, err := services.NormalizeSessionTag(request.Tag)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid tag. Use 1-32 letters, digits, spaces, '-' or '_'")
+		return
+	}
+
+	sessionID := c.Param("id")
+	created, tags, err := h.analyticsService.TagListeningSession(c.Request.Context(), userID.(string), sessionID, tag)
+	if errors.Is(err, services.ErrSessionNotFound) {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Listening session not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error tagging session %s for user %s: %v", sessionID, userID, err)
+		respondQueryError(c, err, "Failed to tag listening session")
+		return
+	}
+
+	status := http.StatusOK
+	if created {
+		status = http.StatusCreated
+	}
+	c.JSON(status, gin.H{
+		"session_id": sessionID,
+		"tags":       tags,
+		"created":    created,
+	})
+}
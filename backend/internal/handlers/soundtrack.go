@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"musike-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Faixas mais tocadas numa parte do dia (?part=morning|afternoon|evening|night), no fuso do usuário
+func (h *AnalyticsHandler) GetSoundtrack(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	loc, err := parseTimezone(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid timezone")
+		return
+	}
+
+	part := c.Query("part")
+	timeFilter := parseTimeFilter(c, "6months") // 6months, 1year, alltime
+	limit := parseLimit(c, 20)
+
+	tracks, err := h.analyticsService.GetSoundtrack(c.Request.Context(), userID.(string), part, timeFilter, loc, limit)
+	if errors.Is(err, services.ErrInvalidDayPart) {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "part must be morning, afternoon, evening or night")
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting %s soundtrack for user %s: %v", part, userID, err)
+		respondQueryError(c, err, "Failed to get soundtrack")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"part":        part,
+		"hours":       services.DayParts[part],
+		"tracks":      tracks,
+		"time_filter": timeFilter,
+		"timezone":    loc.String(),
+	})
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+	"musike-backend/internal/services"
+)
+
+// Token do Spotify para a requisição: o salvo no servidor para o usuário autenticado (renovado se expirou)
+// e, para quem ainda não tem token salvo, o header Spotify-Token. Responde e devolve false quando não há token
+func (h *AnalyticsHandler) spotifyToken(c *gin.Context) (*oauth2.Token, bool) {
+	header := c.GetHeader("Spotify-Token")
+
+	if userID, exists := c.Get("userID"); exists && h.tokenStore != nil {
+		token, err := h.tokenStore.Token(c.Request.Context(), userID.(string))
+		if err == nil {
+			return token, true
+		}
+		if !errors.Is(err, services.ErrNoStoredToken) {
+			log.Printf("Error getting stored spotify token for user %s: %v", userID, err)
+			if header == "" {
+				respondError(c, http.StatusUnauthorized, ErrCodeSpotifyTokenExpired, "Spotify session expired, log in with Spotify again")
+				return nil, false
+			}
+		}
+	}
+
+	if header == "" {
+		respondSpotifyTokenRequired(c)
+		return nil, false
+	}
+	return &oauth2.Token{AccessToken: header}, true
+}
@@ -1,29 +1,43 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
 	"musike-backend/internal/services"
 )
 
 type TrackingHandler struct {
-	trackingService *services.TrackingService
-	authService     *services.AuthService
+	trackingService    *services.TrackingService
+	authService        *services.AuthService
+	spotifyService     *services.SpotifyService
+	preferencesService *services.PreferencesService
+	linkedAccounts     *services.LinkedAccountsService
 }
 
-func NewTrackingHandler(trackingService *services.TrackingService, authService *services.AuthService) *TrackingHandler {
+func NewTrackingHandler(trackingService *services.TrackingService, authService *services.AuthService, spotifyService *services.SpotifyService, preferencesService *services.PreferencesService, linkedAccounts *services.LinkedAccountsService) *TrackingHandler {
 	return &TrackingHandler{
-		trackingService: trackingService,
-		authService:     authService,
+		trackingService:    trackingService,
+		authService:        authService,
+		spotifyService:     spotifyService,
+		preferencesService: preferencesService,
+		linkedAccounts:     linkedAccounts,
 	}
 }
 
+// Máximo de usuários em uma consulta de now-playing em grupo
+const maxNowPlayingGroup = 20
+
 func (h *TrackingHandler) StartTracking(c *gin.Context) {
 	userID, exists := c.Get("userID")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		respondUnauthorized(c)
 		return
 	}
 
@@ -31,7 +45,7 @@ func (h *TrackingHandler) StartTracking(c *gin.Context) {
 	if spotifyToken == "" {
 		spotifyToken = c.PostForm("spotify_token")
 		if spotifyToken == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Spotify token required"})
+			respondSpotifyTokenRequired(c)
 			return
 		}
 	}
@@ -39,9 +53,10 @@ func (h *TrackingHandler) StartTracking(c *gin.Context) {
 	err := h.trackingService.StartTracking(userID.(string), spotifyToken)
 	if err != nil {
 		log.Printf("Error starting tracking for user %s: %v", userID, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start tracking"})
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to start tracking")
 		return
 	}
+	h.linkedAccounts.StartTracking(c.Request.Context(), userID.(string), h.trackingService)
 
 	log.Printf("Started tracking for user: %s", userID)
 	c.JSON(http.StatusOK, gin.H{
@@ -53,14 +68,14 @@ func (h *TrackingHandler) StartTracking(c *gin.Context) {
 func (h *TrackingHandler) StopTracking(c *gin.Context) {
 	userID, exists := c.Get("userID")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		respondUnauthorized(c)
 		return
 	}
 
 	err := h.trackingService.StopTracking(userID.(string))
 	if err != nil {
 		log.Printf("Error stopping tracking for user %s: %v", userID, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to stop tracking"})
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to stop tracking")
 		return
 	}
 
@@ -71,23 +86,171 @@ func (h *TrackingHandler) StopTracking(c *gin.Context) {
 	})
 }
 
+func (h *TrackingHandler) ReplaceToken(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	var request struct {
+		SpotifyToken string `json:"spotify_token" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+
+	// Validar o novo token com uma chamada leve ao perfil antes de aceitá-lo
+	profile, err := h.spotifyService.GetUserProfile(&oauth2.Token{AccessToken: request.SpotifyToken})
+	if err != nil {
+		log.Printf("Rejected replacement Spotify token for user %s: %v", userID, err)
+		respondError(c, http.StatusBadRequest, ErrCodeSpotifyTokenInvalid, "Invalid Spotify token")
+		return
+	}
+
+	spotifyID, err := h.trackingService.GetUserSpotifyID(userID.(string))
+	if err != nil {
+		log.Printf("Error getting Spotify ID for user %s: %v", userID, err)
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to verify Spotify account")
+		return
+	}
+
+	if profile.ID != spotifyID {
+		respondError(c, http.StatusForbidden, ErrCodeSpotifyAccountMismatch, "Spotify token belongs to a different account")
+		return
+	}
+
+	err = h.trackingService.UpdateSpotifyToken(userID.(string), request.SpotifyToken)
+	if errors.Is(err, services.ErrUserNotTracked) {
+		respondError(c, http.StatusNotFound, ErrCodeNotTracked, "User is not being tracked, start tracking instead")
+		return
+	}
+	if err != nil {
+		log.Printf("Error replacing Spotify token for user %s: %v", userID, err)
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to replace Spotify token")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Spotify token updated successfully",
+		"status":  "active",
+	})
+}
+
+// Apaga o histórico gravado pelo tracking e regrava o que o Spotify ainda tem no recently-played.
+// O token precisa ser da conta principal do usuário, senão o histórico seria regravado com escutas de outra conta
+func (h *TrackingHandler) ResyncFull(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	spotifyToken, ok := h.primarySpotifyToken(c, userID.(string))
+	if !ok {
+		return
+	}
+
+	result, err := h.trackingService.ResyncFull(c.Request.Context(), userID.(string), spotifyToken)
+	if errors.Is(err, services.ErrResyncFetchFailed) {
+		log.Printf("Error resyncing history for user %s: %v", userID, err)
+		respondError(c, http.StatusBadGateway, ErrCodeSpotifyError, "Failed to fetch recently played from Spotify, nothing was removed")
+		return
+	}
+	if err != nil {
+		log.Printf("Error resyncing history for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to resync history")
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// Backfill único do recently-played, o mais fundo que o Spotify permitir. Histórico antigo vem do import
+func (h *TrackingHandler) BackfillHistory(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	spotifyToken, ok := h.primarySpotifyToken(c, userID.(string))
+	if !ok {
+		return
+	}
+
+	result, err := h.trackingService.BackfillHistory(c.Request.Context(), userID.(string), spotifyToken)
+	if errors.Is(err, services.ErrBackfillAlreadyDone) {
+		message := "History backfill already done, use the import for older history"
+		if result != nil {
+			message = fmt.Sprintf("History backfill already done at %s, use the import for older history", result.BackfilledAt.Format(time.RFC3339))
+		}
+		respondError(c, http.StatusConflict, ErrCodeDuplicate, message)
+		return
+	}
+	if errors.Is(err, services.ErrResyncFetchFailed) {
+		log.Printf("Error backfilling history for user %s: %v", userID, err)
+		respondError(c, http.StatusBadGateway, ErrCodeSpotifyError, "Failed to fetch recently played from Spotify")
+		return
+	}
+	if err != nil {
+		log.Printf("Error backfilling history for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to backfill history")
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// Token do header Spotify-Token, conferido como sendo da conta principal do usuário. Responde e devolve
+// false quando falta ou é de outra conta
+func (h *TrackingHandler) primarySpotifyToken(c *gin.Context, userID string) (string, bool) {
+	spotifyToken := c.GetHeader("Spotify-Token")
+	if spotifyToken == "" {
+		respondSpotifyTokenRequired(c)
+		return "", false
+	}
+
+	profile, err := h.spotifyService.GetUserProfile(&oauth2.Token{AccessToken: spotifyToken})
+	if err != nil {
+		respondSpotifyError(c, err, "Failed to verify Spotify account")
+		return "", false
+	}
+
+	spotifyID, err := h.trackingService.GetUserSpotifyID(userID)
+	if err != nil {
+		log.Printf("Error getting Spotify ID for user %s: %v", userID, err)
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to verify Spotify account")
+		return "", false
+	}
+
+	if profile.ID != spotifyID {
+		respondError(c, http.StatusForbidden, ErrCodeSpotifyAccountMismatch, "Spotify token belongs to a different account")
+		return "", false
+	}
+
+	return spotifyToken, true
+}
+
 func (h *TrackingHandler) GetCurrentTrack(c *gin.Context) {
 	userID, exists := c.Get("userID")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		respondUnauthorized(c)
 		return
 	}
 
 	spotifyToken := c.GetHeader("Spotify-Token")
 	if spotifyToken == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Spotify token required in header"})
+		respondSpotifyTokenRequired(c)
 		return
 	}
 
 	currentTrack, err := h.trackingService.GetCurrentTrack(spotifyToken)
 	if err != nil {
 		log.Printf("Error getting current track for user %s: %v", userID, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get current track"})
+		respondSpotifyError(c, err, "Failed to get current track")
 		return
 	}
 
@@ -104,6 +267,74 @@ func (h *TrackingHandler) GetCurrentTrack(c *gin.Context) {
 	})
 }
 
+// Faixa atual de vários usuários (?user_ids=a,b,c) para uma tela compartilhada. Só aparecem quem ativou
+// share_now_playing nas preferências e o próprio usuário; os demais vão para unavailable, sem distinguir
+// usuário inexistente de usuário que não autorizou
+func (h *TrackingHandler) GetNowPlayingGroup(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	userIDs := []string{}
+	seen := make(map[string]bool)
+	for _, id := range strings.Split(c.Query("user_ids"), ",") {
+		id = strings.ToLower(strings.TrimSpace(id))
+		if id != "" && !services.IsUUID(id) {
+			respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("Invalid user ID %q", id))
+			return
+		}
+		if id != "" && !seen[id] {
+			seen[id] = true
+			userIDs = append(userIDs, id)
+		}
+	}
+	if len(userIDs) == 0 {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "user_ids is required (comma-separated)")
+		return
+	}
+	if len(userIDs) > maxNowPlayingGroup {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, fmt.Sprintf("At most %d user_ids per request", maxNowPlayingGroup))
+		return
+	}
+
+	sharers, err := h.preferencesService.NowPlayingSharers(c.Request.Context(), userID.(string), userIDs)
+	if err != nil {
+		log.Printf("Error checking now playing consent for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get now playing")
+		return
+	}
+
+	allowed := make([]string, 0, len(sharers))
+	for _, id := range userIDs {
+		if _, ok := sharers[id]; ok {
+			allowed = append(allowed, id)
+		}
+	}
+	group := h.trackingService.GetNowPlayingGroup(allowed)
+
+	users := make([]gin.H, 0, len(allowed))
+	unavailable := []string{}
+	for _, id := range userIDs {
+		displayName, ok := sharers[id]
+		if !ok {
+			unavailable = append(unavailable, id)
+			continue
+		}
+		users = append(users, gin.H{
+			"user_id":      id,
+			"display_name": displayName,
+			"now_playing":  group[id], // null quando não está ouvindo nada
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users":       users,
+		"unavailable": unavailable,
+	})
+}
+
 func (h *TrackingHandler) GetTrackingStatus(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"active_users": h.trackingService.GetActiveTrackingCount(),
@@ -114,7 +345,7 @@ func (h *TrackingHandler) GetTrackingStatus(c *gin.Context) {
 func (h *TrackingHandler) GetRecentListeningHistory(c *gin.Context) {
 	userID, exists := c.Get("userID")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		respondUnauthorized(c)
 		return
 	}
 
@@ -128,7 +359,7 @@ func (h *TrackingHandler) GetRecentListeningHistory(c *gin.Context) {
 func (h *TrackingHandler) ForceFullSync(c *gin.Context) {
 	userID := c.Param("userID")
 	if userID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "User ID is required"})
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "User ID is required")
 		return
 	}
 
@@ -137,7 +368,7 @@ func (h *TrackingHandler) ForceFullSync(c *gin.Context) {
 	err := h.trackingService.ForceFullSync(userID)
 	if err != nil {
 		log.Printf("Error during force full sync for user %s: %v", userID, err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sync tracks: " + err.Error()})
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to sync tracks: "+err.Error())
 		return
 	}
 
@@ -146,3 +377,162 @@ func (h *TrackingHandler) ForceFullSync(c *gin.Context) {
 		"user_id": userID,
 	})
 }
+
+func (h *TrackingHandler) AddManualPlay(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	var request struct {
+		TrackID    string `json:"track_id"`
+		Query      string `json:"query"` // alternativa ao track_id: busca no Spotify
+		PlayedAt   string `json:"played_at" binding:"required"`
+		DurationMs int64  `json:"duration_ms"` // opcional; padrão é a duração da faixa
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, err.Error())
+		return
+	}
+
+	playedAt, err := time.Parse(time.RFC3339, request.PlayedAt)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid played_at, expected RFC 3339")
+		return
+	}
+	playedAt = playedAt.UTC()
+
+	// Pequena tolerância para relógios de cliente adiantados
+	if playedAt.After(time.Now().Add(time.Minute)) {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "played_at cannot be in the future")
+		return
+	}
+
+	if request.DurationMs < 0 {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "duration_ms cannot be negative")
+		return
+	}
+
+	spotifyToken := c.GetHeader("Spotify-Token")
+	trackID := request.TrackID
+
+	if trackID == "" {
+		if request.Query == "" {
+			respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Either track_id or query is required")
+			return
+		}
+		if spotifyToken == "" {
+			respondSpotifyTokenRequired(c)
+			return
+		}
+
+		results, err := h.spotifyService.Search(&oauth2.Token{AccessToken: spotifyToken}, c.GetString("userID"), request.Query, "track", 5)
+		if err != nil {
+			log.Printf("Error searching track for manual play: %v", err)
+			respondSpotifyError(c, err, "Failed to search Spotify")
+			return
+		}
+
+		match := services.BestTrackMatch(results)
+		if match == nil {
+			// Sem um candidato confiável, o cliente escolhe e reenvia com track_id
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error":      "No confident match for query, choose one of the candidates",
+				"code":       ErrCodeAmbiguousMatch,
+				"candidates": results,
+			})
+			return
+		}
+		trackID = match.ID
+	}
+
+	play, err := h.trackingService.AddManualPlay(c.Request.Context(), userID.(string), spotifyToken, trackID, playedAt, request.DurationMs)
+	switch {
+	case errors.Is(err, services.ErrDuplicatePlay):
+		respondError(c, http.StatusConflict, ErrCodeDuplicate, "This play is already recorded")
+		return
+	case errors.Is(err, services.ErrTrackNotFound):
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Track not found")
+		return
+	case errors.Is(err, services.ErrSpotifyTokenRequired):
+		respondSpotifyTokenRequired(c)
+		return
+	case err != nil:
+		log.Printf("Error adding manual play for user %s: %v", userID, err)
+		var apiErr *services.SpotifyAPIError
+		if errors.As(err, &apiErr) {
+			respondSpotifyError(c, err, "Failed to fetch track from Spotify")
+			return
+		}
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to add play")
+		return
+	}
+
+	c.JSON(http.StatusCreated, play)
+}
+
+// Completa no Spotify as faixas/artistas do usuário que ficaram sem detalhes (ex.: imports feitos com enrich=false)
+func (h *TrackingHandler) EnrichCatalog(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	spotifyToken := c.GetHeader("Spotify-Token")
+	if spotifyToken == "" {
+		respondSpotifyTokenRequired(c)
+		return
+	}
+
+	result, err := h.trackingService.EnrichUserCatalog(c.Request.Context(), userID.(string), spotifyToken)
+	if services.IsSpotifyUnauthorized(err) {
+		respondSpotifyError(c, err, "Failed to enrich catalog")
+		return
+	}
+	if err != nil {
+		log.Printf("Error enriching catalog for user %s: %v", userID, err)
+		respondError(c, http.StatusInternalServerError, ErrCodeInternal, "Failed to enrich catalog")
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (h *TrackingHandler) EnrichArtist(c *gin.Context) {
+	if _, exists := c.Get("userID"); !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	spotifyToken := c.GetHeader("Spotify-Token")
+	if spotifyToken == "" {
+		respondSpotifyTokenRequired(c)
+		return
+	}
+
+	artistID := c.Param("id")
+	artist, err := h.trackingService.EnrichArtist(c.Request.Context(), artistID, spotifyToken)
+	if errors.Is(err, services.ErrArtistNotFound) {
+		respondError(c, http.StatusNotFound, ErrCodeNotFound, "Artist not found")
+		return
+	}
+	if errors.Is(err, services.ErrArtistNotEnrichable) {
+		respondError(c, http.StatusUnprocessableEntity, ErrCodeInvalidRequest, "Artist has no Spotify ID (imported by name) and cannot be enriched")
+		return
+	}
+	if err != nil {
+		log.Printf("Error enriching artist %s: %v", artistID, err)
+		respondSpotifyError(c, err, "Failed to enrich artist")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":         artist.ID,
+		"name":       artist.Name,
+		"genres":     artist.Genres,
+		"popularity": artist.Popularity,
+	})
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"musike-backend/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Faixas já escutadas dentro de uma faixa de tempo/energia (?min_tempo=120&max_tempo=&min_energy=&max_energy=),
+// a partir das audio features gravadas pelo enriquecimento
+func (h *AnalyticsHandler) GetWorkoutTracks(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		respondUnauthorized(c)
+		return
+	}
+
+	var filter services.WorkoutFilter
+	bounds := []struct {
+		name  string
+		value *float64
+		max   float64
+	}{
+		{"min_tempo", &filter.MinTempo, 300},
+		{"max_tempo", &filter.MaxTempo, 300},
+		{"min_energy", &filter.MinEnergy, 1},
+		{"max_energy", &filter.MaxEnergy, 1},
+	}
+	for _, bound := range bounds {
+		raw := c.Query(bound.name)
+		if raw == "" {
+			continue
+		}
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil || value < 0 || value > bound.max {
+			respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "Invalid "+bound.name+", expected a number between 0 and "+strconv.FormatFloat(bound.max, 'f', -1, 64))
+			return
+		}
+		*bound.value = value
+	}
+	if (filter.MaxTempo > 0 && filter.MinTempo > filter.MaxTempo) || (filter.MaxEnergy > 0 && filter.MinEnergy > filter.MaxEnergy) {
+		respondError(c, http.StatusBadRequest, ErrCodeInvalidRequest, "min must not be greater than max")
+		return
+	}
+
+	timeFilter := parseTimeFilter(c, "alltime") // 6months, 1year, alltime
+	limit := parseLimit(c, 50)
+
+	result, err := h.analyticsService.GetWorkoutTracks(c.Request.Context(), userID.(string), timeFilter, filter, limit)
+	if errors.Is(err, services.ErrAudioFeaturesMissing) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":    "Audio features not fetched yet, run POST /api/v1/user/enrich first",
+			"code":     ErrCodeAudioFeaturesMissing,
+			"coverage": result.Coverage,
+		})
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting workout tracks for user %s: %v", userID, err)
+		respondQueryError(c, err, "Failed to get workout tracks")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"tracks":      result.Tracks,
+		"coverage":    result.Coverage,
+		"time_filter": timeFilter,
+	})
+}
@@ -1,11 +1,13 @@
 package middleware
 
 import (
+	"crypto/subtle"
 	"fmt"
 	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"musike-backend/internal/apierror"
 	"musike-backend/internal/services"
 )
 
@@ -13,16 +15,14 @@ func Auth(authService *services.AuthService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
-			c.Abort()
+			apierror.Abort(c, http.StatusUnauthorized, apierror.ErrCodeUnauthorized, "Authorization header required")
 			return
 		}
 
 		tokenString := strings.Replace(authHeader, "Bearer ", "", 1)
 		userID, err := authService.ValidateToken(tokenString)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
-			c.Abort()
+			apierror.Abort(c, http.StatusUnauthorized, apierror.ErrCodeUnauthorized, "Invalid token")
 			return
 		}
 
@@ -31,9 +31,34 @@ func Auth(authService *services.AuthService) gin.HandlerFunc {
 	}
 }
 
+// Rotas administrativas exigem o header X-Admin-Token igual a ADMIN_API_TOKEN; sem token configurado ficam desativadas
+func AdminAuth(adminToken string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if adminToken == "" {
+			apierror.Abort(c, http.StatusNotFound, apierror.ErrCodeNotFound, "Admin API disabled")
+			return
+		}
+
+		provided := c.GetHeader("X-Admin-Token")
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(adminToken)) != 1 {
+			apierror.Abort(c, http.StatusUnauthorized, apierror.ErrCodeUnauthorized, "Invalid admin token")
+			return
+		}
+
+		c.Next()
+	}
+}
+
 func CORS() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
+		// Com credenciais o navegador não aceita "*": devolve a origem da requisição. Sem isso o cookie da
+		// vinculação de contas (POST /user/spotify-accounts/link) não é gravado pelo frontend em outra porta
+		if origin := c.GetHeader("Origin"); origin != "" {
+			c.Header("Access-Control-Allow-Origin", origin)
+			c.Header("Vary", "Origin")
+		} else {
+			c.Header("Access-Control-Allow-Origin", "*")
+		}
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
 		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Authorization, Spotify-Token")
 		c.Header("Access-Control-Allow-Credentials", "true")
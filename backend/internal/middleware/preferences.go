@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"log"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"musike-backend/internal/services"
+)
+
+const requestDefaultsKey = "requestDefaults"
+
+// Disponibiliza as preferências do usuário (fuso, time_filter e units padrão) para os handlers e o
+// DurationUnits. A consulta é feita uma vez por requisição, na primeira leitura. Vem depois do Auth
+func RequestDefaults(preferencesService *services.PreferencesService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetString("userID")
+		ctx := c.Request.Context()
+		c.Set(requestDefaultsKey, sync.OnceValue(func() *services.RequestDefaults {
+			defaults, err := preferencesService.RequestDefaults(ctx, userID)
+			if err != nil {
+				log.Printf("Warning: Failed to load preferences for user %s: %v", userID, err)
+				return &services.RequestDefaults{}
+			}
+			return defaults
+		}))
+		c.Next()
+	}
+}
+
+// Preferências do usuário da requisição; vazias fora das rotas com RequestDefaults
+func StoredDefaults(c *gin.Context) *services.RequestDefaults {
+	if load, ok := c.Value(requestDefaultsKey).(func() *services.RequestDefaults); ok {
+		return load()
+	}
+	return &services.RequestDefaults{}
+}
@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"musike-backend/internal/apierror"
+)
+
+// Divisores para converter campos *_ms
+var durationUnits = map[string]float64{
+	"minutes": 60000,
+	"hours":   3600000,
+}
+
+type bufferedWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bufferedWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *bufferedWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+// Com ?units=minutes|hours, cada campo "<nome>_ms" da resposta JSON ganha um irmão "<nome>_<units>"
+// em float (2 casas). Os campos em ms continuam na resposta. Sem o parâmetro vale a preferência units do
+// usuário, lida só quando a resposta tem algum campo em ms para converter; sem ela nada muda. Montado só nas
+// rotas que devolvem durações
+func DurationUnits() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		unit := c.Query("units")
+		if unit == "ms" {
+			c.Next()
+			return
+		}
+		if _, ok := durationUnits[unit]; unit != "" && !ok {
+			apierror.Abort(c, http.StatusBadRequest, apierror.ErrCodeInvalidRequest, "Invalid units. Use ms, minutes or hours")
+			return
+		}
+
+		writer := &bufferedWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+		c.Writer = writer.ResponseWriter
+
+		body := writer.body.Bytes()
+		if writer.Status() == http.StatusOK && strings.HasPrefix(writer.Header().Get("Content-Type"), "application/json") &&
+			bytes.Contains(body, []byte(`_ms"`)) {
+			if unit == "" {
+				unit = StoredDefaults(c).Units
+			}
+			if divisor, ok := durationUnits[unit]; ok {
+				if converted, err := convertDurations(body, unit, divisor); err == nil {
+					body = converted
+				}
+			}
+		}
+		writer.ResponseWriter.Write(body)
+	}
+}
+
+func convertDurations(body []byte, unit string, divisor float64) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber() // preserva inteiros grandes (ms, IDs numéricos) ao recodificar
+
+	var payload interface{}
+	if err := decoder.Decode(&payload); err != nil {
+		return nil, err
+	}
+
+	addDurationFields(payload, unit, divisor)
+	return json.Marshal(payload)
+}
+
+func addDurationFields(value interface{}, unit string, divisor float64) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		converted := make(map[string]float64)
+		for key, field := range v {
+			if number, ok := field.(json.Number); ok && strings.HasSuffix(key, "_ms") {
+				if ms, err := number.Float64(); err == nil {
+					converted[strings.TrimSuffix(key, "_ms")+"_"+unit] = math.Round(ms/divisor*100) / 100
+				}
+				continue
+			}
+			addDurationFields(field, unit, divisor)
+		}
+		for key, amount := range converted {
+			if _, exists := v[key]; !exists {
+				v[key] = amount
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			addDurationFields(item, unit, divisor)
+		}
+	}
+}
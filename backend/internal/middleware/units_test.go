@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"musike-backend/internal/apierror"
+	"musike-backend/internal/services"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// Rota com DurationUnits e preferências que contam quantas vezes foram lidas
+func newUnitsRouter(stored string, loads *int, body gin.H) *gin.Engine {
+	r := gin.New()
+	r.GET("/", func(c *gin.Context) {
+		c.Set(requestDefaultsKey, func() *services.RequestDefaults {
+			*loads++
+			return &services.RequestDefaults{Units: stored}
+		})
+	}, DurationUnits(), func(c *gin.Context) {
+		c.JSON(http.StatusOK, body)
+	})
+	return r
+}
+
+func serveUnits(r *gin.Engine, target string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+	var payload map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &payload)
+	return w, payload
+}
+
+func TestDurationUnitsRejectsUnknownUnit(t *testing.T) {
+	var loads int
+	w, payload := serveUnits(newUnitsRouter("", &loads, gin.H{"total_ms": 60000}), "/?units=seconds")
+
+	if w.Code != http.StatusBadRequest || payload["code"] != apierror.ErrCodeInvalidRequest {
+		t.Errorf("response = %d %v, want 400 %s", w.Code, payload, apierror.ErrCodeInvalidRequest)
+	}
+}
+
+func TestDurationUnitsLoadsPreferenceOnlyForDurations(t *testing.T) {
+	var loads int
+	_, payload := serveUnits(newUnitsRouter("minutes", &loads, gin.H{"plays": 3}), "/")
+	if loads != 0 {
+		t.Errorf("preferences loaded %d times for a response without *_ms fields", loads)
+	}
+	if _, exists := payload["plays"]; !exists {
+		t.Errorf("body = %v, want it unchanged", payload)
+	}
+
+	_, payload = serveUnits(newUnitsRouter("minutes", &loads, gin.H{"total_ms": 90000}), "/")
+	if loads != 1 || payload["total_minutes"] != 1.5 {
+		t.Errorf("loads = %d, body = %v; want 1 load and total_minutes 1.5", loads, payload)
+	}
+
+	// ?units= explícito dispensa a preferência
+	loads = 0
+	_, payload = serveUnits(newUnitsRouter("minutes", &loads, gin.H{"total_ms": 7200000}), "/?units=hours")
+	if loads != 0 || payload["total_hours"] != 2.0 {
+		t.Errorf("loads = %d, body = %v; want no load and total_hours 2", loads, payload)
+	}
+}
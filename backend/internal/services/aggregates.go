@@ -0,0 +1,249 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// *sql.DB ou *sql.Tx: quem grava dentro de uma transação passa a própria transação
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Gêneros distintos da faixa para o usuário $1 (uma escuta conta uma vez por gênero, mesmo com vários artistas)
+var trackGenresQuery = `SELECT DISTINCT g.genre
+		FROM track_artists ta
+		JOIN artists ar ON ar.id = ta.artist_id
+		CROSS JOIN LATERAL UNNEST(` + effectiveGenres("$1::uuid", "ar") + `) AS g(genre)
+		WHERE ta.track_id = $2`
+
+// Soma (delta 1) ou tira (delta -1) uma escuta dos agregados do usuário. Usuários ainda sem agregados são
+// ignorados: a primeira leitura do resumo reconstrói tudo a partir do histórico. Escutas excluídas pelo
+// usuário (artista ou gênero em user_exclusions) também ficam de fora, como na reconstrução. Roda sob
+// lockUserHistory: uma reconstrução em andamento termina antes, e a escuta ainda sem commit não se perde
+func applyPlayAggregates(ctx context.Context, tx *sql.Tx, userID, trackID, source string, listenedMs int64, delta int) error {
+	if err := lockUserHistory(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		UPDATE user_listening_totals
+		SET total_plays = total_plays + $2,
+			total_listened_ms = total_listened_ms + $2 * $3::bigint,
+			updated_at = NOW()
+		WHERE user_id = $1
+			AND EXISTS (
+				SELECT 1 FROM (SELECT $1::uuid AS user_id, $4::varchar AS track_id) lh
+				WHERE `+excludedPlayCondition+`
+			)
+	`, userID, delta, listenedMs, trackID)
+	if err != nil {
+		return fmt.Errorf("failed to update listening totals: %w", err)
+	}
+	if updated, _ := result.RowsAffected(); updated == 0 {
+		return nil
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO user_genre_plays (user_id, genre, plays)
+		SELECT $1, genre, $3 FROM (`+trackGenresQuery+`) genres
+		ON CONFLICT (user_id, genre) DO UPDATE SET plays = user_genre_plays.plays + EXCLUDED.plays
+	`, userID, trackID, delta)
+	if err != nil {
+		return fmt.Errorf("failed to update genre plays: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO user_source_plays (user_id, source, plays)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, source) DO UPDATE SET plays = user_source_plays.plays + EXCLUDED.plays
+	`, userID, source, delta)
+	if err != nil {
+		return fmt.Errorf("failed to update source plays: %w", err)
+	}
+	return nil
+}
+
+// Recalcula do zero os agregados do usuário a partir do histórico. Corrige o que os incrementos não
+// acompanham: gêneros que chegam depois pelo enriquecimento, correções de gênero, exclusões alteradas e
+// falhas no meio do caminho
+func RebuildListeningAggregates(ctx context.Context, db *sql.DB, userID string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Mesmo lock dos incrementos: sem ele, uma escuta gravada durante a leitura do histórico ficaria fora da
+	// contagem e o incremento dela seria sobrescrito pelo upsert
+	if err := lockUserHistory(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO user_listening_totals (user_id, total_plays, total_listened_ms, rebuilt_at, updated_at)
+		SELECT $1, COUNT(*), COALESCE(SUM(lh.listened_duration_ms), 0), NOW(), NOW()
+		FROM listening_history lh
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND `+excludedPlayCondition+`
+		ON CONFLICT (user_id) DO UPDATE SET
+			total_plays = EXCLUDED.total_plays,
+			total_listened_ms = EXCLUDED.total_listened_ms,
+			rebuilt_at = NOW(),
+			updated_at = NOW()
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to rebuild listening totals: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_genre_plays WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to clear genre plays: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO user_genre_plays (user_id, genre, plays)
+		SELECT $1, g.genre, COUNT(*)
+		FROM listening_history lh
+		CROSS JOIN LATERAL (
+			SELECT DISTINCT tg.genre
+			FROM track_artists ta
+			JOIN artists ar ON ar.id = ta.artist_id
+			CROSS JOIN LATERAL UNNEST(`+effectiveGenres("$1::uuid", "ar")+`) AS tg(genre)
+			WHERE ta.track_id = lh.track_id
+		) g
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND `+excludedPlayCondition+`
+		GROUP BY g.genre
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to rebuild genre plays: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_source_plays WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to clear source plays: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO user_source_plays (user_id, source, plays)
+		SELECT $1, lh.source, COUNT(*)
+		FROM listening_history lh
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND `+excludedPlayCondition+`
+		GROUP BY lh.source
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("failed to rebuild source plays: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+type GenrePlays struct {
+	Genre string  `json:"genre"`
+	Plays int64   `json:"plays"`
+	Share float64 `json:"share"` // fração das escutas com o gênero
+}
+
+type StatsSummary struct {
+	TotalPlays    int64            `json:"total_plays"`
+	TotalMinutes  float64          `json:"total_minutes"`
+	UniqueGenres  int              `json:"unique_genres"`
+	TopGenres     []GenrePlays     `json:"top_genres"`
+	PlaysBySource map[string]int64 `json:"plays_by_source"` // import, tracking, manual e scrobble, zerados quando não há
+	RebuiltAt     time.Time        `json:"rebuilt_at"`
+	UpdatedAt     time.Time        `json:"updated_at"`
+}
+
+// Totais de todo o histórico lidos dos agregados pré-calculados, sem varrer listening_history. Na primeira
+// leitura do usuário os agregados são montados a partir do histórico
+func (a *AnalyticsService) GetStatsSummary(ctx context.Context, userID string, genreLimit int) (*StatsSummary, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	summary := &StatsSummary{TopGenres: []GenrePlays{}}
+	var totalListenedMs int64
+	readTotals := func() error {
+		return a.db.QueryRowContext(ctx, `
+			SELECT total_plays, total_listened_ms, rebuilt_at, updated_at
+			FROM user_listening_totals
+			WHERE user_id = $1
+		`, userID).Scan(&summary.TotalPlays, &totalListenedMs, &summary.RebuiltAt, &summary.UpdatedAt)
+	}
+
+	err := readTotals()
+	if err == sql.ErrNoRows {
+		if err = RebuildListeningAggregates(ctx, a.db, userID); err != nil {
+			return nil, err
+		}
+		err = readTotals()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query listening totals: %w", err)
+	}
+	summary.TotalMinutes = roundMinutes(float64(totalListenedMs) / 60000)
+
+	err = a.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM user_genre_plays WHERE user_id = $1 AND plays > 0
+	`, userID).Scan(&summary.UniqueGenres)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count genre plays: %w", err)
+	}
+
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT genre, plays
+		FROM user_genre_plays
+		WHERE user_id = $1 AND plays > 0
+		ORDER BY plays DESC, genre
+		LIMIT $2
+	`, userID, genreLimit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query genre plays: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var genre GenrePlays
+		if err := rows.Scan(&genre.Genre, &genre.Plays); err != nil {
+			continue
+		}
+		if summary.TotalPlays > 0 {
+			genre.Share = roundFraction(float64(genre.Plays) / float64(summary.TotalPlays))
+		}
+		summary.TopGenres = append(summary.TopGenres, genre)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	summary.PlaysBySource, err = a.querySourcePlays(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return summary, nil
+}
+
+func (a *AnalyticsService) querySourcePlays(ctx context.Context, userID string) (map[string]int64, error) {
+	rows, err := a.db.QueryContext(ctx, `SELECT source, plays FROM user_source_plays WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query source plays: %w", err)
+	}
+	defer rows.Close()
+
+	bySource := make(map[string]int64, len(HistorySources))
+	for _, source := range HistorySources {
+		bySource[source] = 0
+	}
+	for rows.Next() {
+		var source string
+		var plays int64
+		if err := rows.Scan(&source, &plays); err != nil {
+			continue
+		}
+		bySource[source] = plays
+	}
+	return bySource, rows.Err()
+}
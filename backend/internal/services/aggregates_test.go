@@ -0,0 +1,154 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Faixa com um artista do gênero informado, com IDs únicos do teste
+func createTestTrack(t *testing.T, db *sql.DB, genre string) (trackID, artistID string) {
+	t.Helper()
+
+	suffix := time.Now().UnixNano()
+	trackID, artistID = fmt.Sprintf("track-%d", suffix), fmt.Sprintf("artist-%d", suffix)
+	for _, stmt := range []struct {
+		query string
+		args  []interface{}
+	}{
+		{`INSERT INTO artists (id, name, genres) VALUES ($1, 'Artist', ARRAY[$2])`, []interface{}{artistID, genre}},
+		{`INSERT INTO tracks (id, name, duration_ms) VALUES ($1, 'Track', 180000)`, []interface{}{trackID}},
+		{`INSERT INTO track_artists (track_id, artist_id) VALUES ($1, $2)`, []interface{}{trackID, artistID}},
+	} {
+		if _, err := db.Exec(stmt.query, stmt.args...); err != nil {
+			t.Fatalf("failed to create test track: %v", err)
+		}
+	}
+	t.Cleanup(func() {
+		db.Exec(`DELETE FROM tracks WHERE id = $1`, trackID)
+		db.Exec(`DELETE FROM artists WHERE id = $1`, artistID)
+	})
+	return trackID, artistID
+}
+
+// Grava a escuta e aplica o incremento, como o tracking faz na mesma transação
+func insertTestPlay(t *testing.T, db *sql.DB, userID, trackID, source string, playedAt time.Time) {
+	t.Helper()
+
+	ctx := context.Background()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO listening_history (user_id, track_id, played_at, listened_duration_ms, source)
+		VALUES ($1, $2, $3, 60000, $4)
+	`, userID, trackID, playedAt, source)
+	if err != nil {
+		t.Fatalf("failed to insert play: %v", err)
+	}
+	if err := applyPlayAggregates(ctx, tx, userID, trackID, source, 60000, 1); err != nil {
+		t.Fatalf("applyPlayAggregates: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("failed to commit play: %v", err)
+	}
+}
+
+func TestStatsSummaryIgnoresExcludedPlays(t *testing.T) {
+	db := openTestDB(t)
+	userID := createTestUser(t, db)
+	keptTrack, _ := createTestTrack(t, db, "rock")
+	excludedTrack, excludedArtist := createTestTrack(t, db, "pop")
+
+	a := NewAnalyticsService(testConfig(), db)
+	ctx := context.Background()
+	if _, err := a.SetExclusions(ctx, userID, Exclusions{ArtistIDs: []string{excludedArtist}}); err != nil {
+		t.Fatalf("SetExclusions: %v", err)
+	}
+
+	start := time.Now().UTC().Add(-time.Hour)
+	insertTestPlay(t, db, userID, keptTrack, "tracking", start)
+
+	// Primeira leitura reconstrói; as seguintes seguem os incrementos
+	if _, err := a.GetStatsSummary(ctx, userID, 10); err != nil {
+		t.Fatalf("GetStatsSummary: %v", err)
+	}
+	insertTestPlay(t, db, userID, keptTrack, "manual", start.Add(time.Minute))
+	insertTestPlay(t, db, userID, excludedTrack, "tracking", start.Add(2*time.Minute))
+
+	summary, err := a.GetStatsSummary(ctx, userID, 10)
+	if err != nil {
+		t.Fatalf("GetStatsSummary: %v", err)
+	}
+	if summary.TotalPlays != 2 || summary.UniqueGenres != 1 {
+		t.Errorf("summary = %d plays, %d genres; want 2 plays, 1 genre (excluded artist left out)", summary.TotalPlays, summary.UniqueGenres)
+	}
+	if summary.PlaysBySource["tracking"] != 1 || summary.PlaysBySource["manual"] != 1 || summary.PlaysBySource["import"] != 0 {
+		t.Errorf("plays_by_source = %v, want tracking 1, manual 1, import 0", summary.PlaysBySource)
+	}
+
+	// Sem a exclusão, a reconstrução volta a contar a escuta
+	if _, err := a.SetExclusions(ctx, userID, Exclusions{}); err != nil {
+		t.Fatalf("SetExclusions: %v", err)
+	}
+	summary, err = a.GetStatsSummary(ctx, userID, 10)
+	if err != nil {
+		t.Fatalf("GetStatsSummary: %v", err)
+	}
+	if summary.TotalPlays != 3 || summary.PlaysBySource["tracking"] != 2 {
+		t.Errorf("after clearing exclusions summary = %d plays, by source %v; want 3 plays, 2 tracking", summary.TotalPlays, summary.PlaysBySource)
+	}
+}
+
+// Reconstrução com uma escuta ainda sem commit: espera a transação da escuta e não perde o incremento
+func TestRebuildWaitsForPendingIncrement(t *testing.T) {
+	db := openTestDB(t)
+	userID := createTestUser(t, db)
+	trackID, _ := createTestTrack(t, db, "rock")
+	ctx := context.Background()
+
+	start := time.Now().UTC().Add(-time.Hour)
+	insertTestPlay(t, db, userID, trackID, "tracking", start)
+	if err := RebuildListeningAggregates(ctx, db, userID); err != nil {
+		t.Fatalf("RebuildListeningAggregates: %v", err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("failed to start transaction: %v", err)
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO listening_history (user_id, track_id, played_at, listened_duration_ms, source)
+		VALUES ($1, $2, $3, 60000, 'tracking')
+	`, userID, trackID, start.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("failed to insert play: %v", err)
+	}
+	if err := applyPlayAggregates(ctx, tx, userID, trackID, "tracking", 60000, 1); err != nil {
+		t.Fatalf("applyPlayAggregates: %v", err)
+	}
+
+	rebuilt := make(chan error, 1)
+	go func() { rebuilt <- RebuildListeningAggregates(ctx, db, userID) }()
+	time.Sleep(200 * time.Millisecond)
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("failed to commit play: %v", err)
+	}
+	if err := <-rebuilt; err != nil {
+		t.Fatalf("RebuildListeningAggregates: %v", err)
+	}
+
+	var totalPlays int64
+	if err := db.QueryRow(`SELECT total_plays FROM user_listening_totals WHERE user_id = $1`, userID).Scan(&totalPlays); err != nil {
+		t.Fatalf("failed to read totals: %v", err)
+	}
+	if totalPlays != 2 {
+		t.Errorf("total_plays = %d, want 2", totalPlays)
+	}
+}
@@ -1,17 +1,26 @@
 package services
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"log"
+	"sync/atomic"
 	"time"
 
+	"github.com/lib/pq"
 	"golang.org/x/oauth2"
+	"golang.org/x/sync/errgroup"
 	"musike-backend/internal/config"
 )
 
 type AnalyticsService struct {
 	config *config.Config
 	db     *sql.DB
+
+	// Cache em memória de /insights/global; duas requisições que encontram o cache vencido podem recalcular juntas
+	insights atomic.Pointer[GlobalInsights]
 }
 
 type UserAnalytics struct {
@@ -27,6 +36,9 @@ type UserAnalytics struct {
 	DiversityScore         float64               `json:"diversity_score"`
 	RecentActivity         []ActivityPoint       `json:"recent_activity"`
 	MonthlyStats           map[string]MonthStats `json:"monthly_stats"`
+	DegradedFields         []string              `json:"degraded_fields"`
+	PendingEnrichment      *EnrichmentStatus     `json:"pending_enrichment,omitempty"`
+	CachedAt               *time.Time            `json:"cached_at,omitempty"`
 }
 
 type GenreStats struct {
@@ -64,35 +76,104 @@ func NewAnalyticsService(cfg *config.Config, db *sql.DB) *AnalyticsService {
 	}
 }
 
-func (a *AnalyticsService) GenerateUserAnalytics(userID string, timeFilter string, spotifyService *SpotifyService, token *oauth2.Token) (*UserAnalytics, error) {
-	topTracks, err := spotifyService.GetTopTracks(token, "long_term", 50)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get top tracks: %w", err)
+// Contexto com o timeout configurado (DB_QUERY_TIMEOUT) para consultas ao banco
+func (a *AnalyticsService) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, a.config.DBQueryTimeout)
+}
+
+// Indica se o erro veio de uma consulta que excedeu o timeout ou foi cancelada
+func IsQueryTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
 	}
 
-	topArtists, err := spotifyService.GetTopArtists(token, "long_term", 50)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get top artists: %w", err)
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "57014" // query_canceled
+}
+
+func (a *AnalyticsService) GenerateUserAnalytics(ctx context.Context, userID string, timeFilter string, spotifyService *SpotifyService, token *oauth2.Token) (*UserAnalytics, error) {
+	// Buscar os dados do Spotify em paralelo para não somar as latências das três chamadas. Só o token expirado
+	// é fatal (o cliente precisa fazer refresh): ele cancela as outras chamadas em vez de esperar por elas. As
+	// demais falhas ficam registradas e o payload degrada
+	var (
+		topTracks      *TopTracksResponse
+		topArtists     *TopArtistsResponse
+		recentlyPlayed *RecentlyPlayedResponse
+		tracksErr      error
+		artistsErr     error
+		recentErr      error
+	)
+
+	group, spotifyCtx := errgroup.WithContext(ctx)
+	fatal := func(err error) error {
+		if IsSpotifyUnauthorized(err) {
+			return err
+		}
+		return nil
+	}
+	group.Go(func() error {
+		topTracks, tracksErr = spotifyService.GetTopTracks(spotifyCtx, token, userID, "long_term", 50)
+		return fatal(tracksErr)
+	})
+	group.Go(func() error {
+		topArtists, artistsErr = spotifyService.GetTopArtists(spotifyCtx, token, userID, "long_term", 50)
+		return fatal(artistsErr)
+	})
+	group.Go(func() error {
+		recentlyPlayed, recentErr = spotifyService.GetRecentlyPlayed(spotifyCtx, token, 50, 0, 0)
+		return fatal(recentErr)
+	})
+	if err := group.Wait(); err != nil {
+		return nil, err
 	}
 
-	recentlyPlayed, err := spotifyService.GetRecentlyPlayed(token, 50)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get recently played: %w", err)
+	// Falhas do Spotify não são fatais: o payload é calculado do banco sempre que possível
+	spotifyTracksOK, spotifyArtistsOK, spotifyRecentOK := true, true, true
+
+	if tracksErr != nil {
+		log.Printf("Warning: failed to get top tracks from Spotify, degrading analytics: %v", tracksErr)
+		topTracks = &TopTracksResponse{}
+		spotifyTracksOK = false
 	}
 
+	if artistsErr != nil {
+		log.Printf("Warning: failed to get top artists from Spotify, degrading analytics: %v", artistsErr)
+		topArtists = &TopArtistsResponse{}
+		spotifyArtistsOK = false
+	}
+
+	if recentErr != nil {
+		log.Printf("Warning: failed to get recently played from Spotify, degrading analytics: %v", recentErr)
+		recentlyPlayed = &RecentlyPlayedResponse{}
+		spotifyRecentOK = false
+	}
+
+	// Consulta que estourou o tempo vira erro (503) em vez de fallback: o banco está sobrecarregado e as
+	// próximas consultas falhariam do mesmo jeito, com o resultado parecendo completo
+	var err error
 	analytics := &UserAnalytics{
-		UserID: userID,
+		UserID:         userID,
+		DegradedFields: []string{},
 	}
 
 	// Usar dados do banco local para tempo total baseado no filtro
-	analytics.TotalListeningTime, err = a.calculateTotalListeningTimeFromDB(userID, timeFilter)
+	analytics.TotalListeningTime, err = a.calculateTotalListeningTimeFromDB(ctx, userID, timeFilter)
+	if IsQueryTimeout(err) {
+		return nil, err
+	}
 	if err != nil {
 		// Fallback para cálculo baseado na API do Spotify se erro no banco
 		analytics.TotalListeningTime = a.calculateTotalListeningTime(topTracks.Items)
+		if !spotifyTracksOK {
+			analytics.DegradedFields = append(analytics.DegradedFields, "total_listening_time_ms")
+		}
 	}
 
 	// Calcular tempo de escuta real e porcentagem média
-	analytics.ActualListeningTime, analytics.AvgListeningPercentage, err = a.calculateActualListeningStats(userID, timeFilter)
+	analytics.ActualListeningTime, analytics.AvgListeningPercentage, err = a.calculateActualListeningStats(ctx, userID, timeFilter)
+	if IsQueryTimeout(err) {
+		return nil, err
+	}
 	if err != nil {
 		// Se não houver dados de tempo de escuta real, usar o tempo total como fallback
 		analytics.ActualListeningTime = analytics.TotalListeningTime
@@ -100,54 +181,102 @@ func (a *AnalyticsService) GenerateUserAnalytics(userID string, timeFilter strin
 	}
 
 	// Calcular total de plays
-	analytics.TotalPlays, err = a.calculateTotalPlays(userID, timeFilter)
+	analytics.TotalPlays, err = a.calculateTotalPlays(ctx, userID, timeFilter)
+	if IsQueryTimeout(err) {
+		return nil, err
+	}
 	if err != nil {
 		// Fallback para 0 se não conseguir calcular
 		analytics.TotalPlays = 0
 	}
 
 	// Calcular tempo médio de play
-	analytics.AveragePlayTime, err = a.calculateAveragePlayTime(userID, timeFilter)
+	analytics.AveragePlayTime, err = a.calculateAveragePlayTime(ctx, userID, timeFilter)
+	if IsQueryTimeout(err) {
+		return nil, err
+	}
 	if err != nil {
 		// Fallback para 0 se não conseguir calcular
 		analytics.AveragePlayTime = 0
 	}
 
 	// Calcular popularidade média das tracks
-	analytics.AverageTrackPopularity, err = a.calculateAverageTrackPopularity(userID, timeFilter)
+	analytics.AverageTrackPopularity, err = a.calculateAverageTrackPopularity(ctx, userID, timeFilter)
+	if IsQueryTimeout(err) {
+		return nil, err
+	}
 	if err != nil {
 		// Fallback para 0 se não conseguir calcular
 		analytics.AverageTrackPopularity = 0.0
 	}
 
 	// Calcular top gêneros do banco de dados local baseado no filtro
-	analytics.TopGenres, err = a.analyzeGenresFromDB(userID, timeFilter)
+	analytics.TopGenres, err = a.analyzeGenresFromDB(ctx, userID, timeFilter)
+	if IsQueryTimeout(err) {
+		return nil, err
+	}
 	if err != nil {
 		// Fallback para análise baseada na API do Spotify se erro no banco
 		analytics.TopGenres = a.analyzeGenres(topArtists.Items)
+		if !spotifyArtistsOK {
+			analytics.DegradedFields = append(analytics.DegradedFields, "top_genres")
+		}
 	}
 
 	// Usar dados do banco local para padrões de escuta baseado no filtro
-	analytics.ListeningPatterns, err = a.analyzeListeningPatternsFromDB(userID, timeFilter)
+	analytics.ListeningPatterns, err = a.analyzeListeningPatternsFromDB(ctx, userID, timeFilter)
+	if IsQueryTimeout(err) {
+		return nil, err
+	}
 	if err != nil {
 		// Fallback para análise baseada na API do Spotify se erro no banco
 		analytics.ListeningPatterns = a.analyzeListeningPatterns(recentlyPlayed.Items)
+		if !spotifyRecentOK {
+			analytics.DegradedFields = append(analytics.DegradedFields, "listening_patterns")
+		}
 	}
 
-	analytics.DiversityScore = a.calculateDiversityScore(topArtists.Items, topTracks.Items)
+	if spotifyArtistsOK {
+		analytics.DiversityScore = a.calculateDiversityScore(topArtists.Items, topTracks.Items)
+	} else {
+		// Sem top artists do Spotify, calcular a diversidade a partir do histórico local
+		analytics.DiversityScore, err = a.calculateDiversityScoreFromDB(ctx, userID, timeFilter)
+		if IsQueryTimeout(err) {
+			return nil, err
+		}
+		if err != nil {
+			analytics.DiversityScore = 0
+		}
+		analytics.DegradedFields = append(analytics.DegradedFields, "diversity_score")
+	}
 
 	// Usar dados do banco local para atividade recente baseado no filtro
-	analytics.RecentActivity, err = a.analyzeRecentActivityFromDB(userID, timeFilter)
+	analytics.RecentActivity, err = a.analyzeRecentActivityFromDB(ctx, userID, timeFilter)
+	if IsQueryTimeout(err) {
+		return nil, err
+	}
 	if err != nil {
 		// Fallback para análise baseada na API do Spotify se erro no banco
 		fmt.Printf("Erro ao buscar atividade recente do DB: %v, usando fallback da API\n", err)
 		analytics.RecentActivity = a.analyzeRecentActivity(recentlyPlayed.Items)
+		if !spotifyRecentOK {
+			analytics.DegradedFields = append(analytics.DegradedFields, "recent_activity")
+		}
 	} else {
 		fmt.Printf("Atividade recente do DB retornou %d dias de dados\n", len(analytics.RecentActivity))
 	}
 
 	analytics.MonthlyStats = a.generateMonthlyStats()
 
+	// Quantas faixas/artistas ainda aguardam o POST /user/enrich
+	analytics.PendingEnrichment, err = a.GetEnrichmentStatus(ctx, userID)
+	if IsQueryTimeout(err) {
+		return nil, err
+	}
+	if err != nil {
+		log.Printf("Warning: failed to count entities pending enrichment: %v", err)
+	}
+
 	return analytics, nil
 }
 
@@ -159,11 +288,14 @@ func (a *AnalyticsService) calculateTotalListeningTime(tracks []SpotifyTrack) in
 	return total
 }
 
-func (a *AnalyticsService) calculateTotalListeningTimeFromDB(userID string, timeFilter string) (int64, error) {
+func (a *AnalyticsService) calculateTotalListeningTimeFromDB(ctx context.Context, userID string, timeFilter string) (int64, error) {
 	if a.db == nil {
 		return 0, fmt.Errorf("database not available")
 	}
 
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
 	// Determinar o período de filtro baseado no parâmetro
 	var startDate time.Time
 	now := time.Now()
@@ -194,7 +326,7 @@ func (a *AnalyticsService) calculateTotalListeningTimeFromDB(userID string, time
 			), 0) as total_time
 			FROM listening_history lh
 			JOIN tracks t ON lh.track_id = t.id
-			WHERE lh.user_id = $1`
+			WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND ` + excludedPlayCondition
 		args = []interface{}{userID}
 	} else {
 		// Com filtro de data para outros filtros
@@ -207,12 +339,12 @@ func (a *AnalyticsService) calculateTotalListeningTimeFromDB(userID string, time
 			), 0) as total_time
 			FROM listening_history lh
 			JOIN tracks t ON lh.track_id = t.id
-			WHERE lh.user_id = $1 AND lh.played_at >= $2`
+			WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND ` + excludedPlayCondition + ` AND lh.played_at >= $2`
 		args = []interface{}{userID, startDate}
 	}
 
 	var totalTime int64
-	err := a.db.QueryRow(query, args...).Scan(&totalTime)
+	err := a.db.QueryRowContext(ctx, query, args...).Scan(&totalTime)
 	if err != nil {
 		return 0, fmt.Errorf("failed to calculate total listening time: %w", err)
 	}
@@ -220,11 +352,14 @@ func (a *AnalyticsService) calculateTotalListeningTimeFromDB(userID string, time
 	return totalTime, nil
 }
 
-func (a *AnalyticsService) calculateActualListeningStats(userID string, timeFilter string) (int64, float64, error) {
+func (a *AnalyticsService) calculateActualListeningStats(ctx context.Context, userID string, timeFilter string) (int64, float64, error) {
 	if a.db == nil {
 		return 0, 0.0, fmt.Errorf("database not available")
 	}
 
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
 	// Determinar o período de filtro baseado no parâmetro
 	var startDate time.Time
 	now := time.Now()
@@ -250,7 +385,7 @@ func (a *AnalyticsService) calculateActualListeningStats(userID string, timeFilt
 				COALESCE(AVG(lh.listening_percentage), 0) as avg_percentage,
 				COUNT(*) as total_tracks
 			FROM listening_history lh
-			WHERE lh.user_id = $1 AND lh.listened_duration_ms > 0`
+			WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND ` + excludedPlayCondition + ` AND lh.listened_duration_ms > 0`
 		args = []interface{}{userID}
 	} else {
 		query = `
@@ -259,7 +394,7 @@ func (a *AnalyticsService) calculateActualListeningStats(userID string, timeFilt
 				COALESCE(AVG(lh.listening_percentage), 0) as avg_percentage,
 				COUNT(*) as total_tracks
 			FROM listening_history lh
-			WHERE lh.user_id = $1 AND lh.played_at >= $2 AND lh.listened_duration_ms > 0`
+			WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND ` + excludedPlayCondition + ` AND lh.played_at >= $2 AND lh.listened_duration_ms > 0`
 		args = []interface{}{userID, startDate}
 	}
 
@@ -267,7 +402,7 @@ func (a *AnalyticsService) calculateActualListeningStats(userID string, timeFilt
 	var avgPercentage float64
 	var totalTracks int
 
-	err := a.db.QueryRow(query, args...).Scan(&actualTime, &avgPercentage, &totalTracks)
+	err := a.db.QueryRowContext(ctx, query, args...).Scan(&actualTime, &avgPercentage, &totalTracks)
 	if err != nil {
 		return 0, 0.0, fmt.Errorf("failed to calculate actual listening stats: %w", err)
 	}
@@ -280,11 +415,14 @@ func (a *AnalyticsService) calculateActualListeningStats(userID string, timeFilt
 	return actualTime, avgPercentage, nil
 }
 
-func (a *AnalyticsService) calculateTotalPlays(userID string, timeFilter string) (int, error) {
+func (a *AnalyticsService) calculateTotalPlays(ctx context.Context, userID string, timeFilter string) (int, error) {
 	if a.db == nil {
 		return 0, fmt.Errorf("database not available")
 	}
 
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
 	// Determinar o período de filtro baseado no parâmetro
 	var startDate time.Time
 	now := time.Now()
@@ -307,18 +445,18 @@ func (a *AnalyticsService) calculateTotalPlays(userID string, timeFilter string)
 		query = `
 			SELECT COUNT(*) as total_plays
 			FROM listening_history lh
-			WHERE lh.user_id = $1`
+			WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND ` + excludedPlayCondition
 		args = []interface{}{userID}
 	} else {
 		query = `
 			SELECT COUNT(*) as total_plays
 			FROM listening_history lh
-			WHERE lh.user_id = $1 AND lh.played_at >= $2`
+			WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND ` + excludedPlayCondition + ` AND lh.played_at >= $2`
 		args = []interface{}{userID, startDate}
 	}
 
 	var totalPlays int
-	err := a.db.QueryRow(query, args...).Scan(&totalPlays)
+	err := a.db.QueryRowContext(ctx, query, args...).Scan(&totalPlays)
 	if err != nil {
 		return 0, fmt.Errorf("failed to calculate total plays: %w", err)
 	}
@@ -326,11 +464,14 @@ func (a *AnalyticsService) calculateTotalPlays(userID string, timeFilter string)
 	return totalPlays, nil
 }
 
-func (a *AnalyticsService) calculateAveragePlayTime(userID string, timeFilter string) (int64, error) {
+func (a *AnalyticsService) calculateAveragePlayTime(ctx context.Context, userID string, timeFilter string) (int64, error) {
 	if a.db == nil {
 		return 0, fmt.Errorf("database not available")
 	}
 
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
 	// Determinar o período de filtro baseado no parâmetro
 	var startDate time.Time
 	now := time.Now()
@@ -353,18 +494,18 @@ func (a *AnalyticsService) calculateAveragePlayTime(userID string, timeFilter st
 		query = `
 			SELECT COALESCE(AVG(lh.listened_duration_ms), 0) as avg_play_time
 			FROM listening_history lh
-			WHERE lh.user_id = $1 AND lh.listened_duration_ms > 0`
+			WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND ` + excludedPlayCondition + ` AND lh.listened_duration_ms > 0`
 		args = []interface{}{userID}
 	} else {
 		query = `
 			SELECT COALESCE(AVG(lh.listened_duration_ms), 0) as avg_play_time
 			FROM listening_history lh
-			WHERE lh.user_id = $1 AND lh.played_at >= $2 AND lh.listened_duration_ms > 0`
+			WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND ` + excludedPlayCondition + ` AND lh.played_at >= $2 AND lh.listened_duration_ms > 0`
 		args = []interface{}{userID, startDate}
 	}
 
 	var avgPlayTime float64
-	err := a.db.QueryRow(query, args...).Scan(&avgPlayTime)
+	err := a.db.QueryRowContext(ctx, query, args...).Scan(&avgPlayTime)
 	if err != nil {
 		return 0, fmt.Errorf("failed to calculate average play time: %w", err)
 	}
@@ -372,11 +513,14 @@ func (a *AnalyticsService) calculateAveragePlayTime(userID string, timeFilter st
 	return int64(avgPlayTime), nil
 }
 
-func (a *AnalyticsService) calculateAverageTrackPopularity(userID string, timeFilter string) (float64, error) {
+func (a *AnalyticsService) calculateAverageTrackPopularity(ctx context.Context, userID string, timeFilter string) (float64, error) {
 	if a.db == nil {
 		return 0.0, fmt.Errorf("database not available")
 	}
 
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
 	// Determinar o período de filtro baseado no parâmetro
 	var startDate time.Time
 	now := time.Now()
@@ -400,19 +544,19 @@ func (a *AnalyticsService) calculateAverageTrackPopularity(userID string, timeFi
 			SELECT COALESCE(AVG(t.popularity), 0) as avg_popularity
 			FROM listening_history lh
 			JOIN tracks t ON lh.track_id = t.id
-			WHERE lh.user_id = $1 AND t.popularity > 0`
+			WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND ` + excludedPlayCondition + ` AND t.popularity > 0`
 		args = []interface{}{userID}
 	} else {
 		query = `
 			SELECT COALESCE(AVG(t.popularity), 0) as avg_popularity
 			FROM listening_history lh
 			JOIN tracks t ON lh.track_id = t.id
-			WHERE lh.user_id = $1 AND lh.played_at >= $2 AND t.popularity > 0`
+			WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND ` + excludedPlayCondition + ` AND lh.played_at >= $2 AND t.popularity > 0`
 		args = []interface{}{userID, startDate}
 	}
 
 	var avgPopularity float64
-	err := a.db.QueryRow(query, args...).Scan(&avgPopularity)
+	err := a.db.QueryRowContext(ctx, query, args...).Scan(&avgPopularity)
 	if err != nil {
 		return 0.0, fmt.Errorf("failed to calculate average track popularity: %w", err)
 	}
@@ -420,62 +564,32 @@ func (a *AnalyticsService) calculateAverageTrackPopularity(userID string, timeFi
 	return avgPopularity, nil
 }
 
-func (a *AnalyticsService) analyzeGenresFromDB(userID string, timeFilter string) ([]GenreStats, error) {
+func (a *AnalyticsService) analyzeGenresFromDB(ctx context.Context, userID string, timeFilter string) ([]GenreStats, error) {
 	if a.db == nil {
 		return nil, fmt.Errorf("database not available")
 	}
 
-	// Determinar o período de filtro baseado no parâmetro
-	var startDate time.Time
-	now := time.Now()
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
 
-	switch timeFilter {
-	case "6months":
-		startDate = now.AddDate(0, -6, 0)
-	case "1year":
-		startDate = now.AddDate(-1, 0, 0)
-	case "alltime":
-		startDate = time.Time{} // Data zero = sem filtro
-	default:
-		startDate = now.AddDate(0, -6, 0) // Default 6 meses
-	}
-
-	var query string
-	var args []interface{}
-
-	if timeFilter == "alltime" {
-		query = `
-			SELECT 
-				UNNEST(string_to_array(TRIM(BOTH '[]"' FROM a.genres::text), '","')) as genre,
-				COUNT(*) as play_count,
-				COUNT(DISTINCT t.id) as track_count,
-				COALESCE(SUM(lh.listened_duration_ms), 0) as total_time
-			FROM listening_history lh
-			JOIN tracks t ON lh.track_id = t.id
-			JOIN artists a ON t.artist_id = a.id
-			WHERE lh.user_id = $1 AND a.genres IS NOT NULL AND a.genres != '[]'
-			GROUP BY genre
-			ORDER BY play_count DESC
-			LIMIT 10`
-		args = []interface{}{userID}
-	} else {
-		query = `
-			SELECT 
-				UNNEST(string_to_array(TRIM(BOTH '[]"' FROM a.genres::text), '","')) as genre,
-				COUNT(*) as play_count,
-				COUNT(DISTINCT t.id) as track_count,
-				COALESCE(SUM(lh.listened_duration_ms), 0) as total_time
-			FROM listening_history lh
-			JOIN tracks t ON lh.track_id = t.id
-			JOIN artists a ON t.artist_id = a.id
-			WHERE lh.user_id = $1 AND lh.played_at >= $2 AND a.genres IS NOT NULL AND a.genres != '[]'
-			GROUP BY genre
-			ORDER BY play_count DESC
-			LIMIT 10`
-		args = []interface{}{userID, startDate}
-	}
-
-	rows, err := a.db.Query(query, args...)
+	// Gêneros vêm de track_artists, com a correção manual do usuário valendo sobre os do Spotify
+	query := `
+		SELECT
+			g.genre,
+			COUNT(*) as play_count,
+			COUNT(DISTINCT lh.track_id) as track_count,
+			COALESCE(SUM(lh.listened_duration_ms), 0) as total_time
+		FROM listening_history lh
+		JOIN track_artists ta ON ta.track_id = lh.track_id
+		JOIN artists ar ON ar.id = ta.artist_id
+		CROSS JOIN LATERAL UNNEST(` + effectiveGenres("lh.user_id", "ar") + `) AS g(genre)
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND ` + excludedPlayCondition + ` AND lh.played_at >= $2
+		GROUP BY g.genre
+		ORDER BY play_count DESC, g.genre
+		LIMIT 10`
+	args := []interface{}{userID, timeFilterStartDate(timeFilter)}
+
+	rows, err := a.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query genres from database: %w", err)
 	}
@@ -511,11 +625,14 @@ func (a *AnalyticsService) analyzeGenresFromDB(userID string, timeFilter string)
 	return genreStats, nil
 }
 
-func (a *AnalyticsService) analyzeListeningPatternsFromDB(userID string, timeFilter string) (ListeningPatterns, error) {
+func (a *AnalyticsService) analyzeListeningPatternsFromDB(ctx context.Context, userID string, timeFilter string) (ListeningPatterns, error) {
 	if a.db == nil {
 		return ListeningPatterns{}, fmt.Errorf("database not available")
 	}
 
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
 	// Determinar o período de filtro baseado no parâmetro
 	var startDate time.Time
 	now := time.Now()
@@ -542,7 +659,7 @@ func (a *AnalyticsService) analyzeListeningPatternsFromDB(userID string, timeFil
 				EXTRACT(DOW FROM lh.played_at) as weekday,
 				COUNT(*) as count
 			FROM listening_history lh
-			WHERE lh.user_id = $1
+			WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND ` + excludedPlayCondition + `
 			GROUP BY EXTRACT(HOUR FROM lh.played_at), EXTRACT(DOW FROM lh.played_at)
 			ORDER BY hour, weekday`
 		args = []interface{}{userID}
@@ -554,13 +671,13 @@ func (a *AnalyticsService) analyzeListeningPatternsFromDB(userID string, timeFil
 				EXTRACT(DOW FROM lh.played_at) as weekday,
 				COUNT(*) as count
 			FROM listening_history lh
-			WHERE lh.user_id = $1 AND lh.played_at >= $2
+			WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND ` + excludedPlayCondition + ` AND lh.played_at >= $2
 			GROUP BY EXTRACT(HOUR FROM lh.played_at), EXTRACT(DOW FROM lh.played_at)
 			ORDER BY hour, weekday`
 		args = []interface{}{userID, startDate}
 	}
 
-	rows, err := a.db.Query(query, args...)
+	rows, err := a.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return ListeningPatterns{}, fmt.Errorf("failed to query listening patterns: %w", err)
 	}
@@ -712,8 +829,48 @@ func (a *AnalyticsService) calculateDiversityScore(artists []SpotifyArtist, trac
 		}
 	}
 
-	genreScore := float64(len(uniqueGenres)) / 10.0   // Normalizado para 10 gêneros
-	artistScore := float64(len(uniqueArtists)) / 50.0 // Normalizado para 50 artistas
+	return diversityScore(len(uniqueGenres), len(uniqueArtists))
+}
+
+func (a *AnalyticsService) calculateDiversityScoreFromDB(ctx context.Context, userID string, timeFilter string) (float64, error) {
+	if a.db == nil {
+		return 0, fmt.Errorf("database not available")
+	}
+
+	uniqueGenres, uniqueArtists, err := a.countUniqueGenresAndArtists(ctx, userID, timeFilterStartDate(timeFilter), time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	return diversityScore(uniqueGenres, uniqueArtists), nil
+}
+
+func (a *AnalyticsService) countUniqueGenresAndArtists(ctx context.Context, userID string, from, to time.Time) (int, int, error) {
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	query := `
+		SELECT
+			COUNT(DISTINCT g.genre) as unique_genres,
+			COUNT(DISTINCT ta.artist_id) as unique_artists
+		FROM listening_history lh
+		JOIN track_artists ta ON ta.track_id = lh.track_id
+		JOIN artists ar ON ar.id = ta.artist_id
+		LEFT JOIN LATERAL UNNEST(` + effectiveGenres("lh.user_id", "ar") + `) AS g(genre) ON TRUE
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND ` + excludedPlayCondition + ` AND lh.played_at >= $2 AND lh.played_at < $3`
+
+	var uniqueGenres, uniqueArtists int
+	err := a.db.QueryRowContext(ctx, query, userID, from, to).Scan(&uniqueGenres, &uniqueArtists)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to count unique genres and artists: %w", err)
+	}
+
+	return uniqueGenres, uniqueArtists, nil
+}
+
+func diversityScore(uniqueGenres, uniqueArtists int) float64 {
+	genreScore := float64(uniqueGenres) / 10.0   // Normalizado para 10 gêneros
+	artistScore := float64(uniqueArtists) / 50.0 // Normalizado para 50 artistas
 
 	if genreScore > 1.0 {
 		genreScore = 1.0
@@ -725,11 +882,30 @@ func (a *AnalyticsService) calculateDiversityScore(artists []SpotifyArtist, trac
 	return (genreScore + artistScore) / 2.0 * 100 // 0-100 score
 }
 
-func (a *AnalyticsService) analyzeRecentActivityFromDB(userID string, timeFilter string) ([]ActivityPoint, error) {
+// Data inicial correspondente ao filtro de tempo (6months, 1year, alltime)
+func timeFilterStartDate(timeFilter string) time.Time {
+	now := time.Now()
+
+	switch timeFilter {
+	case "6months":
+		return now.AddDate(0, -6, 0)
+	case "1year":
+		return now.AddDate(-1, 0, 0)
+	case "alltime":
+		return time.Time{} // Data zero = sem filtro
+	default:
+		return now.AddDate(0, -6, 0) // Default 6 meses
+	}
+}
+
+func (a *AnalyticsService) analyzeRecentActivityFromDB(ctx context.Context, userID string, timeFilter string) ([]ActivityPoint, error) {
 	if a.db == nil {
 		return nil, fmt.Errorf("database not available")
 	}
 
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
 	// Para atividade recente, sempre mostrar os últimos 7 dias independente do filtro
 	now := time.Now()
 
@@ -755,8 +931,8 @@ func (a *AnalyticsService) analyzeRecentActivityFromDB(userID string, timeFilter
 
 	// Primeiro, verificar se há dados na tabela
 	var totalRows int
-	countQuery := `SELECT COUNT(*) FROM listening_history WHERE user_id = $1`
-	countErr := a.db.QueryRow(countQuery, userID).Scan(&totalRows)
+	countQuery := `SELECT COUNT(*) FROM listening_history WHERE user_id = $1 AND deleted_at IS NULL`
+	countErr := a.db.QueryRowContext(ctx, countQuery, userID).Scan(&totalRows)
 	if countErr != nil {
 		fmt.Printf("Erro ao contar registros: %v\n", countErr)
 	} else {
@@ -770,11 +946,11 @@ func (a *AnalyticsService) analyzeRecentActivityFromDB(userID string, timeFilter
 			COUNT(DISTINCT lh.track_id) as unique_tracks,
 			COALESCE(SUM(lh.listened_duration_ms), 0) as duration_ms
 		FROM listening_history lh
-		WHERE lh.user_id = $1 AND lh.played_at >= $2
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND ` + excludedPlayCondition + ` AND lh.played_at >= $2
 		GROUP BY TO_CHAR(lh.played_at, 'YYYY-MM-DD')
 		ORDER BY date DESC`
 
-	rows, err := a.db.Query(query, userID, startDate)
+	rows, err := a.db.QueryContext(ctx, query, userID, startDate)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query recent activity: %w", err)
 	}
@@ -0,0 +1,214 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type ArtistTrackStats struct {
+	TrackID      string    `json:"track_id"`
+	TrackName    string    `json:"track_name"`
+	PlayCount    int       `json:"play_count"`
+	TotalTime    int64     `json:"total_time_ms"`
+	LastPlayedAt time.Time `json:"last_played_at"`
+}
+
+type ArtistTopTracks struct {
+	ArtistID   string             `json:"artist_id"`
+	ArtistName string             `json:"artist_name"`
+	Tracks     []ArtistTrackStats `json:"tracks"`
+}
+
+func (a *AnalyticsService) GetArtistTopTracks(ctx context.Context, userID, artistID, timeFilter string, limit int) (*ArtistTopTracks, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	result := &ArtistTopTracks{ArtistID: artistID, Tracks: make([]ArtistTrackStats, 0)}
+
+	err := a.db.QueryRowContext(ctx, `SELECT name FROM artists WHERE id = $1`, artistID).Scan(&result.ArtistName)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query artist: %w", err)
+	}
+
+	query := `
+		SELECT
+			t.id,
+			t.name,
+			COUNT(*) as play_count,
+			COALESCE(SUM(lh.listened_duration_ms), 0) as total_time,
+			MAX(lh.played_at) as last_played_at
+		FROM listening_history lh
+		JOIN tracks t ON lh.track_id = t.id
+		JOIN track_artists ta ON ta.track_id = t.id
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND ta.artist_id = $2 AND lh.played_at >= $3
+		GROUP BY t.id, t.name
+		ORDER BY play_count DESC, last_played_at DESC
+		LIMIT $4`
+
+	rows, err := a.db.QueryContext(ctx, query, userID, artistID, timeFilterStartDate(timeFilter), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query artist top tracks: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var track ArtistTrackStats
+		if err := rows.Scan(&track.TrackID, &track.TrackName, &track.PlayCount, &track.TotalTime, &track.LastPlayedAt); err != nil {
+			continue
+		}
+		result.Tracks = append(result.Tracks, track)
+	}
+
+	return result, nil
+}
+
+type EnrichedArtist struct {
+	Rank         int       `json:"rank"`
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	Genres       []string  `json:"genres"`
+	Popularity   *int      `json:"popularity"`
+	ImageURL     string    `json:"image_url,omitempty"`
+	PlayCount    int       `json:"play_count"`
+	TotalTime    int64     `json:"total_time_ms"`
+	UniqueTracks int       `json:"unique_tracks"`
+	LastPlayedAt time.Time `json:"last_played_at"`
+}
+
+// Top artistas calculados a partir do histórico local (não do Spotify), com os dados gravados na tabela artists
+func (a *AnalyticsService) GetEnrichedTopArtists(ctx context.Context, userID, timeFilter string, limit, offset int) ([]EnrichedArtist, int, error) {
+	if a.db == nil {
+		return nil, 0, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	startDate := timeFilterStartDate(timeFilter)
+
+	var total int
+	err := a.db.QueryRowContext(ctx, `
+		SELECT COUNT(DISTINCT ta.artist_id)
+		FROM listening_history lh
+		JOIN track_artists ta ON ta.track_id = lh.track_id
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2
+	`, userID, startDate).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count top artists: %w", err)
+	}
+
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT
+			ar.id,
+			ar.name,
+			`+effectiveGenres("$1", "ar")+` as genres,
+			ar.popularity,
+			COALESCE(ar.image_url, '') as image_url,
+			COUNT(*) as play_count,
+			COALESCE(SUM(lh.listened_duration_ms), 0) as total_time,
+			COUNT(DISTINCT lh.track_id) as unique_tracks,
+			MAX(lh.played_at) as last_played_at
+		FROM listening_history lh
+		JOIN track_artists ta ON ta.track_id = lh.track_id
+		JOIN artists ar ON ar.id = ta.artist_id
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2
+		GROUP BY ar.id, ar.name, ar.genres, ar.popularity, ar.image_url
+		ORDER BY play_count DESC, total_time DESC, ar.id
+		LIMIT $3 OFFSET $4
+	`, userID, startDate, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query top artists: %w", err)
+	}
+	defer rows.Close()
+
+	artists := make([]EnrichedArtist, 0)
+	for rows.Next() {
+		var artist EnrichedArtist
+		var genres pq.StringArray
+		var popularity sql.NullInt64
+		if err := rows.Scan(&artist.ID, &artist.Name, &genres, &popularity, &artist.ImageURL,
+			&artist.PlayCount, &artist.TotalTime, &artist.UniqueTracks, &artist.LastPlayedAt); err != nil {
+			continue
+		}
+		artist.Rank = offset + len(artists) + 1
+		artist.Genres = []string(genres)
+		if artist.Genres == nil {
+			artist.Genres = []string{}
+		}
+		if popularity.Valid {
+			p := int(popularity.Int64)
+			artist.Popularity = &p
+		}
+		artists = append(artists, artist)
+	}
+
+	return artists, total, nil
+}
+
+// Artista escutado pelo usuário sem gêneros gravados. Enrichable é falso para os IDs sintéticos do
+// import (artist_...), que não existem no Spotify e só podem receber gêneros manualmente
+type UnenrichedArtist struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Plays      int    `json:"plays"`
+	Enrichable bool   `json:"enrichable"`
+}
+
+// Artistas sem gêneros, dos mais escutados para os menos, para priorizar o enriquecimento
+func (a *AnalyticsService) GetUnenrichedArtists(ctx context.Context, userID string, limit, offset int) ([]UnenrichedArtist, int, error) {
+	if a.db == nil {
+		return nil, 0, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	var total int
+	err := a.db.QueryRowContext(ctx, `
+		SELECT COUNT(DISTINCT ar.id)
+		FROM listening_history lh
+		JOIN track_artists ta ON ta.track_id = lh.track_id
+		JOIN artists ar ON ar.id = ta.artist_id
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND COALESCE(cardinality(`+effectiveGenres("lh.user_id", "ar")+`), 0) = 0
+	`, userID).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count unenriched artists: %w", err)
+	}
+
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT ar.id, ar.name, COUNT(*) as plays, ar.id ~ $2 as enrichable
+		FROM listening_history lh
+		JOIN track_artists ta ON ta.track_id = lh.track_id
+		JOIN artists ar ON ar.id = ta.artist_id
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND COALESCE(cardinality(`+effectiveGenres("lh.user_id", "ar")+`), 0) = 0
+		GROUP BY ar.id, ar.name
+		ORDER BY plays DESC, ar.name
+		LIMIT $3 OFFSET $4
+	`, userID, spotifyIDPattern, limit, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query unenriched artists: %w", err)
+	}
+	defer rows.Close()
+
+	artists := []UnenrichedArtist{}
+	for rows.Next() {
+		var artist UnenrichedArtist
+		if err := rows.Scan(&artist.ID, &artist.Name, &artist.Plays, &artist.Enrichable); err != nil {
+			continue
+		}
+		artists = append(artists, artist)
+	}
+
+	return artists, total, rows.Err()
+}
@@ -0,0 +1,224 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var ErrAudioFeaturesMissing = errors.New("audio features not fetched yet")
+
+type audioFeatures struct {
+	ID           string  `json:"id"`
+	Tempo        float64 `json:"tempo"`
+	Energy       float64 `json:"energy"`
+	Danceability float64 `json:"danceability"`
+	Valence      float64 `json:"valence"`
+}
+
+// Etapa de audio features do enriquecimento: busca tempo, energia, dançabilidade e valência das faixas
+// escutadas que ainda não têm linha em track_audio_features. Faixas sem features no Spotify ficam com a
+// linha vazia para não serem pedidas de novo. O Spotify restringe /v1/audio-features para apps novos: 403
+// encerra só esta etapa e é informado em AudioFeaturesUnavailable
+func (s *TrackingService) enrichAudioFeatures(ctx context.Context, userID, spotifyToken string, result *EnrichmentResult) error {
+	if result.RateLimited {
+		return nil
+	}
+
+	trackIDs, err := s.pendingEnrichmentIDs(ctx, `
+		SELECT DISTINCT lh.track_id
+		FROM listening_history lh
+		LEFT JOIN track_audio_features af ON af.track_id = lh.track_id
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL
+			AND lh.track_id ~ $2 AND af.track_id IS NULL
+		ORDER BY lh.track_id
+		LIMIT $3`, userID, s.config.EnrichMaxTracks)
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(trackIDs) && !result.RateLimited; start += enrichmentBatchSize {
+		batch := trackIDs[start:min(start+enrichmentBatchSize, len(trackIDs))]
+
+		var response struct {
+			AudioFeatures []*audioFeatures `json:"audio_features"`
+		}
+		if err := s.getCatalogBatch(spotifyToken, "/v1/audio-features", batch, &response); err != nil {
+			var apiErr *SpotifyAPIError
+			if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusForbidden {
+				log.Printf("Spotify audio features unavailable for this app, skipping: %v", err)
+				result.AudioFeaturesUnavailable = true
+				return nil
+			}
+			if !s.handleEnrichmentError(err, result, len(batch)) {
+				return err
+			}
+			continue
+		}
+
+		// A resposta vem na ordem dos IDs pedidos, com null para faixas sem features
+		for i, trackID := range batch {
+			var features *audioFeatures
+			if i < len(response.AudioFeatures) {
+				features = response.AudioFeatures[i]
+			}
+			if err := s.saveAudioFeatures(ctx, trackID, features); err != nil {
+				log.Printf("Error saving audio features of track %s: %v", trackID, err)
+				result.Failed++
+				continue
+			}
+			if features != nil {
+				result.AudioFeaturesFetched++
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s *TrackingService) saveAudioFeatures(ctx context.Context, trackID string, features *audioFeatures) error {
+	var tempo, energy, danceability, valence sql.NullFloat64
+	if features != nil {
+		tempo = sql.NullFloat64{Float64: features.Tempo, Valid: true}
+		energy = sql.NullFloat64{Float64: features.Energy, Valid: true}
+		danceability = sql.NullFloat64{Float64: features.Danceability, Valid: true}
+		valence = sql.NullFloat64{Float64: features.Valence, Valid: true}
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO track_audio_features (track_id, tempo, energy, danceability, valence, fetched_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+		ON CONFLICT (track_id) DO UPDATE SET
+			tempo = EXCLUDED.tempo,
+			energy = EXCLUDED.energy,
+			danceability = EXCLUDED.danceability,
+			valence = EXCLUDED.valence,
+			fetched_at = EXCLUDED.fetched_at
+	`, trackID, tempo, energy, danceability, valence)
+	return err
+}
+
+// Faixa de tempo (BPM) e energia (0-1); zero nos máximos significa sem limite
+type WorkoutFilter struct {
+	MinTempo  float64
+	MaxTempo  float64
+	MinEnergy float64
+	MaxEnergy float64
+}
+
+type WorkoutTrack struct {
+	TrackID      string    `json:"track_id"`
+	TrackName    string    `json:"track_name"`
+	Artists      []string  `json:"artists"`
+	Tempo        float64   `json:"tempo"`
+	Energy       float64   `json:"energy"`
+	Danceability float64   `json:"danceability"`
+	PlayCount    int       `json:"play_count"`
+	LastPlayedAt time.Time `json:"last_played_at"`
+}
+
+// Quanto das faixas escutadas no período tem audio features; só as com features entram no filtro
+type AudioFeaturesCoverage struct {
+	PlayedTracks int     `json:"played_tracks"`
+	WithFeatures int     `json:"with_features"`
+	NoFeatures   int     `json:"no_features"` // sem features no Spotify ou sem ID do Spotify (import só pelo nome)
+	Pending      int     `json:"pending"`     // ainda não buscadas; POST /user/enrich busca
+	Percent      float64 `json:"percent"`
+}
+
+type WorkoutTracks struct {
+	Tracks   []WorkoutTrack        `json:"tracks"`
+	Coverage AudioFeaturesCoverage `json:"coverage"`
+}
+
+// Faixas escutadas no período dentro da faixa de tempo/energia, das mais tocadas para as menos, para montar
+// playlists no ritmo do treino. ErrAudioFeaturesMissing quando nenhuma faixa do período tem features ainda
+func (a *AnalyticsService) GetWorkoutTracks(ctx context.Context, userID, timeFilter string, filter WorkoutFilter, limit int) (*WorkoutTracks, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	startDate := timeFilterStartDate(timeFilter)
+	result := &WorkoutTracks{Tracks: []WorkoutTrack{}}
+
+	coverage := &result.Coverage
+	err := a.db.QueryRowContext(ctx, `
+		SELECT
+			COUNT(*),
+			COUNT(*) FILTER (WHERE af.tempo IS NOT NULL),
+			COUNT(*) FILTER (WHERE af.track_id IS NULL AND played.track_id ~ $3)
+		FROM (
+			SELECT DISTINCT lh.track_id
+			FROM listening_history lh
+			WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2
+		) played
+		LEFT JOIN track_audio_features af ON af.track_id = played.track_id
+	`, userID, startDate, spotifyIDPattern).Scan(&coverage.PlayedTracks, &coverage.WithFeatures, &coverage.Pending)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audio features coverage: %w", err)
+	}
+	coverage.NoFeatures = coverage.PlayedTracks - coverage.WithFeatures - coverage.Pending
+	if coverage.PlayedTracks > 0 {
+		coverage.Percent = math.Round(float64(coverage.WithFeatures)/float64(coverage.PlayedTracks)*1000) / 10
+	}
+	if coverage.WithFeatures == 0 {
+		return result, ErrAudioFeaturesMissing
+	}
+
+	rows, err := a.db.QueryContext(ctx, `
+		WITH plays AS (
+			SELECT lh.track_id, COUNT(*) as play_count, MAX(lh.played_at) as last_played_at
+			FROM listening_history lh
+			JOIN track_audio_features af ON af.track_id = lh.track_id
+			WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2
+				AND af.tempo >= $3 AND ($4 = 0 OR af.tempo <= $4)
+				AND af.energy >= $5 AND ($6 = 0 OR af.energy <= $6)
+			GROUP BY lh.track_id
+			ORDER BY play_count DESC, lh.track_id
+			LIMIT $7
+		)
+		SELECT
+			t.id,
+			t.name,
+			ARRAY_REMOVE(ARRAY_AGG(ar.name ORDER BY ar.name), NULL) as artists,
+			af.tempo,
+			af.energy,
+			af.danceability,
+			p.play_count,
+			p.last_played_at
+		FROM plays p
+		JOIN tracks t ON t.id = p.track_id
+		JOIN track_audio_features af ON af.track_id = p.track_id
+		LEFT JOIN track_artists ta ON ta.track_id = t.id
+		LEFT JOIN artists ar ON ar.id = ta.artist_id
+		GROUP BY t.id, t.name, af.tempo, af.energy, af.danceability, p.play_count, p.last_played_at
+		ORDER BY p.play_count DESC, t.id
+	`, userID, startDate, filter.MinTempo, filter.MaxTempo, filter.MinEnergy, filter.MaxEnergy, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query workout tracks: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var track WorkoutTrack
+		var artists pq.StringArray
+		if err := rows.Scan(&track.TrackID, &track.TrackName, &artists, &track.Tempo, &track.Energy,
+			&track.Danceability, &track.PlayCount, &track.LastPlayedAt); err != nil {
+			continue
+		}
+		track.Artists = []string(artists)
+		result.Tracks = append(result.Tracks, track)
+	}
+
+	return result, rows.Err()
+}
@@ -15,6 +15,7 @@ import (
 type AuthService struct {
 	config      *config.Config
 	oauthConfig *oauth2.Config
+	tokens      *tokenCipher
 }
 
 type Claims struct {
@@ -36,35 +37,67 @@ func NewAuthService(cfg *config.Config) *AuthService {
 	log.Printf("Initializing Spotify OAuth with Client ID: %s", cfg.SpotifyClientID[:8]+"...")
 	log.Printf("Redirect URL configured: %s", cfg.SpotifyRedirectURL)
 
+	scopes := []string{
+		"user-read-private",
+		"user-read-email",
+		"user-top-read",
+		"user-read-recently-played",
+		"user-library-read",
+		"playlist-read-private",
+		"user-read-playback-state",
+		"user-read-currently-playing",
+	}
+	// Escrita só para POST /user/playlists/create; quem logou antes precisa consentir de novo
+	if scope := cfg.PlaylistScope(); scope != "" {
+		scopes = append(scopes, scope)
+	}
+
 	oauthConfig := &oauth2.Config{
 		ClientID:     cfg.SpotifyClientID,
 		ClientSecret: cfg.SpotifyClientSecret,
 		RedirectURL:  cfg.SpotifyRedirectURL,
-		Scopes: []string{
-			"user-read-private",
-			"user-read-email",
-			"user-top-read",
-			"user-read-recently-played",
-			"user-library-read",
-			"playlist-read-private",
-			"user-read-playback-state",
-			"user-read-currently-playing",
-		},
-		Endpoint: spotify.Endpoint,
+		Scopes:       scopes,
+		Endpoint:     spotify.Endpoint,
+	}
+
+	tokenKey := cfg.TokenEncryptionKey
+	if tokenKey == "" {
+		tokenKey = cfg.JWTSecret
+	}
+	tokens, err := newTokenCipher(tokenKey)
+	if err != nil {
+		log.Fatalf("Failed to initialize token encryption: %v", err)
 	}
 
 	return &AuthService{
 		config:      cfg,
 		oauthConfig: oauthConfig,
+		tokens:      tokens,
 	}
 }
 
+// Cifra um token do Spotify para gravar no banco
+func (a *AuthService) SealToken(token string) (string, error) {
+	return a.tokens.seal(token)
+}
+
+// Decifra um token lido do banco; tokens gravados antes da cifragem voltam como estão
+func (a *AuthService) OpenToken(stored string) (string, error) {
+	return a.tokens.open(stored)
+}
+
 func (a *AuthService) GetAuthURL(state string) string {
 	authURL := a.oauthConfig.AuthCodeURL(state, oauth2.AccessTypeOffline)
 	log.Printf("Generated auth URL: %s", authURL)
 	return authURL
 }
 
+// Como GetAuthURL, mas força a tela de consentimento do Spotify mesmo para quem já autorizou o app,
+// para conceder scopes adicionados depois do primeiro login
+func (a *AuthService) GetReconsentURL(state string) string {
+	return a.oauthConfig.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.SetAuthURLParam("show_dialog", "true"))
+}
+
 func (a *AuthService) ExchangeCode(code string) (*oauth2.Token, error) {
 	log.Printf("Exchanging authorization code for token...")
 
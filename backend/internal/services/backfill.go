@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+var ErrBackfillAlreadyDone = errors.New("history backfill already done")
+
+type BackfillResult struct {
+	Fetched      int        `json:"fetched"` // escutas que o Spotify devolveu
+	Added        int        `json:"added"`   // escutas que ainda não estavam no histórico
+	Oldest       *time.Time `json:"oldest_played_at"`
+	BackfilledAt time.Time  `json:"backfilled_at"`
+}
+
+// Busca o recently-played o mais fundo que o Spotify deixar (até BACKFILL_MAX_TRACKS) e grava o que faltar.
+// Roda uma vez por usuário: o Spotify só guarda as ~50 escutas mais recentes, então repetir não traz nada
+// além do sync periódico; histórico antigo só vem pelo import do export do Spotify ou do Last.fm
+func (s *TrackingService) BackfillHistory(ctx context.Context, userID, spotifyToken string) (*BackfillResult, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	var backfilledAt sql.NullTime
+	err := s.db.QueryRowContext(ctx, `SELECT history_backfilled_at FROM users WHERE id = $1`, userID).Scan(&backfilledAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query backfill status: %w", err)
+	}
+	if backfilledAt.Valid {
+		return &BackfillResult{BackfilledAt: backfilledAt.Time}, ErrBackfillAlreadyDone
+	}
+
+	window, err := s.fetchRecentlyPlayedWindow(userID, spotifyToken, s.config.BackfillMaxTracks)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrResyncFetchFailed, err)
+	}
+
+	// Escutas e marcação na mesma transação: se algo falhar, nada é gravado e o backfill pode ser repetido. A
+	// linha do usuário fica travada até o commit, então uma chamada simultânea espera e vê o backfill já feito
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `SELECT history_backfilled_at FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&backfilledAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock backfill status: %w", err)
+	}
+	if backfilledAt.Valid {
+		return &BackfillResult{BackfilledAt: backfilledAt.Time}, ErrBackfillAlreadyDone
+	}
+
+	result := &BackfillResult{Fetched: len(window)}
+	// Mais antigas primeiro, como no sync
+	for i := len(window) - 1; i >= 0; i-- {
+		item := window[i]
+		playedAt, err := time.Parse(time.RFC3339, item.PlayedAt)
+		if err != nil || item.Track == nil {
+			continue
+		}
+		if result.Oldest == nil || playedAt.Before(*result.Oldest) {
+			result.Oldest = &playedAt
+		}
+
+		inserted, err := s.insertRecentlyPlayedTrack(ctx, tx, userID, "", spotifyToken, item.Track, playedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save backfilled play: %w", err)
+		}
+		if inserted {
+			result.Added++
+		}
+	}
+
+	err = tx.QueryRowContext(ctx, `
+		UPDATE users SET history_backfilled_at = CURRENT_TIMESTAMP WHERE id = $1
+		RETURNING history_backfilled_at
+	`, userID).Scan(&result.BackfilledAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to mark backfill: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit backfill: %w", err)
+	}
+
+	if result.Added > 0 {
+		s.scheduleMilestoneCheck(userID)
+	}
+
+	log.Printf("History backfill for user %s: %d plays fetched, %d added", userID, result.Fetched, result.Added)
+	return result, nil
+}
@@ -0,0 +1,42 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"musike-backend/internal/config"
+)
+
+func TestBackfillHistoryMarksDoneWithThePlays(t *testing.T) {
+	db := openTestDB(t)
+	userID := createTestUser(t, db)
+
+	fake := newFakeRecentlyPlayed(10, time.Now().Add(-time.Hour))
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	s := NewTrackingService(&config.Config{
+		SpotifyAPIBaseURL: server.URL,
+		BackfillMaxTracks: 50,
+		SessionSaveMode:   "fixed",
+	}, db)
+	ctx := context.Background()
+
+	result, err := s.BackfillHistory(ctx, userID, "token")
+	if err != nil {
+		t.Fatalf("BackfillHistory: %v", err)
+	}
+	if result.Fetched != 10 || result.Added != 10 || result.BackfilledAt.IsZero() {
+		t.Errorf("backfill = %+v, want 10 fetched and added and the done time", result)
+	}
+	if got := countHistory(t, db, userID); got != 10 {
+		t.Errorf("history has %d rows after backfill, want 10", got)
+	}
+
+	if _, err := s.BackfillHistory(ctx, userID, "token"); !errors.Is(err, ErrBackfillAlreadyDone) {
+		t.Errorf("second backfill error = %v, want ErrBackfillAlreadyDone", err)
+	}
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Sessão longa de escuta contínua (mesma regra de intervalo de /user/sessions)
+type Binge struct {
+	SessionID       string    `json:"session_id"`
+	StartedAt       time.Time `json:"started_at"`
+	EndedAt         time.Time `json:"ended_at"` // fim estimado da última faixa
+	DurationMinutes float64   `json:"duration_minutes"`
+	Tracks          int       `json:"tracks"`
+	ListenedMinutes float64   `json:"listened_minutes"`
+	DominantArtist  string    `json:"dominant_artist,omitempty"`
+	DominantGenre   string    `json:"dominant_genre,omitempty"`
+}
+
+// As sessões mais longas com pelo menos BINGE_MIN_DURATION, da mais longa para a mais curta
+func (a *AnalyticsService) GetTopBinges(ctx context.Context, userID string, timeFilter string, limit int) ([]Binge, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	rows, err := a.db.QueryContext(ctx, `
+		WITH `+sessionPlaysCTE("$2", "$3::int")+`,
+		sessions AS (
+			SELECT
+				n.session_no,
+				((ARRAY_AGG(n.id ORDER BY n.played_at, n.id))[1])::text as id,
+				MIN(n.played_at) as started_at,
+				MAX(n.played_at + (CASE WHEN n.listened_duration_ms > 0 THEN n.listened_duration_ms ELSE COALESCE(t.duration_ms, 0) END)
+					* INTERVAL '1 millisecond') as ended_at,
+				COUNT(*) as tracks,
+				SUM(n.listened_duration_ms) as listened_ms
+			FROM numbered n
+			LEFT JOIN tracks t ON t.id = n.track_id
+			GROUP BY n.session_no
+			HAVING MAX(n.played_at) >= $2
+		),
+		top_sessions AS (
+			SELECT * FROM sessions
+			WHERE ended_at - started_at >= $4::int * INTERVAL '1 second'
+			ORDER BY ended_at - started_at DESC, started_at DESC
+			LIMIT $5
+		)
+		SELECT
+			s.id, s.started_at, s.ended_at, s.tracks, s.listened_ms,
+			(
+				SELECT ar.name FROM numbered n
+				JOIN track_artists ta ON ta.track_id = n.track_id
+				JOIN artists ar ON ar.id = ta.artist_id
+				WHERE n.session_no = s.session_no
+				GROUP BY ar.id, ar.name
+				ORDER BY COUNT(*) DESC, ar.name
+				LIMIT 1
+			) as dominant_artist,
+			(
+				SELECT g.genre FROM numbered n
+				JOIN track_artists ta ON ta.track_id = n.track_id
+				JOIN artists ar ON ar.id = ta.artist_id
+				CROSS JOIN LATERAL UNNEST(`+effectiveGenres("$1", "ar")+`) AS g(genre)
+				WHERE n.session_no = s.session_no
+				GROUP BY g.genre
+				ORDER BY COUNT(*) DESC, g.genre
+				LIMIT 1
+			) as dominant_genre
+		FROM top_sessions s
+		ORDER BY s.ended_at - s.started_at DESC, s.started_at DESC
+	`, userID, timeFilterStartDate(timeFilter), int(listeningSessionGap.Seconds()),
+		int(a.config.BingeMinDuration.Seconds()), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query binges: %w", err)
+	}
+	defer rows.Close()
+
+	binges := []Binge{}
+	for rows.Next() {
+		var binge Binge
+		var listenedMs int64
+		var artist, genre sql.NullString
+		if err := rows.Scan(&binge.SessionID, &binge.StartedAt, &binge.EndedAt, &binge.Tracks, &listenedMs, &artist, &genre); err != nil {
+			continue
+		}
+		binge.DurationMinutes = roundMinutes(binge.EndedAt.Sub(binge.StartedAt).Minutes())
+		binge.ListenedMinutes = roundMinutes(float64(listenedMs) / 60000)
+		binge.DominantArtist = artist.String
+		binge.DominantGenre = genre.String
+		binges = append(binges, binge)
+	}
+
+	return binges, rows.Err()
+}
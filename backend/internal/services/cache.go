@@ -0,0 +1,122 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"musike-backend/internal/config"
+)
+
+// Cache em disco de respostas da API do Spotify, chaveado pela URL da requisição.
+// Útil em desenvolvimento e para reduzir as buscas de detalhes de artistas no sync
+type ResponseCache struct {
+	enabled bool
+	dir     string
+	maxAge  time.Duration // maior TTL configurado; entradas mais velhas não servem a ninguém e saem na varredura
+}
+
+func NewResponseCache(cfg *config.Config) *ResponseCache {
+	cache := &ResponseCache{
+		enabled: cfg.SpotifyCacheEnabled,
+		dir:     cfg.SpotifyCacheDir,
+		maxAge:  max(cfg.SpotifyCacheTTL, cfg.ProfileCacheTTL, cfg.ArtistCacheTTL),
+	}
+
+	if cache.enabled {
+		if err := os.MkdirAll(cache.dir, 0o755); err != nil {
+			log.Printf("Warning: failed to create Spotify cache dir %s, cache disabled: %v", cache.dir, err)
+			cache.enabled = false
+		}
+	}
+
+	return cache
+}
+
+func (c *ResponseCache) Get(key string, ttl time.Duration) ([]byte, bool) {
+	if !c.enabled || ttl <= 0 {
+		return nil, false
+	}
+
+	path := c.path(key)
+	info, err := os.Stat(path)
+	if err != nil || time.Since(info.ModTime()) > ttl {
+		return nil, false
+	}
+
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	return body, true
+}
+
+func (c *ResponseCache) Set(key string, body []byte) {
+	if !c.enabled {
+		return
+	}
+
+	// Escrever em arquivo temporário e renomear para não deixar entradas parciais
+	tmp, err := os.CreateTemp(c.dir, "tmp-*")
+	if err != nil {
+		log.Printf("Warning: failed to write Spotify cache entry: %v", err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(body); err != nil {
+		tmp.Close()
+		log.Printf("Warning: failed to write Spotify cache entry: %v", err)
+		return
+	}
+	tmp.Close()
+
+	if err := os.Rename(tmp.Name(), c.path(key)); err != nil {
+		log.Printf("Warning: failed to write Spotify cache entry: %v", err)
+	}
+}
+
+// Varredura do janitor: apaga as entradas mais velhas que o maior TTL e temporários de escritas interrompidas.
+// Devolve quantos arquivos saíram
+func (c *ResponseCache) Sweep(now time.Time) int {
+	if !c.enabled {
+		return 0
+	}
+
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		log.Printf("Warning: failed to list Spotify cache dir %s: %v", c.dir, err)
+		return 0
+	}
+
+	swept := 0
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() || now.Sub(info.ModTime()) <= c.maxAge {
+			continue
+		}
+		if os.Remove(filepath.Join(c.dir, entry.Name())) == nil {
+			swept++
+		}
+	}
+	return swept
+}
+
+func (c *ResponseCache) path(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(hash[:])+".json")
+}
+
+// Respostas de endpoints /me dependem do usuário, então a chave inclui o usuário do Musike: o token muda a
+// cada renovação e, na chave, deixaria entradas órfãs a cada hora. Sem userID (ex.: o /me que descobre de
+// quem é um token) a chave usa um hash do token
+func userScopedCacheKey(apiURL, userID, accessToken string) string {
+	if userID != "" {
+		return apiURL + "#user:" + userID
+	}
+	hash := sha256.Sum256([]byte(accessToken))
+	return apiURL + "#" + hex.EncodeToString(hash[:8])
+}
@@ -0,0 +1,57 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"musike-backend/internal/config"
+)
+
+func TestUserScopedCacheKeySurvivesTokenRefresh(t *testing.T) {
+	const apiURL = "https://api.spotify.com/v1/me/top/tracks"
+	if userScopedCacheKey(apiURL, "user-1", "old-token") != userScopedCacheKey(apiURL, "user-1", "new-token") {
+		t.Error("refreshed token changed the cache key of the same user")
+	}
+	if userScopedCacheKey(apiURL, "user-1", "token") == userScopedCacheKey(apiURL, "user-2", "token") {
+		t.Error("two users share a cache key")
+	}
+	// Sem usuário (o /me que identifica o token) a chave segue o token
+	if userScopedCacheKey(apiURL, "", "token-a") == userScopedCacheKey(apiURL, "", "token-b") {
+		t.Error("anonymous keys of different tokens collide")
+	}
+}
+
+func TestResponseCacheSweepRemovesExpiredEntries(t *testing.T) {
+	cache := NewResponseCache(&config.Config{
+		SpotifyCacheEnabled: true,
+		SpotifyCacheDir:     t.TempDir(),
+		SpotifyCacheTTL:     10 * time.Minute,
+		ArtistCacheTTL:      time.Hour,
+	})
+	cache.Set("fresh", []byte(`{}`))
+	cache.Set("expired", []byte(`{}`))
+
+	now := time.Now()
+	old := now.Add(-2 * time.Hour)
+	if err := os.Chtimes(cache.path("expired"), old, old); err != nil {
+		t.Fatalf("failed to age cache entry: %v", err)
+	}
+	// Temporário de uma escrita interrompida
+	leftover := filepath.Join(cache.dir, "tmp-123")
+	if err := os.WriteFile(leftover, []byte("partial"), 0o644); err != nil {
+		t.Fatalf("failed to write leftover: %v", err)
+	}
+	os.Chtimes(leftover, old, old)
+
+	if swept := cache.Sweep(now); swept != 2 {
+		t.Errorf("swept %d entries, want 2", swept)
+	}
+	if _, ok := cache.Get("fresh", time.Hour); !ok {
+		t.Error("fresh entry was swept")
+	}
+	if _, err := os.Stat(cache.path("expired")); !os.IsNotExist(err) {
+		t.Error("expired entry is still on disk")
+	}
+}
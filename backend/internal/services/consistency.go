@@ -0,0 +1,105 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+type HourConsistency struct {
+	Hour         int     `json:"hour"`
+	DaysListened int     `json:"days_listened"`
+	Coverage     float64 `json:"coverage"` // % dos dias considerados com escuta nessa hora
+	PlayCount    int     `json:"play_count"`
+}
+
+type ListeningConsistency struct {
+	DaysConsidered int               `json:"days_considered"` // da primeira escuta no período até hoje, no fuso pedido
+	TopHours       []HourConsistency `json:"top_hours"`
+	Hours          []HourConsistency `json:"hours"` // as 24 horas, em ordem
+}
+
+// Para cada hora do dia, em quantos dias distintos houve escuta naquela hora. Diferente de PeakHours
+// (volume de plays), mede em que horário o usuário escuta com mais regularidade
+func (a *AnalyticsService) GetListeningConsistency(ctx context.Context, userID string, timeFilter string, top int, loc *time.Location) (*ListeningConsistency, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	startDate := timeFilterStartDate(timeFilter)
+	local := localPlayedAt(3)
+
+	report := &ListeningConsistency{
+		TopHours: []HourConsistency{},
+		Hours:    make([]HourConsistency, 24),
+	}
+	for hour := range report.Hours {
+		report.Hours[hour].Hour = hour
+	}
+
+	var firstDay *time.Time
+	err := a.db.QueryRowContext(ctx, fmt.Sprintf(`
+		SELECT MIN(DATE(%s))
+		FROM listening_history lh
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2`, local),
+		userID, startDate, loc.String()).Scan(&firstDay)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query first listening day: %w", err)
+	}
+	if firstDay == nil {
+		return report, nil
+	}
+
+	now := time.Now().In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	first := time.Date(firstDay.Year(), firstDay.Month(), firstDay.Day(), 0, 0, 0, 0, time.UTC)
+	report.DaysConsidered = max(int(today.Sub(first).Hours()/24)+1, 1)
+
+	rows, err := a.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT
+			EXTRACT(HOUR FROM %[1]s)::int as hour,
+			COUNT(DISTINCT DATE(%[1]s)) as days_listened,
+			COUNT(*) as play_count
+		FROM listening_history lh
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2
+		GROUP BY hour`, local), userID, startDate, loc.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query hourly consistency: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hour, days, plays int
+		if err := rows.Scan(&hour, &days, &plays); err != nil || hour < 0 || hour > 23 {
+			continue
+		}
+		report.Hours[hour].DaysListened = days
+		report.Hours[hour].PlayCount = plays
+		report.Hours[hour].Coverage = math.Round(float64(days)/float64(report.DaysConsidered)*10000) / 100
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Empate na cobertura: a hora com mais plays primeiro
+	ranked := make([]HourConsistency, 0, 24)
+	for _, hour := range report.Hours {
+		if hour.DaysListened > 0 {
+			ranked = append(ranked, hour)
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if ranked[i].DaysListened != ranked[j].DaysListened {
+			return ranked[i].DaysListened > ranked[j].DaysListened
+		}
+		return ranked[i].PlayCount > ranked[j].PlayCount
+	})
+	report.TopHours = ranked[:min(top, len(ranked))]
+
+	return report, nil
+}
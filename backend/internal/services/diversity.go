@@ -0,0 +1,261 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+type DiversityPoint struct {
+	Month         string  `json:"month"`
+	Score         float64 `json:"score"`
+	UniqueGenres  int     `json:"unique_genres"`
+	UniqueArtists int     `json:"unique_artists"`
+}
+
+func (a *AnalyticsService) GetDiversityTimeline(ctx context.Context, userID string, timeFilter string, loc *time.Location) ([]DiversityPoint, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	startDate := timeFilterStartDate(timeFilter)
+
+	query := fmt.Sprintf(`
+		SELECT
+			TO_CHAR(date_trunc('month', %s), 'YYYY-MM') as month,
+			COUNT(DISTINCT g.genre) as unique_genres,
+			COUNT(DISTINCT ta.artist_id) as unique_artists
+		FROM listening_history lh
+		JOIN track_artists ta ON ta.track_id = lh.track_id
+		JOIN artists ar ON ar.id = ta.artist_id
+		LEFT JOIN LATERAL UNNEST(`+effectiveGenres("lh.user_id", "ar")+`) AS g(genre) ON TRUE
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2
+		GROUP BY month
+		ORDER BY month`, localPlayedAt(3))
+
+	rows, err := a.db.QueryContext(ctx, query, userID, startDate, loc.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query diversity timeline: %w", err)
+	}
+	defer rows.Close()
+
+	monthStats := make(map[string]DiversityPoint)
+	firstMonth := ""
+	for rows.Next() {
+		var point DiversityPoint
+		if err := rows.Scan(&point.Month, &point.UniqueGenres, &point.UniqueArtists); err != nil {
+			continue
+		}
+		point.Score = diversityScore(point.UniqueGenres, point.UniqueArtists)
+		monthStats[point.Month] = point
+		if firstMonth == "" {
+			firstMonth = point.Month
+		}
+	}
+
+	timeline := make([]DiversityPoint, 0)
+	if firstMonth == "" {
+		return timeline, nil
+	}
+
+	// Preencher meses sem escuta entre o início do período (ou a primeira escuta) e o mês atual
+	now := time.Now().In(loc)
+	current, _ := time.ParseInLocation("2006-01", firstMonth, loc)
+	if timeFilter != "alltime" {
+		start := startDate.In(loc)
+		current = time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, loc)
+	}
+	lastMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+
+	for ; !current.After(lastMonth); current = current.AddDate(0, 1, 0) {
+		month := current.Format("2006-01")
+		if point, exists := monthStats[month]; exists {
+			timeline = append(timeline, point)
+		} else {
+			timeline = append(timeline, DiversityPoint{Month: month})
+		}
+	}
+
+	return timeline, nil
+}
+
+type DailyDiversity struct {
+	Date          string `json:"date"`
+	Plays         int    `json:"plays"`
+	UniqueArtists int    `json:"unique_artists"`
+	UniqueGenres  int    `json:"unique_genres"`
+}
+
+// Artistas e gêneros distintos por dia local, com zero nos dias sem escuta. Em alltime a série começa na
+// primeira escuta
+func (a *AnalyticsService) GetDailyDiversity(ctx context.Context, userID string, timeFilter string, loc *time.Location) ([]DailyDiversity, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	startDate := timeFilterStartDate(timeFilter)
+
+	// Plays conta escutas, não linhas do JOIN com track_artists (faixas com vários artistas repetem a escuta)
+	query := fmt.Sprintf(`
+		SELECT
+			TO_CHAR(%s, 'YYYY-MM-DD') as day,
+			COUNT(DISTINCT lh.id) as plays,
+			COUNT(DISTINCT ta.artist_id) as unique_artists,
+			COUNT(DISTINCT g.genre) as unique_genres
+		FROM listening_history lh
+		LEFT JOIN track_artists ta ON ta.track_id = lh.track_id
+		LEFT JOIN artists ar ON ar.id = ta.artist_id
+		LEFT JOIN LATERAL UNNEST(`+effectiveGenres("lh.user_id", "ar")+`) AS g(genre) ON TRUE
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2
+		GROUP BY day
+		ORDER BY day`, localPlayedAt(3))
+
+	rows, err := a.db.QueryContext(ctx, query, userID, startDate, loc.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query daily diversity: %w", err)
+	}
+	defer rows.Close()
+
+	dayStats := make(map[string]DailyDiversity)
+	firstDay := ""
+	for rows.Next() {
+		var day DailyDiversity
+		if err := rows.Scan(&day.Date, &day.Plays, &day.UniqueArtists, &day.UniqueGenres); err != nil {
+			continue
+		}
+		dayStats[day.Date] = day
+		if firstDay == "" {
+			firstDay = day.Date
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read daily diversity: %w", err)
+	}
+
+	days := make([]DailyDiversity, 0)
+	if firstDay == "" {
+		return days, nil
+	}
+
+	now := time.Now().In(loc)
+	current, _ := time.ParseInLocation("2006-01-02", firstDay, loc)
+	if timeFilter != "alltime" {
+		start := startDate.In(loc)
+		current = time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, loc)
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+
+	for ; !current.After(today); current = current.AddDate(0, 0, 1) {
+		date := current.Format("2006-01-02")
+		if day, exists := dayStats[date]; exists {
+			days = append(days, day)
+		} else {
+			days = append(days, DailyDiversity{Date: date})
+		}
+	}
+
+	return days, nil
+}
+
+// Entropia de Shannon (em bits) da distribuição das escutas. Evenness normaliza pelo máximo possível com a
+// mesma quantidade de itens (log2 de Distinct): 1 é escuta perfeitamente espalhada
+type EntropyScore struct {
+	Entropy  float64 `json:"entropy"`
+	Evenness float64 `json:"evenness"`
+	Distinct int     `json:"distinct"`
+	Plays    int     `json:"plays"`
+}
+
+type ListeningEntropy struct {
+	Artists EntropyScore `json:"artists"`
+	Genres  EntropyScore `json:"genres"`
+}
+
+// Complemento rigoroso do diversity_score: em vez de contar artistas e gêneros distintos, mede o quão
+// espalhadas estão as escutas entre eles. Escutas de faixas com vários artistas (ou artistas com vários
+// gêneros) contam para cada um, e as exclusões do usuário valem como no diversity_score
+func (a *AnalyticsService) GetListeningEntropy(ctx context.Context, userID string, timeFilter string) (*ListeningEntropy, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	startDate := timeFilterStartDate(timeFilter)
+
+	artistCounts, err := a.queryPlayCounts(ctx, `
+		SELECT COUNT(*)
+		FROM listening_history lh
+		JOIN track_artists ta ON ta.track_id = lh.track_id
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND `+excludedPlayCondition+` AND lh.played_at >= $2
+		GROUP BY ta.artist_id`, userID, startDate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query artist entropy: %w", err)
+	}
+
+	genreCounts, err := a.queryPlayCounts(ctx, `
+		SELECT COUNT(*)
+		FROM listening_history lh
+		JOIN track_artists ta ON ta.track_id = lh.track_id
+		JOIN artists ar ON ar.id = ta.artist_id
+		CROSS JOIN LATERAL UNNEST(`+effectiveGenres("lh.user_id", "ar")+`) AS g(genre)
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND `+excludedPlayCondition+` AND lh.played_at >= $2
+		GROUP BY g.genre`, userID, startDate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query genre entropy: %w", err)
+	}
+
+	return &ListeningEntropy{
+		Artists: shannonEntropy(artistCounts),
+		Genres:  shannonEntropy(genreCounts),
+	}, nil
+}
+
+func (a *AnalyticsService) queryPlayCounts(ctx context.Context, query string, args ...interface{}) ([]int, error) {
+	rows, err := a.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []int
+	for rows.Next() {
+		var count int
+		if err := rows.Scan(&count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
+
+func shannonEntropy(counts []int) EntropyScore {
+	score := EntropyScore{Distinct: len(counts)}
+	for _, count := range counts {
+		score.Plays += count
+	}
+	if score.Plays == 0 {
+		return score
+	}
+
+	var entropy float64
+	for _, count := range counts {
+		p := float64(count) / float64(score.Plays)
+		entropy -= p * math.Log2(p)
+	}
+
+	score.Entropy = roundFraction(entropy)
+	// Um item só tem entropia 0 e nenhuma distribuição a comparar
+	if score.Distinct > 1 {
+		score.Evenness = roundFraction(entropy / math.Log2(float64(score.Distinct)))
+	}
+	return score
+}
@@ -0,0 +1,182 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/lib/pq"
+)
+
+type DurationBucket struct {
+	Label         string  `json:"label"`
+	MinDurationMs int64   `json:"min_duration_ms"`
+	MaxDurationMs *int64  `json:"max_duration_ms"` // nil = sem limite superior
+	PlayCount     int     `json:"play_count"`
+	TotalMinutes  float64 `json:"total_minutes"`
+	Percentage    float64 `json:"percentage"`
+}
+
+type DurationDistribution struct {
+	Buckets         []DurationBucket `json:"buckets"`
+	TotalPlays      int              `json:"total_plays"`
+	UnknownDuration int              `json:"unknown_duration_plays"` // faixas com duration_ms ausente ou 0, fora dos buckets
+}
+
+// Limites dos buckets em minutos: <2, 2-4, 4-6, >6
+var durationBucketMinutes = []int64{2, 4, 6}
+
+// Escutas agrupadas pela duração da faixa (tracks.duration_ms); os minutos somam o tempo realmente escutado.
+// source vazio considera todas as origens
+func (a *AnalyticsService) GetDurationDistribution(ctx context.Context, userID string, timeFilter string, source string) (*DurationDistribution, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	boundsMs := make([]int64, len(durationBucketMinutes))
+	for i, minutes := range durationBucketMinutes {
+		boundsMs[i] = minutes * 60000
+	}
+
+	result := &DurationDistribution{Buckets: make([]DurationBucket, 0, len(boundsMs)+1)}
+	var lower int64
+	for i := 0; i <= len(boundsMs); i++ {
+		bucket := DurationBucket{MinDurationMs: lower}
+		if i < len(boundsMs) {
+			upper := boundsMs[i]
+			bucket.MaxDurationMs = &upper
+			if i == 0 {
+				bucket.Label = fmt.Sprintf("<%dmin", durationBucketMinutes[i])
+			} else {
+				bucket.Label = fmt.Sprintf("%d-%dmin", durationBucketMinutes[i-1], durationBucketMinutes[i])
+			}
+			lower = upper
+		} else {
+			bucket.Label = fmt.Sprintf(">%dmin", durationBucketMinutes[i-1])
+		}
+		result.Buckets = append(result.Buckets, bucket)
+	}
+
+	// width_bucket devolve 0 abaixo do primeiro limite e len(bounds) a partir do último
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT
+			CASE WHEN COALESCE(t.duration_ms, 0) <= 0 THEN -1
+				ELSE width_bucket(t.duration_ms::bigint, $3::bigint[]) END as bucket,
+			COUNT(*) as play_count,
+			COALESCE(SUM(lh.listened_duration_ms), 0) as listened_ms
+		FROM listening_history lh
+		JOIN tracks t ON lh.track_id = t.id
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2 AND `+sourceCondition(4)+`
+		GROUP BY bucket`, userID, timeFilterStartDate(timeFilter), pq.Array(boundsMs), source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query duration distribution: %w", err)
+	}
+	defer rows.Close()
+
+	knownPlays := 0
+	for rows.Next() {
+		var bucket, count int
+		var listenedMs int64
+		if err := rows.Scan(&bucket, &count, &listenedMs); err != nil {
+			continue
+		}
+
+		result.TotalPlays += count
+		if bucket < 0 || bucket >= len(result.Buckets) {
+			result.UnknownDuration += count
+			continue
+		}
+
+		knownPlays += count
+		result.Buckets[bucket].PlayCount += count
+		result.Buckets[bucket].TotalMinutes += float64(listenedMs) / 60000
+	}
+
+	if knownPlays > 0 {
+		for i := range result.Buckets {
+			result.Buckets[i].Percentage = float64(result.Buckets[i].PlayCount) / float64(knownPlays) * 100
+		}
+	}
+
+	return result, nil
+}
+
+type CompletionStage struct {
+	Completion int     `json:"completion"` // % da faixa escutada, no mínimo
+	PlayCount  int     `json:"play_count"`
+	Percentage float64 `json:"percentage"` // % das escutas da amostra que chegaram a esse ponto
+}
+
+type CompletionFunnel struct {
+	Stages       []CompletionStage `json:"stages"`
+	SampledPlays int               `json:"sampled_plays"` // escutas com duração da faixa e tempo escutado conhecidos
+	TotalPlays   int               `json:"total_plays"`
+	Coverage     float64           `json:"coverage"` // fração (0-1) das escutas que entram na amostra
+}
+
+var completionFunnelStages = []int{25, 50, 75, 100}
+
+// Quantas escutas chegaram a 25/50/75/100% da faixa. Só entram escutas com tracks.duration_ms e
+// listened_duration_ms conhecidos (> 0); Coverage mostra o tamanho da amostra. source vazio considera todas
+// as origens
+func (a *AnalyticsService) GetCompletionFunnel(ctx context.Context, userID string, timeFilter string, source string) (*CompletionFunnel, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	result := &CompletionFunnel{Stages: make([]CompletionStage, len(completionFunnelStages))}
+	for i, stage := range completionFunnelStages {
+		result.Stages[i].Completion = stage
+	}
+
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT
+			stage,
+			COUNT(*) FILTER (WHERE known) as sampled,
+			COUNT(*) FILTER (WHERE known AND ratio * 100 >= stage) as reached,
+			COUNT(*) as total
+		FROM (
+			SELECT
+				COALESCE(t.duration_ms, 0) > 0 AND COALESCE(lh.listened_duration_ms, 0) > 0 as known,
+				lh.listened_duration_ms::float / NULLIF(t.duration_ms, 0) as ratio
+			FROM listening_history lh
+			JOIN tracks t ON lh.track_id = t.id
+			WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2 AND `+sourceCondition(4)+`
+		) plays
+		CROSS JOIN UNNEST($3::int[]) AS s(stage)
+		GROUP BY stage`, userID, timeFilterStartDate(timeFilter), pq.Array(completionFunnelStages), source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query completion funnel: %w", err)
+	}
+	defer rows.Close()
+
+	reachedByStage := make(map[int]int)
+	for rows.Next() {
+		var stage, reached int
+		if err := rows.Scan(&stage, &result.SampledPlays, &reached, &result.TotalPlays); err != nil {
+			continue
+		}
+		reachedByStage[stage] = reached
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range result.Stages {
+		result.Stages[i].PlayCount = reachedByStage[result.Stages[i].Completion]
+		if result.SampledPlays > 0 {
+			result.Stages[i].Percentage = math.Round(float64(result.Stages[i].PlayCount)/float64(result.SampledPlays)*10000) / 100
+		}
+	}
+	if result.TotalPlays > 0 {
+		result.Coverage = math.Round(float64(result.SampledPlays)/float64(result.TotalPlays)*1000) / 1000
+	}
+
+	return result, nil
+}
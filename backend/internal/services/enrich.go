@@ -0,0 +1,395 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// IDs reais do Spotify (22 caracteres base62); os sintéticos do import (artist_..., track_...) não podem ser enriquecidos
+const spotifyIDPattern = `^[0-9A-Za-z]{22}$`
+
+var spotifyIDRegexp = regexp.MustCompile(spotifyIDPattern)
+
+// Limite de IDs por chamada em /v1/tracks e /v1/artists
+const enrichmentBatchSize = 50
+
+// Artistas que continuam sem gêneros nem imagem depois de uma busca só voltam a ser buscados após 1, 2, 4, 8 e
+// depois a cada 16 dias (enrich_attempts), em vez de gastar chamadas em toda execução
+const artistEnrichDueCondition = `(ar.enrich_attempted_at IS NULL
+	OR ar.enrich_attempted_at <= NOW() - INTERVAL '1 day' * POWER(2, LEAST(ar.enrich_attempts, 5) - 1))`
+
+var (
+	ErrArtistNotFound      = errors.New("artist not found")
+	ErrArtistNotEnrichable = errors.New("artist has no spotify id")
+)
+
+type EnrichmentStatus struct {
+	PendingTracks        int `json:"pending_tracks"`         // faixas escutadas sem duração/popularidade ou sem a flag explicit
+	PendingArtists       int `json:"pending_artists"`        // artistas escutados sem gêneros nem imagem, fora os que esperam nova tentativa
+	PendingAudioFeatures int `json:"pending_audio_features"` // faixas escutadas sem audio features buscadas
+}
+
+type EnrichmentResult struct {
+	TracksEnriched           int              `json:"tracks_enriched"`
+	ArtistsEnriched          int              `json:"artists_enriched"`
+	AudioFeaturesFetched     int              `json:"audio_features_fetched"`
+	AudioFeaturesUnavailable bool             `json:"audio_features_unavailable"` // o Spotify recusou /v1/audio-features para o app
+	ArtistRelationsCorrected int64            `json:"artist_relations_corrected"` // vínculos sintéticos removidos das faixas resolvidas
+	Failed                   int              `json:"failed"`
+	RateLimited              bool             `json:"rate_limited"`
+	Remaining                EnrichmentStatus `json:"remaining"`
+}
+
+func queryEnrichmentStatus(ctx context.Context, db *sql.DB, userID string) (*EnrichmentStatus, error) {
+	status := &EnrichmentStatus{}
+	err := db.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COUNT(DISTINCT t.id)
+			 FROM listening_history lh
+			 JOIN tracks t ON t.id = lh.track_id
+			 WHERE lh.user_id = $1 AND lh.deleted_at IS NULL
+				AND t.id ~ $2 AND (COALESCE(t.duration_ms, 0) = 0 OR t.explicit IS NULL)),
+			(SELECT COUNT(DISTINCT ar.id)
+			 FROM listening_history lh
+			 JOIN track_artists ta ON ta.track_id = lh.track_id
+			 JOIN artists ar ON ar.id = ta.artist_id
+			 WHERE lh.user_id = $1 AND lh.deleted_at IS NULL
+				AND ar.id ~ $2 AND COALESCE(cardinality(ar.genres), 0) = 0 AND COALESCE(ar.image_url, '') = ''
+				AND `+artistEnrichDueCondition+`),
+			(SELECT COUNT(DISTINCT lh.track_id)
+			 FROM listening_history lh
+			 LEFT JOIN track_audio_features af ON af.track_id = lh.track_id
+			 WHERE lh.user_id = $1 AND lh.deleted_at IS NULL
+				AND lh.track_id ~ $2 AND af.track_id IS NULL)
+	`, userID, spotifyIDPattern).Scan(&status.PendingTracks, &status.PendingArtists, &status.PendingAudioFeatures)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count entities pending enrichment: %w", err)
+	}
+	return status, nil
+}
+
+func (a *AnalyticsService) GetEnrichmentStatus(ctx context.Context, userID string) (*EnrichmentStatus, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	return queryEnrichmentStatus(ctx, a.db, userID)
+}
+
+// Completa no Spotify as faixas e artistas do usuário que vieram incompletos (ex.: import), em lotes de 50.
+// Processa no máximo EnrichMaxTracks faixas por execução; rate limit encerra a execução sem erro
+func (s *TrackingService) EnrichUserCatalog(ctx context.Context, userID, spotifyToken string) (*EnrichmentResult, error) {
+	result := &EnrichmentResult{}
+
+	trackIDs, err := s.pendingEnrichmentIDs(ctx, `
+		SELECT DISTINCT t.id
+		FROM listening_history lh
+		JOIN tracks t ON t.id = lh.track_id
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL
+			AND t.id ~ $2 AND (COALESCE(t.duration_ms, 0) = 0 OR t.explicit IS NULL)
+		ORDER BY t.id
+		LIMIT $3`, userID, s.config.EnrichMaxTracks)
+	if err != nil {
+		return nil, err
+	}
+
+	for start := 0; start < len(trackIDs) && !result.RateLimited; start += enrichmentBatchSize {
+		batch := trackIDs[start:min(start+enrichmentBatchSize, len(trackIDs))]
+
+		var response struct {
+			Tracks []*CurrentlyPlayingTrack `json:"tracks"`
+		}
+		if err := s.getCatalogBatch(spotifyToken, "/v1/tracks", batch, &response); err != nil {
+			if !s.handleEnrichmentError(err, result, len(batch)) {
+				return nil, err
+			}
+			continue
+		}
+
+		for _, track := range response.Tracks {
+			if track == nil {
+				result.Failed++
+				continue
+			}
+			if err := s.saveEnrichedTrack(ctx, track); err != nil {
+				log.Printf("Error saving enriched track %s: %v", track.ID, err)
+				result.Failed++
+				continue
+			}
+			result.TracksEnriched++
+		}
+	}
+
+	// Artistas: inclui os que acabaram de ser criados a partir das faixas acima
+	artistIDs, err := s.pendingEnrichmentIDs(ctx, `
+		SELECT DISTINCT ar.id
+		FROM listening_history lh
+		JOIN track_artists ta ON ta.track_id = lh.track_id
+		JOIN artists ar ON ar.id = ta.artist_id
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL
+			AND ar.id ~ $2 AND COALESCE(cardinality(ar.genres), 0) = 0 AND COALESCE(ar.image_url, '') = ''
+			AND `+artistEnrichDueCondition+`
+		ORDER BY ar.id
+		LIMIT $3`, userID, s.config.EnrichMaxTracks)
+	if err != nil {
+		return nil, err
+	}
+
+	for start := 0; start < len(artistIDs) && !result.RateLimited; start += enrichmentBatchSize {
+		batch := artistIDs[start:min(start+enrichmentBatchSize, len(artistIDs))]
+
+		var response struct {
+			Artists []*SpotifyArtist `json:"artists"`
+		}
+		if err := s.getCatalogBatch(spotifyToken, "/v1/artists", batch, &response); err != nil {
+			if !s.handleEnrichmentError(err, result, len(batch)) {
+				return nil, err
+			}
+			continue
+		}
+
+		// Conta a tentativa de todo o lote: os que vieram com dados deixam de estar pendentes e o contador não
+		// importa mais
+		if err := s.recordArtistEnrichAttempts(ctx, batch); err != nil {
+			log.Printf("Error recording artist enrichment attempts: %v", err)
+		}
+
+		for _, artist := range response.Artists {
+			if artist == nil {
+				result.Failed++
+				continue
+			}
+			if err := s.saveEnrichedArtist(ctx, artist); err != nil {
+				log.Printf("Error saving enriched artist %s: %v", artist.ID, err)
+				result.Failed++
+				continue
+			}
+			result.ArtistsEnriched++
+		}
+	}
+
+	if err := s.enrichAudioFeatures(ctx, userID, spotifyToken, result); err != nil {
+		return nil, err
+	}
+
+	// As faixas enriquecidas ganharam os artistas reais: os vínculos sintéticos do import saem
+	if result.TracksEnriched > 0 {
+		corrected, err := ReconcileTrackArtists(ctx, s.db, userID)
+		if err != nil {
+			log.Printf("Error reconciling track artists for user %s: %v", userID, err)
+		}
+		result.ArtistRelationsCorrected = corrected
+	}
+
+	remaining, err := queryEnrichmentStatus(ctx, s.db, userID)
+	if err != nil {
+		return nil, err
+	}
+	result.Remaining = *remaining
+
+	// Gêneros novos (e artistas trocados) não entram nos agregados pelos incrementos, e os analytics em cache
+	// foram calculados sem eles
+	if result.ArtistsEnriched > 0 || result.ArtistRelationsCorrected > 0 {
+		if err := RebuildListeningAggregates(ctx, s.db, userID); err != nil {
+			log.Printf("Error rebuilding listening aggregates for user %s: %v", userID, err)
+		}
+		if err := invalidateAnalyticsCache(ctx, s.db, userID); err != nil {
+			log.Printf("Error invalidating analytics cache for user %s: %v", userID, err)
+		}
+	}
+
+	log.Printf("Enrichment for user %s: %d tracks, %d artists, %d audio features, %d failed (remaining: %d tracks, %d artists, %d audio features)",
+		userID, result.TracksEnriched, result.ArtistsEnriched, result.AudioFeaturesFetched, result.Failed,
+		remaining.PendingTracks, remaining.PendingArtists, remaining.PendingAudioFeatures)
+
+	return result, nil
+}
+
+// Enriquece um artista específico do catálogo (gêneros, popularidade e imagem), mesmo que já tenha dados
+func (s *TrackingService) EnrichArtist(ctx context.Context, artistID, spotifyToken string) (*SpotifyArtist, error) {
+	var exists bool
+	err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM artists WHERE id = $1)`, artistID).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query artist: %w", err)
+	}
+	if !exists {
+		return nil, ErrArtistNotFound
+	}
+	if !spotifyIDRegexp.MatchString(artistID) {
+		return nil, ErrArtistNotEnrichable
+	}
+
+	var artist SpotifyArtist
+	if err := s.getJSON(spotifyToken, s.config.SpotifyAPIBaseURL+"/v1/artists/"+url.PathEscape(artistID), &artist); err != nil {
+		var apiErr *SpotifyAPIError
+		if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusNotFound || apiErr.StatusCode == http.StatusBadRequest) {
+			return nil, ErrArtistNotEnrichable
+		}
+		return nil, err
+	}
+
+	if err := s.saveEnrichedArtist(ctx, &artist); err != nil {
+		return nil, fmt.Errorf("failed to save enriched artist: %w", err)
+	}
+	if artist.Genres == nil {
+		artist.Genres = []string{}
+	}
+	return &artist, nil
+}
+
+func (s *TrackingService) pendingEnrichmentIDs(ctx context.Context, query, userID string, limit int) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, query, userID, spotifyIDPattern, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query entities pending enrichment: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// 429 encerra a execução (o que falta fica para a próxima); 401 é fatal; outros erros só contam como falha do lote
+func (s *TrackingService) handleEnrichmentError(err error, result *EnrichmentResult, batchSize int) bool {
+	if IsSpotifyUnauthorized(err) {
+		return false
+	}
+
+	log.Printf("Error fetching enrichment batch: %v", err)
+	var apiErr *SpotifyAPIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusTooManyRequests {
+		result.RateLimited = true
+		return true
+	}
+
+	result.Failed += batchSize
+	return true
+}
+
+func (s *TrackingService) getCatalogBatch(spotifyToken, path string, ids []string, out interface{}) error {
+	params := url.Values{}
+	params.Set("ids", strings.Join(ids, ","))
+
+	return s.getJSON(spotifyToken, s.config.SpotifyAPIBaseURL+path+"?"+params.Encode(), out)
+}
+
+// Atualiza a faixa com os dados do Spotify e troca o álbum/artistas sintéticos do import pelos reais.
+// Os artistas entram só com o nome; gêneros e imagem vêm na etapa de artistas
+func (s *TrackingService) saveEnrichedTrack(ctx context.Context, track *CurrentlyPlayingTrack) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	album := track.Album
+	imageURL := ""
+	if len(album.Images) > 0 {
+		imageURL = album.Images[0].URL
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO albums (id, name, release_date, image_url, total_tracks, created_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, 0), NOW())
+		ON CONFLICT (id) DO UPDATE SET
+			name = EXCLUDED.name,
+			release_date = COALESCE(EXCLUDED.release_date, albums.release_date),
+			image_url = EXCLUDED.image_url,
+			total_tracks = COALESCE(EXCLUDED.total_tracks, albums.total_tracks)
+	`, album.ID, album.Name, normalizeReleaseDate(album.ReleaseDate), imageURL, album.TotalTracks)
+	if err != nil {
+		return fmt.Errorf("failed to save album: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE tracks SET
+			name = $2,
+			album_id = $3,
+			duration_ms = $4,
+			popularity = $5,
+			preview_url = $6,
+			explicit = $7
+		WHERE id = $1
+	`, track.ID, track.Name, album.ID, track.DurationMs, track.Popularity, track.PreviewURL, track.Explicit)
+	if err != nil {
+		return fmt.Errorf("failed to update track: %w", err)
+	}
+
+	if len(track.Artists) > 0 {
+		artistIDs := make([]string, 0, len(track.Artists))
+		for _, artist := range track.Artists {
+			_, err = tx.ExecContext(ctx, `
+				INSERT INTO artists (id, name, created_at)
+				VALUES ($1, $2, NOW())
+				ON CONFLICT (id) DO NOTHING
+			`, artist.ID, artist.Name)
+			if err != nil {
+				return fmt.Errorf("failed to save artist: %w", err)
+			}
+
+			_, err = tx.ExecContext(ctx, `
+				INSERT INTO track_artists (track_id, artist_id)
+				VALUES ($1, $2)
+				ON CONFLICT DO NOTHING
+			`, track.ID, artist.ID)
+			if err != nil {
+				return fmt.Errorf("failed to save track-artist relation: %w", err)
+			}
+			artistIDs = append(artistIDs, artist.ID)
+		}
+
+		// Vínculos com artistas que o Spotify não lista (ex.: artist_<nome> do import) deixam de valer
+		_, err = tx.ExecContext(ctx, `
+			DELETE FROM track_artists WHERE track_id = $1 AND NOT (artist_id = ANY($2))
+		`, track.ID, pq.Array(artistIDs))
+		if err != nil {
+			return fmt.Errorf("failed to remove stale track-artist relations: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (s *TrackingService) recordArtistEnrichAttempts(ctx context.Context, artistIDs []string) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE artists SET enrich_attempts = enrich_attempts + 1, enrich_attempted_at = NOW()
+		WHERE id = ANY($1)
+	`, pq.Array(artistIDs))
+	return err
+}
+
+func (s *TrackingService) saveEnrichedArtist(ctx context.Context, artist *SpotifyArtist) error {
+	imageURL := ""
+	if len(artist.Images) > 0 {
+		imageURL = artist.Images[0].URL
+	}
+
+	genres := pq.StringArray(artist.Genres)
+	if genres == nil {
+		genres = pq.StringArray{}
+	}
+
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE artists SET name = $2, genres = $3, popularity = $4, image_url = $5
+		WHERE id = $1
+	`, artist.ID, artist.Name, genres, artist.Popularity, imageURL)
+	return err
+}
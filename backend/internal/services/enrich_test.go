@@ -0,0 +1,60 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"musike-backend/internal/config"
+)
+
+// Artista que o Spotify não resolve é buscado uma vez e depois espera, em vez de voltar em toda execução
+func TestEnrichUserCatalogBacksOffUnresolvedArtists(t *testing.T) {
+	db := openTestDB(t)
+	userID := createTestUser(t, db)
+	trackID, _ := createTestTrack(t, db, "rock")
+
+	artistID := "0unresolvedArtist00000" // formato de ID do Spotify, 22 caracteres
+	if _, err := db.Exec(`INSERT INTO artists (id, name) VALUES ($1, 'Unresolved') ON CONFLICT (id) DO NOTHING`, artistID); err != nil {
+		t.Fatalf("failed to create artist: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Exec(`DELETE FROM track_artists WHERE artist_id = $1`, artistID)
+		db.Exec(`DELETE FROM artists WHERE id = $1`, artistID)
+	})
+	if _, err := db.Exec(`INSERT INTO track_artists (track_id, artist_id) VALUES ($1, $2)`, trackID, artistID); err != nil {
+		t.Fatalf("failed to link artist: %v", err)
+	}
+	insertTestPlay(t, db, userID, trackID, "import", time.Now().Add(-time.Hour))
+
+	var artistCalls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/artists" {
+			artistCalls.Add(1)
+			w.Write([]byte(`{"artists": [null]}`))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	s := NewTrackingService(&config.Config{SpotifyAPIBaseURL: server.URL, EnrichMaxTracks: 50, SessionSaveMode: "fixed"}, db)
+	ctx := context.Background()
+
+	for run := 0; run < 2; run++ {
+		if _, err := s.EnrichUserCatalog(ctx, userID, "token"); err != nil {
+			t.Fatalf("EnrichUserCatalog run %d: %v", run, err)
+		}
+	}
+	if got := artistCalls.Load(); got != 1 {
+		t.Errorf("Spotify /v1/artists called %d times in two runs, want 1", got)
+	}
+
+	var attempts int
+	if err := db.QueryRow(`SELECT enrich_attempts FROM artists WHERE id = $1`, artistID).Scan(&attempts); err != nil || attempts != 1 {
+		t.Errorf("enrich_attempts = %d (err %v), want 1", attempts, err)
+	}
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Valores observados (o que está no banco) e estimados (extrapolação) ficam em blocos separados
+type ListeningEstimate struct {
+	Observed   ObservedListening  `json:"observed"`
+	Estimated  EstimatedListening `json:"estimated"`
+	Confidence string             `json:"confidence"` // high, medium, low
+	Note       string             `json:"note"`
+}
+
+type ObservedListening struct {
+	TotalMinutes           float64 `json:"total_minutes"`
+	Plays                  int     `json:"plays"`
+	ActiveDays             int     `json:"active_days"`
+	AvgMinutesPerActiveDay float64 `json:"avg_minutes_per_active_day"`
+	FirstDay               string  `json:"first_day,omitempty"`
+	LastDay                string  `json:"last_day,omitempty"`
+	SpanDays               int     `json:"span_days"`
+}
+
+type EstimatedListening struct {
+	TotalMinutes float64 `json:"total_minutes"`
+	MissingDays  int     `json:"missing_days"`  // dias dentro de lacunas tratadas como dados ausentes
+	ActivityRate float64 `json:"activity_rate"` // fração de dias com escuta fora das lacunas
+	AddedMinutes float64 `json:"added_minutes"` // quanto a estimativa soma ao observado
+}
+
+// Sequências sem nenhuma escuta a partir desse tamanho são tratadas como falta de dados (ex.: período
+// sem export/import), não como dias sem ouvir música
+const estimateGapDays = 14
+
+// Extrapola o tempo total de escuta preenchendo as lacunas do histórico com a média por dia ativo,
+// ponderada pela fração de dias ativos no restante do período
+func (a *AnalyticsService) GetListeningEstimate(ctx context.Context, userID string, loc *time.Location) (*ListeningEstimate, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		WITH days AS (
+			SELECT DATE(%s) as day, COUNT(*) as plays, COALESCE(SUM(lh.listened_duration_ms), 0) as duration_ms
+			FROM listening_history lh
+			WHERE lh.user_id = $1 AND lh.deleted_at IS NULL
+			GROUP BY day
+		),
+		gaps AS (
+			SELECT day - LAG(day) OVER (ORDER BY day) - 1 as empty_days
+			FROM days
+		)
+		SELECT
+			COUNT(*),
+			COALESCE(SUM(plays), 0),
+			COALESCE(SUM(duration_ms), 0),
+			COALESCE(TO_CHAR(MIN(day), 'YYYY-MM-DD'), ''),
+			COALESCE(TO_CHAR(MAX(day), 'YYYY-MM-DD'), ''),
+			COALESCE(MAX(day) - MIN(day) + 1, 0),
+			(SELECT COALESCE(SUM(empty_days), 0) FROM gaps WHERE empty_days >= $3)
+		FROM days`, localPlayedAt(2))
+
+	estimate := &ListeningEstimate{}
+	observed := &estimate.Observed
+	var durationMs int64
+	err := a.db.QueryRowContext(ctx, query, userID, loc.String(), estimateGapDays).Scan(
+		&observed.ActiveDays, &observed.Plays, &durationMs, &observed.FirstDay, &observed.LastDay,
+		&observed.SpanDays, &estimate.Estimated.MissingDays)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query listening estimate: %w", err)
+	}
+
+	observed.TotalMinutes = roundMinutes(float64(durationMs) / 60000)
+
+	if observed.ActiveDays == 0 {
+		estimate.Confidence = "low"
+		estimate.Note = "No listening history yet, nothing to estimate"
+		return estimate, nil
+	}
+
+	avgPerActiveDay := float64(durationMs) / 60000 / float64(observed.ActiveDays)
+	observed.AvgMinutesPerActiveDay = roundMinutes(avgPerActiveDay)
+
+	estimated := &estimate.Estimated
+	coveredDays := observed.SpanDays - estimated.MissingDays
+	estimated.ActivityRate = math.Round(float64(observed.ActiveDays)/float64(coveredDays)*1000) / 1000
+
+	added := float64(estimated.MissingDays) * float64(observed.ActiveDays) / float64(coveredDays) * avgPerActiveDay
+	estimated.AddedMinutes = roundMinutes(added)
+	estimated.TotalMinutes = roundMinutes(float64(durationMs)/60000 + added)
+
+	missingShare := float64(estimated.MissingDays) / float64(observed.SpanDays)
+	switch {
+	case observed.ActiveDays < 30:
+		estimate.Confidence = "low"
+		estimate.Note = "Too few active days for a reliable average; the estimate is only indicative"
+	case missingShare == 0:
+		estimate.Confidence = "high"
+		estimate.Note = "No gaps found in the history; the estimate equals the observed total"
+	case missingShare < 0.1:
+		estimate.Confidence = "high"
+		estimate.Note = "Small gaps were filled using your average per active day"
+	case missingShare < 0.35:
+		estimate.Confidence = "medium"
+		estimate.Note = "Gaps in the history were filled using your average per active day; treat the estimate as approximate"
+	default:
+		estimate.Confidence = "low"
+		estimate.Note = "A large part of the period has no data; the estimate relies heavily on extrapolation"
+	}
+
+	return estimate, nil
+}
+
+func roundMinutes(minutes float64) float64 {
+	return math.Round(minutes*100) / 100
+}
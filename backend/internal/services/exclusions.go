@@ -0,0 +1,103 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+const maxExcludedArtists = 200
+
+var ErrInvalidExclusions = errors.New("invalid exclusions")
+
+// Artistas e gêneros que o usuário tirou dos analytics (ex.: ruído branco, música de ninar)
+type Exclusions struct {
+	ArtistIDs []string `json:"artist_ids"`
+	Genres    []string `json:"genres"`
+}
+
+// Condição para os *FromDB: a escuta sai dos analytics se algum artista da faixa estiver excluído, pelo ID
+// ou por ter um gênero (já com a correção do usuário) na lista. Com user_exclusions vazia o NOT EXISTS
+// para no primeiro JOIN; com listas cheias custa um lookup em track_artists e artists por escuta
+var excludedPlayCondition = `NOT EXISTS (
+			SELECT 1 FROM user_exclusions ux
+			JOIN track_artists xta ON xta.track_id = lh.track_id
+			JOIN artists xar ON xar.id = xta.artist_id
+			WHERE ux.user_id = lh.user_id
+				AND (xar.id = ANY(ux.artist_ids) OR ` + effectiveGenres("lh.user_id", "xar") + ` && ux.genres)
+		)`
+
+func (a *AnalyticsService) GetExclusions(ctx context.Context, userID string) (*Exclusions, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	var artistIDs, genres pq.StringArray
+	err := a.db.QueryRowContext(ctx, `
+		SELECT artist_ids, genres FROM user_exclusions WHERE user_id = $1
+	`, userID).Scan(&artistIDs, &genres)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to query exclusions: %w", err)
+	}
+
+	exclusions := &Exclusions{ArtistIDs: []string(artistIDs), Genres: []string(genres)}
+	if exclusions.ArtistIDs == nil {
+		exclusions.ArtistIDs = []string{}
+	}
+	if exclusions.Genres == nil {
+		exclusions.Genres = []string{}
+	}
+	return exclusions, nil
+}
+
+// Substitui a lista inteira; listas vazias voltam a incluir tudo. Gêneros são normalizados como nas correções
+func (a *AnalyticsService) SetExclusions(ctx context.Context, userID string, exclusions Exclusions) (*Exclusions, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	genres, err := NormalizeGenres(exclusions.Genres)
+	if err != nil {
+		return nil, err
+	}
+
+	artistIDs := make([]string, 0, len(exclusions.ArtistIDs))
+	seen := make(map[string]bool, len(exclusions.ArtistIDs))
+	for _, artistID := range exclusions.ArtistIDs {
+		if artistID == "" || seen[artistID] {
+			continue
+		}
+		seen[artistID] = true
+		artistIDs = append(artistIDs, artistID)
+	}
+	if len(artistIDs) > maxExcludedArtists {
+		return nil, fmt.Errorf("%w: at most %d excluded artists", ErrInvalidExclusions, maxExcludedArtists)
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	_, err = a.db.ExecContext(ctx, `
+		INSERT INTO user_exclusions (user_id, artist_ids, genres)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET
+			artist_ids = EXCLUDED.artist_ids,
+			genres = EXCLUDED.genres,
+			updated_at = CURRENT_TIMESTAMP
+	`, userID, pq.StringArray(artistIDs), pq.StringArray(genres))
+	if err != nil {
+		return nil, fmt.Errorf("failed to save exclusions: %w", err)
+	}
+
+	// Agregados e analytics pré-calculados contavam as escutas que agora ficam de fora (ou o contrário)
+	if err := a.refreshDerivedData(ctx, userID); err != nil {
+		return nil, err
+	}
+	return &Exclusions{ArtistIDs: artistIDs, Genres: genres}, nil
+}
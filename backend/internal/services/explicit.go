@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"fmt"
+)
+
+type ExplicitBucket struct {
+	Plays        int     `json:"plays"`
+	Minutes      float64 `json:"minutes"`
+	PlaysShare   float64 `json:"plays_share"`   // fração das escutas com a flag conhecida
+	MinutesShare float64 `json:"minutes_share"` // fração dos minutos com a flag conhecida
+}
+
+// Escutas de faixas sem a flag explicit (import ainda não enriquecido) ficam fora das frações e entram
+// só em Unknown e na cobertura
+type ExplicitRatio struct {
+	TimeFilter string         `json:"time_filter"`
+	Explicit   ExplicitBucket `json:"explicit"`
+	Clean      ExplicitBucket `json:"clean"`
+	Unknown    struct {
+		Plays   int     `json:"plays"`
+		Minutes float64 `json:"minutes"`
+	} `json:"unknown"`
+	Coverage float64 `json:"coverage"` // fração das escutas com a flag conhecida; POST /user/enrich completa
+}
+
+// Divisão das escutas e dos minutos entre faixas explícitas e limpas
+func (a *AnalyticsService) GetExplicitRatio(ctx context.Context, userID, timeFilter string) (*ExplicitRatio, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	ratio := &ExplicitRatio{TimeFilter: timeFilter}
+	var explicitMs, cleanMs, unknownMs int64
+	err := a.db.QueryRowContext(ctx, `
+		SELECT
+			COUNT(*) FILTER (WHERE t.explicit),
+			COALESCE(SUM(lh.listened_duration_ms) FILTER (WHERE t.explicit), 0),
+			COUNT(*) FILTER (WHERE NOT t.explicit),
+			COALESCE(SUM(lh.listened_duration_ms) FILTER (WHERE NOT t.explicit), 0),
+			COUNT(*) FILTER (WHERE t.explicit IS NULL),
+			COALESCE(SUM(lh.listened_duration_ms) FILTER (WHERE t.explicit IS NULL), 0)
+		FROM listening_history lh
+		LEFT JOIN tracks t ON t.id = lh.track_id
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2
+	`, userID, timeFilterStartDate(timeFilter)).Scan(&ratio.Explicit.Plays, &explicitMs, &ratio.Clean.Plays, &cleanMs,
+		&ratio.Unknown.Plays, &unknownMs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query explicit ratio: %w", err)
+	}
+
+	ratio.Explicit.Minutes = roundMinutes(float64(explicitMs) / 60000)
+	ratio.Clean.Minutes = roundMinutes(float64(cleanMs) / 60000)
+	ratio.Unknown.Minutes = roundMinutes(float64(unknownMs) / 60000)
+
+	knownPlays := ratio.Explicit.Plays + ratio.Clean.Plays
+	if knownPlays > 0 {
+		ratio.Explicit.PlaysShare = roundFraction(float64(ratio.Explicit.Plays) / float64(knownPlays))
+		ratio.Clean.PlaysShare = roundFraction(float64(ratio.Clean.Plays) / float64(knownPlays))
+	}
+	if knownMs := explicitMs + cleanMs; knownMs > 0 {
+		ratio.Explicit.MinutesShare = roundFraction(float64(explicitMs) / float64(knownMs))
+		ratio.Clean.MinutesShare = roundFraction(float64(cleanMs) / float64(knownMs))
+	}
+	if totalPlays := knownPlays + ratio.Unknown.Plays; totalPlays > 0 {
+		ratio.Coverage = roundFraction(float64(knownPlays) / float64(totalPlays))
+	}
+
+	return ratio, nil
+}
@@ -0,0 +1,145 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var ErrInvalidFeedToken = errors.New("invalid feed token")
+
+// Máximo de eventos no feed, para o calendário não baixar um arquivo enorme a cada sincronização
+const maxFeedEvents = 500
+
+func hashFeedToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Gera um novo token do feed (invalida o anterior). Só o hash fica no banco
+func (a *AnalyticsService) RotateFeedToken(ctx context.Context, userID string) (string, error) {
+	if a.db == nil {
+		return "", fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate feed token: %w", err)
+	}
+	token := hex.EncodeToString(raw)
+
+	_, err := a.db.ExecContext(ctx, `UPDATE users SET feed_token_hash = $1 WHERE id = $2`, hashFeedToken(token), userID)
+	if err != nil {
+		return "", fmt.Errorf("failed to save feed token: %w", err)
+	}
+
+	return token, nil
+}
+
+func (a *AnalyticsService) GetUserIDByFeedToken(ctx context.Context, token string) (string, error) {
+	if a.db == nil {
+		return "", fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	var userID string
+	err := a.db.QueryRowContext(ctx, `SELECT id FROM users WHERE feed_token_hash = $1`, hashFeedToken(token)).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return "", ErrInvalidFeedToken
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to query feed token: %w", err)
+	}
+
+	return userID, nil
+}
+
+// Sessão de escuta do feed, com as escutas em ordem cronológica
+type FeedSession struct {
+	ID        string
+	StartedAt time.Time
+	Plays     []HistoryEntry
+}
+
+// Sessões dos últimos FeedWindowDays dias (limitadas a maxFeedEvents), mais recentes primeiro. As sessões são as
+// mesmas de /user/sessions, então o UID do evento continua o mesmo enquanto a sessão cresce
+func (a *AnalyticsService) GetFeedSessions(ctx context.Context, userID string) ([]FeedSession, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	since := time.Now().AddDate(0, 0, -a.config.FeedWindowDays)
+
+	rows, err := a.db.QueryContext(ctx, `
+		WITH `+sessionPlaysCTE("$2", "$3::int")+`,
+		sessions AS (
+			SELECT
+				session_no,
+				((ARRAY_AGG(id ORDER BY played_at, id))[1])::text as id,
+				MIN(played_at) as started_at
+			FROM numbered
+			GROUP BY session_no
+			HAVING MAX(played_at) >= $2
+			ORDER BY started_at DESC
+			LIMIT $4
+		)
+		SELECT
+			s.id,
+			s.started_at,
+			n.id,
+			n.played_at,
+			t.id,
+			t.name,
+			ARRAY(
+				SELECT ar.name FROM track_artists ta JOIN artists ar ON ta.artist_id = ar.id
+				WHERE ta.track_id = t.id ORDER BY ar.name
+			) as artists,
+			COALESCE(al.name, '') as album_name,
+			COALESCE(t.duration_ms, 0) as duration_ms,
+			n.listened_duration_ms
+		FROM sessions s
+		JOIN numbered n ON n.session_no = s.session_no
+		JOIN tracks t ON n.track_id = t.id
+		LEFT JOIN albums al ON t.album_id = al.id
+		ORDER BY s.started_at DESC, n.played_at, n.id`, userID, since.UTC(), int(listeningSessionGap.Seconds()), maxFeedEvents)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query feed sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := make([]FeedSession, 0)
+	for rows.Next() {
+		var sessionID string
+		var startedAt time.Time
+		var entry HistoryEntry
+		var artists pq.StringArray
+		if err := rows.Scan(&sessionID, &startedAt, &entry.ID, &entry.PlayedAt, &entry.TrackID, &entry.TrackName, &artists,
+			&entry.AlbumName, &entry.DurationMs, &entry.ListenedDurationMs); err != nil {
+			continue
+		}
+		entry.Artists = []string(artists)
+
+		if len(sessions) == 0 || sessions[len(sessions)-1].ID != sessionID {
+			sessions = append(sessions, FeedSession{ID: sessionID, StartedAt: startedAt})
+		}
+		last := &sessions[len(sessions)-1]
+		last.Plays = append(last.Plays, entry)
+	}
+
+	return sessions, rows.Err()
+}
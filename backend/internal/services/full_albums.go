@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type FullAlbumListen struct {
+	AlbumID          string    `json:"album_id"`
+	AlbumName        string    `json:"album_name"`
+	ImageURL         string    `json:"image_url,omitempty"`
+	Artists          []string  `json:"artists,omitempty"`
+	Date             string    `json:"date"` // dia (no fuso pedido) em que a audição começou
+	StartedAt        time.Time `json:"started_at"`
+	EndedAt          time.Time `json:"ended_at"`
+	TracksPlayed     int       `json:"tracks_played"`
+	TotalTracks      int       `json:"total_tracks"`
+	Completion       float64   `json:"completion"`         // 0-1
+	EstimatedTotal   bool      `json:"estimated_total"`    // total_tracks desconhecido: usa as faixas do álbum já salvas
+	FromAlbumContext bool      `json:"from_album_context"` // alguma faixa tocou com o álbum como contexto
+}
+
+// Uma pausa maior que isso entre duas escutas do mesmo álbum encerra a audição
+const fullAlbumMaxGap = 30 * time.Minute
+
+// Singles e faixas soltas não contam como álbum
+const fullAlbumMinTracks = 3
+
+// Audições de álbum: sequências de escutas consecutivas do mesmo álbum (sem outra faixa no meio e sem pausa
+// maior que fullAlbumMaxGap) cujas faixas distintas cobrem pelo menos minCompletion% do álbum.
+// minCompletion 0 usa FULL_ALBUM_MIN_COMPLETION
+func (a *AnalyticsService) GetFullAlbumListens(ctx context.Context, userID string, timeFilter string, minCompletion int, limit int, loc *time.Location) ([]FullAlbumListen, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	if minCompletion <= 0 {
+		minCompletion = a.config.FullAlbumMinCompletion
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	startDate := timeFilterStartDate(timeFilter)
+
+	// Escutas sem álbum entram na janela para interromper a sequência e só saem ao agrupar
+	query := `
+		WITH plays AS (
+			SELECT
+				lh.played_at,
+				` + localPlayedAt(5) + ` as local_played_at,
+				lh.track_id,
+				t.album_id,
+				lh.context_type,
+				LAG(t.album_id) OVER (ORDER BY lh.played_at) as prev_album_id,
+				LAG(lh.played_at) OVER (ORDER BY lh.played_at) as prev_played_at
+			FROM listening_history lh
+			JOIN tracks t ON lh.track_id = t.id
+			WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2
+		),
+		runs AS (
+			SELECT *,
+				SUM(CASE
+					WHEN album_id IS DISTINCT FROM prev_album_id OR played_at - prev_played_at > $3::int * INTERVAL '1 second' THEN 1
+					ELSE 0
+				END) OVER (ORDER BY played_at) as run_id
+			FROM plays
+		),
+		album_runs AS (
+			SELECT
+				album_id,
+				MIN(played_at) as started_at,
+				MIN(local_played_at) as local_started_at,
+				MAX(played_at) as ended_at,
+				COUNT(DISTINCT track_id) as tracks_played,
+				BOOL_OR(context_type = 'album') as from_album_context
+			FROM runs
+			WHERE album_id IS NOT NULL
+			GROUP BY run_id, album_id
+		),
+		scored AS (
+			SELECT
+				ar.*,
+				al.name,
+				COALESCE(al.image_url, '') as image_url,
+				COALESCE(NULLIF(al.total_tracks, 0), (SELECT COUNT(*) FROM tracks WHERE album_id = al.id)) as total_tracks,
+				COALESCE(al.total_tracks, 0) = 0 as estimated_total
+			FROM album_runs ar
+			JOIN albums al ON al.id = ar.album_id
+			WHERE ar.tracks_played >= $4
+		)
+		SELECT
+			s.album_id,
+			s.name,
+			s.image_url,
+			ARRAY(
+				SELECT DISTINCT art.name FROM tracks t
+				JOIN track_artists ta ON ta.track_id = t.id
+				JOIN artists art ON art.id = ta.artist_id
+				WHERE t.album_id = s.album_id
+				ORDER BY art.name
+			) as artists,
+			TO_CHAR(s.local_started_at, 'YYYY-MM-DD') as date,
+			s.started_at,
+			s.ended_at,
+			s.tracks_played,
+			s.total_tracks,
+			LEAST(1.0, s.tracks_played::float / s.total_tracks) as completion,
+			s.estimated_total,
+			s.from_album_context
+		FROM scored s
+		WHERE s.total_tracks >= $4 AND s.tracks_played::float / s.total_tracks >= $6
+		ORDER BY s.started_at DESC
+		LIMIT $7`
+
+	rows, err := a.db.QueryContext(ctx, query, userID, startDate, int(fullAlbumMaxGap.Seconds()),
+		fullAlbumMinTracks, loc.String(), float64(minCompletion)/100, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query full album listens: %w", err)
+	}
+	defer rows.Close()
+
+	listens := []FullAlbumListen{}
+	for rows.Next() {
+		var listen FullAlbumListen
+		var artists pq.StringArray
+		if err := rows.Scan(&listen.AlbumID, &listen.AlbumName, &listen.ImageURL, &artists, &listen.Date,
+			&listen.StartedAt, &listen.EndedAt, &listen.TracksPlayed, &listen.TotalTracks, &listen.Completion,
+			&listen.EstimatedTotal, &listen.FromAlbumContext); err != nil {
+			continue
+		}
+		listen.Artists = []string(artists)
+		listen.Completion = math.Round(listen.Completion*1000) / 1000
+		listens = append(listens, listen)
+	}
+
+	return listens, rows.Err()
+}
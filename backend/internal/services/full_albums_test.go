@@ -0,0 +1,57 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Uma faixa sem álbum no meio interrompe a audição, e a data é o dia no fuso pedido
+func TestFullAlbumListensBrokenByAlbumlessPlay(t *testing.T) {
+	db := openTestDB(t)
+	userID := createTestUser(t, db)
+	a := NewAnalyticsService(testConfig(), db)
+
+	albumID := fmt.Sprintf("album-%d", time.Now().UnixNano())
+	if _, err := db.Exec(`INSERT INTO albums (id, name, total_tracks) VALUES ($1, 'Album', 3)`, albumID); err != nil {
+		t.Fatalf("failed to create test album: %v", err)
+	}
+	t.Cleanup(func() { db.Exec(`DELETE FROM albums WHERE id = $1`, albumID) })
+
+	albumTracks := make([]string, 3)
+	for i := range albumTracks {
+		albumTracks[i], _ = createTestTrack(t, db, "rock")
+		if _, err := db.Exec(`UPDATE tracks SET album_id = $1 WHERE id = $2`, albumID, albumTracks[i]); err != nil {
+			t.Fatalf("failed to set track album: %v", err)
+		}
+	}
+	// createTestTrack não define álbum
+	singleID, _ := createTestTrack(t, db, "pop")
+
+	// 01:00 UTC ainda é o dia anterior em São Paulo
+	day := time.Now().UTC().AddDate(0, 0, -3).Truncate(24 * time.Hour)
+	broken := day.Add(-2 * time.Hour)
+	for i, trackID := range []string{albumTracks[0], singleID, albumTracks[1], albumTracks[2]} {
+		insertTestPlay(t, db, userID, trackID, "tracking", broken.Add(time.Duration(i)*4*time.Minute))
+	}
+	full := day.Add(time.Hour)
+	for i, trackID := range albumTracks {
+		insertTestPlay(t, db, userID, trackID, "tracking", full.Add(time.Duration(i)*4*time.Minute))
+	}
+
+	loc, err := time.LoadLocation("America/Sao_Paulo")
+	if err != nil {
+		t.Skipf("timezone data not available: %v", err)
+	}
+	listens, err := a.GetFullAlbumListens(context.Background(), userID, "6months", 100, 10, loc)
+	if err != nil {
+		t.Fatalf("GetFullAlbumListens: %v", err)
+	}
+	if len(listens) != 1 || !listens[0].StartedAt.Equal(full) || listens[0].TracksPlayed != 3 {
+		t.Fatalf("listens = %+v, want only the uninterrupted run starting at %s", listens, full)
+	}
+	if want := full.In(loc).Format("2006-01-02"); listens[0].Date != want {
+		t.Errorf("date = %s, want %s", listens[0].Date, want)
+	}
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+// Curiosidade com o texto pronto e o número por trás dele, para o cliente poder formatar do seu jeito
+type FunFact struct {
+	Key   string  `json:"key"`
+	Text  string  `json:"text"`
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit"`
+}
+
+type FunFacts struct {
+	TimeFilter   string    `json:"time_filter"`
+	TotalMinutes float64   `json:"total_minutes"`
+	TotalPlays   int       `json:"total_plays"`
+	Facts        []FunFact `json:"facts"`
+}
+
+// Curiosidades calculadas só a partir do total de minutos e de escutas do período. As constantes de
+// comparação (filme, passos por minuto, tamanho do passo) vêm da config (FUN_FACT_*)
+func (a *AnalyticsService) GetFunFacts(ctx context.Context, userID, timeFilter string) (*FunFacts, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	var totalMs int64
+	result := &FunFacts{TimeFilter: timeFilter, Facts: []FunFact{}}
+	err := a.db.QueryRowContext(ctx, `
+		SELECT COUNT(*), COALESCE(SUM(listened_duration_ms), 0)
+		FROM listening_history
+		WHERE user_id = $1 AND deleted_at IS NULL AND played_at >= $2
+	`, userID, timeFilterStartDate(timeFilter)).Scan(&result.TotalPlays, &totalMs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query fun facts totals: %w", err)
+	}
+
+	if result.TotalPlays == 0 {
+		return nil, ErrNoListeningHistory
+	}
+
+	minutes := float64(totalMs) / 60000
+	result.TotalMinutes = roundMinutes(minutes)
+
+	days := minutes / (24 * 60)
+	result.Facts = append(result.Facts, FunFact{
+		Key:   "days_listening",
+		Text:  fmt.Sprintf("You spent %.1f full days listening to music", days),
+		Value: math.Round(days*10) / 10,
+		Unit:  "days",
+	})
+
+	if movieMinutes := a.config.FunFactMovieMinutes; movieMinutes > 0 {
+		times := minutes / float64(movieMinutes)
+		result.Facts = append(result.Facts, FunFact{
+			Key:   "movie_watches",
+			Text:  fmt.Sprintf("That's enough time to watch %s %.0f times", a.config.FunFactMovieName, math.Floor(times)),
+			Value: math.Floor(times),
+			Unit:  "times",
+		})
+	}
+
+	// Caminhada no ritmo configurado durante todas as escutas e durante uma escuta média
+	metersPerMinute := float64(a.config.FunFactStepsPerMinute) * float64(a.config.FunFactStepLengthCm) / 100
+	if metersPerMinute > 0 {
+		km := minutes * metersPerMinute / 1000
+		result.Facts = append(result.Facts, FunFact{
+			Key:   "walking_distance",
+			Text:  fmt.Sprintf("Walking while you listened, you would have covered %.0f km", km),
+			Value: math.Round(km),
+			Unit:  "km",
+		})
+
+		songMeters := minutes / float64(result.TotalPlays) * metersPerMinute
+		result.Facts = append(result.Facts, FunFact{
+			Key:   "song_walk",
+			Text:  fmt.Sprintf("Each song lasts about %.0f m of walking", songMeters),
+			Value: math.Round(songMeters),
+			Unit:  "meters",
+		})
+	}
+
+	averageMinutes := minutes / float64(result.TotalPlays)
+	result.Facts = append(result.Facts, FunFact{
+		Key:   "average_play",
+		Text:  fmt.Sprintf("Across %d plays, you listened to each song for %.1f minutes on average", result.TotalPlays, averageMinutes),
+		Value: math.Round(averageMinutes*10) / 10,
+		Unit:  "minutes",
+	})
+
+	return result, nil
+}
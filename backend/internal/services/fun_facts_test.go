@@ -0,0 +1,29 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEmptyPeriodReportsNoListeningHistory(t *testing.T) {
+	db := openTestDB(t)
+	userID := createTestUser(t, db)
+	a := NewAnalyticsService(testConfig(), db)
+	ctx := context.Background()
+
+	if _, err := a.GetFunFacts(ctx, userID, "alltime"); !errors.Is(err, ErrNoListeningHistory) {
+		t.Errorf("GetFunFacts error = %v, want ErrNoListeningHistory", err)
+	}
+	if _, err := a.GetTop5Card(ctx, userID, "alltime", time.UTC); !errors.Is(err, ErrNoListeningHistory) {
+		t.Errorf("GetTop5Card error = %v, want ErrNoListeningHistory", err)
+	}
+
+	trackID, _ := createTestTrack(t, db, "rock")
+	insertTestPlay(t, db, userID, trackID, "tracking", time.Now().Add(-time.Hour))
+	facts, err := a.GetFunFacts(ctx, userID, "alltime")
+	if err != nil || facts.TotalPlays != 1 {
+		t.Errorf("GetFunFacts = %+v, %v, want 1 play", facts, err)
+	}
+}
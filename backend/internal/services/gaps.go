@@ -0,0 +1,91 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+type ListeningGap struct {
+	Start        time.Time `json:"start"` // última escuta antes do intervalo
+	End          time.Time `json:"end"`   // primeira escuta depois (agora, se o intervalo continua)
+	DurationSecs int64     `json:"duration_seconds"`
+	Days         float64   `json:"days"`
+	Ongoing      bool      `json:"ongoing"` // sem escuta desde Start até agora
+}
+
+// Maiores intervalos sem escuta no período (férias, pausas da música), do maior para o menor. O intervalo
+// entre a última escuta e agora também entra, marcado como ongoing
+func (a *AnalyticsService) GetListeningGaps(ctx context.Context, userID, timeFilter string, loc *time.Location, limit int) ([]ListeningGap, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	startDate := timeFilterStartDate(timeFilter)
+
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT gap_start, gap_end
+		FROM (
+			SELECT
+				LAG(lh.played_at) OVER (ORDER BY lh.played_at) as gap_start,
+				lh.played_at as gap_end
+			FROM listening_history lh
+			WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2
+		) gaps
+		WHERE gap_start IS NOT NULL AND gap_end > gap_start
+		ORDER BY gap_end - gap_start DESC
+		LIMIT $3
+	`, userID, startDate, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query listening gaps: %w", err)
+	}
+	defer rows.Close()
+
+	gaps := make([]ListeningGap, 0, limit+1)
+	for rows.Next() {
+		var gap ListeningGap
+		if err := rows.Scan(&gap.Start, &gap.End); err != nil {
+			continue
+		}
+		gaps = append(gaps, gap)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read listening gaps: %w", err)
+	}
+
+	var lastPlay sql.NullTime
+	err = a.db.QueryRowContext(ctx, `
+		SELECT MAX(played_at) FROM listening_history WHERE user_id = $1 AND deleted_at IS NULL AND played_at >= $2
+	`, userID, startDate).Scan(&lastPlay)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query last play: %w", err)
+	}
+
+	now := time.Now()
+	if lastPlay.Valid && now.After(lastPlay.Time) {
+		gaps = append(gaps, ListeningGap{Start: lastPlay.Time, End: now, Ongoing: true})
+	}
+
+	sort.SliceStable(gaps, func(i, j int) bool {
+		return gaps[i].End.Sub(gaps[i].Start) > gaps[j].End.Sub(gaps[j].Start)
+	})
+	if len(gaps) > limit {
+		gaps = gaps[:limit]
+	}
+
+	for i := range gaps {
+		duration := gaps[i].End.Sub(gaps[i].Start)
+		gaps[i].DurationSecs = int64(duration.Seconds())
+		gaps[i].Days = math.Round(duration.Hours()/24*100) / 100
+		gaps[i].Start = gaps[i].Start.In(loc)
+		gaps[i].End = gaps[i].End.In(loc)
+	}
+
+	return gaps, nil
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+)
+
+const (
+	maxGenreOverrides     = 20
+	maxGenreOverrideRunes = 64
+)
+
+var ErrInvalidGenres = errors.New("invalid genres")
+
+// Gêneros de artistAlias valendo para o usuário userRef: a correção feita por ele em artist_genre_overrides,
+// se houver, senão os do Spotify. Usar no lugar de <alias>.genres em toda consulta de gênero por usuário
+func effectiveGenres(userRef, artistAlias string) string {
+	return fmt.Sprintf(`COALESCE((
+			SELECT ago.genres FROM artist_genre_overrides ago
+			WHERE ago.user_id = %s AND ago.artist_id = %s.id
+		), %s.genres)`, userRef, artistAlias, artistAlias)
+}
+
+// Gêneros em minúsculas e sem espaços sobrando, como os do Spotify; repetidos são descartados
+func NormalizeGenres(genres []string) ([]string, error) {
+	if len(genres) > maxGenreOverrides {
+		return nil, fmt.Errorf("%w: at most %d genres", ErrInvalidGenres, maxGenreOverrides)
+	}
+
+	normalized := make([]string, 0, len(genres))
+	seen := make(map[string]bool, len(genres))
+	for _, genre := range genres {
+		genre = strings.ToLower(strings.Join(strings.Fields(genre), " "))
+		if genre == "" || utf8.RuneCountInString(genre) > maxGenreOverrideRunes {
+			return nil, fmt.Errorf("%w: each genre must have 1-%d characters", ErrInvalidGenres, maxGenreOverrideRunes)
+		}
+		if !seen[genre] {
+			seen[genre] = true
+			normalized = append(normalized, genre)
+		}
+	}
+	return normalized, nil
+}
+
+// Substitui, só para o usuário, os gêneros do artista. Lista vazia marca o artista como sem gênero
+func (a *AnalyticsService) SetGenreOverride(ctx context.Context, userID, artistID string, genres []string) error {
+	if a.db == nil {
+		return fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	result, err := a.db.ExecContext(ctx, `
+		INSERT INTO artist_genre_overrides (user_id, artist_id, genres)
+		SELECT $1, ar.id, $3 FROM artists ar WHERE ar.id = $2
+		ON CONFLICT (user_id, artist_id) DO UPDATE SET
+			genres = EXCLUDED.genres,
+			updated_at = CURRENT_TIMESTAMP
+	`, userID, artistID, pq.StringArray(genres))
+	if err != nil {
+		return fmt.Errorf("failed to save genre override: %w", err)
+	}
+	if saved, _ := result.RowsAffected(); saved == 0 {
+		return ErrArtistNotFound
+	}
+
+	return a.refreshDerivedData(ctx, userID)
+}
+
+// Volta a usar os gêneros do Spotify para o artista
+func (a *AnalyticsService) DeleteGenreOverride(ctx context.Context, userID, artistID string) error {
+	if a.db == nil {
+		return fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	result, err := a.db.ExecContext(ctx, `
+		DELETE FROM artist_genre_overrides WHERE user_id = $1 AND artist_id = $2
+	`, userID, artistID)
+	if err != nil {
+		return fmt.Errorf("failed to delete genre override: %w", err)
+	}
+	if removed, _ := result.RowsAffected(); removed == 0 {
+		return ErrArtistNotFound
+	}
+
+	return a.refreshDerivedData(ctx, userID)
+}
+
+// Agregados e analytics pré-calculados dependem dos gêneros e das exclusões do usuário; recalcula depois de
+// qualquer mudança neles
+func (a *AnalyticsService) refreshDerivedData(ctx context.Context, userID string) error {
+	if err := RebuildListeningAggregates(ctx, a.db, userID); err != nil {
+		return err
+	}
+	return invalidateAnalyticsCache(ctx, a.db, userID)
+}
+
+// Para os caminhos de escrita fora do pacote (import)
+func InvalidateAnalyticsCache(ctx context.Context, db *sql.DB, userID string) error {
+	return invalidateAnalyticsCache(ctx, db, userID)
+}
+
+// Apaga os analytics pré-calculados do usuário; a próxima leitura calcula de novo com os dados atuais.
+// Recebe a transação que grava a escuta quando houver, para o cache sumir junto com o commit
+func invalidateAnalyticsCache(ctx context.Context, db execer, userID string) error {
+	if _, err := db.ExecContext(ctx, `DELETE FROM user_analytics_cache WHERE user_id = $1`, userID); err != nil {
+		return fmt.Errorf("failed to invalidate analytics cache: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+type GenreTotals struct {
+	Genre        string    `json:"genre"`
+	PlayCount    int       `json:"play_count"`
+	Minutes      float64   `json:"minutes"`
+	FirstPlayed  time.Time `json:"first_played_at"`
+	LastPlayedAt time.Time `json:"last_played_at"`
+}
+
+// Ordenações aceitas por GetAllGenres (valor de ?sort=)
+var genreSortColumns = map[string]string{
+	"plays":   "play_count DESC, genre ASC",
+	"minutes": "duration_ms DESC, genre ASC",
+	"name":    "genre ASC",
+	"recent":  "last_played_at DESC, genre ASC",
+}
+
+func IsValidGenreSort(sort string) bool {
+	_, ok := genreSortColumns[sort]
+	return ok
+}
+
+func (a *AnalyticsService) GetAllGenres(ctx context.Context, userID string, timeFilter string, search string, sort string) ([]GenreTotals, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	orderBy, ok := genreSortColumns[sort]
+	if !ok {
+		orderBy = genreSortColumns["plays"]
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	// DISTINCT por (escuta, gênero) para que uma faixa com dois artistas do mesmo gênero conte uma vez
+	query := fmt.Sprintf(`
+		WITH play_genres AS (
+			SELECT DISTINCT lh.id, lh.played_at, COALESCE(lh.listened_duration_ms, 0) as listened_duration_ms, g.genre
+			FROM listening_history lh
+			JOIN track_artists ta ON ta.track_id = lh.track_id
+			JOIN artists ar ON ar.id = ta.artist_id
+			CROSS JOIN LATERAL UNNEST(`+effectiveGenres("lh.user_id", "ar")+`) AS g(genre)
+			WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2
+				AND ($3 = '' OR STRPOS(LOWER(g.genre), $3) > 0)
+		)
+		SELECT
+			genre,
+			COUNT(*) as play_count,
+			SUM(listened_duration_ms) as duration_ms,
+			MIN(played_at) as first_played_at,
+			MAX(played_at) as last_played_at
+		FROM play_genres
+		GROUP BY genre
+		ORDER BY %s`, orderBy)
+
+	rows, err := a.db.QueryContext(ctx, query, userID, timeFilterStartDate(timeFilter), strings.ToLower(strings.TrimSpace(search)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query genres: %w", err)
+	}
+	defer rows.Close()
+
+	genres := make([]GenreTotals, 0)
+	for rows.Next() {
+		var genre GenreTotals
+		var durationMs int64
+		if err := rows.Scan(&genre.Genre, &genre.PlayCount, &durationMs, &genre.FirstPlayed, &genre.LastPlayedAt); err != nil {
+			continue
+		}
+		genre.Minutes = float64(durationMs) / 60000
+		genres = append(genres, genre)
+	}
+
+	return genres, nil
+}
+
+type GenreNode struct {
+	Genre string `json:"genre"`
+	Plays int    `json:"plays"` // escutas dos artistas com o gênero
+}
+
+// Dois gêneros ligados por artistas que têm os dois. Weight soma as escutas desses artistas na janela
+type GenreEdge struct {
+	Source  string `json:"source"`
+	Target  string `json:"target"`
+	Weight  int    `json:"weight"`
+	Artists int    `json:"artists"`
+}
+
+type GenreNetwork struct {
+	Nodes []GenreNode `json:"nodes"`
+	Edges []GenreEdge `json:"edges"`
+}
+
+// Rede de gêneros que andam juntos na escuta do usuário: cada artista com vários gêneros liga todos os pares
+// deles, com o peso das escutas do artista. Arestas abaixo de minWeight ficam de fora e só as `limit` mais
+// pesadas voltam; os nós são os gêneros que aparecem nessas arestas
+func (a *AnalyticsService) GetGenreNetwork(ctx context.Context, userID string, timeFilter string, minWeight, limit int) (*GenreNetwork, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	query := `
+		WITH artist_plays AS (
+			SELECT ta.artist_id, COUNT(*) as plays
+			FROM listening_history lh
+			JOIN track_artists ta ON ta.track_id = lh.track_id
+			WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2
+			GROUP BY ta.artist_id
+		),
+		artist_genres AS (
+			SELECT DISTINCT ap.artist_id, ap.plays, g.genre
+			FROM artist_plays ap
+			JOIN artists ar ON ar.id = ap.artist_id
+			CROSS JOIN LATERAL UNNEST(` + effectiveGenres("$1::uuid", "ar") + `) AS g(genre)
+		),
+		genre_plays AS (
+			SELECT genre, SUM(plays) as plays FROM artist_genres GROUP BY genre
+		),
+		edges AS (
+			SELECT s.genre as source, t.genre as target, SUM(s.plays) as weight, COUNT(*) as artists
+			FROM artist_genres s
+			JOIN artist_genres t ON t.artist_id = s.artist_id AND t.genre > s.genre
+			GROUP BY s.genre, t.genre
+			HAVING SUM(s.plays) >= $3
+			ORDER BY weight DESC, source, target
+			LIMIT $4
+		)
+		SELECT e.source, e.target, e.weight, e.artists, sp.plays, tp.plays
+		FROM edges e
+		JOIN genre_plays sp ON sp.genre = e.source
+		JOIN genre_plays tp ON tp.genre = e.target
+		ORDER BY e.weight DESC, e.source, e.target`
+
+	rows, err := a.db.QueryContext(ctx, query, userID, timeFilterStartDate(timeFilter), minWeight, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query genre network: %w", err)
+	}
+	defer rows.Close()
+
+	network := &GenreNetwork{Nodes: []GenreNode{}, Edges: []GenreEdge{}}
+	nodePlays := make(map[string]int)
+	for rows.Next() {
+		var edge GenreEdge
+		var sourcePlays, targetPlays int
+		if err := rows.Scan(&edge.Source, &edge.Target, &edge.Weight, &edge.Artists, &sourcePlays, &targetPlays); err != nil {
+			continue
+		}
+		nodePlays[edge.Source] = sourcePlays
+		nodePlays[edge.Target] = targetPlays
+		network.Edges = append(network.Edges, edge)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for genre, plays := range nodePlays {
+		network.Nodes = append(network.Nodes, GenreNode{Genre: genre, Plays: plays})
+	}
+	sort.Slice(network.Nodes, func(i, j int) bool {
+		if network.Nodes[i].Plays != network.Nodes[j].Plays {
+			return network.Nodes[i].Plays > network.Nodes[j].Plays
+		}
+		return network.Nodes[i].Genre < network.Nodes[j].Genre
+	})
+
+	return network, nil
+}
@@ -0,0 +1,513 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type HistoryEntry struct {
+	ID                 string    `json:"id"`
+	PlayedAt           time.Time `json:"played_at"`
+	TrackID            string    `json:"track_id"`
+	TrackName          string    `json:"track_name"`
+	Artists            []string  `json:"artists"`
+	AlbumName          string    `json:"album_name"`
+	DurationMs         int64     `json:"duration_ms"`
+	ListenedDurationMs int64     `json:"listened_duration_ms"`
+	Source             string    `json:"source"`
+}
+
+func (a *AnalyticsService) GetHistoryForDate(ctx context.Context, userID string, date time.Time, loc *time.Location, source string) ([]HistoryEntry, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	// Limites do dia no fuso do usuário, convertidos para UTC (played_at é gravado em UTC)
+	dayStart := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, loc)
+	dayEnd := dayStart.AddDate(0, 0, 1)
+
+	query := `
+		SELECT
+			lh.id,
+			lh.played_at,
+			t.id,
+			t.name,
+			ARRAY_REMOVE(ARRAY_AGG(ar.name ORDER BY ar.name), NULL) as artists,
+			COALESCE(al.name, '') as album_name,
+			COALESCE(t.duration_ms, 0) as duration_ms,
+			COALESCE(lh.listened_duration_ms, 0) as listened_duration_ms,
+			lh.source
+		FROM listening_history lh
+		JOIN tracks t ON lh.track_id = t.id
+		LEFT JOIN albums al ON t.album_id = al.id
+		LEFT JOIN track_artists ta ON ta.track_id = t.id
+		LEFT JOIN artists ar ON ta.artist_id = ar.id
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2 AND lh.played_at < $3 AND ` + sourceCondition(4) + `
+		GROUP BY lh.id, lh.played_at, t.id, t.name, al.name, t.duration_ms, lh.listened_duration_ms, lh.source
+		ORDER BY lh.played_at ASC`
+
+	rows, err := a.db.QueryContext(ctx, query, userID, dayStart.UTC(), dayEnd.UTC(), source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query history for date: %w", err)
+	}
+	defer rows.Close()
+
+	history := make([]HistoryEntry, 0)
+	for rows.Next() {
+		var entry HistoryEntry
+		var artists pq.StringArray
+		if err := rows.Scan(&entry.ID, &entry.PlayedAt, &entry.TrackID, &entry.TrackName, &artists,
+			&entry.AlbumName, &entry.DurationMs, &entry.ListenedDurationMs, &entry.Source); err != nil {
+			continue
+		}
+		entry.PlayedAt = entry.PlayedAt.In(loc)
+		entry.Artists = []string(artists)
+		history = append(history, entry)
+	}
+
+	return history, nil
+}
+
+type CalendarDay struct {
+	Date      string  `json:"date"`
+	PlayCount int     `json:"play_count"`
+	Minutes   float64 `json:"minutes"`
+}
+
+// played_at é gravado em UTC; converte para o fuso passado no parâmetro posicional $n
+func localPlayedAt(param int) string {
+	return fmt.Sprintf("((lh.played_at AT TIME ZONE 'UTC') AT TIME ZONE $%d)", param)
+}
+
+// source vazio considera todas as origens
+func (a *AnalyticsService) GetMonthlyCalendar(ctx context.Context, userID string, year int, month time.Month, loc *time.Location, source string) ([]CalendarDay, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	monthStart := time.Date(year, month, 1, 0, 0, 0, 0, loc)
+	monthEnd := monthStart.AddDate(0, 1, 0)
+
+	query := fmt.Sprintf(`
+		SELECT
+			TO_CHAR(date_trunc('day', %s), 'YYYY-MM-DD') as day,
+			COUNT(*) as play_count,
+			COALESCE(SUM(lh.listened_duration_ms), 0) as duration_ms
+		FROM listening_history lh
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2 AND lh.played_at < $3 AND %s
+		GROUP BY day`, localPlayedAt(4), sourceCondition(5))
+
+	rows, err := a.db.QueryContext(ctx, query, userID, monthStart.UTC(), monthEnd.UTC(), loc.String(), source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query calendar: %w", err)
+	}
+	defer rows.Close()
+
+	dayStats := make(map[string]CalendarDay)
+	for rows.Next() {
+		var day CalendarDay
+		var durationMs int64
+		if err := rows.Scan(&day.Date, &day.PlayCount, &durationMs); err != nil {
+			continue
+		}
+		day.Minutes = float64(durationMs) / 60000
+		dayStats[day.Date] = day
+	}
+
+	// Preencher os dias sem escuta com zero
+	var calendar []CalendarDay
+	for d := monthStart; d.Before(monthEnd); d = d.AddDate(0, 0, 1) {
+		dateStr := d.Format("2006-01-02")
+		if day, exists := dayStats[dateStr]; exists {
+			calendar = append(calendar, day)
+		} else {
+			calendar = append(calendar, CalendarDay{Date: dateStr})
+		}
+	}
+
+	return calendar, nil
+}
+
+// Plays cujo artista (qualquer um dos artistas da faixa) tem o gênero informado
+var genrePlayCondition = `EXISTS (
+			SELECT 1 FROM track_artists gta
+			JOIN artists ga ON ga.id = gta.artist_id
+			WHERE gta.track_id = lh.track_id AND $3 = ANY(` + effectiveGenres("lh.user_id", "ga") + `)
+		)`
+
+// Com cursor, pagina por keyset a partir dele (offset é ignorado); sem cursor, mantém LIMIT/OFFSET.
+// nextCursor vem vazio na última página
+func (a *AnalyticsService) GetHistoryByGenre(ctx context.Context, userID string, genre string, source string, timeFilter string, limit int, offset int, cursor *HistoryCursor) ([]HistoryEntry, int, string, error) {
+	if a.db == nil {
+		return nil, 0, "", fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	startDate := timeFilterStartDate(timeFilter)
+
+	var total int
+	err := a.db.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM listening_history lh
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2 AND `+genrePlayCondition+`
+			AND `+sourceCondition(4),
+		userID, startDate, genre, source).Scan(&total)
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("failed to count history by genre: %w", err)
+	}
+
+	// Uma linha a mais indica se existe próxima página
+	args := []interface{}{userID, startDate, genre, source, limit + 1}
+	page := "LIMIT $5 OFFSET $6"
+	keyset := ""
+	if cursor != nil {
+		args = append(args, cursor.PlayedAt, cursor.ID)
+		page = "LIMIT $5"
+		keyset = "AND (lh.played_at, lh.id) < ($6, $7::uuid)"
+	} else {
+		args = append(args, offset)
+	}
+
+	query := historyEntrySelect + `
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2 AND ` + genrePlayCondition + `
+			AND ` + sourceCondition(4) + ` ` + keyset + `
+		GROUP BY lh.id, lh.played_at, t.id, t.name, al.name, t.duration_ms, lh.listened_duration_ms, lh.source
+		ORDER BY lh.played_at DESC, lh.id DESC
+		` + page
+
+	history, nextCursor, err := a.queryHistoryPage(ctx, query, limit, args...)
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("failed to query history by genre: %w", err)
+	}
+
+	return history, total, nextCursor, nil
+}
+
+// Histórico completo do mais recente para o mais antigo, paginado por keyset (played_at, id):
+// o custo de cada página não cresce com a profundidade, ao contrário de OFFSET
+func (a *AnalyticsService) GetHistoryPage(ctx context.Context, userID string, source string, cursor *HistoryCursor, limit int) ([]HistoryEntry, string, error) {
+	if a.db == nil {
+		return nil, "", fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	args := []interface{}{userID, limit + 1, source}
+	keyset := ""
+	if cursor != nil {
+		args = append(args, cursor.PlayedAt, cursor.ID)
+		keyset = "AND (lh.played_at, lh.id) < ($4, $5::uuid)"
+	}
+
+	query := historyEntrySelect + `
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND ` + sourceCondition(3) + ` ` + keyset + `
+		GROUP BY lh.id, lh.played_at, t.id, t.name, al.name, t.duration_ms, lh.listened_duration_ms, lh.source
+		ORDER BY lh.played_at DESC, lh.id DESC
+		LIMIT $2`
+
+	history, nextCursor, err := a.queryHistoryPage(ctx, query, limit, args...)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to query history page: %w", err)
+	}
+
+	return history, nextCursor, nil
+}
+
+const historyEntrySelect = `
+		SELECT
+			lh.id,
+			lh.played_at,
+			t.id,
+			t.name,
+			ARRAY_REMOVE(ARRAY_AGG(ar.name ORDER BY ar.name), NULL) as artists,
+			COALESCE(al.name, '') as album_name,
+			COALESCE(t.duration_ms, 0) as duration_ms,
+			COALESCE(lh.listened_duration_ms, 0) as listened_duration_ms,
+			lh.source
+		FROM listening_history lh
+		JOIN tracks t ON lh.track_id = t.id
+		LEFT JOIN albums al ON t.album_id = al.id
+		LEFT JOIN track_artists ta ON ta.track_id = t.id
+		LEFT JOIN artists ar ON ta.artist_id = ar.id`
+
+// Executa uma consulta que pede limit+1 linhas; a linha extra só indica que há próxima página
+func (a *AnalyticsService) queryHistoryPage(ctx context.Context, query string, limit int, args ...interface{}) ([]HistoryEntry, string, error) {
+	rows, err := a.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	history := make([]HistoryEntry, 0, limit)
+	hasMore := false
+	for rows.Next() {
+		if len(history) == limit {
+			hasMore = true
+			break
+		}
+
+		var entry HistoryEntry
+		var artists pq.StringArray
+		if err := rows.Scan(&entry.ID, &entry.PlayedAt, &entry.TrackID, &entry.TrackName, &artists,
+			&entry.AlbumName, &entry.DurationMs, &entry.ListenedDurationMs, &entry.Source); err != nil {
+			continue
+		}
+		entry.Artists = []string(artists)
+		history = append(history, entry)
+	}
+
+	nextCursor := ""
+	if hasMore && len(history) > 0 {
+		last := history[len(history)-1]
+		nextCursor = EncodeHistoryCursor(HistoryCursor{PlayedAt: last.PlayedAt, ID: last.ID})
+	}
+
+	return history, nextCursor, rows.Err()
+}
+
+var ErrHistoryEntryNotFound = errors.New("history entry not found")
+
+// Sem escutas no período: só os insights que montam algo a partir delas (card, curiosidades) devolvem isso; os
+// demais analytics devolvem o resultado vazio
+var ErrNoListeningHistory = errors.New("no listening history in the period")
+
+// Soft delete: a escuta some de todos os analytics (deleted_at IS NULL) mas pode ser restaurada.
+// Continua contando na deduplicação do sync, para o recently-played não reinseri-la
+func (a *AnalyticsService) DeleteHistoryEntry(ctx context.Context, userID, historyID string) error {
+	return a.setHistoryEntryDeleted(ctx, userID, historyID, true)
+}
+
+func (a *AnalyticsService) RestoreHistoryEntry(ctx context.Context, userID, historyID string) error {
+	return a.setHistoryEntryDeleted(ctx, userID, historyID, false)
+}
+
+func (a *AnalyticsService) setHistoryEntryDeleted(ctx context.Context, userID, historyID string, deleted bool) error {
+	if a.db == nil {
+		return fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	query := `UPDATE listening_history SET deleted_at = NOW()
+		WHERE id = $1::uuid AND user_id = $2 AND deleted_at IS NULL
+		RETURNING track_id, source, COALESCE(listened_duration_ms, 0)`
+	delta := -1
+	if !deleted {
+		query = `UPDATE listening_history SET deleted_at = NULL
+			WHERE id = $1::uuid AND user_id = $2 AND deleted_at IS NOT NULL
+			RETURNING track_id, source, COALESCE(listened_duration_ms, 0)`
+		delta = 1
+	}
+
+	tx, err := a.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var trackID, source string
+	var listenedMs int64
+	err = tx.QueryRowContext(ctx, query, historyID, userID).Scan(&trackID, &source, &listenedMs)
+	if err == sql.ErrNoRows {
+		return ErrHistoryEntryNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update history entry: %w", err)
+	}
+
+	if err := applyPlayAggregates(ctx, tx, userID, trackID, source, listenedMs, delta); err != nil {
+		return err
+	}
+	// Os analytics pré-calculados incluíam (ou não) essa escuta
+	if err := invalidateAnalyticsCache(ctx, tx, userID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// Quantas vezes o usuário já ouviu cada uma das faixas, numa única consulta. Faixas nunca ouvidas ficam fora do mapa
+func (a *AnalyticsService) GetTrackPlayCounts(ctx context.Context, userID string, trackIDs []string) (map[string]int, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	counts := make(map[string]int)
+	if len(trackIDs) == 0 {
+		return counts, nil
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT track_id, COUNT(*)
+		FROM listening_history
+		WHERE user_id = $1 AND deleted_at IS NULL AND track_id = ANY($2)
+		GROUP BY track_id
+	`, userID, pq.Array(trackIDs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query track play counts: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var trackID string
+		var plays int
+		if err := rows.Scan(&trackID, &plays); err != nil {
+			continue
+		}
+		counts[trackID] = plays
+	}
+
+	return counts, rows.Err()
+}
+
+// Mudanças devolvidas por GetHistorySince por página
+const MaxHistoryDeltaLimit = 500
+
+type HistoryDeltaEntry struct {
+	HistoryEntry
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type HistoryDelta struct {
+	Plays      []HistoryDeltaEntry `json:"plays"`
+	DeletedIDs []string            `json:"deleted_ids"` // escutas removidas desde a rodada anterior (tombstones)
+	NextCursor string              `json:"next_cursor"` // vazio quando não há mais mudanças nesta rodada
+	SyncToken  string              `json:"sync_token"`  // ?since= da próxima rodada
+}
+
+// Ponto de partida da rodada: o sync_token da rodada anterior ou, na primeira sincronização, um instante
+// (escutas gravadas depois dele, pelo created_at)
+type HistorySyncStart struct {
+	SyncToken uint64
+	Since     time.Time
+}
+
+// Mudanças no histórico (escutas gravadas, removidas ou restauradas) em ordem de transação. Cada escuta guarda a
+// transação que a mudou por último (change_xid) e o sync_token é o xmin do snapshot do banco na primeira página:
+// toda transação que ainda não tinha feito commit tem id maior ou igual a ele, então nada gravado no meio da
+// rodada (ex.: um import grande) fica para trás. Escutas de transações abertas podem vir de novo na rodada
+// seguinte; o cliente deduplica pelo id. Na primeira sincronização (sem sync_token) não há tombstones
+func (a *AnalyticsService) GetHistorySince(ctx context.Context, userID string, start HistorySyncStart, cursor *HistoryChangeCursor, limit int) (*HistoryDelta, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	// (change_xid, id) > (sync_token, uuid zero) é o mesmo que change_xid >= sync_token
+	position := HistoryChangeCursor{ChangeXID: start.SyncToken, ID: "00000000-0000-0000-0000-000000000000"}
+	if cursor != nil {
+		position = *cursor
+	} else {
+		var xmin string
+		if err := a.db.QueryRowContext(ctx, `SELECT pg_snapshot_xmin(pg_current_snapshot())::text`).Scan(&xmin); err != nil {
+			return nil, fmt.Errorf("failed to read sync token: %w", err)
+		}
+		syncToken, err := strconv.ParseUint(xmin, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse sync token %q: %w", xmin, err)
+		}
+		position.SyncToken = syncToken
+	}
+
+	var since sql.NullTime
+	if start.SyncToken == 0 && !start.Since.IsZero() {
+		since = sql.NullTime{Time: start.Since, Valid: true}
+	}
+
+	// Usa o índice (user_id, change_xid, id); uma linha a mais indica se existe próxima página
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT
+			lh.id,
+			lh.change_xid::text,
+			lh.deleted_at IS NOT NULL,
+			lh.played_at,
+			lh.created_at,
+			t.id,
+			t.name,
+			ARRAY(
+				SELECT ar.name FROM track_artists ta
+				JOIN artists ar ON ar.id = ta.artist_id
+				WHERE ta.track_id = t.id
+				ORDER BY ar.name
+			) as artists,
+			COALESCE(al.name, '') as album_name,
+			COALESCE(t.duration_ms, 0) as duration_ms,
+			COALESCE(lh.listened_duration_ms, 0) as listened_duration_ms,
+			lh.source
+		FROM listening_history lh
+		JOIN tracks t ON lh.track_id = t.id
+		LEFT JOIN albums al ON t.album_id = al.id
+		WHERE lh.user_id = $1
+			AND (lh.change_xid, lh.id) > ($2::text::xid8, $3::uuid)
+			AND ($4::boolean OR lh.deleted_at IS NULL)
+			AND ($5::timestamp IS NULL OR lh.created_at > $5)
+		ORDER BY lh.change_xid, lh.id
+		LIMIT $6
+	`, userID, strconv.FormatUint(position.ChangeXID, 10), position.ID, start.SyncToken > 0, since, limit+1)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query history since: %w", err)
+	}
+	defer rows.Close()
+
+	delta := &HistoryDelta{
+		Plays:      make([]HistoryDeltaEntry, 0, limit),
+		DeletedIDs: []string{},
+		SyncToken:  strconv.FormatUint(position.SyncToken, 10),
+	}
+	var last HistoryChangeCursor
+	returned := 0
+	for rows.Next() {
+		if returned == limit {
+			delta.NextCursor = EncodeHistoryChangeCursor(last)
+			break
+		}
+
+		var entry HistoryDeltaEntry
+		var changeXID string
+		var deleted bool
+		var artists pq.StringArray
+		if err := rows.Scan(&entry.ID, &changeXID, &deleted, &entry.PlayedAt, &entry.CreatedAt, &entry.TrackID, &entry.TrackName,
+			&artists, &entry.AlbumName, &entry.DurationMs, &entry.ListenedDurationMs, &entry.Source); err != nil {
+			continue
+		}
+		xid, err := strconv.ParseUint(changeXID, 10, 64)
+		if err != nil {
+			continue
+		}
+		last = HistoryChangeCursor{SyncToken: position.SyncToken, ChangeXID: xid, ID: entry.ID}
+		returned++
+
+		if deleted {
+			delta.DeletedIDs = append(delta.DeletedIDs, entry.ID)
+			continue
+		}
+		entry.Artists = []string(artists)
+		delta.Plays = append(delta.Plays, entry)
+	}
+
+	return delta, rows.Err()
+}
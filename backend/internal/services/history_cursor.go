@@ -0,0 +1,88 @@
+package services
+
+import (
+	"encoding/base64"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Posição de uma escuta na ordem (played_at DESC, id DESC); o id desempata escutas no mesmo instante
+type HistoryCursor struct {
+	PlayedAt time.Time
+	ID       string
+}
+
+var ErrInvalidHistoryCursor = errors.New("invalid history cursor")
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// IDs de escutas e usuários são UUIDs; os handlers validam antes de consultar, para o cast ::uuid não falhar
+func IsUUID(id string) bool {
+	return uuidPattern.MatchString(id)
+}
+
+// Token opaco para o cliente: "<played_at em unix µs>:<id>" em base64 URL-safe.
+// Microssegundos é a precisão do TIMESTAMP no Postgres, então o cursor volta exatamente ao valor gravado
+func EncodeHistoryCursor(cursor HistoryCursor) string {
+	raw := strconv.FormatInt(cursor.PlayedAt.UnixMicro(), 10) + ":" + cursor.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func DecodeHistoryCursor(token string) (*HistoryCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidHistoryCursor
+	}
+
+	micros, id, found := strings.Cut(string(raw), ":")
+	if !found || !IsUUID(id) {
+		return nil, ErrInvalidHistoryCursor
+	}
+
+	playedAt, err := strconv.ParseInt(micros, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidHistoryCursor
+	}
+
+	return &HistoryCursor{PlayedAt: time.UnixMicro(playedAt).UTC(), ID: id}, nil
+}
+
+// Posição na sincronização incremental, na ordem (change_xid, id), mais o sync_token da rodada: o token
+// é lido na primeira página e precisa ser o mesmo nas seguintes
+type HistoryChangeCursor struct {
+	SyncToken uint64
+	ChangeXID uint64
+	ID        string
+}
+
+// Token opaco "<sync_token>:<change_xid>:<id>" em base64 URL-safe
+func EncodeHistoryChangeCursor(cursor HistoryChangeCursor) string {
+	raw := strconv.FormatUint(cursor.SyncToken, 10) + ":" + strconv.FormatUint(cursor.ChangeXID, 10) + ":" + cursor.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func DecodeHistoryChangeCursor(token string) (*HistoryChangeCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidHistoryCursor
+	}
+
+	parts := strings.Split(string(raw), ":")
+	if len(parts) != 3 || !IsUUID(parts[2]) {
+		return nil, ErrInvalidHistoryCursor
+	}
+
+	syncToken, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidHistoryCursor
+	}
+	changeXID, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return nil, ErrInvalidHistoryCursor
+	}
+
+	return &HistoryChangeCursor{SyncToken: syncToken, ChangeXID: changeXID, ID: parts[2]}, nil
+}
@@ -0,0 +1,37 @@
+package services
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func TestHistoryChangeCursorRoundTrip(t *testing.T) {
+	cursor := HistoryChangeCursor{SyncToken: 7421, ChangeXID: 7455, ID: "0b6c7a52-58f0-4d1e-9a43-2f7f1c3e9d10"}
+
+	decoded, err := DecodeHistoryChangeCursor(EncodeHistoryChangeCursor(cursor))
+	if err != nil {
+		t.Fatalf("DecodeHistoryChangeCursor: %v", err)
+	}
+	if *decoded != cursor {
+		t.Errorf("decoded cursor = %+v, want %+v", *decoded, cursor)
+	}
+}
+
+func TestDecodeHistoryChangeCursorRejectsInvalidTokens(t *testing.T) {
+	encode := func(raw string) string { return base64.RawURLEncoding.EncodeToString([]byte(raw)) }
+
+	for _, token := range []string{
+		"not base64!",
+		encode("7421:7455"),
+		encode("7421:7455:not-a-uuid"),
+		encode("x:7455:0b6c7a52-58f0-4d1e-9a43-2f7f1c3e9d10"),
+		encode("7421:-1:0b6c7a52-58f0-4d1e-9a43-2f7f1c3e9d10"),
+		// cursor de /user/history (played_at:id) não serve para a sincronização
+		EncodeHistoryCursor(HistoryCursor{ID: "0b6c7a52-58f0-4d1e-9a43-2f7f1c3e9d10"}),
+	} {
+		if _, err := DecodeHistoryChangeCursor(token); !errors.Is(err, ErrInvalidHistoryCursor) {
+			t.Errorf("DecodeHistoryChangeCursor(%q) error = %v, want ErrInvalidHistoryCursor", token, err)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Agregados da comunidade: nenhum campo identifica usuários (sem IDs, nomes ou contagens de um usuário só)
+type GlobalInsights struct {
+	WindowDays        int                  `json:"window_days"`
+	ActiveUsers       int                  `json:"active_users"`
+	TopGenres         []GlobalGenreInsight `json:"top_genres"`
+	AvgDiversityScore float64              `json:"avg_diversity_score"`
+	AvgDailyMinutes   float64              `json:"avg_daily_minutes"`
+	InsufficientData  bool                 `json:"insufficient_data"` // poucos usuários ativos para publicar agregados
+	ComputedAt        time.Time            `json:"computed_at"`
+}
+
+type GlobalGenreInsight struct {
+	Genre      string  `json:"genre"`
+	Listeners  int     `json:"listeners"`
+	Plays      int     `json:"plays"`
+	Percentage float64 `json:"percentage"`
+}
+
+const globalInsightsTopGenres = 10
+
+// Um agregado só é publicado se reunir pelo menos tantos usuários, para não expor o histórico de alguém
+const globalInsightsMinUsers = 5
+
+// Estatísticas anônimas de todos os usuários nos últimos GLOBAL_INSIGHTS_DAYS dias.
+// O resultado fica em memória por GLOBAL_INSIGHTS_CACHE_TTL
+func (a *AnalyticsService) GetGlobalInsights(ctx context.Context) (*GlobalInsights, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	if cached := a.insights.Load(); cached != nil && time.Since(cached.ComputedAt) < a.config.GlobalInsightsCacheTTL {
+		return cached, nil
+	}
+
+	insights, err := a.computeGlobalInsights(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	a.insights.Store(insights)
+	return insights, nil
+}
+
+func (a *AnalyticsService) computeGlobalInsights(ctx context.Context) (*GlobalInsights, error) {
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	windowDays := max(a.config.GlobalInsightsDays, 1)
+	insights := &GlobalInsights{
+		WindowDays: windowDays,
+		TopGenres:  []GlobalGenreInsight{},
+		ComputedAt: time.Now(),
+	}
+	startDate := insights.ComputedAt.AddDate(0, 0, -windowDays)
+
+	// Contagens por usuário; só as médias saem deste método
+	userRows, err := a.db.QueryContext(ctx, `
+		WITH listening AS (
+			SELECT user_id, COALESCE(SUM(listened_duration_ms), 0) as duration_ms
+			FROM listening_history
+			WHERE deleted_at IS NULL AND played_at >= $1
+			GROUP BY user_id
+		),
+		variety AS (
+			SELECT
+				lh.user_id,
+				COUNT(DISTINCT g.genre) as unique_genres,
+				COUNT(DISTINCT ta.artist_id) as unique_artists
+			FROM listening_history lh
+			JOIN track_artists ta ON ta.track_id = lh.track_id
+			JOIN artists ar ON ar.id = ta.artist_id
+			LEFT JOIN LATERAL UNNEST(`+effectiveGenres("lh.user_id", "ar")+`) AS g(genre) ON TRUE
+			WHERE lh.deleted_at IS NULL AND lh.played_at >= $1
+			GROUP BY lh.user_id
+		),
+		)
+		SELECT
+			l.duration_ms,
+			COALESCE(v.unique_genres, 0) as unique_genres,
+			COALESCE(v.unique_artists, 0) as unique_artists
+		FROM listening l
+		LEFT JOIN variety v ON v.user_id = l.user_id
+	`, startDate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute global listening averages: %w", err)
+	}
+
+	var diversityTotal, minutesTotal float64
+	for userRows.Next() {
+		var durationMs int64
+		var uniqueGenres, uniqueArtists int
+		if err := userRows.Scan(&durationMs, &uniqueGenres, &uniqueArtists); err != nil {
+			continue
+		}
+		insights.ActiveUsers++
+		diversityTotal += diversityScore(uniqueGenres, uniqueArtists)
+		minutesTotal += float64(durationMs) / 60000 / float64(windowDays)
+	}
+	userRows.Close()
+	if err := userRows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to compute global listening averages: %w", err)
+	}
+
+	if insights.ActiveUsers < globalInsightsMinUsers {
+		// Com poucos usuários a média praticamente revela o histórico de cada um
+		return &GlobalInsights{
+			WindowDays:       windowDays,
+			TopGenres:        []GlobalGenreInsight{},
+			InsufficientData: true,
+			ComputedAt:       insights.ComputedAt,
+		}, nil
+	}
+
+	insights.AvgDiversityScore = math.Round(diversityTotal/float64(insights.ActiveUsers)*100) / 100
+	insights.AvgDailyMinutes = math.Round(minutesTotal/float64(insights.ActiveUsers)*100) / 100
+
+	rows, err := a.db.QueryContext(ctx, `
+		WITH genre_plays AS (
+			SELECT g.genre, lh.user_id
+			FROM listening_history lh
+			JOIN track_artists ta ON ta.track_id = lh.track_id
+			JOIN artists ar ON ar.id = ta.artist_id
+			CROSS JOIN LATERAL UNNEST(`+effectiveGenres("lh.user_id", "ar")+`) AS g(genre)
+			WHERE lh.deleted_at IS NULL AND lh.played_at >= $1
+		)
+		SELECT
+			genre,
+			COUNT(DISTINCT user_id) as listeners,
+			COUNT(*) as plays,
+			COUNT(*) * 100.0 / (SELECT COUNT(*) FROM genre_plays) as percentage
+		FROM genre_plays
+		GROUP BY genre
+		HAVING COUNT(DISTINCT user_id) >= $2
+		ORDER BY plays DESC, genre
+		LIMIT $3
+	`, startDate, globalInsightsMinUsers, globalInsightsTopGenres)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute global top genres: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var genre GlobalGenreInsight
+		if err := rows.Scan(&genre.Genre, &genre.Listeners, &genre.Plays, &genre.Percentage); err != nil {
+			continue
+		}
+		genre.Percentage = math.Round(genre.Percentage*100) / 100
+		insights.TopGenres = append(insights.TopGenres, genre)
+	}
+
+	return insights, rows.Err()
+}
+
+func (a *AnalyticsService) GlobalInsightsCacheTTL() time.Duration {
+	return a.config.GlobalInsightsCacheTTL
+}
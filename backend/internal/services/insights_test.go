@@ -0,0 +1,37 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"musike-backend/internal/config"
+)
+
+func TestGetGlobalInsightsServesCachedResultWithinTTL(t *testing.T) {
+	// sql.Open não conecta: qualquer consulta falharia, então o resultado tem que vir do cache
+	db, err := sql.Open("postgres", "postgres://nobody@127.0.0.1:1/none?sslmode=disable&connect_timeout=1")
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	defer db.Close()
+
+	a := NewAnalyticsService(&config.Config{GlobalInsightsCacheTTL: time.Hour}, db)
+	cached := &GlobalInsights{ActiveUsers: 7, AvgDiversityScore: diversityScore(5, 25), ComputedAt: time.Now().Add(-time.Minute)}
+	a.insights.Store(cached)
+
+	insights, err := a.GetGlobalInsights(context.Background())
+	if err != nil {
+		t.Fatalf("GetGlobalInsights: %v", err)
+	}
+	if insights != cached {
+		t.Errorf("GetGlobalInsights = %+v, want the cached result", insights)
+	}
+
+	// Vencido, recalcula (e aqui falha por não haver banco)
+	a.insights.Store(&GlobalInsights{ComputedAt: time.Now().Add(-2 * time.Hour)})
+	if _, err := a.GetGlobalInsights(context.Background()); err == nil {
+		t.Error("GetGlobalInsights with an expired cache did not query the database")
+	}
+}
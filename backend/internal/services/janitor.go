@@ -0,0 +1,67 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+type janitorSweep struct {
+	name  string
+	sweep func(now time.Time) int
+}
+
+// Goroutine que varre periodicamente o estado em memória (códigos OAuth já usados, sessões de tracking
+// paradas ou ociosas) e o cache do Spotify em disco para não crescerem em instâncias de longa duração. Cada
+// varredura cuida das próprias travas
+type Janitor struct {
+	interval    time.Duration
+	sweeps      []janitorSweep
+	stopChannel chan bool
+}
+
+func NewJanitor(interval time.Duration) *Janitor {
+	return &Janitor{
+		interval:    interval,
+		stopChannel: make(chan bool),
+	}
+}
+
+// Registra uma varredura; deve ser chamado antes do Start. sweep devolve quantos itens removeu
+func (j *Janitor) Register(name string, sweep func(now time.Time) int) {
+	j.sweeps = append(j.sweeps, janitorSweep{name: name, sweep: sweep})
+}
+
+func (j *Janitor) Start() {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			j.RunOnce()
+		case <-j.stopChannel:
+			log.Println("Stopping janitor...")
+			return
+		}
+	}
+}
+
+func (j *Janitor) Stop() {
+	j.stopChannel <- true
+}
+
+func (j *Janitor) RunOnce() {
+	now := time.Now()
+	cleaned := make([]string, 0, len(j.sweeps))
+	for _, sweep := range j.sweeps {
+		if count := sweep.sweep(now); count > 0 {
+			cleaned = append(cleaned, fmt.Sprintf("%d %s", count, sweep.name))
+		}
+	}
+
+	if len(cleaned) > 0 {
+		log.Printf("Janitor cleaned %s", strings.Join(cleaned, ", "))
+	}
+}
@@ -0,0 +1,392 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// Tempo para o usuário concluir o login do Spotify depois de pedir a vinculação
+const linkStateTTL = 10 * time.Minute
+
+var (
+	ErrLinkedAccountNotFound = errors.New("linked spotify account not found")
+	ErrAccountAlreadyLinked  = errors.New("spotify account already belongs to a user")
+)
+
+// Conta do Spotify extra vinculada a um usuário (ex.: conta pessoal e do trabalho). As escutas de todas
+// as contas ficam no mesmo user_id, então os analytics já somam todas
+type LinkedAccount struct {
+	SpotifyID   string    `json:"spotify_id"`
+	DisplayName string    `json:"display_name"`
+	LinkedAt    time.Time `json:"linked_at"`
+}
+
+type pendingLink struct {
+	userID    string
+	expiresAt time.Time
+}
+
+type LinkedAccountsService struct {
+	db          *sql.DB
+	authService *AuthService
+
+	pendingMutex sync.Mutex
+	pending      map[string]pendingLink // state do OAuth -> usuário que pediu a vinculação
+	refreshLocks keyedMutex             // uma renovação por conta vinculada
+}
+
+func NewLinkedAccountsService(db *sql.DB, authService *AuthService) *LinkedAccountsService {
+	return &LinkedAccountsService{
+		db:          db,
+		authService: authService,
+		pending:     make(map[string]pendingLink),
+	}
+}
+
+// Prefixo do state do OAuth nas vinculações; no callback separa vinculação de login comum
+const linkStatePrefix = "musike-link-"
+
+// Pedido de vinculação: a URL de consentimento do Spotify e o usuário do Musike que vai receber a conta
+type LinkRequest struct {
+	AuthURL     string
+	State       string
+	UserID      string
+	DisplayName string
+	ExpiresAt   time.Time
+}
+
+// Se o state do callback veio de POST /user/spotify-accounts/link
+func IsLinkState(state string) bool {
+	return strings.HasPrefix(state, linkStatePrefix)
+}
+
+// Inicia a vinculação de outra conta; o state identifica o usuário no callback. A URL força a tela de
+// consentimento (show_dialog) para o Spotify não reaproveitar em silêncio a conta já logada no navegador
+func (l *LinkedAccountsService) StartLink(ctx context.Context, userID string) (*LinkRequest, error) {
+	if l.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	// Nome da conta do Musike que vai receber a vinculação, para o cliente confirmar antes do login
+	var displayName sql.NullString
+	err := l.db.QueryRowContext(ctx, `SELECT display_name FROM users WHERE id = $1`, userID).Scan(&displayName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load user: %w", err)
+	}
+
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, fmt.Errorf("failed to generate link state: %w", err)
+	}
+	state := linkStatePrefix + hex.EncodeToString(raw)
+
+	l.pendingMutex.Lock()
+	now := time.Now()
+	for key, link := range l.pending {
+		if now.After(link.expiresAt) {
+			delete(l.pending, key)
+		}
+	}
+	expiresAt := now.Add(linkStateTTL)
+	l.pending[state] = pendingLink{userID: userID, expiresAt: expiresAt}
+	l.pendingMutex.Unlock()
+
+	return &LinkRequest{
+		AuthURL:     l.authService.GetReconsentURL(state),
+		State:       state,
+		UserID:      userID,
+		DisplayName: displayName.String,
+		ExpiresAt:   expiresAt,
+	}, nil
+}
+
+// Usuário que pediu a vinculação com esse state (o state só vale uma vez)
+func (l *LinkedAccountsService) ConsumeLinkState(state string) (string, bool) {
+	l.pendingMutex.Lock()
+	defer l.pendingMutex.Unlock()
+
+	link, exists := l.pending[state]
+	if !exists {
+		return "", false
+	}
+	delete(l.pending, state)
+	if time.Now().After(link.expiresAt) {
+		return "", false
+	}
+	return link.userID, true
+}
+
+// Vincula (ou atualiza o token de) uma conta do Spotify ao usuário. A conta não pode ser a principal
+// de nenhum usuário nem estar vinculada a outro
+func (l *LinkedAccountsService) Link(ctx context.Context, userID string, profile *SpotifyUser, token *oauth2.Token) error {
+	if l.db == nil {
+		return fmt.Errorf("database not available")
+	}
+
+	var primaryOwner string
+	err := l.db.QueryRowContext(ctx, `SELECT id FROM users WHERE spotify_id = $1`, profile.ID).Scan(&primaryOwner)
+	if err == nil {
+		return ErrAccountAlreadyLinked
+	}
+	if err != sql.ErrNoRows {
+		return fmt.Errorf("failed to check spotify account owner: %w", err)
+	}
+
+	var expiresAt interface{}
+	if !token.Expiry.IsZero() {
+		expiresAt = token.Expiry.UTC()
+	}
+
+	accessToken, err := l.authService.SealToken(token.AccessToken)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt linked spotify token: %w", err)
+	}
+	refreshToken, err := l.authService.SealToken(token.RefreshToken)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt linked spotify token: %w", err)
+	}
+
+	result, err := l.db.ExecContext(ctx, `
+		INSERT INTO linked_spotify_accounts (user_id, spotify_id, display_name, access_token, refresh_token, expires_at)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6)
+		ON CONFLICT (spotify_id) DO UPDATE SET
+			display_name = EXCLUDED.display_name,
+			access_token = EXCLUDED.access_token,
+			refresh_token = COALESCE(EXCLUDED.refresh_token, linked_spotify_accounts.refresh_token),
+			expires_at = EXCLUDED.expires_at,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE linked_spotify_accounts.user_id = EXCLUDED.user_id
+	`, userID, profile.ID, profile.DisplayName, accessToken, refreshToken, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to link spotify account: %w", err)
+	}
+	if linked, _ := result.RowsAffected(); linked == 0 {
+		return ErrAccountAlreadyLinked // vinculada a outro usuário
+	}
+	return nil
+}
+
+// Se a conta do Spotify está vinculada a algum usuário (e por isso não pode virar um usuário próprio)
+func (l *LinkedAccountsService) IsLinked(ctx context.Context, spotifyID string) (bool, error) {
+	if l.db == nil {
+		return false, fmt.Errorf("database not available")
+	}
+
+	var linked bool
+	err := l.db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM linked_spotify_accounts WHERE spotify_id = $1)
+	`, spotifyID).Scan(&linked)
+	if err != nil {
+		return false, fmt.Errorf("failed to check linked spotify account: %w", err)
+	}
+	return linked, nil
+}
+
+func (l *LinkedAccountsService) List(ctx context.Context, userID string) ([]LinkedAccount, error) {
+	if l.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	rows, err := l.db.QueryContext(ctx, `
+		SELECT spotify_id, COALESCE(display_name, ''), created_at
+		FROM linked_spotify_accounts
+		WHERE user_id = $1
+		ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query linked spotify accounts: %w", err)
+	}
+	defer rows.Close()
+
+	accounts := []LinkedAccount{}
+	for rows.Next() {
+		var account LinkedAccount
+		if err := rows.Scan(&account.SpotifyID, &account.DisplayName, &account.LinkedAt); err != nil {
+			continue
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, rows.Err()
+}
+
+// As escutas já gravadas da conta continuam no histórico
+func (l *LinkedAccountsService) Unlink(ctx context.Context, userID, spotifyID string) error {
+	if l.db == nil {
+		return fmt.Errorf("database not available")
+	}
+
+	result, err := l.db.ExecContext(ctx, `
+		DELETE FROM linked_spotify_accounts WHERE user_id = $1 AND spotify_id = $2
+	`, userID, spotifyID)
+	if err != nil {
+		return fmt.Errorf("failed to unlink spotify account: %w", err)
+	}
+	if removed, _ := result.RowsAffected(); removed == 0 {
+		return ErrLinkedAccountNotFound
+	}
+	return nil
+}
+
+// Tokens válidos das contas vinculadas do usuário (spotify_id -> token), renovando os expirados.
+// Contas cujo token não pôde ser renovado ficam de fora
+func (l *LinkedAccountsService) Tokens(ctx context.Context, userID string) (map[string]*oauth2.Token, error) {
+	if l.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	rows, err := l.db.QueryContext(ctx, `SELECT spotify_id FROM linked_spotify_accounts WHERE user_id = $1`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load linked spotify accounts: %w", err)
+	}
+
+	var spotifyIDs []string
+	for rows.Next() {
+		var spotifyID string
+		if err := rows.Scan(&spotifyID); err != nil {
+			continue
+		}
+		spotifyIDs = append(spotifyIDs, spotifyID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	tokens := make(map[string]*oauth2.Token, len(spotifyIDs))
+	for _, spotifyID := range spotifyIDs {
+		token, err := l.Token(ctx, userID, spotifyID)
+		if err != nil {
+			log.Printf("Error refreshing linked spotify account %s for user %s: %v", spotifyID, userID, err)
+			continue
+		}
+		tokens[spotifyID] = token
+	}
+	return tokens, nil
+}
+
+// Token válido de uma conta vinculada, renovando (e salvando) se estiver expirado ou perto de expirar.
+// Renovações da mesma conta são serializadas: a segunda reaproveita o token que a primeira salvou
+func (l *LinkedAccountsService) Token(ctx context.Context, userID, spotifyID string) (*oauth2.Token, error) {
+	if l.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	unlock := l.refreshLocks.lock(userID + "|" + spotifyID)
+	defer unlock()
+
+	token, err := l.load(ctx, userID, spotifyID)
+	if err != nil {
+		return nil, err
+	}
+	if token.Expiry.IsZero() || time.Now().Add(tokenRefreshMargin).Before(token.Expiry) {
+		return token, nil
+	}
+
+	if token.RefreshToken == "" {
+		return nil, fmt.Errorf("linked spotify token expired and has no refresh token")
+	}
+
+	refreshed, err := l.authService.RefreshSpotifyToken(token.RefreshToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to refresh linked spotify token: %w", err)
+	}
+
+	accessToken, err := l.authService.SealToken(refreshed.AccessToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt linked spotify token: %w", err)
+	}
+	refreshToken, err := l.authService.SealToken(refreshed.RefreshToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt linked spotify token: %w", err)
+	}
+
+	_, err = l.db.ExecContext(ctx, `
+		UPDATE linked_spotify_accounts SET
+			access_token = $1,
+			refresh_token = COALESCE(NULLIF($2, ''), refresh_token),
+			expires_at = $3,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE user_id = $4 AND spotify_id = $5
+	`, accessToken, refreshToken, refreshed.Expiry.UTC(), userID, spotifyID)
+	if err != nil {
+		log.Printf("Error saving refreshed token for linked account %s: %v", spotifyID, err)
+	}
+
+	log.Printf("Refreshed token for linked spotify account %s of user %s (expires at %v)", spotifyID, userID, refreshed.Expiry)
+	return refreshed, nil
+}
+
+func (l *LinkedAccountsService) load(ctx context.Context, userID, spotifyID string) (*oauth2.Token, error) {
+	var accessToken string
+	var refreshToken sql.NullString
+	var expiresAt sql.NullTime
+
+	err := l.db.QueryRowContext(ctx, `
+		SELECT access_token, refresh_token, expires_at
+		FROM linked_spotify_accounts
+		WHERE user_id = $1 AND spotify_id = $2
+	`, userID, spotifyID).Scan(&accessToken, &refreshToken, &expiresAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrLinkedAccountNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load linked spotify token: %w", err)
+	}
+
+	accessToken, err = l.authService.OpenToken(accessToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt linked spotify token: %w", err)
+	}
+	refresh, err := l.authService.OpenToken(refreshToken.String)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt linked spotify token: %w", err)
+	}
+
+	token := &oauth2.Token{AccessToken: accessToken, RefreshToken: refresh, TokenType: "Bearer"}
+	if expiresAt.Valid {
+		token.Expiry = expiresAt.Time
+	}
+	return token, nil
+}
+
+// Fonte de tokens da conta vinculada para o tracker: devolve o token atual enquanto vale e renova pelo
+// Token quando expira, então o tracking da conta não morre junto com o access token de uma hora
+func (l *LinkedAccountsService) TokenSource(userID, spotifyID string, token *oauth2.Token) oauth2.TokenSource {
+	return oauth2.ReuseTokenSource(token, &linkedTokenSource{accounts: l, userID: userID, spotifyID: spotifyID})
+}
+
+type linkedTokenSource struct {
+	accounts  *LinkedAccountsService
+	userID    string
+	spotifyID string
+}
+
+func (s *linkedTokenSource) Token() (*oauth2.Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return s.accounts.Token(ctx, s.userID, s.spotifyID)
+}
+
+// Começa (ou recomeça) o tracking de todas as contas vinculadas do usuário, junto com o da conta principal
+func (l *LinkedAccountsService) StartTracking(ctx context.Context, userID string, trackingService *TrackingService) {
+	tokens, err := l.Tokens(ctx, userID)
+	if err != nil {
+		log.Printf("Warning: Failed to load linked accounts for user %s: %v", userID, err)
+		return
+	}
+	for spotifyID, token := range tokens {
+		if err := trackingService.StartLinkedTracking(userID, spotifyID, l.TokenSource(userID, spotifyID, token)); err != nil {
+			log.Printf("Warning: Failed to start tracking for linked account %s: %v", spotifyID, err)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package services
+
+import "sync"
+
+// Um mutex por chave (usuário, conta), criado sob demanda e descartado quando ninguém mais o usa, para que
+// operações de chaves diferentes não esperem umas pelas outras
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	mu      sync.Mutex
+	waiters int
+}
+
+// Trava a chave e devolve a função que destrava
+func (k *keyedMutex) lock(key string) func() {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = make(map[string]*keyedLock)
+	}
+	lock, exists := k.locks[key]
+	if !exists {
+		lock = &keyedLock{}
+		k.locks[key] = lock
+	}
+	lock.waiters++
+	k.mu.Unlock()
+
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
+
+		k.mu.Lock()
+		lock.waiters--
+		if lock.waiters == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
@@ -0,0 +1,52 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeyedMutexSerializesSameKey(t *testing.T) {
+	var locks keyedMutex
+	unlock := locks.lock("user|account")
+
+	acquired := make(chan struct{})
+	go func() {
+		defer close(acquired)
+		locks.lock("user|account")()
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("second lock of the same key acquired while the first was held")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlock()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("second lock not acquired after unlock")
+	}
+
+	if len(locks.locks) != 0 {
+		t.Errorf("locks map has %d entries after all unlocks, want 0", len(locks.locks))
+	}
+}
+
+func TestKeyedMutexDifferentKeysDoNotBlock(t *testing.T) {
+	var locks keyedMutex
+	unlock := locks.lock("user|a")
+	defer unlock()
+
+	done := make(chan struct{})
+	go func() {
+		locks.lock("user|b")()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("lock of another key blocked")
+	}
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Score de fidelidade de um artista, com os componentes usados no cálculo:
+// Score = 100 * sqrt(RelativeShare * Consistency). RelativeShare compara as escutas com as do artista mais
+// escutado da janela; Consistency é a fração dos meses considerados em que o artista foi escutado. Uma obsessão
+// de um mês tem RelativeShare alto e Consistency baixa; um favorito de longa data tem os dois altos
+type ArtistLoyalty struct {
+	Rank           int     `json:"rank"`
+	ArtistID       string  `json:"artist_id"`
+	ArtistName     string  `json:"artist_name"`
+	ImageURL       string  `json:"image_url,omitempty"`
+	Plays          int     `json:"plays"`
+	Share          float64 `json:"share"`          // fração das escutas da janela
+	RelativeShare  float64 `json:"relative_share"` // escutas / escutas do artista mais escutado
+	ActiveMonths   int     `json:"active_months"`
+	Consistency    float64 `json:"consistency"`      // active_months / months_considered
+	PeakMonth      string  `json:"peak_month"`       // mês com mais escutas do artista
+	PeakMonthShare float64 `json:"peak_month_share"` // fração das escutas do artista concentrada no pico
+	Score          float64 `json:"score"`            // 0-100
+}
+
+type LoyaltyReport struct {
+	Months           int             `json:"months"`
+	MonthsConsidered int             `json:"months_considered"` // meses desde a primeira escuta dentro da janela
+	TotalPlays       int             `json:"total_plays"`
+	Artists          []ArtistLoyalty `json:"artists"`
+}
+
+// Fidelidade dos artistas mais escutados nos últimos `months` meses (incluindo o atual), ordenados pelo score.
+// Usa o mesmo agrupamento mensal no fuso do usuário que /user/monthly-favorites
+func (a *AnalyticsService) GetLoyalty(ctx context.Context, userID string, months int, loc *time.Location, limit int) (*LoyaltyReport, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	now := time.Now().In(loc)
+	currentMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+	firstMonth := currentMonth.AddDate(0, -(months - 1), 0)
+
+	report := &LoyaltyReport{Months: months, Artists: []ArtistLoyalty{}}
+
+	var firstPlay *time.Time
+	err := a.db.QueryRowContext(ctx, `
+		SELECT COUNT(*), MIN(played_at)
+		FROM listening_history
+		WHERE user_id = $1 AND deleted_at IS NULL AND played_at >= $2
+	`, userID, firstMonth.UTC()).Scan(&report.TotalPlays, &firstPlay)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query loyalty totals: %w", err)
+	}
+	if firstPlay == nil {
+		return report, nil
+	}
+
+	// Meses antes da primeira escuta não contam como meses sem o artista
+	local := firstPlay.In(loc)
+	start := time.Date(local.Year(), local.Month(), 1, 0, 0, 0, 0, loc)
+	report.MonthsConsidered = (currentMonth.Year()-start.Year())*12 + int(currentMonth.Month()-start.Month()) + 1
+
+	query := fmt.Sprintf(`
+		WITH monthly AS (
+			SELECT
+				ta.artist_id,
+				TO_CHAR(date_trunc('month', %s), 'YYYY-MM') as month,
+				COUNT(*) as plays
+			FROM listening_history lh
+			JOIN track_artists ta ON ta.track_id = lh.track_id
+			WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2
+			GROUP BY ta.artist_id, month
+		),
+		totals AS (
+			SELECT
+				artist_id,
+				SUM(plays) as plays,
+				COUNT(*) as active_months,
+				(ARRAY_AGG(month ORDER BY plays DESC, month DESC))[1] as peak_month,
+				MAX(plays) as peak_plays
+			FROM monthly
+			GROUP BY artist_id
+			ORDER BY plays DESC, artist_id
+			LIMIT $4
+		)
+		SELECT ar.id, ar.name, COALESCE(ar.image_url, ''), t.plays, t.active_months, t.peak_month, t.peak_plays
+		FROM totals t
+		JOIN artists ar ON ar.id = t.artist_id`, localPlayedAt(3))
+
+	rows, err := a.db.QueryContext(ctx, query, userID, firstMonth.UTC(), loc.String(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query artist loyalty: %w", err)
+	}
+	defer rows.Close()
+
+	var topPlays int
+	for rows.Next() {
+		var artist ArtistLoyalty
+		var peakPlays int
+		if err := rows.Scan(&artist.ArtistID, &artist.ArtistName, &artist.ImageURL, &artist.Plays,
+			&artist.ActiveMonths, &artist.PeakMonth, &peakPlays); err != nil {
+			continue
+		}
+		artist.PeakMonthShare = roundFraction(float64(peakPlays) / float64(artist.Plays))
+		topPlays = max(topPlays, artist.Plays)
+		report.Artists = append(report.Artists, artist)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range report.Artists {
+		artist := &report.Artists[i]
+		relativeShare := float64(artist.Plays) / float64(topPlays)
+		consistency := float64(artist.ActiveMonths) / float64(report.MonthsConsidered)
+
+		artist.Share = roundFraction(float64(artist.Plays) / float64(report.TotalPlays))
+		artist.RelativeShare = roundFraction(relativeShare)
+		artist.Consistency = roundFraction(consistency)
+		artist.Score = math.Round(math.Sqrt(relativeShare*consistency)*1000) / 10
+	}
+
+	sort.SliceStable(report.Artists, func(i, j int) bool {
+		if report.Artists[i].Score != report.Artists[j].Score {
+			return report.Artists[i].Score > report.Artists[j].Score
+		}
+		if report.Artists[i].Plays != report.Artists[j].Plays {
+			return report.Artists[i].Plays > report.Artists[j].Plays
+		}
+		return report.Artists[i].ArtistID < report.Artists[j].ArtistID
+	})
+	for i := range report.Artists {
+		report.Artists[i].Rank = i + 1
+	}
+
+	return report, nil
+}
+
+func roundFraction(value float64) float64 {
+	return math.Round(value*1000) / 1000
+}
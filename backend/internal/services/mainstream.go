@@ -0,0 +1,161 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+type PopularityTier struct {
+	MinPopularity int     `json:"min_popularity"`
+	MaxPopularity int     `json:"max_popularity"`
+	PlayCount     int     `json:"play_count"`
+	Percentage    float64 `json:"percentage"`
+}
+
+type MainstreamScore struct {
+	Score       float64          `json:"score"` // popularidade média ponderada pelas escutas, 0-100
+	Tiers       []PopularityTier `json:"tiers"`
+	TotalPlays  int              `json:"total_plays"`
+	RatedPlays  int              `json:"rated_plays"`
+	Coverage    float64          `json:"coverage"` // % das escutas com popularidade conhecida
+	LowCoverage bool             `json:"low_coverage"`
+}
+
+// Abaixo disso o score não é representativo (muitas faixas sem popularidade enriquecida)
+const minPopularityCoverage = 50.0
+
+func (a *AnalyticsService) GetMainstreamScore(ctx context.Context, userID string, timeFilter string) (*MainstreamScore, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT COALESCE(t.popularity, 0) as popularity, COUNT(*) as play_count
+		FROM listening_history lh
+		JOIN tracks t ON lh.track_id = t.id
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2
+		GROUP BY popularity`, userID, timeFilterStartDate(timeFilter))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query track popularity: %w", err)
+	}
+	defer rows.Close()
+
+	result := &MainstreamScore{Tiers: popularityTiers(a.config.PopularityTierBounds)}
+
+	var weightedSum int64
+	for rows.Next() {
+		var popularity, count int
+		if err := rows.Scan(&popularity, &count); err != nil {
+			continue
+		}
+
+		result.TotalPlays += count
+
+		// Popularidade 0 significa "não enriquecida", não "nenhum ouvinte"
+		if popularity <= 0 {
+			continue
+		}
+
+		result.RatedPlays += count
+		weightedSum += int64(popularity) * int64(count)
+		for i := range result.Tiers {
+			if popularity >= result.Tiers[i].MinPopularity && popularity <= result.Tiers[i].MaxPopularity {
+				result.Tiers[i].PlayCount += count
+				break
+			}
+		}
+	}
+
+	if result.RatedPlays > 0 {
+		result.Score = float64(weightedSum) / float64(result.RatedPlays)
+		for i := range result.Tiers {
+			result.Tiers[i].Percentage = float64(result.Tiers[i].PlayCount) / float64(result.RatedPlays) * 100
+		}
+	}
+	if result.TotalPlays > 0 {
+		result.Coverage = float64(result.RatedPlays) / float64(result.TotalPlays) * 100
+	}
+	result.LowCoverage = result.Coverage < minPopularityCoverage
+
+	return result, nil
+}
+
+// Converte os limites configurados (ex.: 30,50,70) em faixas 1-29, 30-49, 50-69, 70-100
+func popularityTiers(bounds []int64) []PopularityTier {
+	sorted := make([]int, 0, len(bounds))
+	for _, bound := range bounds {
+		if bound > 1 && bound <= 100 {
+			sorted = append(sorted, int(bound))
+		}
+	}
+	sort.Ints(sorted)
+
+	tiers := make([]PopularityTier, 0, len(sorted)+1)
+	lower := 1
+	for _, bound := range sorted {
+		if bound <= lower {
+			continue
+		}
+		tiers = append(tiers, PopularityTier{MinPopularity: lower, MaxPopularity: bound - 1})
+		lower = bound
+	}
+	return append(tiers, PopularityTier{MinPopularity: lower, MaxPopularity: 100})
+}
+
+type ArtistAffinityPoint struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Popularity int    `json:"popularity"` // eixo x: popularidade no Spotify
+	Plays      int    `json:"plays"`      // eixo y: escutas do usuário
+}
+
+type ArtistAffinity struct {
+	Artists           []ArtistAffinityPoint `json:"artists"`
+	UnknownPopularity int                   `json:"unknown_popularity"` // artistas do top sem popularidade enriquecida, fora do gráfico
+}
+
+// Popularidade x escutas dos artistas mais ouvidos, para um gráfico de dispersão. Dos limit artistas do top,
+// os sem popularidade conhecida ficam de fora e só entram na contagem
+func (a *AnalyticsService) GetArtistAffinity(ctx context.Context, userID string, timeFilter string, limit int) (*ArtistAffinity, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT ar.id, ar.name, COALESCE(ar.popularity, 0) as popularity, COUNT(*) as plays
+		FROM listening_history lh
+		JOIN track_artists ta ON ta.track_id = lh.track_id
+		JOIN artists ar ON ar.id = ta.artist_id
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2
+		GROUP BY ar.id, ar.name, ar.popularity
+		ORDER BY plays DESC, ar.name
+		LIMIT $3`, userID, timeFilterStartDate(timeFilter), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query artist affinity: %w", err)
+	}
+	defer rows.Close()
+
+	result := &ArtistAffinity{Artists: []ArtistAffinityPoint{}}
+	for rows.Next() {
+		var point ArtistAffinityPoint
+		if err := rows.Scan(&point.ID, &point.Name, &point.Popularity, &point.Plays); err != nil {
+			continue
+		}
+
+		// Popularidade 0 significa "não enriquecida", não "nenhum ouvinte"
+		if point.Popularity <= 0 {
+			result.UnknownPopularity++
+			continue
+		}
+		result.Artists = append(result.Artists, point)
+	}
+
+	return result, rows.Err()
+}
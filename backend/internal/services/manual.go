@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+var (
+	ErrDuplicatePlay        = errors.New("play already recorded")
+	ErrTrackNotFound        = errors.New("track not found")
+	ErrSpotifyTokenRequired = errors.New("spotify token required to fetch track details")
+)
+
+type ManualPlay struct {
+	TrackID             string    `json:"track_id"`
+	TrackName           string    `json:"track_name"`
+	PlayedAt            time.Time `json:"played_at"`
+	ListenedDurationMs  int64     `json:"listened_duration_ms"`
+	ListeningPercentage float64   `json:"listening_percentage"`
+}
+
+func (s *TrackingService) GetTrack(spotifyToken, trackID string) (*CurrentlyPlayingTrack, error) {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/v1/tracks/%s", s.config.SpotifyAPIBaseURL, trackID), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+spotifyToken)
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusBadRequest {
+		return nil, ErrTrackNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &SpotifyAPIError{StatusCode: resp.StatusCode}
+	}
+
+	var track CurrentlyPlayingTrack
+	if err := json.NewDecoder(resp.Body).Decode(&track); err != nil {
+		return nil, err
+	}
+	return &track, nil
+}
+
+// Registra uma escuta informada pelo usuário. Se a faixa ainda não está no banco, busca os
+// detalhes no Spotify e grava o catálogo pelo mesmo caminho do sync. listenedMs = 0 assume a faixa inteira
+func (s *TrackingService) AddManualPlay(ctx context.Context, userID, spotifyToken, trackID string, playedAt time.Time, listenedMs int64) (*ManualPlay, error) {
+	var trackName string
+	var durationMs int64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT name, COALESCE(duration_ms, 0) FROM tracks WHERE id = $1
+	`, trackID).Scan(&trackName, &durationMs)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to query track: %w", err)
+	}
+
+	var track *CurrentlyPlayingTrack
+	if err == sql.ErrNoRows {
+		if spotifyToken == "" {
+			return nil, ErrSpotifyTokenRequired
+		}
+
+		track, err = s.GetTrack(spotifyToken, trackID)
+		if err != nil {
+			return nil, err
+		}
+		trackName = track.Name
+		durationMs = int64(track.DurationMs)
+	}
+
+	var exists bool
+	err = s.db.QueryRowContext(ctx, `
+		SELECT EXISTS(SELECT 1 FROM listening_history WHERE user_id = $1 AND track_id = $2 AND played_at = $3)
+	`, userID, trackID, playedAt).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check existing play: %w", err)
+	}
+	if exists {
+		return nil, ErrDuplicatePlay
+	}
+
+	if listenedMs <= 0 {
+		listenedMs = durationMs
+	}
+
+	listeningPercentage := float64(0)
+	if durationMs > 0 {
+		listeningPercentage = min(float64(listenedMs)/float64(durationMs)*100, 100)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if track != nil {
+		if err := s.saveTrackCatalog(ctx, tx, spotifyToken, track); err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO listening_history (user_id, track_id, played_at, context_type, listened_duration_ms, listening_percentage, source, created_at)
+		VALUES ($1, $2, $3, 'manual', $4, $5, 'manual', NOW())
+	`, userID, trackID, playedAt, listenedMs, listeningPercentage)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save listening history: %w", err)
+	}
+
+	if err := applyPlayAggregates(ctx, tx, userID, trackID, "manual", listenedMs, 1); err != nil {
+		return nil, err
+	}
+	if err := invalidateAnalyticsCache(ctx, tx, userID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	log.Printf("Manually added play for user %s: %s at %s", userID, trackName, playedAt.Format(time.RFC3339))
+
+	return &ManualPlay{
+		TrackID:             trackID,
+		TrackName:           trackName,
+		PlayedAt:            playedAt,
+		ListenedDurationMs:  listenedMs,
+		ListeningPercentage: listeningPercentage,
+	}, nil
+}
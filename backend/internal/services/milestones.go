@@ -0,0 +1,262 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+type Milestone struct {
+	Type      string     `json:"type"` // plays, tracks, artists, minutes
+	Threshold int64      `json:"threshold"`
+	Current   int64      `json:"current"`
+	Progress  float64    `json:"progress"` // 0-100
+	Reached   bool       `json:"reached"`
+	ReachedAt *time.Time `json:"reached_at,omitempty"`
+}
+
+func (a *AnalyticsService) GetMilestones(ctx context.Context, userID string) ([]Milestone, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	// Percorrer o histórico em ordem cronológica acumulando plays, faixas únicas e minutos
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT lh.played_at, lh.track_id, COALESCE(lh.listened_duration_ms, 0)
+		FROM listening_history lh
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL
+		ORDER BY lh.played_at ASC, lh.id ASC
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query listening history for milestones: %w", err)
+	}
+	defer rows.Close()
+
+	var playDates, trackDates []time.Time
+	var minuteDates []time.Time // data em que cada minuto acumulado foi atingido, indexado por milestone
+	minuteThresholds := sortedThresholds(a.config.MilestoneMinutes)
+	seenTracks := make(map[string]bool)
+	var totalMs int64
+
+	for rows.Next() {
+		var playedAt time.Time
+		var trackID string
+		var durationMs int64
+		if err := rows.Scan(&playedAt, &trackID, &durationMs); err != nil {
+			continue
+		}
+
+		playDates = append(playDates, playedAt)
+		if !seenTracks[trackID] {
+			seenTracks[trackID] = true
+			trackDates = append(trackDates, playedAt)
+		}
+
+		totalMs += durationMs
+		for len(minuteDates) < len(minuteThresholds) && totalMs/60000 >= minuteThresholds[len(minuteDates)] {
+			minuteDates = append(minuteDates, playedAt)
+		}
+	}
+
+	// Data da primeira escuta de cada artista, em ordem
+	artistRows, err := a.db.QueryContext(ctx, `
+		SELECT MIN(lh.played_at) as first_played
+		FROM listening_history lh
+		JOIN track_artists ta ON ta.track_id = lh.track_id
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL
+		GROUP BY ta.artist_id
+		ORDER BY first_played ASC
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query artist first plays: %w", err)
+	}
+	defer artistRows.Close()
+
+	var artistDates []time.Time
+	for artistRows.Next() {
+		var firstPlayed time.Time
+		if err := artistRows.Scan(&firstPlayed); err != nil {
+			continue
+		}
+		artistDates = append(artistDates, firstPlayed)
+	}
+
+	milestones := make([]Milestone, 0)
+	milestones = append(milestones, countMilestones("plays", a.config.MilestonePlays, playDates)...)
+	milestones = append(milestones, countMilestones("tracks", a.config.MilestoneTracks, trackDates)...)
+	milestones = append(milestones, countMilestones("artists", a.config.MilestoneArtists, artistDates)...)
+
+	for i, threshold := range minuteThresholds {
+		milestone := newMilestone("minutes", threshold, totalMs/60000)
+		if i < len(minuteDates) {
+			reachedAt := minuteDates[i]
+			milestone.ReachedAt = &reachedAt
+		}
+		milestones = append(milestones, milestone)
+	}
+
+	return milestones, nil
+}
+
+// dates[n-1] é a data em que o n-ésimo item foi atingido
+func countMilestones(milestoneType string, thresholds []int64, dates []time.Time) []Milestone {
+	var milestones []Milestone
+	for _, threshold := range sortedThresholds(thresholds) {
+		milestone := newMilestone(milestoneType, threshold, int64(len(dates)))
+		if milestone.Reached {
+			reachedAt := dates[threshold-1]
+			milestone.ReachedAt = &reachedAt
+		}
+		milestones = append(milestones, milestone)
+	}
+	return milestones
+}
+
+func newMilestone(milestoneType string, threshold, current int64) Milestone {
+	progress := float64(current) / float64(threshold) * 100
+	if progress > 100 {
+		progress = 100
+	}
+
+	return Milestone{
+		Type:      milestoneType,
+		Threshold: threshold,
+		Current:   current,
+		Progress:  progress,
+		Reached:   current >= threshold,
+	}
+}
+
+func sortedThresholds(thresholds []int64) []int64 {
+	sorted := append([]int64(nil), thresholds...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
+
+// Roda checkMilestones numa goroutine: a contagem percorre todo o histórico do usuário e não deve atrasar a
+// gravação da escuta. Pedidos do mesmo usuário enquanto uma verificação roda viram uma só verificação logo
+// depois dela, que já vê todas as escutas gravadas
+func (s *TrackingService) scheduleMilestoneCheck(userID string) {
+	s.milestoneMutex.Lock()
+	if _, running := s.milestonePending[userID]; running {
+		s.milestonePending[userID] = true
+		s.milestoneMutex.Unlock()
+		return
+	}
+	s.milestonePending[userID] = false
+	s.milestoneMutex.Unlock()
+
+	go func() {
+		for {
+			s.checkUserMilestones(userID)
+
+			s.milestoneMutex.Lock()
+			if !s.milestonePending[userID] {
+				delete(s.milestonePending, userID)
+				s.milestoneMutex.Unlock()
+				return
+			}
+			s.milestonePending[userID] = false
+			s.milestoneMutex.Unlock()
+		}
+	}()
+}
+
+// Compara as contagens atuais com as maiores já vistas e notifica o webhook para cada limite cruzado.
+// Na primeira verificação de um usuário só grava a linha de base, para não disparar marcos antigos
+func (s *TrackingService) checkMilestones(userID string) {
+	if !s.webhook.Enabled() || s.db == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var current [4]int64 // plays, tracks, artists, minutes
+	err := s.db.QueryRowContext(ctx, `
+		SELECT
+			COUNT(*),
+			COUNT(DISTINCT lh.track_id),
+			(SELECT COUNT(DISTINCT ta.artist_id)
+			 FROM listening_history lh2
+			 JOIN track_artists ta ON ta.track_id = lh2.track_id
+			 WHERE lh2.user_id = $1 AND lh2.deleted_at IS NULL),
+			COALESCE(SUM(lh.listened_duration_ms), 0) / 60000
+		FROM listening_history lh
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL
+	`, userID).Scan(&current[0], &current[1], &current[2], &current[3])
+	if err != nil {
+		log.Printf("Error counting milestones for user %s: %v", userID, err)
+		return
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error starting milestone transaction: %v", err)
+		return
+	}
+	defer tx.Rollback()
+
+	var previous [4]int64
+	err = tx.QueryRowContext(ctx, `
+		SELECT plays, tracks, artists, minutes FROM user_milestone_state
+		WHERE user_id = $1
+		FOR UPDATE
+	`, userID).Scan(&previous[0], &previous[1], &previous[2], &previous[3])
+	baseline := err == sql.ErrNoRows
+	if err != nil && !baseline {
+		log.Printf("Error loading milestone state for user %s: %v", userID, err)
+		return
+	}
+
+	// GREATEST: apagar escutas e voltar a cruzar o mesmo limite não dispara de novo
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO user_milestone_state (user_id, plays, tracks, artists, minutes, updated_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+		ON CONFLICT (user_id) DO UPDATE SET
+			plays = GREATEST(user_milestone_state.plays, EXCLUDED.plays),
+			tracks = GREATEST(user_milestone_state.tracks, EXCLUDED.tracks),
+			artists = GREATEST(user_milestone_state.artists, EXCLUDED.artists),
+			minutes = GREATEST(user_milestone_state.minutes, EXCLUDED.minutes),
+			updated_at = NOW()
+	`, userID, current[0], current[1], current[2], current[3])
+	if err != nil {
+		log.Printf("Error saving milestone state for user %s: %v", userID, err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error committing milestone state: %v", err)
+		return
+	}
+
+	if baseline {
+		return
+	}
+
+	types := [4]string{"plays", "tracks", "artists", "minutes"}
+	thresholds := [4][]int64{s.config.MilestonePlays, s.config.MilestoneTracks, s.config.MilestoneArtists, s.config.MilestoneMinutes}
+	now := time.Now().UTC()
+
+	for i, milestoneType := range types {
+		for _, threshold := range sortedThresholds(thresholds[i]) {
+			if previous[i] < threshold && current[i] >= threshold {
+				log.Printf("User %s reached milestone: %d %s", userID, threshold, milestoneType)
+				s.webhook.Notify(MilestoneEvent{
+					Event:     "milestone.reached",
+					UserID:    userID,
+					Milestone: milestoneType,
+					Threshold: threshold,
+					Current:   current[i],
+					Timestamp: now,
+				})
+			}
+		}
+	}
+}
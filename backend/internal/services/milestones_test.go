@@ -0,0 +1,66 @@
+package services
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"musike-backend/internal/config"
+)
+
+func TestScheduleMilestoneCheckRunsOffPathAndCoalesces(t *testing.T) {
+	s := newTestTrackingService(&config.Config{})
+
+	release := make(chan struct{})
+	started := make(chan struct{}, 10)
+	var mu sync.Mutex
+	calls := 0
+	s.checkUserMilestones = func(userID string) {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		started <- struct{}{}
+		<-release
+	}
+
+	// Não pode bloquear quem grava a escuta
+	done := make(chan struct{})
+	go func() {
+		s.scheduleMilestoneCheck("user")
+		<-started
+		for i := 0; i < 5; i++ {
+			s.scheduleMilestoneCheck("user")
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("scheduleMilestoneCheck blocked while a check was running")
+	}
+
+	// Os 5 pedidos durante a primeira verificação viram uma segunda
+	release <- struct{}{}
+	<-started
+	release <- struct{}{}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		s.milestoneMutex.Lock()
+		_, running := s.milestonePending["user"]
+		s.milestoneMutex.Unlock()
+		if !running {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("milestone check still pending after release")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != 2 {
+		t.Errorf("checkMilestones ran %d times, want 2", calls)
+	}
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const momentumWindowDays = 7
+
+type MomentumPoint struct {
+	Date           string  `json:"date"`
+	Minutes        float64 `json:"minutes"`         // minutos escutados no dia
+	AverageMinutes float64 `json:"average_minutes"` // média dos 7 dias terminando neste
+}
+
+// Minutos por dia local e a média móvel de 7 dias. Dias sem escuta entram como zero antes da média, e os 6
+// dias anteriores ao período também são buscados para os primeiros pontos já terem a janela completa
+func (a *AnalyticsService) GetMomentum(ctx context.Context, userID, timeFilter string, loc *time.Location) ([]MomentumPoint, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	to := time.Now().In(loc)
+	from := timeFilterStartDate(timeFilter).In(loc)
+	if timeFilter == "alltime" {
+		// Em alltime a série começa na primeira escuta, não no ano zero
+		queryCtx, cancel := a.queryContext(ctx)
+		var first sql.NullTime
+		err := a.db.QueryRowContext(queryCtx, `
+			SELECT MIN(played_at) FROM listening_history WHERE user_id = $1 AND deleted_at IS NULL
+		`, userID).Scan(&first)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("failed to query first play: %w", err)
+		}
+		if !first.Valid {
+			return []MomentumPoint{}, nil
+		}
+		from = first.Time.In(loc)
+	}
+
+	daily, err := a.GetTimeSeries(ctx, userID, "day", from.AddDate(0, 0, -(momentumWindowDays-1)), to, loc, "")
+	if err != nil {
+		return nil, err
+	}
+
+	points := make([]MomentumPoint, 0, len(daily))
+	windowSum := 0.0
+	for i, day := range daily {
+		windowSum += day.Minutes
+		if i >= momentumWindowDays {
+			windowSum -= daily[i-momentumWindowDays].Minutes
+		}
+		if i < momentumWindowDays-1 {
+			continue
+		}
+		points = append(points, MomentumPoint{
+			Date:           day.BucketStart[:len("2006-01-02")],
+			Minutes:        day.Minutes,
+			AverageMinutes: roundMinutes(windowSum / momentumWindowDays),
+		})
+	}
+
+	return points, nil
+}
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type MonthlyFavoriteItem struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	Artists      []string  `json:"artists,omitempty"`
+	Plays        int       `json:"plays"`
+	LastPlayedAt time.Time `json:"last_played_at"`
+}
+
+type MonthlyFavorite struct {
+	Month  string               `json:"month"`
+	Track  *MonthlyFavoriteItem `json:"track"`
+	Artist *MonthlyFavoriteItem `json:"artist"`
+}
+
+// Faixa e artista mais escutados em cada um dos últimos `months` meses (incluindo o atual).
+// Empates são resolvidos pela escuta mais recente e depois pelo ID; meses sem escuta vêm com track/artist nulos.
+func (a *AnalyticsService) GetMonthlyFavorites(ctx context.Context, userID string, months int, loc *time.Location) ([]MonthlyFavorite, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	now := time.Now().In(loc)
+	firstMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc).AddDate(0, -(months - 1), 0)
+
+	trackQuery := fmt.Sprintf(`
+		SELECT DISTINCT ON (month)
+			TO_CHAR(date_trunc('month', %s), 'YYYY-MM') as month,
+			t.id,
+			t.name,
+			ARRAY(
+				SELECT ar.name FROM track_artists ta
+				JOIN artists ar ON ar.id = ta.artist_id
+				WHERE ta.track_id = t.id
+				ORDER BY ar.name
+			) as artists,
+			COUNT(*) as plays,
+			MAX(lh.played_at) as last_played_at
+		FROM listening_history lh
+		JOIN tracks t ON lh.track_id = t.id
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2
+		GROUP BY month, t.id, t.name
+		ORDER BY month, plays DESC, last_played_at DESC, t.id`, localPlayedAt(3))
+
+	trackRows, err := a.db.QueryContext(ctx, trackQuery, userID, firstMonth.UTC(), loc.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query monthly favorite tracks: %w", err)
+	}
+	defer trackRows.Close()
+
+	topTracks := make(map[string]*MonthlyFavoriteItem)
+	for trackRows.Next() {
+		var month string
+		var item MonthlyFavoriteItem
+		var artists pq.StringArray
+		if err := trackRows.Scan(&month, &item.ID, &item.Name, &artists, &item.Plays, &item.LastPlayedAt); err != nil {
+			continue
+		}
+		item.Artists = []string(artists)
+		topTracks[month] = &item
+	}
+
+	artistQuery := fmt.Sprintf(`
+		SELECT DISTINCT ON (month)
+			TO_CHAR(date_trunc('month', %s), 'YYYY-MM') as month,
+			ar.id,
+			ar.name,
+			COUNT(*) as plays,
+			MAX(lh.played_at) as last_played_at
+		FROM listening_history lh
+		JOIN track_artists ta ON ta.track_id = lh.track_id
+		JOIN artists ar ON ar.id = ta.artist_id
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2
+		GROUP BY month, ar.id, ar.name
+		ORDER BY month, plays DESC, last_played_at DESC, ar.id`, localPlayedAt(3))
+
+	artistRows, err := a.db.QueryContext(ctx, artistQuery, userID, firstMonth.UTC(), loc.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query monthly favorite artists: %w", err)
+	}
+	defer artistRows.Close()
+
+	topArtists := make(map[string]*MonthlyFavoriteItem)
+	for artistRows.Next() {
+		var month string
+		var item MonthlyFavoriteItem
+		if err := artistRows.Scan(&month, &item.ID, &item.Name, &item.Plays, &item.LastPlayedAt); err != nil {
+			continue
+		}
+		topArtists[month] = &item
+	}
+
+	// Mais recente primeiro; meses sem escuta aparecem explicitamente
+	favorites := make([]MonthlyFavorite, 0, months)
+	for current := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc); !current.Before(firstMonth); current = current.AddDate(0, -1, 0) {
+		month := current.Format("2006-01")
+		favorites = append(favorites, MonthlyFavorite{
+			Month:  month,
+			Track:  topTracks[month],
+			Artist: topArtists[month],
+		})
+	}
+
+	return favorites, nil
+}
+
+type MonthlyGenre struct {
+	Month      string  `json:"month"`
+	Genre      *string `json:"genre"` // nulo em meses sem escuta com gênero conhecido
+	Plays      int     `json:"plays"`
+	Percentage float64 `json:"percentage"` // fatia das escutas com gênero do mês
+	Tied       bool    `json:"tied"`       // outro gênero teve as mesmas escutas; vence o primeiro em ordem alfabética
+}
+
+// Gênero mais escutado em cada um dos últimos `months` meses (incluindo o atual), do mais recente para o mais antigo
+func (a *AnalyticsService) GetDominantGenreTimeline(ctx context.Context, userID string, months int, loc *time.Location) ([]MonthlyGenre, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	now := time.Now().In(loc)
+	firstMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc).AddDate(0, -(months - 1), 0)
+
+	// DISTINCT por (escuta, gênero) para que uma faixa com dois artistas do mesmo gênero conte uma vez
+	query := fmt.Sprintf(`
+		WITH play_genres AS (
+			SELECT DISTINCT lh.id, TO_CHAR(date_trunc('month', %s), 'YYYY-MM') as month, g.genre
+			FROM listening_history lh
+			JOIN track_artists ta ON ta.track_id = lh.track_id
+			JOIN artists ar ON ar.id = ta.artist_id
+			CROSS JOIN LATERAL UNNEST(`+effectiveGenres("lh.user_id", "ar")+`) AS g(genre)
+			WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2
+		),
+		month_plays AS (
+			SELECT month, COUNT(DISTINCT id) as plays FROM play_genres GROUP BY month
+		),
+		ranked AS (
+			SELECT
+				month,
+				genre,
+				COUNT(*) as plays,
+				COUNT(*) OVER (PARTITION BY month, COUNT(*)) as same_plays,
+				ROW_NUMBER() OVER (PARTITION BY month ORDER BY COUNT(*) DESC, genre) as position
+			FROM play_genres
+			GROUP BY month, genre
+		)
+		SELECT r.month, r.genre, r.plays, r.plays * 100.0 / mp.plays, r.same_plays > 1
+		FROM ranked r
+		JOIN month_plays mp ON mp.month = r.month
+		WHERE r.position = 1`, localPlayedAt(3))
+
+	rows, err := a.db.QueryContext(ctx, query, userID, firstMonth.UTC(), loc.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query dominant genres: %w", err)
+	}
+	defer rows.Close()
+
+	dominant := make(map[string]MonthlyGenre)
+	for rows.Next() {
+		var item MonthlyGenre
+		var genre string
+		if err := rows.Scan(&item.Month, &genre, &item.Plays, &item.Percentage, &item.Tied); err != nil {
+			continue
+		}
+		item.Genre = &genre
+		item.Percentage = math.Round(item.Percentage*100) / 100
+		dominant[item.Month] = item
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Mais recente primeiro; meses sem escuta aparecem explicitamente
+	timeline := make([]MonthlyGenre, 0, months)
+	for current := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc); !current.Before(firstMonth); current = current.AddDate(0, -1, 0) {
+		month := current.Format("2006-01")
+		item, ok := dominant[month]
+		if !ok {
+			item = MonthlyGenre{Month: month}
+		}
+		timeline = append(timeline, item)
+	}
+
+	return timeline, nil
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type NeglectedItem struct {
+	ID                  string    `json:"id"`
+	Name                string    `json:"name"`
+	Artists             []string  `json:"artists,omitempty"`
+	TotalPlays          int       `json:"total_plays"`
+	LastPlayedAt        time.Time `json:"last_played_at"`
+	DaysSinceLastPlayed int       `json:"days_since_last_played"`
+}
+
+type NeglectedFavorites struct {
+	WindowDays int             `json:"window_days"`
+	MinPlays   int             `json:"min_plays"`
+	Tracks     []NeglectedItem `json:"tracks"`
+	Artists    []NeglectedItem `json:"artists"`
+}
+
+// Faixas e artistas muito escutados no histórico mas sem nenhuma escuta nos últimos windowDays dias
+func (a *AnalyticsService) GetNeglectedFavorites(ctx context.Context, userID string, windowDays, minPlays, limit int) (*NeglectedFavorites, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	now := time.Now()
+	recentSince := now.AddDate(0, 0, -windowDays)
+	result := &NeglectedFavorites{
+		WindowDays: windowDays,
+		MinPlays:   minPlays,
+		Tracks:     make([]NeglectedItem, 0),
+		Artists:    make([]NeglectedItem, 0),
+	}
+
+	trackRows, err := a.db.QueryContext(ctx, `
+		SELECT
+			t.id,
+			t.name,
+			ARRAY(
+				SELECT ar.name FROM track_artists ta
+				JOIN artists ar ON ar.id = ta.artist_id
+				WHERE ta.track_id = t.id
+				ORDER BY ar.name
+			) as artists,
+			COUNT(*) as total_plays,
+			MAX(lh.played_at) as last_played_at
+		FROM listening_history lh
+		JOIN tracks t ON lh.track_id = t.id
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL
+		GROUP BY t.id, t.name
+		HAVING COUNT(*) >= $3 AND COUNT(*) FILTER (WHERE lh.played_at >= $2) = 0
+		ORDER BY total_plays DESC, last_played_at DESC
+		LIMIT $4`, userID, recentSince, minPlays, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query neglected tracks: %w", err)
+	}
+	defer trackRows.Close()
+
+	for trackRows.Next() {
+		var item NeglectedItem
+		var artists pq.StringArray
+		if err := trackRows.Scan(&item.ID, &item.Name, &artists, &item.TotalPlays, &item.LastPlayedAt); err != nil {
+			continue
+		}
+		item.Artists = []string(artists)
+		item.DaysSinceLastPlayed = int(now.Sub(item.LastPlayedAt).Hours() / 24)
+		result.Tracks = append(result.Tracks, item)
+	}
+
+	artistRows, err := a.db.QueryContext(ctx, `
+		SELECT
+			ar.id,
+			ar.name,
+			COUNT(*) as total_plays,
+			MAX(lh.played_at) as last_played_at
+		FROM listening_history lh
+		JOIN track_artists ta ON ta.track_id = lh.track_id
+		JOIN artists ar ON ar.id = ta.artist_id
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL
+		GROUP BY ar.id, ar.name
+		HAVING COUNT(*) >= $3 AND COUNT(*) FILTER (WHERE lh.played_at >= $2) = 0
+		ORDER BY total_plays DESC, last_played_at DESC
+		LIMIT $4`, userID, recentSince, minPlays, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query neglected artists: %w", err)
+	}
+	defer artistRows.Close()
+
+	for artistRows.Next() {
+		var item NeglectedItem
+		if err := artistRows.Scan(&item.ID, &item.Name, &item.TotalPlays, &item.LastPlayedAt); err != nil {
+			continue
+		}
+		item.DaysSinceLastPlayed = int(now.Sub(item.LastPlayedAt).Hours() / 24)
+		result.Artists = append(result.Artists, item)
+	}
+
+	return result, nil
+}
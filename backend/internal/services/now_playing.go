@@ -0,0 +1,60 @@
+package services
+
+import (
+	"strings"
+	"time"
+)
+
+// Resumo do que um usuário está ouvindo, enxuto para telas de parede (ex.: modo festa)
+type NowPlaying struct {
+	TrackID    string `json:"track_id"`
+	Track      string `json:"track"`
+	Artists    string `json:"artists"`
+	ImageURL   string `json:"image_url,omitempty"`
+	ProgressMs int    `json:"progress_ms"`
+	DurationMs int    `json:"duration_ms"`
+	IsPlaying  bool   `json:"is_playing"`
+}
+
+// Faixa atual (conta principal) de cada usuário em tracking, lida do estado em memória sob uma única
+// leitura do lock para a foto do grupo ser consistente. Usuários sem tracking ou sem faixa ficam de fora
+func (s *TrackingService) GetNowPlayingGroup(userIDs []string) map[string]*NowPlaying {
+	s.trackingMutex.RLock()
+	defer s.trackingMutex.RUnlock()
+
+	now := time.Now()
+	group := make(map[string]*NowPlaying, len(userIDs))
+	for _, userID := range userIDs {
+		tracking, exists := s.activeTracking[userID]
+		if !exists || !tracking.IsActive || tracking.LastTrack == nil {
+			continue
+		}
+		track := tracking.LastTrack
+
+		// LastTrack guarda a faixa como estava quando começou; o progresso e a pausa vêm da última consulta
+		// ao Spotify, e enquanto toca estimamos o quanto andou desde então
+		progress := tracking.LastProgressMs
+		playing := tracking.PausedSince.IsZero()
+		if playing {
+			progress += int(now.Sub(tracking.LastUpdated).Milliseconds())
+			if track.DurationMs > 0 {
+				progress = min(progress, track.DurationMs)
+			}
+		}
+
+		nowPlaying := &NowPlaying{
+			TrackID:    track.ID,
+			Track:      track.Name,
+			Artists:    strings.Join(getArtistNames(track.Artists), ", "),
+			ProgressMs: progress,
+			DurationMs: track.DurationMs,
+			IsPlaying:  playing,
+		}
+		if len(track.Album.Images) > 0 {
+			nowPlaying.ImageURL = track.Album.Images[0].URL
+		}
+		group[userID] = nowPlaying
+	}
+
+	return group
+}
@@ -0,0 +1,40 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNowPlayingUsesLatestPoll(t *testing.T) {
+	s, _ := newRecordingTracker(10 * time.Minute)
+	start := time.Now().Add(-time.Minute)
+
+	playing := newTestTracking(start)
+	playing.UserID = "playing"
+	drivePolls(s, playing, start, []playerPoll{
+		{0, "a", true, 0},
+		{30 * time.Second, "a", true, 30 * time.Second},
+	})
+
+	paused := newTestTracking(start)
+	paused.UserID = "paused"
+	drivePolls(s, paused, start, []playerPoll{
+		{0, "b", true, 0},
+		{30 * time.Second, "b", true, 30 * time.Second},
+		{45 * time.Second, "b", false, 45 * time.Second},
+	})
+
+	s.activeTracking["playing"] = playing
+	s.activeTracking["paused"] = paused
+	group := s.GetNowPlayingGroup([]string{"playing", "paused"})
+
+	// Tocando: progresso da última consulta (30s) mais o tempo desde ela (~30s)
+	if got := group["playing"]; got == nil || !got.IsPlaying || got.ProgressMs < 59000 || got.ProgressMs > 62000 {
+		t.Errorf("playing = %+v, want is_playing with progress around 60000ms", got)
+	}
+
+	// Pausado: parado no progresso da última consulta, mesmo com a faixa tocando quando começou
+	if got := group["paused"]; got == nil || got.IsPlaying || got.ProgressMs != 45000 {
+		t.Errorf("paused = %+v, want not playing at 45000ms", got)
+	}
+}
@@ -0,0 +1,185 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type OnThisDayItem struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Artists []string `json:"artists,omitempty"`
+	Plays   int      `json:"plays"`
+}
+
+type OnThisDayYear struct {
+	Year       int             `json:"year"`
+	Date       string          `json:"date"` // dia efetivamente consultado naquele ano (29/02 vira 28/02 fora de ano bissexto)
+	Plays      int             `json:"plays"`
+	Minutes    float64         `json:"minutes"`
+	TopTracks  []OnThisDayItem `json:"top_tracks"`
+	TopArtists []OnThisDayItem `json:"top_artists"`
+}
+
+const onThisDayTopItems = 5
+
+// O mesmo dia/mês em cada ano anterior, desde o ano da primeira escuta. Anos sem escuta vêm zerados,
+// do mais recente para o mais antigo
+func (a *AnalyticsService) GetOnThisDay(ctx context.Context, userID string, date time.Time, loc *time.Location) ([]OnThisDayYear, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	years := []OnThisDayYear{}
+
+	var firstPlay *time.Time
+	err := a.db.QueryRowContext(ctx, `
+		SELECT MIN(played_at) FROM listening_history WHERE user_id = $1 AND deleted_at IS NULL
+	`, userID).Scan(&firstPlay)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query first play: %w", err)
+	}
+	if firstPlay == nil {
+		return years, nil
+	}
+
+	firstYear := firstPlay.In(loc).Year()
+	days := []string{}
+	byDate := make(map[string]*OnThisDayYear)
+	for year := date.Year() - 1; year >= firstYear; year-- {
+		day := sameDayInYear(date, year)
+		years = append(years, OnThisDayYear{
+			Year:       year,
+			Date:       day,
+			TopTracks:  []OnThisDayItem{},
+			TopArtists: []OnThisDayItem{},
+		})
+		days = append(days, day)
+	}
+	for idx := range years {
+		byDate[years[idx].Date] = &years[idx]
+	}
+	if len(days) == 0 {
+		return years, nil
+	}
+
+	localDate := fmt.Sprintf("DATE(%s)", localPlayedAt(3))
+
+	totalsQuery := fmt.Sprintf(`
+		SELECT TO_CHAR(%s, 'YYYY-MM-DD') as day, COUNT(*), COALESCE(SUM(lh.listened_duration_ms), 0)
+		FROM listening_history lh
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND %s = ANY($2::date[])
+		GROUP BY day`, localDate, localDate)
+
+	rows, err := a.db.QueryContext(ctx, totalsQuery, userID, pq.Array(days), loc.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query on this day totals: %w", err)
+	}
+	for rows.Next() {
+		var day string
+		var plays int
+		var durationMs int64
+		if err := rows.Scan(&day, &plays, &durationMs); err != nil {
+			continue
+		}
+		if entry, exists := byDate[day]; exists {
+			entry.Plays = plays
+			entry.Minutes = float64(durationMs) / 60000
+		}
+	}
+	rows.Close()
+
+	tracksQuery := fmt.Sprintf(`
+		SELECT day, id, name, artists, plays FROM (
+			SELECT
+				TO_CHAR(%s, 'YYYY-MM-DD') as day,
+				t.id,
+				t.name,
+				ARRAY(
+					SELECT ar.name FROM track_artists ta
+					JOIN artists ar ON ar.id = ta.artist_id
+					WHERE ta.track_id = t.id
+					ORDER BY ar.name
+				) as artists,
+				COUNT(*) as plays,
+				ROW_NUMBER() OVER (PARTITION BY TO_CHAR(%s, 'YYYY-MM-DD') ORDER BY COUNT(*) DESC, t.name) as rank
+			FROM listening_history lh
+			JOIN tracks t ON lh.track_id = t.id
+			WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND %s = ANY($2::date[])
+			GROUP BY day, t.id, t.name
+		) ranked
+		WHERE rank <= $4
+		ORDER BY day, rank`, localDate, localDate, localDate)
+
+	rows, err = a.db.QueryContext(ctx, tracksQuery, userID, pq.Array(days), loc.String(), onThisDayTopItems)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query on this day tracks: %w", err)
+	}
+	for rows.Next() {
+		var day string
+		var item OnThisDayItem
+		var artists pq.StringArray
+		if err := rows.Scan(&day, &item.ID, &item.Name, &artists, &item.Plays); err != nil {
+			continue
+		}
+		item.Artists = []string(artists)
+		if entry, exists := byDate[day]; exists {
+			entry.TopTracks = append(entry.TopTracks, item)
+		}
+	}
+	rows.Close()
+
+	artistsQuery := fmt.Sprintf(`
+		SELECT day, id, name, plays FROM (
+			SELECT
+				TO_CHAR(%s, 'YYYY-MM-DD') as day,
+				ar.id,
+				ar.name,
+				COUNT(*) as plays,
+				ROW_NUMBER() OVER (PARTITION BY TO_CHAR(%s, 'YYYY-MM-DD') ORDER BY COUNT(*) DESC, ar.name) as rank
+			FROM listening_history lh
+			JOIN track_artists ta ON ta.track_id = lh.track_id
+			JOIN artists ar ON ar.id = ta.artist_id
+			WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND %s = ANY($2::date[])
+			GROUP BY day, ar.id, ar.name
+		) ranked
+		WHERE rank <= $4
+		ORDER BY day, rank`, localDate, localDate, localDate)
+
+	rows, err = a.db.QueryContext(ctx, artistsQuery, userID, pq.Array(days), loc.String(), onThisDayTopItems)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query on this day artists: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var day string
+		var item OnThisDayItem
+		if err := rows.Scan(&day, &item.ID, &item.Name, &item.Plays); err != nil {
+			continue
+		}
+		if entry, exists := byDate[day]; exists {
+			entry.TopArtists = append(entry.TopArtists, item)
+		}
+	}
+
+	return years, rows.Err()
+}
+
+// Mesmo dia e mês em outro ano; 29 de fevereiro cai em 28 de fevereiro nos anos não bissextos
+func sameDayInYear(date time.Time, year int) string {
+	day := date.Day()
+	if date.Month() == time.February && day == 29 && !isLeapYear(year) {
+		day = 28
+	}
+	return fmt.Sprintf("%04d-%02d-%02d", year, int(date.Month()), day)
+}
+
+func isLeapYear(year int) bool {
+	return year%4 == 0 && (year%100 != 0 || year%400 == 0)
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"fmt"
+)
+
+type OneHitArtist struct {
+	ArtistID       string  `json:"artist_id"`
+	ArtistName     string  `json:"artist_name"`
+	ImageURL       string  `json:"image_url,omitempty"`
+	ArtistPlays    int     `json:"artist_plays"`
+	DistinctTracks int     `json:"distinct_tracks"`
+	TopTrackID     string  `json:"top_track_id"`
+	TopTrackName   string  `json:"top_track_name"`
+	TopTrackPlays  int     `json:"top_track_plays"`
+	Dominance      float64 `json:"dominance"` // top_track_plays / artist_plays
+}
+
+type OneHitReport struct {
+	Threshold         int            `json:"threshold"` // % mínima das escutas do artista na faixa mais tocada
+	MinPlays          int            `json:"min_plays"`
+	ArtistsConsidered int            `json:"artists_considered"` // artistas com pelo menos min_plays escutas
+	OneHitArtists     int            `json:"one_hit_artists"`
+	Artists           []OneHitArtist `json:"artists"`
+}
+
+// Artistas em que uma única faixa concentra pelo menos `threshold`% das escutas ("só conheço uma música
+// deles"). Artistas com menos de minPlays escutas ficam de fora, já que com uma ou duas escutas toda faixa domina
+func (a *AnalyticsService) GetOneHitArtists(ctx context.Context, userID, timeFilter string, threshold, minPlays, limit int) (*OneHitReport, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	report := &OneHitReport{Threshold: threshold, MinPlays: minPlays, Artists: []OneHitArtist{}}
+
+	rows, err := a.db.QueryContext(ctx, `
+		WITH track_plays AS (
+			SELECT ta.artist_id, lh.track_id, COUNT(*) as plays
+			FROM listening_history lh
+			JOIN track_artists ta ON ta.track_id = lh.track_id
+			WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2
+			GROUP BY ta.artist_id, lh.track_id
+		),
+		artist_plays AS (
+			SELECT
+				artist_id,
+				SUM(plays)::int as plays,
+				COUNT(*) as distinct_tracks,
+				(ARRAY_AGG(track_id ORDER BY plays DESC, track_id))[1] as top_track_id,
+				MAX(plays) as top_track_plays
+			FROM track_plays
+			GROUP BY artist_id
+			HAVING SUM(plays) >= $3
+		)
+		SELECT
+			ap.artist_id,
+			ar.name,
+			COALESCE(ar.image_url, ''),
+			ap.plays,
+			ap.distinct_tracks,
+			ap.top_track_id,
+			t.name,
+			ap.top_track_plays,
+			COUNT(*) OVER () as considered
+		FROM artist_plays ap
+		JOIN artists ar ON ar.id = ap.artist_id
+		JOIN tracks t ON t.id = ap.top_track_id
+		ORDER BY ap.top_track_plays * 100 >= ap.plays * $4 DESC, ap.plays DESC, ap.artist_id
+	`, userID, timeFilterStartDate(timeFilter), minPlays, threshold)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query one-hit artists: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var artist OneHitArtist
+		if err := rows.Scan(&artist.ArtistID, &artist.ArtistName, &artist.ImageURL, &artist.ArtistPlays,
+			&artist.DistinctTracks, &artist.TopTrackID, &artist.TopTrackName, &artist.TopTrackPlays,
+			&report.ArtistsConsidered); err != nil {
+			continue
+		}
+		if artist.TopTrackPlays*100 < artist.ArtistPlays*threshold {
+			// Ordenados com os dominados primeiro; daqui em diante só artistas explorados com variedade
+			break
+		}
+		report.OneHitArtists++
+		if len(report.Artists) < limit {
+			artist.Dominance = roundFraction(float64(artist.TopTrackPlays) / float64(artist.ArtistPlays))
+			report.Artists = append(report.Artists, artist)
+		}
+	}
+
+	return report, rows.Err()
+}
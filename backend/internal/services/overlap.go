@@ -0,0 +1,84 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Intervalo [From, To) de escutas comparado em GetPeriodOverlap
+type Period struct {
+	From time.Time
+	To   time.Time
+}
+
+// Interseção dos itens distintos escutados em A e em B. AToB é a fração dos itens de A que voltaram em B
+// (retenção de A para B) e BToA a fração dos itens de B que já estavam em A; 0 quando o período não tem escutas
+type OverlapStats struct {
+	InA    int     `json:"in_a"`
+	InB    int     `json:"in_b"`
+	Shared int     `json:"shared"`
+	AToB   float64 `json:"a_to_b"`
+	BToA   float64 `json:"b_to_a"`
+}
+
+type PeriodOverlap struct {
+	Tracks  OverlapStats `json:"tracks"`
+	Artists OverlapStats `json:"artists"`
+}
+
+// Quanto do que foi escutado em um período continuou no outro (faixas e artistas distintos), para ver
+// quanto a rotação mudou. Os períodos podem se sobrepor
+func (a *AnalyticsService) GetPeriodOverlap(ctx context.Context, userID string, periodA, periodB Period) (*PeriodOverlap, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	var overlap PeriodOverlap
+	tracks, artists := &overlap.Tracks, &overlap.Artists
+	err := a.db.QueryRowContext(ctx, `
+		WITH a_tracks AS (
+			SELECT DISTINCT lh.track_id
+			FROM listening_history lh
+			WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2 AND lh.played_at < $3
+		),
+		b_tracks AS (
+			SELECT DISTINCT lh.track_id
+			FROM listening_history lh
+			WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $4 AND lh.played_at < $5
+		),
+		a_artists AS (
+			SELECT DISTINCT ta.artist_id FROM a_tracks t JOIN track_artists ta ON ta.track_id = t.track_id
+		),
+		b_artists AS (
+			SELECT DISTINCT ta.artist_id FROM b_tracks t JOIN track_artists ta ON ta.track_id = t.track_id
+		)
+		SELECT
+			(SELECT COUNT(*) FROM a_tracks),
+			(SELECT COUNT(*) FROM b_tracks),
+			(SELECT COUNT(*) FROM a_tracks JOIN b_tracks USING (track_id)),
+			(SELECT COUNT(*) FROM a_artists),
+			(SELECT COUNT(*) FROM b_artists),
+			(SELECT COUNT(*) FROM a_artists JOIN b_artists USING (artist_id))
+	`, userID, periodA.From.UTC(), periodA.To.UTC(), periodB.From.UTC(), periodB.To.UTC()).Scan(
+		&tracks.InA, &tracks.InB, &tracks.Shared, &artists.InA, &artists.InB, &artists.Shared)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query period overlap: %w", err)
+	}
+
+	tracks.AToB, tracks.BToA = overlapFraction(tracks.Shared, tracks.InA), overlapFraction(tracks.Shared, tracks.InB)
+	artists.AToB, artists.BToA = overlapFraction(artists.Shared, artists.InA), overlapFraction(artists.Shared, artists.InB)
+
+	return &overlap, nil
+}
+
+func overlapFraction(shared, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return math.Round(float64(shared)/float64(total)*1000) / 1000
+}
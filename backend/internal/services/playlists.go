@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/oauth2"
+)
+
+// Máximo de faixas por chamada de adicionar faixas na API do Spotify
+const playlistTracksBatch = 100
+
+type SpotifyPlaylist struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	URI          string `json:"uri"`
+	Public       bool   `json:"public"`
+	ExternalURLs struct {
+		Spotify string `json:"spotify"`
+	} `json:"external_urls"`
+}
+
+// 403 do Spotify: o token não tem o scope da operação (ex.: playlist-modify-private)
+func IsSpotifyForbidden(err error) bool {
+	var apiErr *SpotifyAPIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusForbidden
+}
+
+// IDs sintéticos do import (artist_..., track_...) não existem no Spotify
+func IsSpotifyID(id string) bool {
+	return spotifyIDRegexp.MatchString(id)
+}
+
+// Cria uma playlist vazia na conta dona do token. Precisa de playlist-modify-private (ou -public para pública)
+func (s *SpotifyService) CreatePlaylist(token *oauth2.Token, name, description string, public bool) (*SpotifyPlaylist, error) {
+	body := map[string]interface{}{
+		"name":        name,
+		"description": description,
+		"public":      public,
+	}
+
+	var playlist SpotifyPlaylist
+	if err := s.doRequest(context.Background(), "POST", s.config.SpotifyAPIBaseURL+"/v1/me/playlists", token, body, &playlist); err != nil {
+		return nil, err
+	}
+
+	return &playlist, nil
+}
+
+// Adiciona as faixas na ordem recebida, em lotes de 100. Se um lote falhar, os anteriores já foram adicionados
+func (s *SpotifyService) AddTracksToPlaylist(token *oauth2.Token, playlistID string, trackIDs []string) error {
+	apiURL := s.config.SpotifyAPIBaseURL + "/v1/playlists/" + url.PathEscape(playlistID) + "/tracks"
+
+	for start := 0; start < len(trackIDs); start += playlistTracksBatch {
+		batch := trackIDs[start:min(start+playlistTracksBatch, len(trackIDs))]
+		uris := make([]string, len(batch))
+		for i, trackID := range batch {
+			uris[i] = "spotify:track:" + trackID
+		}
+
+		if err := s.doRequest(context.Background(), "POST", apiURL, token, map[string]interface{}{"uris": uris}, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
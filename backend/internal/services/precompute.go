@@ -0,0 +1,159 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"golang.org/x/oauth2"
+	"musike-backend/internal/config"
+)
+
+// Job diário que pré-calcula os analytics dos usuários com tracking ativo
+// (os únicos com token do Spotify disponível) para os filtros configurados
+type AnalyticsPrecomputeJob struct {
+	config           *config.Config
+	analyticsService *AnalyticsService
+	spotifyService   *SpotifyService
+	trackingService  *TrackingService
+	stopChannel      chan bool
+}
+
+func NewAnalyticsPrecomputeJob(cfg *config.Config, analyticsService *AnalyticsService, spotifyService *SpotifyService, trackingService *TrackingService) *AnalyticsPrecomputeJob {
+	return &AnalyticsPrecomputeJob{
+		config:           cfg,
+		analyticsService: analyticsService,
+		spotifyService:   spotifyService,
+		trackingService:  trackingService,
+		stopChannel:      make(chan bool),
+	}
+}
+
+func (j *AnalyticsPrecomputeJob) Start() {
+	for {
+		next := nextRunAt(time.Now(), j.config.AnalyticsPrecomputeHour)
+		log.Printf("Next analytics precompute run scheduled for %s", next.Format(time.RFC3339))
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+			j.RunOnce()
+		case <-j.stopChannel:
+			timer.Stop()
+			log.Println("Stopping analytics precompute job...")
+			return
+		}
+	}
+}
+
+func (j *AnalyticsPrecomputeJob) Stop() {
+	j.stopChannel <- true
+}
+
+func (j *AnalyticsPrecomputeJob) RunOnce() {
+	users := j.trackingService.GetActiveUserTokens()
+	log.Printf("Precomputing analytics for %d active users, filters %v", len(users), j.config.AnalyticsPrecomputeFilters)
+
+	computed := 0
+	for userID, spotifyToken := range users {
+		token := &oauth2.Token{AccessToken: spotifyToken}
+		for _, timeFilter := range j.config.AnalyticsPrecomputeFilters {
+			ctx := context.Background()
+			analytics, err := j.analyticsService.GenerateUserAnalytics(ctx, userID, timeFilter, j.spotifyService, token)
+			if err != nil {
+				log.Printf("Error precomputing analytics for user %s (%s): %v", userID, timeFilter, err)
+				continue
+			}
+
+			if len(analytics.DegradedFields) > 0 {
+				log.Printf("Not caching analytics for user %s (%s): degraded fields %v", userID, timeFilter, analytics.DegradedFields)
+				continue
+			}
+			if err := j.analyticsService.SaveCachedAnalytics(ctx, analytics, timeFilter); err != nil {
+				log.Printf("Error caching analytics for user %s (%s): %v", userID, timeFilter, err)
+				continue
+			}
+			computed++
+		}
+	}
+
+	log.Printf("Analytics precompute finished: %d entries cached", computed)
+}
+
+func nextRunAt(now time.Time, hour int) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), hour, 0, 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// Analytics pré-calculados ainda válidos, ou nil. Com o precompute desligado o cache não é usado: ninguém
+// o renova, e uma entrada antiga ficaria sendo servida até expirar
+func (a *AnalyticsService) GetCachedAnalytics(ctx context.Context, userID string, timeFilter string) (*UserAnalytics, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+	if !a.config.AnalyticsPrecomputeEnabled {
+		return nil, nil
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	var payload []byte
+	var computedAt time.Time
+	err := a.db.QueryRowContext(ctx, `
+		SELECT payload, computed_at FROM user_analytics_cache
+		WHERE user_id = $1 AND time_filter = $2 AND computed_at >= $3
+	`, userID, timeFilter, time.Now().Add(-a.config.AnalyticsCacheMaxAge)).Scan(&payload, &computedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query cached analytics: %w", err)
+	}
+
+	var analytics UserAnalytics
+	if err := json.Unmarshal(payload, &analytics); err != nil {
+		return nil, fmt.Errorf("failed to decode cached analytics: %w", err)
+	}
+	analytics.CachedAt = &computedAt
+
+	return &analytics, nil
+}
+
+// Guarda os analytics no cache. Resultados com campos degradados (Spotify fora, consulta estourando o tempo)
+// não são guardados, senão ficariam sendo servidos incompletos até a próxima invalidação
+func (a *AnalyticsService) SaveCachedAnalytics(ctx context.Context, analytics *UserAnalytics, timeFilter string) error {
+	if a.db == nil {
+		return fmt.Errorf("database not available")
+	}
+	if !a.config.AnalyticsPrecomputeEnabled || len(analytics.DegradedFields) > 0 {
+		return nil
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	payload, err := json.Marshal(analytics)
+	if err != nil {
+		return fmt.Errorf("failed to encode analytics: %w", err)
+	}
+
+	_, err = a.db.ExecContext(ctx, `
+		INSERT INTO user_analytics_cache (user_id, time_filter, payload, computed_at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (user_id, time_filter) DO UPDATE SET
+			payload = EXCLUDED.payload,
+			computed_at = EXCLUDED.computed_at
+	`, analytics.UserID, timeFilter, payload)
+	if err != nil {
+		return fmt.Errorf("failed to save cached analytics: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,116 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"musike-backend/internal/config"
+
+	"golang.org/x/oauth2"
+)
+
+// Banco que nunca conecta: qualquer consulta falha, então só passa o que não chega ao banco
+func unreachableDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("postgres", "postgres://musike@127.0.0.1:1/none?sslmode=disable&connect_timeout=1")
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestCachedAnalyticsSkippedWhenPrecomputeDisabled(t *testing.T) {
+	a := NewAnalyticsService(&config.Config{AnalyticsPrecomputeEnabled: false}, unreachableDB(t))
+
+	cached, err := a.GetCachedAnalytics(context.Background(), "user", "6months")
+	if err != nil || cached != nil {
+		t.Errorf("GetCachedAnalytics = %v, %v; want nil, nil with precompute disabled", cached, err)
+	}
+	if err := a.SaveCachedAnalytics(context.Background(), &UserAnalytics{UserID: "user"}, "6months"); err != nil {
+		t.Errorf("SaveCachedAnalytics with precompute disabled: %v", err)
+	}
+}
+
+func TestSaveCachedAnalyticsSkipsDegradedPayload(t *testing.T) {
+	a := NewAnalyticsService(&config.Config{AnalyticsPrecomputeEnabled: true}, unreachableDB(t))
+
+	degraded := &UserAnalytics{UserID: "user", DegradedFields: []string{"top_genres"}}
+	if err := a.SaveCachedAnalytics(context.Background(), degraded, "6months"); err != nil {
+		t.Errorf("SaveCachedAnalytics with degraded fields: %v", err)
+	}
+
+	// Sem campos degradados o save chega ao banco (e falha aqui, que não tem banco)
+	if err := a.SaveCachedAnalytics(context.Background(), &UserAnalytics{UserID: "user"}, "6months"); err == nil {
+		t.Error("SaveCachedAnalytics of a complete payload did not reach the database")
+	}
+}
+
+func TestGenerateUserAnalyticsReturnsQueryTimeout(t *testing.T) {
+	// Spotify fora: sem o banco, todos os campos cairiam no fallback degradado
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{SpotifyAPIBaseURL: server.URL, DBQueryTimeout: time.Second}
+	a := NewAnalyticsService(cfg, unreachableDB(t))
+
+	// Prazo já vencido: a primeira consulta ao banco falha por timeout
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	analytics, err := a.GenerateUserAnalytics(ctx, "user", "6months", NewSpotifyService(cfg), &oauth2.Token{AccessToken: "token"})
+	if !IsQueryTimeout(err) {
+		t.Fatalf("GenerateUserAnalytics error = %v, want a query timeout", err)
+	}
+	if analytics != nil {
+		t.Errorf("GenerateUserAnalytics returned analytics %+v along with the timeout", analytics)
+	}
+}
+
+func TestGenerateUserAnalyticsCancelsSpotifyCallsOnExpiredToken(t *testing.T) {
+	// Top tracks responde 401 na hora; as outras chamadas só voltam quando o request é cancelado
+	cancelled := make(chan struct{}, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/me/top/tracks" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			cancelled <- struct{}{}
+		case <-time.After(10 * time.Second):
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{SpotifyAPIBaseURL: server.URL, DBQueryTimeout: time.Second}
+	a := NewAnalyticsService(cfg, unreachableDB(t))
+
+	start := time.Now()
+	analytics, err := a.GenerateUserAnalytics(context.Background(), "user", "6months", NewSpotifyService(cfg), &oauth2.Token{AccessToken: "token"})
+	if !IsSpotifyUnauthorized(err) {
+		t.Fatalf("GenerateUserAnalytics error = %v, want Spotify unauthorized", err)
+	}
+	if analytics != nil {
+		t.Errorf("GenerateUserAnalytics returned analytics %+v along with the expired token", analytics)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("GenerateUserAnalytics took %v, want the pending Spotify calls cancelled", elapsed)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-cancelled:
+		case <-time.After(5 * time.Second):
+			t.Fatal("pending Spotify call was not cancelled")
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"musike-backend/internal/config"
+
+	"github.com/lib/pq"
+)
+
+var ErrInvalidPreference = errors.New("invalid preference")
+
+var (
+	preferenceTimeFilters = []string{"6months", "1year", "alltime"}
+	preferenceUnits       = []string{"ms", "minutes", "hours"} // unidade para exibir tempo de escuta (?units=)
+)
+
+// Preferências do usuário (user_preferences); campos não definidos por ele usam o padrão
+type UserPreferences struct {
+	Timezone          string `json:"timezone"`
+	AutoStartTracking bool   `json:"auto_start_tracking"`
+	WeeklyReport      bool   `json:"weekly_report"` // opt-in do relatório semanal
+	DefaultTimeFilter string `json:"default_time_filter"`
+	Units             string `json:"units"`
+	ShareNowPlaying   bool   `json:"share_now_playing"` // aparece no now-playing em grupo de outros usuários
+}
+
+// Campos ausentes (nil) ficam como estão
+type UserPreferencesUpdate struct {
+	Timezone          *string `json:"timezone"`
+	AutoStartTracking *bool   `json:"auto_start_tracking"`
+	WeeklyReport      *bool   `json:"weekly_report"`
+	DefaultTimeFilter *string `json:"default_time_filter"`
+	Units             *string `json:"units"`
+	ShareNowPlaying   *bool   `json:"share_now_playing"`
+}
+
+type PreferencesService struct {
+	config *config.Config
+	db     *sql.DB
+}
+
+func NewPreferencesService(cfg *config.Config, db *sql.DB) *PreferencesService {
+	return &PreferencesService{
+		config: cfg,
+		db:     db,
+	}
+}
+
+func (p *PreferencesService) defaults() *UserPreferences {
+	return &UserPreferences{
+		Timezone:          "UTC",
+		AutoStartTracking: p.config.AutoStartTracking,
+		WeeklyReport:      false,
+		DefaultTimeFilter: "6months",
+		Units:             "ms",
+		ShareNowPlaying:   false,
+	}
+}
+
+func (p *PreferencesService) Get(ctx context.Context, userID string) (*UserPreferences, error) {
+	if p.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	var timezone, timeFilter, units sql.NullString
+	var autoStart, weeklyReport, shareNowPlaying sql.NullBool
+	err := p.db.QueryRowContext(ctx, `
+		SELECT timezone, auto_start_tracking, weekly_report, default_time_filter, units, share_now_playing
+		FROM user_preferences WHERE user_id = $1
+	`, userID).Scan(&timezone, &autoStart, &weeklyReport, &timeFilter, &units, &shareNowPlaying)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to query user preferences: %w", err)
+	}
+
+	preferences := p.defaults()
+	if timezone.Valid {
+		preferences.Timezone = timezone.String
+	}
+	if autoStart.Valid {
+		preferences.AutoStartTracking = autoStart.Bool
+	}
+	if weeklyReport.Valid {
+		preferences.WeeklyReport = weeklyReport.Bool
+	}
+	if timeFilter.Valid {
+		preferences.DefaultTimeFilter = timeFilter.String
+	}
+	if units.Valid {
+		preferences.Units = units.String
+	}
+	if shareNowPlaying.Valid {
+		preferences.ShareNowPlaying = shareNowPlaying.Bool
+	}
+	return preferences, nil
+}
+
+// Valida todos os campos enviados antes de gravar; um campo inválido devolve ErrInvalidPreference sem alterar nada
+func (p *PreferencesService) Update(ctx context.Context, userID string, update UserPreferencesUpdate) (*UserPreferences, error) {
+	if p.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	if err := validatePreferences(update); err != nil {
+		return nil, err
+	}
+
+	_, err := p.db.ExecContext(ctx, `
+		INSERT INTO user_preferences (user_id, timezone, auto_start_tracking, weekly_report, default_time_filter, units, share_now_playing, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, CURRENT_TIMESTAMP)
+		ON CONFLICT (user_id) DO UPDATE SET
+			timezone = COALESCE(EXCLUDED.timezone, user_preferences.timezone),
+			auto_start_tracking = COALESCE(EXCLUDED.auto_start_tracking, user_preferences.auto_start_tracking),
+			weekly_report = COALESCE(EXCLUDED.weekly_report, user_preferences.weekly_report),
+			default_time_filter = COALESCE(EXCLUDED.default_time_filter, user_preferences.default_time_filter),
+			units = COALESCE(EXCLUDED.units, user_preferences.units),
+			share_now_playing = COALESCE(EXCLUDED.share_now_playing, user_preferences.share_now_playing),
+			updated_at = CURRENT_TIMESTAMP
+	`, userID, update.Timezone, update.AutoStartTracking, update.WeeklyReport, update.DefaultTimeFilter, update.Units, update.ShareNowPlaying)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update user preferences: %w", err)
+	}
+
+	return p.Get(ctx, userID)
+}
+
+func validatePreferences(update UserPreferencesUpdate) error {
+	if update.Timezone != nil {
+		if *update.Timezone == "" {
+			return fmt.Errorf("%w: timezone must not be empty", ErrInvalidPreference)
+		}
+		if _, err := time.LoadLocation(*update.Timezone); err != nil {
+			return fmt.Errorf("%w: unknown timezone %q", ErrInvalidPreference, *update.Timezone)
+		}
+	}
+	if update.DefaultTimeFilter != nil && !slices.Contains(preferenceTimeFilters, *update.DefaultTimeFilter) {
+		return fmt.Errorf("%w: default_time_filter must be one of %v", ErrInvalidPreference, preferenceTimeFilters)
+	}
+	if update.Units != nil && !slices.Contains(preferenceUnits, *update.Units) {
+		return fmt.Errorf("%w: units must be one of %v", ErrInvalidPreference, preferenceUnits)
+	}
+	return nil
+}
+
+// Preferências que mudam os padrões das requisições (?tz=, ?time_filter=, ?units=). Vazias quando o usuário
+// não definiu: aí vale o padrão de cada endpoint
+type RequestDefaults struct {
+	Timezone   string
+	TimeFilter string
+	Units      string
+}
+
+func (p *PreferencesService) RequestDefaults(ctx context.Context, userID string) (*RequestDefaults, error) {
+	if p.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	defaults := &RequestDefaults{}
+	err := p.db.QueryRowContext(ctx, `
+		SELECT COALESCE(timezone, ''), COALESCE(default_time_filter, ''), COALESCE(units, '')
+		FROM user_preferences WHERE user_id = $1
+	`, userID).Scan(&defaults.Timezone, &defaults.TimeFilter, &defaults.Units)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to query user preferences: %w", err)
+	}
+	return defaults, nil
+}
+
+// Se o tracking deve começar sozinho após o login; sem banco ou em caso de erro vale o padrão global
+func (p *PreferencesService) AutoStartTracking(ctx context.Context, userID string) bool {
+	preferences, err := p.Get(ctx, userID)
+	if err != nil {
+		return p.config.AutoStartTracking
+	}
+	return preferences.AutoStartTracking
+}
+
+// Dos userIDs pedidos, os que aceitaram mostrar o que estão ouvindo (share_now_playing), mais o próprio
+// requester, com o nome de exibição de cada um
+func (p *PreferencesService) NowPlayingSharers(ctx context.Context, requesterID string, userIDs []string) (map[string]string, error) {
+	if p.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	// Comparação como uuid para usar a chave primária; o handler já recusou IDs malformados
+	rows, err := p.db.QueryContext(ctx, `
+		SELECT u.id, COALESCE(u.display_name, '')
+		FROM users u
+		LEFT JOIN user_preferences up ON up.user_id = u.id
+		WHERE u.id = ANY($1::uuid[])
+			AND (u.id = $2 OR COALESCE(up.share_now_playing, FALSE))
+	`, pq.Array(userIDs), requesterID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query now playing consent: %w", err)
+	}
+	defer rows.Close()
+
+	sharers := make(map[string]string, len(userIDs))
+	for rows.Next() {
+		var userID, displayName string
+		if err := rows.Scan(&userID, &displayName); err != nil {
+			continue
+		}
+		sharers[userID] = displayName
+	}
+	return sharers, rows.Err()
+}
@@ -0,0 +1,19 @@
+package services
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidatePreferencesUnits(t *testing.T) {
+	for _, units := range []string{"ms", "minutes", "hours"} {
+		if err := validatePreferences(UserPreferencesUpdate{Units: &units}); err != nil {
+			t.Errorf("units %q rejected: %v", units, err)
+		}
+	}
+
+	invalid := "seconds"
+	if err := validatePreferences(UserPreferencesUpdate{Units: &invalid}); !errors.Is(err, ErrInvalidPreference) {
+		t.Errorf("units %q accepted (err %v)", invalid, err)
+	}
+}
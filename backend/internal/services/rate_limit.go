@@ -0,0 +1,71 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// Token bucket global das chamadas do tracker ao Spotify. Cada chamada reserva um token; sem token disponível
+// a reserva deixa o saldo negativo e a chamada espera o tempo de repor o que falta, então quem chega depois
+// espera mais e as chamadas saem espaçadas no ritmo configurado
+type tokenBucket struct {
+	mu       sync.Mutex
+	interval time.Duration // tempo para repor um token
+	burst    float64
+	tokens   float64
+	last     time.Time
+}
+
+// nil (sem limite) quando perMinute <= 0. O burst é de um segundo de chamadas, no mínimo uma
+func newTokenBucket(perMinute int) *tokenBucket {
+	if perMinute <= 0 {
+		return nil
+	}
+
+	burst := float64(max(perMinute/60, 1))
+	return &tokenBucket{
+		interval: time.Minute / time.Duration(perMinute),
+		burst:    burst,
+		tokens:   burst,
+		last:     time.Now(),
+	}
+}
+
+// Bloqueia até a chamada caber no limite ou até stop fechar; devolve true no segundo caso, e aí a chamada não
+// deve ser feita. O token reservado não é devolvido: quem para não volta a chamar
+func (b *tokenBucket) wait(stop <-chan struct{}) bool {
+	if b == nil {
+		return isStopped(stop)
+	}
+
+	b.mu.Lock()
+	now := time.Now()
+	b.tokens = min(b.tokens+float64(now.Sub(b.last))/float64(b.interval), b.burst)
+	b.last = now
+	b.tokens--
+	delay := time.Duration(-b.tokens * float64(b.interval))
+	b.mu.Unlock()
+
+	if delay <= 0 {
+		return isStopped(stop)
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return false
+	case <-stop:
+		return true
+	}
+}
+
+func isStopped(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,34 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucketWaitReturnsOnStop(t *testing.T) {
+	// Uma chamada por minuto: a segunda esperaria um minuto inteiro
+	b := newTokenBucket(1)
+	stop := make(chan struct{})
+	if b.wait(stop) {
+		t.Fatal("first call reported a stop")
+	}
+
+	stopped := make(chan bool, 1)
+	go func() { stopped <- b.wait(stop) }()
+	close(stop)
+
+	select {
+	case got := <-stopped:
+		if !got {
+			t.Error("wait did not report the stop")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("wait kept sleeping after the stop")
+	}
+
+	// Sem limite, uma parada já recebida também é informada
+	var unlimited *tokenBucket
+	if !unlimited.wait(stop) {
+		t.Error("nil bucket ignored the stop")
+	}
+}
@@ -0,0 +1,199 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Recalcula em lote os dados derivados de todos os usuários: listening_percentage das escutas cuja
+// duração passou a ser conhecida (ex.: após o enriquecimento), o score mainstream/diversidade gravados em
+// user_analytics e os agregados de /user/stats/summary. O cache de /user/analytics dos usuários é invalidado no caminho
+type StatsRecomputeJob struct {
+	analyticsService *AnalyticsService
+	mutex            sync.RWMutex
+	progress         RecomputeProgress
+}
+
+type RecomputeProgress struct {
+	Running        bool       `json:"running"`
+	StartedAt      *time.Time `json:"started_at,omitempty"`
+	FinishedAt     *time.Time `json:"finished_at,omitempty"`
+	TotalUsers     int        `json:"total_users"`
+	ProcessedUsers int        `json:"processed_users"`
+	FailedUsers    int        `json:"failed_users"`
+	RowsUpdated    int64      `json:"rows_updated"`
+	LastError      string     `json:"last_error,omitempty"`
+}
+
+var ErrRecomputeRunning = errors.New("stats recompute already running")
+
+// Time filters gravados em user_analytics.time_range
+var recomputeTimeFilters = []string{"6months", "1year", "alltime"}
+
+// O UPDATE de um usuário com muito histórico pode passar do DB_QUERY_TIMEOUT das consultas de analytics
+const recomputeUserTimeout = 5 * time.Minute
+
+func NewStatsRecomputeJob(analyticsService *AnalyticsService) *StatsRecomputeJob {
+	return &StatsRecomputeJob{analyticsService: analyticsService}
+}
+
+func (j *StatsRecomputeJob) Progress() RecomputeProgress {
+	j.mutex.RLock()
+	defer j.mutex.RUnlock()
+	return j.progress
+}
+
+// Inicia o recálculo em background; só uma execução por vez
+func (j *StatsRecomputeJob) Start() error {
+	if j.analyticsService.db == nil {
+		return fmt.Errorf("database not available")
+	}
+
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if j.progress.Running {
+		return ErrRecomputeRunning
+	}
+
+	now := time.Now()
+	j.progress = RecomputeProgress{Running: true, StartedAt: &now}
+	go j.run()
+	return nil
+}
+
+func (j *StatsRecomputeJob) run() {
+	userIDs, err := j.listUsers()
+	if err != nil {
+		log.Printf("Stats recompute failed to list users: %v", err)
+		j.finish(err)
+		return
+	}
+
+	j.mutex.Lock()
+	j.progress.TotalUsers = len(userIDs)
+	j.mutex.Unlock()
+
+	log.Printf("Recomputing derived stats for %d users", len(userIDs))
+
+	for _, userID := range userIDs {
+		rows, err := j.recomputeUser(userID)
+
+		j.mutex.Lock()
+		j.progress.ProcessedUsers++
+		j.progress.RowsUpdated += rows
+		if err != nil {
+			j.progress.FailedUsers++
+			j.progress.LastError = err.Error()
+		}
+		j.mutex.Unlock()
+
+		if err != nil {
+			log.Printf("Error recomputing stats for user %s: %v", userID, err)
+		}
+	}
+
+	j.finish(nil)
+
+	progress := j.Progress()
+	log.Printf("Stats recompute finished: %d/%d users, %d failed, %d history rows updated",
+		progress.ProcessedUsers, progress.TotalUsers, progress.FailedUsers, progress.RowsUpdated)
+}
+
+func (j *StatsRecomputeJob) finish(err error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	now := time.Now()
+	j.progress.Running = false
+	j.progress.FinishedAt = &now
+	if err != nil {
+		j.progress.LastError = err.Error()
+	}
+}
+
+func (j *StatsRecomputeJob) listUsers() ([]string, error) {
+	rows, err := j.analyticsService.db.Query(`SELECT id FROM users ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			continue
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, rows.Err()
+}
+
+func (j *StatsRecomputeJob) recomputeUser(userID string) (int64, error) {
+	a := j.analyticsService
+	ctx, cancel := context.WithTimeout(context.Background(), recomputeUserTimeout)
+	defer cancel()
+
+	// Mesma regra do tracking ao vivo: tempo escutado / duração da faixa, limitado a 100%
+	result, err := a.db.ExecContext(ctx, `
+		UPDATE listening_history lh
+		SET listening_percentage = LEAST(100, ROUND(lh.listened_duration_ms * 100.0 / t.duration_ms, 2))
+		FROM tracks t
+		WHERE lh.track_id = t.id
+			AND lh.user_id = $1
+			AND t.duration_ms > 0
+			AND lh.listened_duration_ms > 0
+			AND lh.listening_percentage IS DISTINCT FROM LEAST(100, ROUND(lh.listened_duration_ms * 100.0 / t.duration_ms, 2))
+	`, userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update listening percentage: %w", err)
+	}
+	rowsUpdated, _ := result.RowsAffected()
+
+	for _, timeFilter := range recomputeTimeFilters {
+		mainstream, err := a.GetMainstreamScore(ctx, userID, timeFilter)
+		if err != nil {
+			return rowsUpdated, err
+		}
+
+		diversity, err := a.calculateDiversityScoreFromDB(ctx, userID, timeFilter)
+		if err != nil {
+			return rowsUpdated, err
+		}
+
+		totalTime, err := a.calculateTotalListeningTimeFromDB(ctx, userID, timeFilter)
+		if err != nil {
+			return rowsUpdated, err
+		}
+
+		_, err = a.db.ExecContext(ctx, `
+			INSERT INTO user_analytics (user_id, time_range, total_listening_time_ms, diversity_score, mainstream_score, computed_at)
+			VALUES ($1, $2, $3, $4, $5, NOW())
+			ON CONFLICT (user_id, time_range) DO UPDATE SET
+				total_listening_time_ms = EXCLUDED.total_listening_time_ms,
+				diversity_score = EXCLUDED.diversity_score,
+				mainstream_score = EXCLUDED.mainstream_score,
+				computed_at = NOW()
+		`, userID, timeFilter, totalTime, diversity, mainstream.Score)
+		if err != nil {
+			return rowsUpdated, fmt.Errorf("failed to save derived stats: %w", err)
+		}
+	}
+
+	// Corrige o que os incrementos de /user/stats/summary deixaram escapar
+	if err := RebuildListeningAggregates(ctx, a.db, userID); err != nil {
+		return rowsUpdated, err
+	}
+
+	// Analytics em cache foram calculados com os números antigos
+	if err := invalidateAnalyticsCache(ctx, a.db, userID); err != nil {
+		return rowsUpdated, err
+	}
+
+	return rowsUpdated, nil
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type ListeningRecord struct {
+	Date       string  `json:"date"`
+	Value      float64 `json:"value"`
+	Unit       string  `json:"unit"`
+	TrackID    string  `json:"track_id,omitempty"`
+	TrackName  string  `json:"track_name,omitempty"`
+	ArtistID   string  `json:"artist_id,omitempty"`
+	ArtistName string  `json:"artist_name,omitempty"`
+}
+
+type ListeningRecords struct {
+	MostMinutesDay       *ListeningRecord `json:"most_minutes_day"`
+	MostUniqueTracksDay  *ListeningRecord `json:"most_unique_tracks_day"`
+	LongestPlay          *ListeningRecord `json:"longest_play"`
+	MostReplayedTrackDay *ListeningRecord `json:"most_replayed_track_day"`
+	HardestArtistBinge   *ListeningRecord `json:"hardest_artist_binge"`
+}
+
+func (a *AnalyticsService) GetListeningRecords(ctx context.Context, userID string, timeFilter string, loc *time.Location) (*ListeningRecords, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	startDate := timeFilterStartDate(timeFilter)
+	day := fmt.Sprintf("TO_CHAR(%s, 'YYYY-MM-DD')", localPlayedAt(3))
+	records := &ListeningRecords{}
+	var err error
+
+	// Cada recorde é uma consulta pequena que retorna (dia, valor, id, nome)
+	records.MostMinutesDay, err = a.queryListeningRecord(ctx, fmt.Sprintf(`
+		SELECT %s as day, SUM(lh.listened_duration_ms) / 60000.0 as minutes, '' as id, '' as name
+		FROM listening_history lh
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2
+		GROUP BY day
+		ORDER BY minutes DESC, day DESC
+		LIMIT 1`, day), "minutes", "", userID, startDate, loc.String())
+	if err != nil {
+		return nil, err
+	}
+
+	records.MostUniqueTracksDay, err = a.queryListeningRecord(ctx, fmt.Sprintf(`
+		SELECT %s as day, COUNT(DISTINCT lh.track_id) as unique_tracks, '' as id, '' as name
+		FROM listening_history lh
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2
+		GROUP BY day
+		ORDER BY unique_tracks DESC, day DESC
+		LIMIT 1`, day), "tracks", "", userID, startDate, loc.String())
+	if err != nil {
+		return nil, err
+	}
+
+	records.LongestPlay, err = a.queryListeningRecord(ctx, fmt.Sprintf(`
+		SELECT %s as day, lh.listened_duration_ms / 60000.0 as minutes, t.id, t.name
+		FROM listening_history lh
+		JOIN tracks t ON lh.track_id = t.id
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2
+		ORDER BY lh.listened_duration_ms DESC, lh.played_at DESC
+		LIMIT 1`, day), "minutes", "track", userID, startDate, loc.String())
+	if err != nil {
+		return nil, err
+	}
+
+	records.MostReplayedTrackDay, err = a.queryListeningRecord(ctx, fmt.Sprintf(`
+		SELECT %s as day, COUNT(*) as plays, t.id, t.name
+		FROM listening_history lh
+		JOIN tracks t ON lh.track_id = t.id
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2
+		GROUP BY day, t.id, t.name
+		ORDER BY plays DESC, day DESC
+		LIMIT 1`, day), "plays", "track", userID, startDate, loc.String())
+	if err != nil {
+		return nil, err
+	}
+
+	records.HardestArtistBinge, err = a.queryListeningRecord(ctx, fmt.Sprintf(`
+		SELECT %s as day, COUNT(*) as plays, ar.id, ar.name
+		FROM listening_history lh
+		JOIN track_artists ta ON ta.track_id = lh.track_id
+		JOIN artists ar ON ar.id = ta.artist_id
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2
+		GROUP BY day, ar.id, ar.name
+		ORDER BY plays DESC, day DESC
+		LIMIT 1`, day), "plays", "artist", userID, startDate, loc.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+func (a *AnalyticsService) queryListeningRecord(ctx context.Context, query, unit, kind string, args ...interface{}) (*ListeningRecord, error) {
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	record := &ListeningRecord{Unit: unit}
+	var id, name string
+
+	err := a.db.QueryRowContext(ctx, query, args...).Scan(&record.Date, &record.Value, &id, &name)
+	if err == sql.ErrNoRows {
+		return nil, nil // Sem dados para este recorde
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query listening record: %w", err)
+	}
+
+	switch kind {
+	case "track":
+		record.TrackID, record.TrackName = id, name
+	case "artist":
+		record.ArtistID, record.ArtistName = id, name
+	}
+
+	return record, nil
+}
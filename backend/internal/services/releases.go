@@ -0,0 +1,191 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+)
+
+type EraStats struct {
+	Decade     int     `json:"decade"`
+	Label      string  `json:"label"`
+	PlayCount  int     `json:"play_count"`
+	Minutes    float64 `json:"minutes"`
+	Percentage float64 `json:"percentage"`
+}
+
+type EraBreakdown struct {
+	Eras         []EraStats `json:"eras"`
+	UnknownPlays int        `json:"unknown_release_date_plays"`
+}
+
+var releaseDatePattern = regexp.MustCompile(`^\d{4}(-\d{2}(-\d{2})?)?$`)
+
+// Spotify informa release_date com precisão de ano ("1986"), mês ("1986-05") ou dia.
+// Completa as partes ausentes com 01 para caber numa coluna DATE; datas inválidas viram NULL
+func normalizeReleaseDate(releaseDate string) interface{} {
+	if !releaseDatePattern.MatchString(releaseDate) {
+		return nil
+	}
+
+	switch len(releaseDate) {
+	case 4:
+		return releaseDate + "-01-01"
+	case 7:
+		return releaseDate + "-01"
+	default:
+		return releaseDate
+	}
+}
+
+func (a *AnalyticsService) GetMusicEras(ctx context.Context, userID string, timeFilter string) (*EraBreakdown, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	startDate := timeFilterStartDate(timeFilter)
+
+	query := `
+		SELECT
+			(EXTRACT(YEAR FROM al.release_date)::int / 10) * 10 as decade,
+			COUNT(*) as play_count,
+			COALESCE(SUM(lh.listened_duration_ms), 0) as duration_ms
+		FROM listening_history lh
+		JOIN tracks t ON lh.track_id = t.id
+		JOIN albums al ON t.album_id = al.id
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2 AND al.release_date IS NOT NULL
+		GROUP BY decade
+		ORDER BY decade`
+
+	rows, err := a.db.QueryContext(ctx, query, userID, startDate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query music eras: %w", err)
+	}
+	defer rows.Close()
+
+	breakdown := &EraBreakdown{Eras: make([]EraStats, 0)}
+	totalPlays := 0
+
+	for rows.Next() {
+		var era EraStats
+		var durationMs int64
+		if err := rows.Scan(&era.Decade, &era.PlayCount, &durationMs); err != nil {
+			continue
+		}
+		era.Label = fmt.Sprintf("%ds", era.Decade)
+		era.Minutes = float64(durationMs) / 60000
+		totalPlays += era.PlayCount
+		breakdown.Eras = append(breakdown.Eras, era)
+	}
+
+	for i := range breakdown.Eras {
+		if totalPlays > 0 {
+			breakdown.Eras[i].Percentage = float64(breakdown.Eras[i].PlayCount) / float64(totalPlays) * 100
+		}
+	}
+
+	// Escutas sem data de lançamento conhecida (álbuns importados ou sem álbum)
+	err = a.db.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM listening_history lh
+		JOIN tracks t ON lh.track_id = t.id
+		LEFT JOIN albums al ON t.album_id = al.id
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2 AND al.release_date IS NULL
+	`, userID, startDate).Scan(&breakdown.UnknownPlays)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count plays without release date: %w", err)
+	}
+
+	return breakdown, nil
+}
+
+type ReleaseYearStats struct {
+	Year       int     `json:"year"`
+	PlayCount  int     `json:"play_count"`
+	Minutes    float64 `json:"minutes"`
+	Percentage float64 `json:"percentage"`
+}
+
+type ReleaseYearTimeline struct {
+	Years        []ReleaseYearStats `json:"years"`
+	UnknownPlays int                `json:"unknown_release_date_plays"`
+}
+
+// Escutas por ano de lançamento do álbum, do menor ao maior ano presente, com os anos intermediários zerados.
+// Datas só com ano já são gravadas como AAAA-01-01 (normalizeReleaseDate); álbuns sem data ficam de fora
+func (a *AnalyticsService) GetReleaseYears(ctx context.Context, userID string, timeFilter string) (*ReleaseYearTimeline, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	startDate := timeFilterStartDate(timeFilter)
+
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT
+			EXTRACT(YEAR FROM al.release_date)::int as release_year,
+			COUNT(*) as play_count,
+			COALESCE(SUM(lh.listened_duration_ms), 0) as duration_ms
+		FROM listening_history lh
+		JOIN tracks t ON lh.track_id = t.id
+		JOIN albums al ON t.album_id = al.id
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2 AND al.release_date IS NOT NULL
+		GROUP BY release_year
+		ORDER BY release_year`, userID, startDate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query release years: %w", err)
+	}
+	defer rows.Close()
+
+	yearStats := make(map[int]ReleaseYearStats)
+	minYear, maxYear := 0, 0
+	totalPlays := 0
+
+	for rows.Next() {
+		var stats ReleaseYearStats
+		var durationMs int64
+		if err := rows.Scan(&stats.Year, &stats.PlayCount, &durationMs); err != nil {
+			continue
+		}
+		stats.Minutes = float64(durationMs) / 60000
+		yearStats[stats.Year] = stats
+		totalPlays += stats.PlayCount
+
+		if minYear == 0 || stats.Year < minYear {
+			minYear = stats.Year
+		}
+		if stats.Year > maxYear {
+			maxYear = stats.Year
+		}
+	}
+
+	timeline := &ReleaseYearTimeline{Years: make([]ReleaseYearStats, 0)}
+	if totalPlays > 0 {
+		for year := minYear; year <= maxYear; year++ {
+			stats, exists := yearStats[year]
+			if !exists {
+				stats = ReleaseYearStats{Year: year}
+			}
+			stats.Percentage = float64(stats.PlayCount) / float64(totalPlays) * 100
+			timeline.Years = append(timeline.Years, stats)
+		}
+	}
+
+	err = a.db.QueryRowContext(ctx, `
+		SELECT COUNT(*)
+		FROM listening_history lh
+		JOIN tracks t ON lh.track_id = t.id
+		LEFT JOIN albums al ON t.album_id = al.id
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2 AND al.release_date IS NULL
+	`, userID, startDate).Scan(&timeline.UnknownPlays)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count plays without release date: %w", err)
+	}
+
+	return timeline, nil
+}
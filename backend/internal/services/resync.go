@@ -0,0 +1,88 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+var ErrResyncFetchFailed = errors.New("failed to fetch recently played")
+
+type ResyncResult struct {
+	Removed int64 `json:"removed"` // escutas gravadas pelo tracking que foram apagadas
+	Added   int   `json:"added"`   // escutas regravadas a partir do recently-played
+	Fetched int   `json:"fetched"` // escutas disponíveis no recently-played
+}
+
+// Apaga as escutas gravadas pelo tracking da conta principal (imports, escutas manuais e escutas apagadas pelo
+// usuário ficam) e regrava a janela do recently-played. O recently-played é buscado antes, e a remoção e a
+// regravação vão na mesma transação: se o Spotify ou o banco falharem, nada é removido
+func (s *TrackingService) ResyncFull(ctx context.Context, userID, spotifyToken string) (*ResyncResult, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	window, err := s.fetchRecentlyPlayedWindow(userID, spotifyToken, s.syncMaxTracks())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrResyncFetchFailed, err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Trava o histórico do usuário antes de apagar: o tracker não grava no meio da troca
+	if err := lockUserHistory(ctx, tx, userID); err != nil {
+		return nil, err
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		DELETE FROM listening_history
+		WHERE user_id = $1 AND source = 'tracking' AND spotify_account IS NULL AND deleted_at IS NULL
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to purge tracked history: %w", err)
+	}
+
+	resync := &ResyncResult{Fetched: len(window)}
+	resync.Removed, _ = result.RowsAffected()
+
+	// Mais antigas primeiro, como no sync periódico
+	for i := len(window) - 1; i >= 0; i-- {
+		item := window[i]
+		if item.Track == nil {
+			continue
+		}
+		playedAt, err := time.Parse(time.RFC3339, item.PlayedAt)
+		if err != nil {
+			continue
+		}
+
+		inserted, err := s.insertRecentlyPlayedTrack(ctx, tx, userID, "", spotifyToken, item.Track, playedAt)
+		if err != nil {
+			return nil, err
+		}
+		if inserted {
+			resync.Added++
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit resync: %w", err)
+	}
+
+	// Os agregados receberam só os incrementos das escutas regravadas, sem descontar as apagadas
+	if err := RebuildListeningAggregates(ctx, s.db, userID); err != nil {
+		log.Printf("Error rebuilding listening aggregates after resync for user %s: %v", userID, err)
+	}
+	if err := invalidateAnalyticsCache(ctx, s.db, userID); err != nil {
+		log.Printf("Error invalidating analytics cache after resync for user %s: %v", userID, err)
+	}
+
+	log.Printf("Full resync for user %s: %d tracked plays removed, %d re-added", userID, resync.Removed, resync.Added)
+	return resync, nil
+}
@@ -0,0 +1,53 @@
+package services
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"musike-backend/internal/config"
+)
+
+func TestResyncFullKeepsDeletedPlays(t *testing.T) {
+	db := openTestDB(t)
+	userID := createTestUser(t, db)
+
+	fake := newFakeRecentlyPlayed(10, time.Now().Add(-time.Hour))
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	s := NewTrackingService(&config.Config{
+		SpotifyAPIBaseURL: server.URL,
+		SyncMaxTracks:     50,
+		SessionSaveMode:   "fixed",
+	}, db)
+	s.syncUserRecentlyPlayed(&UserTracking{UserID: userID, SpotifyToken: "token", IsActive: true})
+
+	// Uma escuta apagada pelo usuário não pode voltar com o resync
+	var deletedID string
+	err := db.QueryRow(`
+		UPDATE listening_history SET deleted_at = NOW()
+		WHERE id = (SELECT id FROM listening_history WHERE user_id = $1 ORDER BY played_at LIMIT 1)
+		RETURNING id
+	`, userID).Scan(&deletedID)
+	if err != nil {
+		t.Fatalf("failed to delete a play: %v", err)
+	}
+
+	result, err := s.ResyncFull(context.Background(), userID, "token")
+	if err != nil {
+		t.Fatalf("ResyncFull: %v", err)
+	}
+	if result.Removed != 9 || result.Added != 9 || result.Fetched != 10 {
+		t.Errorf("resync = %+v, want 9 removed, 9 added, 10 fetched", result)
+	}
+
+	if got := countHistory(t, db, userID); got != 10 {
+		t.Errorf("history has %d rows after resync, want 10", got)
+	}
+	var stillDeleted bool
+	if err := db.QueryRow(`SELECT deleted_at IS NOT NULL FROM listening_history WHERE id = $1`, deletedID).Scan(&stillDeleted); err != nil || !stillDeleted {
+		t.Errorf("deleted play %s came back after resync (err %v)", deletedID, err)
+	}
+}
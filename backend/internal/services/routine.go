@@ -0,0 +1,205 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type WeekdayRoutine struct {
+	Weekday   int    `json:"weekday"` // 0 = domingo, como EXTRACT(DOW)
+	Name      string `json:"name"`
+	HasData   bool   `json:"has_data"`
+	StartHour int    `json:"start_hour"`
+	EndHour   int    `json:"end_hour"` // exclusivo
+	PlayCount int    `json:"play_count"`
+	Summary   string `json:"summary"`
+}
+
+type ListeningRoutine struct {
+	Weekdays       []WeekdayRoutine `json:"weekdays"`
+	PlayCounts     [7][24]int       `json:"play_counts"`     // [weekday][hora]
+	AverageMinutes [7][24]float64   `json:"average_minutes"` // média por dia ativo daquele weekday
+	ActiveDays     [7]int           `json:"active_days"`
+}
+
+// source vazio considera todas as origens
+func (a *AnalyticsService) GetListeningRoutine(ctx context.Context, userID string, timeFilter string, loc *time.Location, source string) (*ListeningRoutine, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	startDate := timeFilterStartDate(timeFilter)
+	local := localPlayedAt(3)
+	routine := &ListeningRoutine{}
+
+	rows, err := a.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT
+			EXTRACT(DOW FROM %[1]s)::int as weekday,
+			EXTRACT(HOUR FROM %[1]s)::int as hour,
+			COUNT(*) as play_count,
+			COALESCE(SUM(lh.listened_duration_ms), 0) as duration_ms
+		FROM listening_history lh
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2 AND %[2]s
+		GROUP BY weekday, hour`, local, sourceCondition(4)), userID, startDate, loc.String(), source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query listening routine: %w", err)
+	}
+	defer rows.Close()
+
+	var minutes [7][24]float64
+	for rows.Next() {
+		var weekday, hour, count int
+		var durationMs int64
+		if err := rows.Scan(&weekday, &hour, &count, &durationMs); err != nil {
+			continue
+		}
+		if weekday < 0 || weekday > 6 || hour < 0 || hour > 23 {
+			continue
+		}
+		routine.PlayCounts[weekday][hour] = count
+		minutes[weekday][hour] = float64(durationMs) / 60000
+	}
+
+	dayRows, err := a.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT EXTRACT(DOW FROM %[1]s)::int as weekday, COUNT(DISTINCT DATE(%[1]s)) as active_days
+		FROM listening_history lh
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2 AND %[2]s
+		GROUP BY weekday`, local, sourceCondition(4)), userID, startDate, loc.String(), source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query active days: %w", err)
+	}
+	defer dayRows.Close()
+
+	for dayRows.Next() {
+		var weekday, activeDays int
+		if err := dayRows.Scan(&weekday, &activeDays); err != nil || weekday < 0 || weekday > 6 {
+			continue
+		}
+		routine.ActiveDays[weekday] = activeDays
+	}
+
+	for weekday := 0; weekday < 7; weekday++ {
+		if routine.ActiveDays[weekday] > 0 {
+			for hour := 0; hour < 24; hour++ {
+				routine.AverageMinutes[weekday][hour] = minutes[weekday][hour] / float64(routine.ActiveDays[weekday])
+			}
+		}
+		routine.Weekdays = append(routine.Weekdays, weekdayRoutine(weekday, routine.PlayCounts[weekday]))
+	}
+
+	return routine, nil
+}
+
+// Janela contínua em torno da hora de pico, incluindo as horas vizinhas com pelo menos 70% do pico
+func weekdayRoutine(weekday int, hourCounts [24]int) WeekdayRoutine {
+	name := time.Weekday(weekday).String()
+	routine := WeekdayRoutine{Weekday: weekday, Name: name}
+
+	peakHour := 0
+	for hour, count := range hourCounts {
+		if count > hourCounts[peakHour] {
+			peakHour = hour
+		}
+	}
+
+	if hourCounts[peakHour] == 0 {
+		routine.Summary = fmt.Sprintf("No listening recorded on %ss", name)
+		return routine
+	}
+
+	threshold := int(float64(hourCounts[peakHour]) * 0.7)
+	start, end := peakHour, peakHour+1
+	for start > 0 && hourCounts[start-1] > 0 && hourCounts[start-1] >= threshold {
+		start--
+	}
+	for end < 24 && hourCounts[end] > 0 && hourCounts[end] >= threshold {
+		end++
+	}
+
+	routine.HasData = true
+	routine.StartHour = start
+	routine.EndHour = end
+	for hour := start; hour < end; hour++ {
+		routine.PlayCount += hourCounts[hour]
+	}
+	routine.Summary = fmt.Sprintf("You listen most on %ss between %02d:00 and %02d:00", name, start, end%24)
+
+	return routine
+}
+
+type WeekdayPeak struct {
+	Weekday   int     `json:"weekday"` // 0 = domingo, como EXTRACT(DOW)
+	Name      string  `json:"name"`
+	HasData   bool    `json:"has_data"`
+	Hour      *int    `json:"hour"` // nulo em dias sem escuta
+	PlayCount int     `json:"play_count"`
+	Minutes   float64 `json:"minutes"`
+	Tied      bool    `json:"tied"` // outra hora teve as mesmas escutas; vence a mais cedo
+	Summary   string  `json:"summary"`
+}
+
+// Hora com mais escutas em cada dia da semana (fuso do usuário), o recorte do pico de /user/routine
+func (a *AnalyticsService) GetPeakByWeekday(ctx context.Context, userID string, timeFilter string, loc *time.Location, source string) ([]WeekdayPeak, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	rows, err := a.db.QueryContext(ctx, fmt.Sprintf(`
+		WITH hours AS (
+			SELECT
+				EXTRACT(DOW FROM %[1]s)::int as weekday,
+				EXTRACT(HOUR FROM %[1]s)::int as hour,
+				COUNT(*) as play_count,
+				COALESCE(SUM(lh.listened_duration_ms), 0) as duration_ms
+			FROM listening_history lh
+			WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2 AND %[2]s
+			GROUP BY weekday, hour
+		)
+		SELECT DISTINCT ON (weekday)
+			weekday,
+			hour,
+			play_count,
+			duration_ms,
+			COUNT(*) OVER (PARTITION BY weekday, play_count) > 1 as tied
+		FROM hours
+		ORDER BY weekday, play_count DESC, hour`, localPlayedAt(3), sourceCondition(4)), userID, timeFilterStartDate(timeFilter), loc.String(), source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query peak hour by weekday: %w", err)
+	}
+	defer rows.Close()
+
+	peaks := make([]WeekdayPeak, 7)
+	for weekday := range peaks {
+		name := time.Weekday(weekday).String()
+		peaks[weekday] = WeekdayPeak{
+			Weekday: weekday,
+			Name:    name,
+			Summary: fmt.Sprintf("No listening recorded on %ss", name),
+		}
+	}
+
+	for rows.Next() {
+		var weekday, hour, count int
+		var durationMs int64
+		var tied bool
+		if err := rows.Scan(&weekday, &hour, &count, &durationMs, &tied); err != nil || weekday < 0 || weekday > 6 {
+			continue
+		}
+		peak := &peaks[weekday]
+		peak.HasData = true
+		peak.Hour = &hour
+		peak.PlayCount = count
+		peak.Minutes = roundMinutes(float64(durationMs) / 60000)
+		peak.Tied = tied
+		peak.Summary = fmt.Sprintf("%ss you peak at %02d:00", peak.Name, hour)
+	}
+
+	return peaks, rows.Err()
+}
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/oauth2"
+)
+
+type SearchResult struct {
+	Type       string   `json:"type"` // track, artist, album
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Artists    []string `json:"artists,omitempty"`
+	Album      string   `json:"album,omitempty"`
+	DurationMs int      `json:"duration_ms,omitempty"`
+	ImageURL   string   `json:"image_url,omitempty"`
+	Popularity int      `json:"popularity"`
+	Confidence float64  `json:"confidence"` // 0-1, quanto o resultado corresponde à busca
+}
+
+// Confiança mínima para o importador aceitar automaticamente uma faixa
+const minTrackMatchConfidence = 0.6
+
+func IsValidSearchType(searchType string) bool {
+	return searchType == "track" || searchType == "artist" || searchType == "album"
+}
+
+// Busca no Spotify e devolve os candidatos ordenados pela confiança da correspondência
+func (s *SpotifyService) Search(token *oauth2.Token, userID, query, searchType string, limit int) ([]SearchResult, error) {
+	if !IsValidSearchType(searchType) {
+		return nil, fmt.Errorf("invalid search type: %s", searchType)
+	}
+
+	params := url.Values{}
+	params.Set("q", query)
+	params.Set("type", searchType)
+	params.Set("limit", strconv.Itoa(limit))
+
+	apiURL := s.config.SpotifyAPIBaseURL + "/v1/search?" + params.Encode()
+
+	var response struct {
+		Tracks struct {
+			Items []SpotifyTrack `json:"items"`
+		} `json:"tracks"`
+		Artists struct {
+			Items []SpotifyArtist `json:"items"`
+		} `json:"artists"`
+		Albums struct {
+			Items []struct {
+				ID      string          `json:"id"`
+				Name    string          `json:"name"`
+				Artists []SpotifyArtist `json:"artists"`
+				Images  []struct {
+					URL string `json:"url"`
+				} `json:"images"`
+			} `json:"items"`
+		} `json:"albums"`
+	}
+	if err := s.getUserScoped(context.Background(), apiURL, userID, token, s.config.SpotifyCacheTTL, &response); err != nil {
+		return nil, err
+	}
+
+	results := make([]SearchResult, 0)
+	for _, track := range response.Tracks.Items {
+		result := SearchResult{
+			Type:       "track",
+			ID:         track.ID,
+			Name:       track.Name,
+			Artists:    getArtistNames(track.Artists),
+			Album:      track.Album.Name,
+			DurationMs: track.Duration,
+			Popularity: track.Popularity,
+		}
+		if len(track.Album.Images) > 0 {
+			result.ImageURL = track.Album.Images[0].URL
+		}
+		results = append(results, result)
+	}
+	for _, artist := range response.Artists.Items {
+		result := SearchResult{Type: "artist", ID: artist.ID, Name: artist.Name, Popularity: artist.Popularity}
+		if len(artist.Images) > 0 {
+			result.ImageURL = artist.Images[0].URL
+		}
+		results = append(results, result)
+	}
+	for _, album := range response.Albums.Items {
+		result := SearchResult{Type: "album", ID: album.ID, Name: album.Name, Artists: getArtistNames(album.Artists)}
+		if len(album.Images) > 0 {
+			result.ImageURL = album.Images[0].URL
+		}
+		results = append(results, result)
+	}
+
+	queryTokens := searchTokens(query)
+	for i := range results {
+		results[i].Confidence = matchConfidence(queryTokens, results[i])
+	}
+
+	// Empate na confiança: o mais popular primeiro
+	sort.SliceStable(results, func(a, b int) bool {
+		if results[a].Confidence != results[b].Confidence {
+			return results[a].Confidence > results[b].Confidence
+		}
+		return results[a].Popularity > results[b].Popularity
+	})
+
+	return results, nil
+}
+
+// Faixa mais provável para artista + nome; nil quando nenhum candidato é confiável o bastante
+func (s *SpotifyService) SearchTrack(token *oauth2.Token, userID, artist, track string) (*SearchResult, error) {
+	results, err := s.Search(token, userID, fmt.Sprintf("track:%s artist:%s", track, artist), "track", 5)
+	if err != nil {
+		return nil, err
+	}
+
+	return BestTrackMatch(results), nil
+}
+
+// Melhor candidato da busca quando a confiança é suficiente para aceitá-lo automaticamente
+func BestTrackMatch(results []SearchResult) *SearchResult {
+	if len(results) == 0 || results[0].Confidence < minTrackMatchConfidence {
+		return nil
+	}
+	return &results[0]
+}
+
+// Palavras normalizadas da busca, sem os prefixos de campo do Spotify (track:, artist:, ...)
+func searchTokens(text string) []string {
+	var tokens []string
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		if idx := strings.Index(word, ":"); idx >= 0 {
+			word = word[idx+1:]
+		}
+		word = strings.TrimFunc(word, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+		})
+		if word != "" {
+			tokens = append(tokens, word)
+		}
+	}
+	return tokens
+}
+
+// Média ponderada entre quanto da busca aparece no resultado (peso maior) e
+// quanto do nome do resultado aparece na busca (penaliza versões "ao vivo", "remix", etc.)
+func matchConfidence(queryTokens []string, result SearchResult) float64 {
+	if len(queryTokens) == 0 {
+		return 0
+	}
+
+	candidate := make(map[string]bool)
+	for _, token := range searchTokens(result.Name + " " + strings.Join(result.Artists, " ")) {
+		candidate[token] = true
+	}
+
+	query := make(map[string]bool)
+	found := 0
+	for _, token := range queryTokens {
+		query[token] = true
+		if candidate[token] {
+			found++
+		}
+	}
+	recall := float64(found) / float64(len(queryTokens))
+
+	nameTokens := searchTokens(result.Name)
+	precision := 1.0
+	if len(nameTokens) > 0 {
+		matched := 0
+		for _, token := range nameTokens {
+			if query[token] {
+				matched++
+			}
+		}
+		precision = float64(matched) / float64(len(nameTokens))
+	}
+
+	return 0.7*recall + 0.3*precision
+}
@@ -0,0 +1,376 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+)
+
+// Escutas separadas por mais que isso ficam em sessões diferentes
+const listeningSessionGap = 30 * time.Minute
+
+const maxSessionTagLength = 32
+
+var sessionTagPattern = regexp.MustCompile(`^[\p{L}\p{N} _-]+$`)
+
+var (
+	ErrSessionNotFound = errors.New("listening session not found")
+	ErrInvalidTag      = errors.New("invalid tag")
+)
+
+// O ID da sessão é o ID da primeira escuta dela
+type ListeningSession struct {
+	ID        string    `json:"id"`
+	StartedAt time.Time `json:"started_at"`
+	EndedAt   time.Time `json:"ended_at"` // início da última escuta
+	Plays     int       `json:"plays"`
+	Minutes   float64   `json:"minutes"`
+	Tags      []string  `json:"tags"`
+}
+
+type TaggedItem struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Artists []string `json:"artists,omitempty"`
+	Plays   int      `json:"plays"`
+}
+
+type TaggedAnalytics struct {
+	Tag      string `json:"tag"`
+	Sessions int    `json:"sessions"`
+	FilteredAnalytics
+}
+
+// Versão reduzida dos analytics, calculada só do banco sobre um subconjunto das escutas
+type FilteredAnalytics struct {
+	TotalPlays             int          `json:"total_plays"`
+	TotalListeningTime     int64        `json:"total_listening_time_ms"`
+	AverageTrackPopularity float64      `json:"average_track_popularity"`
+	TopTracks              []TaggedItem `json:"top_tracks"`
+	TopArtists             []TaggedItem `json:"top_artists"`
+	TopGenres              []GenreStats `json:"top_genres"`
+}
+
+// Tags são gravadas em minúsculas e sem espaços sobrando, para "Workout" e " workout" serem a mesma
+func NormalizeSessionTag(tag string) (string, error) {
+	tag = strings.ToLower(strings.Join(strings.Fields(tag), " "))
+	if tag == "" || utf8.RuneCountInString(tag) > maxSessionTagLength || !sessionTagPattern.MatchString(tag) {
+		return "", ErrInvalidTag
+	}
+	return tag, nil
+}
+
+// Escutas do usuário ($1) numeradas por sessão (session_no): uma sessão nova começa quando a escuta anterior
+// ficou mais de gap segundos para trás. start é o início do período, mas as escutas começam na abertura da
+// sessão em andamento nesse instante: a sessão que atravessa o início do período mantém o mesmo início (e ID)
+// de quando é calculada sem corte. Quem usa descarta as sessões que terminaram antes de start. start e gap são
+// expressões SQL (parâmetros posicionais). Define os CTEs plays e numbered
+func sessionPlaysCTE(start, gap string) string {
+	return fmt.Sprintf(`session_window AS (
+			SELECT COALESCE((
+				SELECT opening.played_at FROM listening_history opening
+				WHERE opening.user_id = $1 AND opening.deleted_at IS NULL AND opening.played_at < %[1]s
+					AND NOT EXISTS (
+						SELECT 1 FROM listening_history prev
+						WHERE prev.user_id = $1 AND prev.deleted_at IS NULL
+							AND prev.played_at < opening.played_at
+							AND prev.played_at >= opening.played_at - %[2]s * INTERVAL '1 second'
+					)
+				ORDER BY opening.played_at DESC
+				LIMIT 1
+			), %[1]s) as started_at
+		),
+		plays AS (
+			SELECT
+				lh.id,
+				lh.track_id,
+				lh.played_at,
+				COALESCE(lh.listened_duration_ms, 0) as listened_duration_ms,
+				LAG(lh.played_at) OVER (ORDER BY lh.played_at, lh.id) as prev_played_at
+			FROM listening_history lh, session_window w
+			WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= w.started_at
+		),
+		numbered AS (
+			SELECT *,
+				SUM(CASE WHEN prev_played_at IS NULL OR played_at - prev_played_at > %[2]s * INTERVAL '1 second' THEN 1 ELSE 0 END)
+					OVER (ORDER BY played_at, id) as session_no
+			FROM plays
+		)`, start, gap)
+}
+
+// Sessões do usuário (escutas com no máximo listeningSessionGap entre uma e outra), das mais recentes para as mais antigas
+func (a *AnalyticsService) GetListeningSessions(ctx context.Context, userID string, timeFilter string, limit int) ([]ListeningSession, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	startDate := timeFilterStartDate(timeFilter)
+
+	rows, err := a.db.QueryContext(ctx, `
+		WITH `+sessionPlaysCTE("$2", "$3::int")+`,
+		sessions AS (
+			SELECT
+				((ARRAY_AGG(id ORDER BY played_at, id))[1])::text as id,
+				MIN(played_at) as started_at,
+				MAX(played_at) as ended_at,
+				COUNT(*) as plays,
+				SUM(listened_duration_ms) as duration_ms
+			FROM numbered
+			GROUP BY session_no
+			HAVING MAX(played_at) >= $2
+		)
+		SELECT
+			s.id, s.started_at, s.ended_at, s.plays, s.duration_ms,
+			ARRAY(SELECT st.tag FROM session_tags st WHERE st.user_id = $1 AND st.session_id::text = s.id ORDER BY st.tag) as tags
+		FROM sessions s
+		ORDER BY s.started_at DESC
+		LIMIT $4
+	`, userID, startDate, int(listeningSessionGap.Seconds()), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query listening sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := []ListeningSession{}
+	for rows.Next() {
+		var session ListeningSession
+		var durationMs int64
+		var tags pq.StringArray
+		if err := rows.Scan(&session.ID, &session.StartedAt, &session.EndedAt, &session.Plays, &durationMs, &tags); err != nil {
+			continue
+		}
+		session.Minutes = roundMinutes(float64(durationMs) / 60000)
+		session.Tags = []string(tags)
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// Marca a sessão com a tag. A tag fica ligada só à primeira escuta da sessão; as escutas da sessão são
+// calculadas na leitura, então escutas gravadas depois (ex.: import) entram nela. Marcar de novo não duplica.
+// Devolve se a tag é nova na sessão
+func (a *AnalyticsService) TagListeningSession(ctx context.Context, userID, sessionID, tag string) (bool, []string, error) {
+	if a.db == nil {
+		return false, nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	if err := a.checkSessionStart(ctx, userID, sessionID); err != nil {
+		return false, nil, err
+	}
+
+	result, err := a.db.ExecContext(ctx, `
+		INSERT INTO session_tags (user_id, session_id, tag)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id, session_id, tag) DO NOTHING
+	`, userID, sessionID, tag)
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to save session tag: %w", err)
+	}
+	inserted, _ := result.RowsAffected()
+	created := inserted > 0
+
+	var tags pq.StringArray
+	err = a.db.QueryRowContext(ctx, `
+		SELECT ARRAY(SELECT tag FROM session_tags WHERE user_id = $1 AND session_id = $2 ORDER BY tag)
+	`, userID, sessionID).Scan(&tags)
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to query session tags: %w", err)
+	}
+
+	return created, []string(tags), nil
+}
+
+// ErrSessionNotFound se a escuta sessionID não existe ou não abre uma sessão (há outra escuta menos de
+// listeningSessionGap antes dela). Não depende do período pedido na listagem das sessões
+func (a *AnalyticsService) checkSessionStart(ctx context.Context, userID, sessionID string) error {
+	var exists bool
+	err := a.db.QueryRowContext(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM listening_history anchor
+			WHERE anchor.id::text = $1 AND anchor.user_id = $2 AND anchor.deleted_at IS NULL
+				AND NOT EXISTS (
+					SELECT 1 FROM listening_history prev
+					WHERE prev.user_id = $2 AND prev.deleted_at IS NULL
+						AND prev.played_at < anchor.played_at
+						AND prev.played_at >= anchor.played_at - $3::int * INTERVAL '1 second'
+				)
+		)
+	`, sessionID, userID, int(listeningSessionGap.Seconds())).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to resolve listening session: %w", err)
+	}
+	if !exists {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// Sessões do período ($3) marcadas com a tag $2, calculadas na hora a partir das escutas: cada sessão é
+// reconhecida pela primeira escuta (session_id), como na listagem. Define os CTEs tagged_sessions e tagged_plays
+var taggedSessionsCTE = sessionPlaysCTE("$3", fmt.Sprintf("%d", int(listeningSessionGap.Seconds()))) + `,
+		session_starts AS (
+			SELECT session_no, (ARRAY_AGG(id ORDER BY played_at, id))[1] as session_id
+			FROM numbered
+			GROUP BY session_no
+			HAVING MAX(played_at) >= $3
+		),
+		tagged_sessions AS (
+			SELECT ss.session_no FROM session_starts ss
+			JOIN session_tags st ON st.session_id = ss.session_id
+			WHERE st.user_id = $1 AND st.tag = $2
+		),
+		tagged_plays AS (
+			SELECT n.id FROM numbered n JOIN tagged_sessions ts ON ts.session_no = n.session_no
+		)`
+
+// Escutas das sessões marcadas com a tag $2
+var taggedPlayCondition = `lh.id IN (WITH ` + taggedSessionsCTE + ` SELECT id FROM tagged_plays)`
+
+// Versão reduzida dos analytics considerando só as escutas das sessões com a tag (sem dados do Spotify)
+func (a *AnalyticsService) GetTaggedAnalytics(ctx context.Context, userID, tag, timeFilter string) (*TaggedAnalytics, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	filtered, err := a.filteredAnalytics(ctx, userID, timeFilter, taggedPlayCondition, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	analytics := &TaggedAnalytics{Tag: tag, FilteredAnalytics: *filtered}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	err = a.db.QueryRowContext(ctx, `
+		WITH `+taggedSessionsCTE+`
+		SELECT COUNT(*) FROM tagged_sessions
+	`, userID, tag, timeFilterStartDate(timeFilter)).Scan(&analytics.Sessions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count tagged sessions: %w", err)
+	}
+
+	return analytics, nil
+}
+
+// Analytics das escutas que satisfazem condition, que recebe o valor do filtro em $2 ($3 é o início do período)
+func (a *AnalyticsService) filteredAnalytics(ctx context.Context, userID, timeFilter, condition, value string) (*FilteredAnalytics, error) {
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	startDate := timeFilterStartDate(timeFilter)
+	analytics := &FilteredAnalytics{
+		TopTracks:  []TaggedItem{},
+		TopArtists: []TaggedItem{},
+		TopGenres:  []GenreStats{},
+	}
+
+	err := a.db.QueryRowContext(ctx, `
+		SELECT
+			COUNT(*),
+			COALESCE(SUM(CASE WHEN t.duration_ms > 0 THEN t.duration_ms ELSE GREATEST(lh.listened_duration_ms, 0) END), 0),
+			COALESCE(AVG(NULLIF(t.popularity, 0)), 0)
+		FROM listening_history lh
+		JOIN tracks t ON lh.track_id = t.id
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $3 AND `+condition,
+		userID, value, startDate).Scan(&analytics.TotalPlays, &analytics.TotalListeningTime, &analytics.AverageTrackPopularity)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query filtered totals: %w", err)
+	}
+
+	rows, err := a.db.QueryContext(ctx, `
+		SELECT
+			t.id,
+			t.name,
+			ARRAY(
+				SELECT ar.name FROM track_artists ta
+				JOIN artists ar ON ar.id = ta.artist_id
+				WHERE ta.track_id = t.id
+				ORDER BY ar.name
+			) as artists,
+			COUNT(*) as plays
+		FROM listening_history lh
+		JOIN tracks t ON lh.track_id = t.id
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $3 AND `+condition+`
+		GROUP BY t.id, t.name
+		ORDER BY plays DESC, t.name
+		LIMIT 10`, userID, value, startDate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query filtered top tracks: %w", err)
+	}
+	for rows.Next() {
+		var item TaggedItem
+		var artists pq.StringArray
+		if err := rows.Scan(&item.ID, &item.Name, &artists, &item.Plays); err != nil {
+			continue
+		}
+		item.Artists = []string(artists)
+		analytics.TopTracks = append(analytics.TopTracks, item)
+	}
+	rows.Close()
+
+	rows, err = a.db.QueryContext(ctx, `
+		SELECT ar.id, ar.name, COUNT(*) as plays
+		FROM listening_history lh
+		JOIN track_artists ta ON ta.track_id = lh.track_id
+		JOIN artists ar ON ar.id = ta.artist_id
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $3 AND `+condition+`
+		GROUP BY ar.id, ar.name
+		ORDER BY plays DESC, ar.name
+		LIMIT 10`, userID, value, startDate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query filtered top artists: %w", err)
+	}
+	for rows.Next() {
+		var item TaggedItem
+		if err := rows.Scan(&item.ID, &item.Name, &item.Plays); err != nil {
+			continue
+		}
+		analytics.TopArtists = append(analytics.TopArtists, item)
+	}
+	rows.Close()
+
+	rows, err = a.db.QueryContext(ctx, `
+		SELECT
+			g.genre,
+			COUNT(DISTINCT lh.track_id) as track_count,
+			COUNT(*) as play_count,
+			COALESCE(SUM(lh.listened_duration_ms), 0) as total_time,
+			COUNT(*) * 100.0 / SUM(COUNT(*)) OVER () as percentage
+		FROM listening_history lh
+		JOIN track_artists ta ON ta.track_id = lh.track_id
+		JOIN artists ar ON ar.id = ta.artist_id
+		CROSS JOIN LATERAL UNNEST(`+effectiveGenres("lh.user_id", "ar")+`) AS g(genre)
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $3 AND `+condition+`
+		GROUP BY g.genre
+		ORDER BY play_count DESC, g.genre
+		LIMIT 10`, userID, value, startDate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query filtered genres: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var genre GenreStats
+		if err := rows.Scan(&genre.Genre, &genre.TrackCount, &genre.PlayCount, &genre.TotalTime, &genre.Percentage); err != nil {
+			continue
+		}
+		genre.Percentage = math.Round(genre.Percentage*100) / 100
+		analytics.TopGenres = append(analytics.TopGenres, genre)
+	}
+
+	return analytics, rows.Err()
+}
@@ -0,0 +1,57 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// A sessão que atravessa o início do período mantém o ID e as escutas de quando é calculada sem corte, então a
+// tag marcada pela listagem vale e os analytics da tag contam as escutas da sessão dentro do período
+func TestSessionCrossingPeriodStartKeepsItsStart(t *testing.T) {
+	db := openTestDB(t)
+	userID := createTestUser(t, db)
+	trackID, _ := createTestTrack(t, db, "rock")
+	a := NewAnalyticsService(testConfig(), db)
+	ctx := context.Background()
+
+	periodStart := time.Now().UTC().AddDate(0, -6, 0)
+	for _, offset := range []time.Duration{-10 * time.Minute, 10 * time.Minute, 20 * time.Minute} {
+		insertTestPlay(t, db, userID, trackID, "tracking", periodStart.Add(offset))
+	}
+
+	var firstPlayID string
+	if err := db.QueryRow(`SELECT id FROM listening_history WHERE user_id = $1 ORDER BY played_at LIMIT 1`, userID).Scan(&firstPlayID); err != nil {
+		t.Fatalf("failed to load first play: %v", err)
+	}
+
+	sessions, err := a.GetListeningSessions(ctx, userID, "6months", 10)
+	if err != nil {
+		t.Fatalf("GetListeningSessions: %v", err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != firstPlayID || sessions[0].Plays != 3 {
+		t.Fatalf("sessions = %+v, want one session of 3 plays starting at %s", sessions, firstPlayID)
+	}
+
+	if _, _, err := a.TagListeningSession(ctx, userID, sessions[0].ID, "workout"); err != nil {
+		t.Fatalf("TagListeningSession: %v", err)
+	}
+
+	analytics, err := a.GetTaggedAnalytics(ctx, userID, "workout", "6months")
+	if err != nil {
+		t.Fatalf("GetTaggedAnalytics: %v", err)
+	}
+	if analytics.Sessions != 1 || analytics.TotalPlays != 2 {
+		t.Errorf("tagged analytics: %d sessions and %d plays, want 1 session and the 2 plays inside the period", analytics.Sessions, analytics.TotalPlays)
+	}
+
+	// Escuta gravada depois da tag, ainda dentro da sessão, passa a contar
+	insertTestPlay(t, db, userID, trackID, "import", periodStart.Add(30*time.Minute))
+	analytics, err = a.GetTaggedAnalytics(ctx, userID, "workout", "6months")
+	if err != nil {
+		t.Fatalf("GetTaggedAnalytics: %v", err)
+	}
+	if analytics.TotalPlays != 3 {
+		t.Errorf("tagged analytics after a later play: %d plays, want 3", analytics.TotalPlays)
+	}
+}
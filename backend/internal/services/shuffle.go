@@ -0,0 +1,170 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+type DeviceShuffleStats struct {
+	DeviceType   string `json:"device_type"`
+	ShufflePlays int    `json:"shuffle_plays"`
+	OrderedPlays int    `json:"ordered_plays"`
+}
+
+type ShuffleSummary struct {
+	ShufflePlays      int                  `json:"shuffle_plays"`
+	OrderedPlays      int                  `json:"ordered_plays"`
+	UnknownPlays      int                  `json:"unknown_plays"`
+	ShuffleMinutes    float64              `json:"shuffle_minutes"`
+	OrderedMinutes    float64              `json:"ordered_minutes"`
+	ShufflePercentage float64              `json:"shuffle_percentage"`
+	ByDevice          []DeviceShuffleStats `json:"by_device"`
+}
+
+// O estado de shuffle só é conhecido para escutas do tracking ao vivo (repeat_state
+// preenchido) ou de importações (platform preenchido); o sync do recently-played não traz
+const shuffleKnownCondition = "(lh.repeat_state IS NOT NULL OR NULLIF(lh.platform, '') IS NOT NULL)"
+
+func (a *AnalyticsService) GetShuffleSummary(ctx context.Context, userID string, timeFilter string) (*ShuffleSummary, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	startDate := timeFilterStartDate(timeFilter)
+	summary := &ShuffleSummary{ByDevice: make([]DeviceShuffleStats, 0)}
+
+	var shuffleMs, orderedMs int64
+	err := a.db.QueryRowContext(ctx, fmt.Sprintf(`
+		SELECT
+			COUNT(*) FILTER (WHERE %[1]s AND lh.shuffle) as shuffle_plays,
+			COUNT(*) FILTER (WHERE %[1]s AND NOT COALESCE(lh.shuffle, FALSE)) as ordered_plays,
+			COUNT(*) FILTER (WHERE NOT %[1]s) as unknown_plays,
+			COALESCE(SUM(lh.listened_duration_ms) FILTER (WHERE %[1]s AND lh.shuffle), 0) as shuffle_ms,
+			COALESCE(SUM(lh.listened_duration_ms) FILTER (WHERE %[1]s AND NOT COALESCE(lh.shuffle, FALSE)), 0) as ordered_ms
+		FROM listening_history lh
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2`, shuffleKnownCondition),
+		userID, startDate).Scan(&summary.ShufflePlays, &summary.OrderedPlays, &summary.UnknownPlays, &shuffleMs, &orderedMs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query shuffle summary: %w", err)
+	}
+
+	summary.ShuffleMinutes = float64(shuffleMs) / 60000
+	summary.OrderedMinutes = float64(orderedMs) / 60000
+	if known := summary.ShufflePlays + summary.OrderedPlays; known > 0 {
+		summary.ShufflePercentage = float64(summary.ShufflePlays) / float64(known) * 100
+	}
+
+	rows, err := a.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT
+			COALESCE(NULLIF(lh.device_type, ''), NULLIF(lh.platform, ''), 'Unknown') as device,
+			COUNT(*) FILTER (WHERE lh.shuffle) as shuffle_plays,
+			COUNT(*) FILTER (WHERE NOT COALESCE(lh.shuffle, FALSE)) as ordered_plays
+		FROM listening_history lh
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2 AND %s
+		GROUP BY device
+		ORDER BY COUNT(*) DESC`, shuffleKnownCondition), userID, startDate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query shuffle by device: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var device DeviceShuffleStats
+		if err := rows.Scan(&device.DeviceType, &device.ShufflePlays, &device.OrderedPlays); err != nil {
+			continue
+		}
+		summary.ByDevice = append(summary.ByDevice, device)
+	}
+
+	return summary, nil
+}
+
+type ShuffleMonth struct {
+	Month             string  `json:"month"`
+	ShufflePlays      int     `json:"shuffle_plays"`
+	OrderedPlays      int     `json:"ordered_plays"`
+	UnknownPlays      int     `json:"unknown_plays"`
+	ShufflePercentage float64 `json:"shuffle_percentage"` // sobre as escutas com estado conhecido do mês
+}
+
+// Resumo de /user/shuffle com a fatia dos minutos, a cobertura e a evolução mês a mês
+type ShuffleStats struct {
+	*ShuffleSummary
+	ShuffleMinutesPercentage float64        `json:"shuffle_minutes_percentage"`
+	CoveragePercentage       float64        `json:"coverage_percentage"` // escutas com estado de shuffle conhecido
+	Months                   []ShuffleMonth `json:"months"`              // mais recente primeiro
+}
+
+// Shuffle x em ordem no período e nos últimos `months` meses (fuso do usuário). Escutas sem estado de shuffle
+// conhecido (sync do recently-played) ficam fora das porcentagens e só contam na cobertura
+func (a *AnalyticsService) GetShuffleStats(ctx context.Context, userID, timeFilter string, months int, loc *time.Location) (*ShuffleStats, error) {
+	summary, err := a.GetShuffleSummary(ctx, userID, timeFilter)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	stats := &ShuffleStats{ShuffleSummary: summary}
+	summary.ShuffleMinutes = roundMinutes(summary.ShuffleMinutes)
+	summary.OrderedMinutes = roundMinutes(summary.OrderedMinutes)
+	summary.ShufflePercentage = math.Round(summary.ShufflePercentage*100) / 100
+	if knownMinutes := summary.ShuffleMinutes + summary.OrderedMinutes; knownMinutes > 0 {
+		stats.ShuffleMinutesPercentage = math.Round(summary.ShuffleMinutes/knownMinutes*10000) / 100
+	}
+	known := summary.ShufflePlays + summary.OrderedPlays
+	if total := known + summary.UnknownPlays; total > 0 {
+		stats.CoveragePercentage = math.Round(float64(known)/float64(total)*10000) / 100
+	}
+
+	now := time.Now().In(loc)
+	firstMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc).AddDate(0, -(months - 1), 0)
+
+	rows, err := a.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT
+			TO_CHAR(date_trunc('month', %[2]s), 'YYYY-MM') as month,
+			COUNT(*) FILTER (WHERE %[1]s AND lh.shuffle),
+			COUNT(*) FILTER (WHERE %[1]s AND NOT COALESCE(lh.shuffle, FALSE)),
+			COUNT(*) FILTER (WHERE NOT %[1]s)
+		FROM listening_history lh
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2
+		GROUP BY month`, shuffleKnownCondition, localPlayedAt(3)), userID, firstMonth.UTC(), loc.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query shuffle by month: %w", err)
+	}
+	defer rows.Close()
+
+	byMonth := make(map[string]ShuffleMonth)
+	for rows.Next() {
+		var month ShuffleMonth
+		if err := rows.Scan(&month.Month, &month.ShufflePlays, &month.OrderedPlays, &month.UnknownPlays); err != nil {
+			continue
+		}
+		if known := month.ShufflePlays + month.OrderedPlays; known > 0 {
+			month.ShufflePercentage = math.Round(float64(month.ShufflePlays)/float64(known)*10000) / 100
+		}
+		byMonth[month.Month] = month
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Mais recente primeiro; meses sem escuta aparecem explicitamente
+	stats.Months = make([]ShuffleMonth, 0, months)
+	for current := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc); !current.Before(firstMonth); current = current.AddDate(0, -1, 0) {
+		key := current.Format("2006-01")
+		month, ok := byMonth[key]
+		if !ok {
+			month = ShuffleMonth{Month: key}
+		}
+		stats.Months = append(stats.Months, month)
+	}
+
+	return stats, nil
+}
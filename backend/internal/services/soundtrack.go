@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+var ErrInvalidDayPart = errors.New("invalid part of day")
+
+// Faixa de horas locais de cada parte do dia, com fim exclusivo. A noite vira o dia (22h às 5h)
+type DayPart struct {
+	StartHour int `json:"start_hour"`
+	EndHour   int `json:"end_hour"`
+}
+
+var DayParts = map[string]DayPart{
+	"morning":   {StartHour: 5, EndHour: 12},
+	"afternoon": {StartHour: 12, EndHour: 18},
+	"evening":   {StartHour: 18, EndHour: 22},
+	"night":     {StartHour: 22, EndHour: 5},
+}
+
+type SoundtrackTrack struct {
+	Rank         int       `json:"rank"`
+	TrackID      string    `json:"track_id"`
+	TrackName    string    `json:"track_name"`
+	Artists      []string  `json:"artists"`
+	AlbumName    string    `json:"album_name"`
+	ImageURL     string    `json:"image_url,omitempty"`
+	PlayCount    int       `json:"play_count"`
+	TotalTime    int64     `json:"total_time_ms"`
+	LastPlayedAt time.Time `json:"last_played_at"`
+}
+
+// Faixas mais tocadas numa parte do dia (hora local do usuário), base para playlists do tipo "manhã"
+func (a *AnalyticsService) GetSoundtrack(ctx context.Context, userID, part, timeFilter string, loc *time.Location, limit int) ([]SoundtrackTrack, error) {
+	dayPart, ok := DayParts[part]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidDayPart, part)
+	}
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	// Com início maior que o fim a faixa passa da meia-noite
+	hourCondition := "EXTRACT(HOUR FROM %[1]s) >= $4 AND EXTRACT(HOUR FROM %[1]s) < $5"
+	if dayPart.StartHour > dayPart.EndHour {
+		hourCondition = "(EXTRACT(HOUR FROM %[1]s) >= $4 OR EXTRACT(HOUR FROM %[1]s) < $5)"
+	}
+
+	// Contagem por faixa antes do JOIN com os artistas, que repetiria a escuta em faixas com vários artistas
+	query := fmt.Sprintf(`
+		WITH plays AS (
+			SELECT
+				lh.track_id,
+				COUNT(*) as play_count,
+				COALESCE(SUM(lh.listened_duration_ms), 0) as total_time,
+				MAX(lh.played_at) as last_played_at
+			FROM listening_history lh
+			WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2 AND `+hourCondition+`
+			GROUP BY lh.track_id
+			ORDER BY play_count DESC, total_time DESC, lh.track_id
+			LIMIT $6
+		)
+		SELECT
+			t.id,
+			t.name,
+			ARRAY_REMOVE(ARRAY_AGG(ar.name ORDER BY ar.name), NULL) as artists,
+			COALESCE(al.name, '') as album_name,
+			COALESCE(al.image_url, '') as image_url,
+			p.play_count,
+			p.total_time,
+			p.last_played_at
+		FROM plays p
+		JOIN tracks t ON t.id = p.track_id
+		LEFT JOIN albums al ON al.id = t.album_id
+		LEFT JOIN track_artists ta ON ta.track_id = t.id
+		LEFT JOIN artists ar ON ar.id = ta.artist_id
+		GROUP BY t.id, t.name, al.name, al.image_url, p.play_count, p.total_time, p.last_played_at
+		ORDER BY p.play_count DESC, p.total_time DESC, t.id`, localPlayedAt(3))
+
+	rows, err := a.db.QueryContext(ctx, query, userID, timeFilterStartDate(timeFilter), loc.String(),
+		dayPart.StartHour, dayPart.EndHour, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query soundtrack: %w", err)
+	}
+	defer rows.Close()
+
+	tracks := make([]SoundtrackTrack, 0)
+	for rows.Next() {
+		var track SoundtrackTrack
+		var artists pq.StringArray
+		if err := rows.Scan(&track.TrackID, &track.TrackName, &artists, &track.AlbumName, &track.ImageURL,
+			&track.PlayCount, &track.TotalTime, &track.LastPlayedAt); err != nil {
+			continue
+		}
+		track.Rank = len(tracks) + 1
+		track.Artists = []string(artists)
+		track.LastPlayedAt = track.LastPlayedAt.In(loc)
+		tracks = append(tracks, track)
+	}
+
+	return tracks, rows.Err()
+}
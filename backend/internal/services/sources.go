@@ -0,0 +1,46 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Valores de listening_history.source: import (histórico estendido do Spotify), tracking (sync do
+// recently-played), manual (POST /user/history) e scrobble (importação do Last.fm)
+var HistorySources = []string{"import", "tracking", "manual", "scrobble"}
+
+var ErrInvalidSource = errors.New("invalid source")
+
+// Vazio significa todas as origens
+func ValidateHistorySource(source string) error {
+	if source != "" && !slices.Contains(HistorySources, source) {
+		return ErrInvalidSource
+	}
+	return nil
+}
+
+// Filtro por origem no parâmetro posicional $n; com valor vazio não filtra nada
+func sourceCondition(param int) string {
+	return fmt.Sprintf("($%d = '' OR lh.source = $%d)", param, param)
+}
+
+type SourceAnalytics struct {
+	Source string `json:"source"`
+	FilteredAnalytics
+}
+
+// Versão reduzida dos analytics considerando só as escutas de uma origem (sem dados do Spotify)
+func (a *AnalyticsService) GetSourceAnalytics(ctx context.Context, userID, source, timeFilter string) (*SourceAnalytics, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	filtered, err := a.filteredAnalytics(ctx, userID, timeFilter, "lh.source = $2", source)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SourceAnalytics{Source: source, FilteredAnalytics: *filtered}, nil
+}
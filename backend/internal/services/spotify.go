@@ -1,7 +1,10 @@
 package services
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -17,6 +20,7 @@ import (
 type SpotifyService struct {
 	config *config.Config
 	client *http.Client
+	cache  *ResponseCache
 }
 
 type SpotifyUser struct {
@@ -59,6 +63,7 @@ type SpotifyAlbum struct {
 	ID          string `json:"id"`
 	Name        string `json:"name"`
 	ReleaseDate string `json:"release_date"`
+	TotalTracks int    `json:"total_tracks"`
 	Images      []struct {
 		URL string `json:"url"`
 	} `json:"images"`
@@ -79,130 +84,146 @@ type RecentlyPlayedResponse struct {
 		Track    SpotifyTrack `json:"track"`
 		PlayedAt time.Time    `json:"played_at"`
 	} `json:"items"`
+	Next    string `json:"next"`
+	Cursors *struct {
+		After  string `json:"after"`
+		Before string `json:"before"`
+	} `json:"cursors"`
 }
 
 func NewSpotifyService(cfg *config.Config) *SpotifyService {
 	return &SpotifyService{
 		config: cfg,
 		client: &http.Client{Timeout: 30 * time.Second},
+		cache:  NewResponseCache(cfg),
 	}
 }
 
-func (s *SpotifyService) GetUserProfile(token *oauth2.Token) (*SpotifyUser, error) {
-	req, err := http.NewRequest("GET", "https://api.spotify.com/v1/me", nil)
+// Resposta não-200 da API do Spotify; o status permite distinguir token inválido e rate limit
+type SpotifyAPIError struct {
+	StatusCode int
+}
+
+func (e *SpotifyAPIError) Error() string {
+	return fmt.Sprintf("spotify API error: %d", e.StatusCode)
+}
+
+// 401 do Spotify: o token do cliente expirou ou foi revogado
+func IsSpotifyUnauthorized(err error) bool {
+	var apiErr *SpotifyAPIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnauthorized
+}
+
+// Executa uma chamada autenticada na API do Spotify, enviando body como JSON (nil não envia corpo), e
+// decodifica o JSON da resposta em out (nil ignora o corpo). Status fora de 2xx vira SpotifyAPIError.
+// Único ponto de saída HTTP do serviço
+func (s *SpotifyService) doRequest(ctx context.Context, method, apiURL string, token *oauth2.Token, body, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, apiURL, reqBody)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
 
 	resp, err := s.client.Do(req)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("spotify API error: %d", resp.StatusCode)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &SpotifyAPIError{StatusCode: resp.StatusCode}
 	}
 
-	var user SpotifyUser
-	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
-		return nil, err
+	if out == nil {
+		return nil
 	}
-
-	return &user, nil
+	return json.NewDecoder(resp.Body).Decode(out)
 }
 
-func (s *SpotifyService) GetTopTracks(token *oauth2.Token, timeRange string, limit int) (*TopTracksResponse, error) {
-	params := url.Values{}
-	params.Set("time_range", timeRange)
-	params.Set("limit", strconv.Itoa(limit))
-
-	apiURL := "https://api.spotify.com/v1/me/top/tracks?" + params.Encode()
-
-	req, err := http.NewRequest("GET", apiURL, nil)
-	if err != nil {
-		return nil, err
+// GET autenticado em endpoints do usuário, servido pelo cache em disco quando habilitado
+func (s *SpotifyService) getUserScoped(ctx context.Context, apiURL, userID string, token *oauth2.Token, ttl time.Duration, out interface{}) error {
+	cacheKey := userScopedCacheKey(apiURL, userID, token.AccessToken)
+	if body, ok := s.cache.Get(cacheKey, ttl); ok {
+		return json.Unmarshal(body, out)
 	}
 
-	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
-
-	resp, err := s.client.Do(req)
-	if err != nil {
-		return nil, err
+	var body json.RawMessage
+	if err := s.doRequest(ctx, "GET", apiURL, token, nil, &body); err != nil {
+		return err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("spotify API error: %d", resp.StatusCode)
-	}
+	s.cache.Set(cacheKey, body)
+	return json.Unmarshal(body, out)
+}
 
-	var tracks TopTracksResponse
-	if err := json.NewDecoder(resp.Body).Decode(&tracks); err != nil {
+// O perfil é o que identifica a conta dona do token (login, checagem de conta no tracking), então fica no cache
+// pelo token e não pelo usuário
+func (s *SpotifyService) GetUserProfile(token *oauth2.Token) (*SpotifyUser, error) {
+	var user SpotifyUser
+	if err := s.getUserScoped(context.Background(), s.config.SpotifyAPIBaseURL+"/v1/me", "", token, s.config.ProfileCacheTTL, &user); err != nil {
 		return nil, err
 	}
 
-	return &tracks, nil
+	return &user, nil
 }
 
-func (s *SpotifyService) GetTopArtists(token *oauth2.Token, timeRange string, limit int) (*TopArtistsResponse, error) {
+func (s *SpotifyService) GetTopTracks(ctx context.Context, token *oauth2.Token, userID, timeRange string, limit int) (*TopTracksResponse, error) {
 	params := url.Values{}
 	params.Set("time_range", timeRange)
 	params.Set("limit", strconv.Itoa(limit))
 
-	apiURL := "https://api.spotify.com/v1/me/top/artists?" + params.Encode()
+	apiURL := s.config.SpotifyAPIBaseURL + "/v1/me/top/tracks?" + params.Encode()
 
-	req, err := http.NewRequest("GET", apiURL, nil)
-	if err != nil {
+	var tracks TopTracksResponse
+	if err := s.getUserScoped(ctx, apiURL, userID, token, s.config.SpotifyCacheTTL, &tracks); err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	return &tracks, nil
+}
 
-	resp, err := s.client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
+func (s *SpotifyService) GetTopArtists(ctx context.Context, token *oauth2.Token, userID, timeRange string, limit int) (*TopArtistsResponse, error) {
+	params := url.Values{}
+	params.Set("time_range", timeRange)
+	params.Set("limit", strconv.Itoa(limit))
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("spotify API error: %d", resp.StatusCode)
-	}
+	apiURL := s.config.SpotifyAPIBaseURL + "/v1/me/top/artists?" + params.Encode()
 
 	var artists TopArtistsResponse
-	if err := json.NewDecoder(resp.Body).Decode(&artists); err != nil {
+	if err := s.getUserScoped(ctx, apiURL, userID, token, s.config.SpotifyCacheTTL, &artists); err != nil {
 		return nil, err
 	}
 
 	return &artists, nil
 }
 
-func (s *SpotifyService) GetRecentlyPlayed(token *oauth2.Token, limit int) (*RecentlyPlayedResponse, error) {
+// after/before são cursores em unix ms (0 = não enviado); o Spotify aceita só um dos dois por vez
+func (s *SpotifyService) GetRecentlyPlayed(ctx context.Context, token *oauth2.Token, limit int, after, before int64) (*RecentlyPlayedResponse, error) {
 	params := url.Values{}
 	params.Set("limit", strconv.Itoa(limit))
-
-	apiURL := "https://api.spotify.com/v1/me/player/recently-played?" + params.Encode()
-
-	req, err := http.NewRequest("GET", apiURL, nil)
-	if err != nil {
-		return nil, err
+	if after > 0 {
+		params.Set("after", strconv.FormatInt(after, 10))
+	} else if before > 0 {
+		params.Set("before", strconv.FormatInt(before, 10))
 	}
 
-	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
-
-	resp, err := s.client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("spotify API error: %d", resp.StatusCode)
-	}
+	apiURL := s.config.SpotifyAPIBaseURL + "/v1/me/player/recently-played?" + params.Encode()
 
 	var recent RecentlyPlayedResponse
-	if err := json.NewDecoder(resp.Body).Decode(&recent); err != nil {
+	if err := s.doRequest(ctx, "GET", apiURL, token, nil, &recent); err != nil {
 		return nil, err
 	}
 
@@ -220,28 +241,10 @@ func (s *SpotifyService) GetRecommendations(token *oauth2.Token, seedArtists, se
 	}
 	params.Set("limit", "20")
 
-	apiURL := "https://api.spotify.com/v1/recommendations?" + params.Encode()
-
-	req, err := http.NewRequest("GET", apiURL, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	req.Header.Set("Authorization", "Bearer "+token.AccessToken)
-
-	resp, err := s.client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
+	apiURL := s.config.SpotifyAPIBaseURL + "/v1/recommendations?" + params.Encode()
 
 	var result map[string]interface{}
-	if err := json.Unmarshal(body, &result); err != nil {
+	if err := s.doRequest(context.Background(), "GET", apiURL, token, nil, &result); err != nil {
 		return nil, err
 	}
 
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"musike-backend/internal/config"
+
+	_ "github.com/lib/pq"
+)
+
+// Banco com o schema de database/init.sql aplicado, via TEST_DATABASE_URL. Sem a variável os testes que
+// gravam no banco são pulados
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	if err := db.Ping(); err != nil {
+		t.Fatalf("failed to connect to test database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// Usuário descartável; o ON DELETE CASCADE leva junto o histórico gravado pelo teste
+func createTestUser(t *testing.T, db *sql.DB) string {
+	t.Helper()
+
+	var userID string
+	err := db.QueryRow(`INSERT INTO users (spotify_id) VALUES ($1) RETURNING id`,
+		fmt.Sprintf("test-%d", time.Now().UnixNano())).Scan(&userID)
+	if err != nil {
+		t.Fatalf("failed to create test user: %v", err)
+	}
+	t.Cleanup(func() {
+		db.ExecContext(context.Background(), `DELETE FROM users WHERE id = $1`, userID)
+	})
+	return userID
+}
+
+func countHistory(t *testing.T, db *sql.DB, userID string) int {
+	t.Helper()
+
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM listening_history WHERE user_id = $1`, userID).Scan(&count); err != nil {
+		t.Fatalf("failed to count history: %v", err)
+	}
+	return count
+}
+
+// Configuração mínima para os serviços que consultam o banco com timeout
+func testConfig() *config.Config {
+	return &config.Config{DBQueryTimeout: 10 * time.Second, SessionSaveMode: "fixed"}
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+type WeekTotals struct {
+	Minutes float64 `json:"minutes"`
+	Plays   float64 `json:"plays"`
+}
+
+type WeeklyComparison struct {
+	WeekStart        string     `json:"week_start"` // segunda-feira da semana atual, no fuso pedido
+	DaysElapsed      int        `json:"days_elapsed"`
+	ThisWeek         WeekTotals `json:"this_week"`
+	Average          WeekTotals `json:"average"` // média das semanas anteriores no mesmo trecho (segunda até o dia e hora atuais)
+	WeeksConsidered  int        `json:"weeks_considered"`
+	MinutesDeltaPct  *float64   `json:"minutes_delta_pct"` // nil sem semanas anteriores ou média zero
+	PlaysDeltaPct    *float64   `json:"plays_delta_pct"`
+	InsufficientData bool       `json:"insufficient_data"` // menos de comparisonWeeks semanas de histórico
+}
+
+// Semanas completas anteriores usadas na média
+const comparisonWeeks = 12
+
+// Semana atual (de segunda até agora, no fuso loc) comparada com a média das até 12 semanas anteriores
+// no mesmo trecho da semana: numa quarta às 10h, de segunda até quarta às 10h de cada semana. Comparar com
+// semanas inteiras deixaria a variação sempre muito negativa no começo da semana. Para quem tem menos
+// histórico, a média usa só as semanas desde a primeira escuta
+func (a *AnalyticsService) GetWeeklyComparison(ctx context.Context, userID string, loc *time.Location) (*WeeklyComparison, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	now := time.Now().In(loc)
+	weekStart, elapsed := weekStretch(now)
+	daysSinceMonday := (int(now.Weekday()) + 6) % 7
+	historyStart := weekStart.AddDate(0, 0, -7*comparisonWeeks)
+	local := localPlayedAt(4)
+
+	var thisWeekPlays, previousPlays int
+	var thisWeekMs, previousMs int64
+	var firstPlay *time.Time
+	err := a.db.QueryRowContext(ctx, fmt.Sprintf(`
+		SELECT
+			COUNT(*) FILTER (WHERE lh.played_at >= $2),
+			COALESCE(SUM(lh.listened_duration_ms) FILTER (WHERE lh.played_at >= $2), 0),
+			COUNT(*) FILTER (WHERE lh.played_at < $2 AND same_stretch),
+			COALESCE(SUM(lh.listened_duration_ms) FILTER (WHERE lh.played_at < $2 AND same_stretch), 0),
+			(SELECT MIN(played_at) FROM listening_history WHERE user_id = $1 AND deleted_at IS NULL)
+		FROM listening_history lh
+		CROSS JOIN LATERAL (
+			SELECT %[1]s - DATE_TRUNC('week', %[1]s) < $5 * INTERVAL '1 second' as same_stretch
+		) stretch
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $3
+	`, local), userID, weekStart.UTC(), historyStart.UTC(), loc.String(), elapsed.Seconds()).Scan(&thisWeekPlays, &thisWeekMs, &previousPlays, &previousMs, &firstPlay)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query weekly comparison: %w", err)
+	}
+
+	comparison := &WeeklyComparison{
+		WeekStart:   weekStart.Format("2006-01-02"),
+		DaysElapsed: daysSinceMonday + 1,
+		ThisWeek: WeekTotals{
+			Minutes: roundMinutes(float64(thisWeekMs) / 60000),
+			Plays:   float64(thisWeekPlays),
+		},
+		WeeksConsidered: comparisonWeeks,
+	}
+
+	// Semanas completas desde a semana da primeira escuta (as anteriores a ela não contam como semanas sem escuta)
+	if firstPlay == nil || !firstPlay.Before(weekStart) {
+		comparison.WeeksConsidered = 0
+	} else if firstPlay.After(historyStart) {
+		comparison.WeeksConsidered = int(math.Ceil(weekStart.Sub(*firstPlay).Hours() / (24 * 7)))
+	}
+	comparison.InsufficientData = comparison.WeeksConsidered < comparisonWeeks
+
+	if comparison.WeeksConsidered == 0 {
+		return comparison, nil
+	}
+
+	weeks := float64(comparison.WeeksConsidered)
+	comparison.Average = WeekTotals{
+		Minutes: roundMinutes(float64(previousMs) / 60000 / weeks),
+		Plays:   math.Round(float64(previousPlays)/weeks*100) / 100,
+	}
+	comparison.MinutesDeltaPct = percentDelta(float64(thisWeekMs), float64(previousMs)/weeks)
+	comparison.PlaysDeltaPct = percentDelta(float64(thisWeekPlays), float64(previousPlays)/weeks)
+
+	return comparison, nil
+}
+
+// Segunda-feira da semana de now (meia-noite no fuso de now) e o trecho decorrido desde ela no relógio local,
+// que é o que bate com o mesmo dia e hora das outras semanas mesmo quando a semana tem troca de horário de verão
+func weekStretch(now time.Time) (time.Time, time.Duration) {
+	daysSinceMonday := (int(now.Weekday()) + 6) % 7
+	weekStart := time.Date(now.Year(), now.Month(), now.Day()-daysSinceMonday, 0, 0, 0, 0, now.Location())
+	elapsed := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), now.Second(), 0, time.UTC).
+		Sub(time.Date(weekStart.Year(), weekStart.Month(), weekStart.Day(), 0, 0, 0, 0, time.UTC))
+	return weekStart, elapsed
+}
+
+// Variação percentual de current sobre base (23 = 23% a mais); nil quando a base é zero
+func percentDelta(current, base float64) *float64 {
+	if base <= 0 {
+		return nil
+	}
+	delta := math.Round((current-base)/base*10000) / 100
+	return &delta
+}
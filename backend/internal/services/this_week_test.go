@@ -0,0 +1,62 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWeekStretch(t *testing.T) {
+	saoPaulo, err := time.LoadLocation("America/Sao_Paulo")
+	if err != nil {
+		t.Fatalf("LoadLocation: %v", err)
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatalf("LoadLocation: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		now       time.Time
+		weekStart time.Time
+		elapsed   time.Duration
+	}{
+		{
+			name:      "monday start",
+			now:       time.Date(2026, 10, 12, 0, 0, 0, 0, saoPaulo),
+			weekStart: time.Date(2026, 10, 12, 0, 0, 0, 0, saoPaulo),
+			elapsed:   0,
+		},
+		{
+			name:      "wednesday morning",
+			now:       time.Date(2026, 10, 14, 10, 30, 0, 0, saoPaulo),
+			weekStart: time.Date(2026, 10, 12, 0, 0, 0, 0, saoPaulo),
+			elapsed:   2*24*time.Hour + 10*time.Hour + 30*time.Minute,
+		},
+		{
+			name:      "sunday night",
+			now:       time.Date(2026, 10, 18, 23, 0, 0, 0, saoPaulo),
+			weekStart: time.Date(2026, 10, 12, 0, 0, 0, 0, saoPaulo),
+			elapsed:   6*24*time.Hour + 23*time.Hour,
+		},
+		{
+			// Horário de verão termina no domingo 1/11: o relógio local conta 6 dias e 12h, não 13h reais
+			name:      "week with a DST change",
+			now:       time.Date(2026, 11, 1, 12, 0, 0, 0, newYork),
+			weekStart: time.Date(2026, 10, 26, 0, 0, 0, 0, newYork),
+			elapsed:   6*24*time.Hour + 12*time.Hour,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			weekStart, elapsed := weekStretch(tt.now)
+			if !weekStart.Equal(tt.weekStart) {
+				t.Errorf("weekStart = %v, want %v", weekStart, tt.weekStart)
+			}
+			if elapsed != tt.elapsed {
+				t.Errorf("elapsed = %v, want %v", elapsed, tt.elapsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Esquema estável para exportação: um ponto por intervalo, sem buracos, em ordem crescente
+type TimeSeriesPoint struct {
+	BucketStart string  `json:"bucket_start"` // horário local no fuso pedido, YYYY-MM-DDTHH:MM:SS
+	Plays       int     `json:"plays"`
+	Minutes     float64 `json:"minutes"`
+}
+
+var timeSeriesGranularities = map[string]time.Duration{
+	"hour": time.Hour,
+	"day":  24 * time.Hour,
+	"week": 7 * 24 * time.Hour,
+}
+
+const maxTimeSeriesPoints = 10000
+
+var ErrTimeSeriesTooLarge = fmt.Errorf("time series exceeds %d points", maxTimeSeriesPoints)
+
+var ErrInvalidGranularity = errors.New("invalid granularity")
+
+// Escutas e minutos por hora, dia ou semana (semanas começam na segunda) entre os dias from e to, inclusive,
+// no fuso loc. Intervalos sem escuta vêm zerados; os intervalos são regulares no horário local. source vazio
+// considera todas as origens
+func (a *AnalyticsService) GetTimeSeries(ctx context.Context, userID, granularity string, from, to time.Time, loc *time.Location, source string) ([]TimeSeriesPoint, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	step, ok := timeSeriesGranularities[granularity]
+	if !ok {
+		return nil, ErrInvalidGranularity
+	}
+
+	rangeStart := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, loc)
+	rangeEnd := time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, loc).AddDate(0, 0, 1)
+	if int(rangeEnd.Sub(rangeStart)/step) > maxTimeSeriesPoints {
+		return nil, ErrTimeSeriesTooLarge
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+		WITH buckets AS (
+			SELECT generate_series(
+				DATE_TRUNC($3, $4::timestamp),
+				$5::timestamp - INTERVAL '1 second',
+				('1 ' || $3)::interval
+			) as bucket
+		),
+		plays AS (
+			SELECT
+				DATE_TRUNC($3, %s) as bucket,
+				COUNT(*) as plays,
+				COALESCE(SUM(lh.listened_duration_ms), 0) as duration_ms
+			FROM listening_history lh
+			WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $6 AND lh.played_at < $7 AND %s
+			GROUP BY 1
+		)
+		SELECT TO_CHAR(b.bucket, 'YYYY-MM-DD"T"HH24:MI:SS'), COALESCE(p.plays, 0), COALESCE(p.duration_ms, 0)
+		FROM buckets b
+		LEFT JOIN plays p ON p.bucket = b.bucket
+		ORDER BY b.bucket`, localPlayedAt(2), sourceCondition(8))
+
+	rows, err := a.db.QueryContext(ctx, query, userID, loc.String(), granularity,
+		rangeStart.Format("2006-01-02"), rangeEnd.Format("2006-01-02"), rangeStart.UTC(), rangeEnd.UTC(), source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query time series: %w", err)
+	}
+	defer rows.Close()
+
+	points := []TimeSeriesPoint{}
+	for rows.Next() {
+		var point TimeSeriesPoint
+		var durationMs int64
+		if err := rows.Scan(&point.BucketStart, &point.Plays, &durationMs); err != nil {
+			continue
+		}
+		point.Minutes = roundMinutes(float64(durationMs) / 60000)
+		points = append(points, point)
+	}
+
+	return points, rows.Err()
+}
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Prefixo dos tokens cifrados no banco; valores sem ele são de antes da cifragem e são lidos como estão
+// até a próxima renovação gravar o token cifrado
+const sealedTokenPrefix = "enc:v1:"
+
+var ErrInvalidSealedToken = errors.New("invalid sealed token")
+
+// Cifra (AES-256-GCM) os tokens do Spotify gravados em spotify_tokens e linked_spotify_accounts. A chave é
+// derivada de TOKEN_ENCRYPTION_KEY
+type tokenCipher struct {
+	aead cipher.AEAD
+}
+
+func newTokenCipher(secret string) (*tokenCipher, error) {
+	key := sha256.Sum256([]byte("musike-spotify-tokens|" + secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &tokenCipher{aead: aead}, nil
+}
+
+// Vazio continua vazio, para o NULLIF dos upserts manter o refresh token anterior
+func (c *tokenCipher) seal(token string) (string, error) {
+	if token == "" {
+		return "", nil
+	}
+
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := c.aead.Seal(nonce, nonce, []byte(token), nil)
+	return sealedTokenPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+func (c *tokenCipher) open(stored string) (string, error) {
+	encoded, sealed := strings.CutPrefix(stored, sealedTokenPrefix)
+	if !sealed {
+		return stored, nil
+	}
+
+	raw, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil || len(raw) < c.aead.NonceSize() {
+		return "", ErrInvalidSealedToken
+	}
+	nonce, ciphertext := raw[:c.aead.NonceSize()], raw[c.aead.NonceSize():]
+	token, err := c.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		// Chave trocada: o usuário precisa logar de novo
+		return "", ErrInvalidSealedToken
+	}
+	return string(token), nil
+}
@@ -0,0 +1,82 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTokenCipherRoundTrip(t *testing.T) {
+	c, err := newTokenCipher("secret")
+	if err != nil {
+		t.Fatalf("newTokenCipher: %v", err)
+	}
+
+	first, err := c.seal("refresh-token")
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	second, err := c.seal("refresh-token")
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	if !strings.HasPrefix(first, sealedTokenPrefix) || strings.Contains(first, "refresh-token") {
+		t.Errorf("sealed token = %q, want it encrypted", first)
+	}
+	if first == second {
+		t.Error("sealing the same token twice gave the same value, want a fresh nonce")
+	}
+
+	opened, err := c.open(first)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if opened != "refresh-token" {
+		t.Errorf("open = %q, want %q", opened, "refresh-token")
+	}
+}
+
+func TestTokenCipherKeepsEmptyAndLegacyTokens(t *testing.T) {
+	c, err := newTokenCipher("secret")
+	if err != nil {
+		t.Fatalf("newTokenCipher: %v", err)
+	}
+
+	if sealed, err := c.seal(""); err != nil || sealed != "" {
+		t.Errorf("seal(\"\") = %q, %v, want empty", sealed, err)
+	}
+	// tokens gravados antes da cifragem continuam legíveis
+	if opened, err := c.open("plain-token"); err != nil || opened != "plain-token" {
+		t.Errorf("open(plain) = %q, %v, want the token unchanged", opened, err)
+	}
+}
+
+func TestTokenCipherRejectsWrongKeyAndTampering(t *testing.T) {
+	c, err := newTokenCipher("secret")
+	if err != nil {
+		t.Fatalf("newTokenCipher: %v", err)
+	}
+	other, err := newTokenCipher("another-secret")
+	if err != nil {
+		t.Fatalf("newTokenCipher: %v", err)
+	}
+
+	sealed, err := c.seal("refresh-token")
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	tampered := sealed[:len(sealed)-2] + "AA"
+	if tampered == sealed {
+		tampered = sealed[:len(sealed)-2] + "BB"
+	}
+
+	for name, open := range map[string]func() (string, error){
+		"wrong key": func() (string, error) { return other.open(sealed) },
+		"tampered":  func() (string, error) { return c.open(tampered) },
+		"truncated": func() (string, error) { return c.open(sealedTokenPrefix + "AAAA") },
+	} {
+		if _, err := open(); !errors.Is(err, ErrInvalidSealedToken) {
+			t.Errorf("%s: open error = %v, want ErrInvalidSealedToken", name, err)
+		}
+	}
+}
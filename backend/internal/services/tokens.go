@@ -0,0 +1,172 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// Renova o token um pouco antes de expirar, para não entregar um token que vence no meio da requisição
+const tokenRefreshMargin = time.Minute
+
+var ErrNoStoredToken = errors.New("no stored spotify token for user")
+
+// Tokens do Spotify salvos no login (spotify_tokens), cifrados pelo AuthService e renovados com o refresh
+// token quando expiram
+type SpotifyTokenStore struct {
+	db           *sql.DB
+	authService  *AuthService
+	refreshLocks keyedMutex // uma renovação por usuário
+}
+
+func NewSpotifyTokenStore(db *sql.DB, authService *AuthService) *SpotifyTokenStore {
+	return &SpotifyTokenStore{
+		db:          db,
+		authService: authService,
+	}
+}
+
+// Sem refresh token novo (o Spotify nem sempre devolve um no refresh), mantém o anterior. O mesmo vale para
+// os scopes concedidos, que só vêm na resposta quando o Spotify os informa
+func (s *SpotifyTokenStore) Save(ctx context.Context, userID string, token *oauth2.Token) error {
+	if s.db == nil {
+		return fmt.Errorf("database not available")
+	}
+
+	var expiresAt interface{}
+	if !token.Expiry.IsZero() {
+		expiresAt = token.Expiry.UTC()
+	}
+	scope, _ := token.Extra("scope").(string)
+
+	accessToken, err := s.authService.SealToken(token.AccessToken)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt spotify token: %w", err)
+	}
+	refreshToken, err := s.authService.SealToken(token.RefreshToken)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt spotify token: %w", err)
+	}
+
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO spotify_tokens (user_id, access_token, refresh_token, expires_at, scope, updated_at)
+		VALUES ($1, $2, NULLIF($3, ''), $4, NULLIF($5, ''), NOW())
+		ON CONFLICT (user_id) DO UPDATE SET
+			access_token = EXCLUDED.access_token,
+			refresh_token = COALESCE(EXCLUDED.refresh_token, spotify_tokens.refresh_token),
+			expires_at = EXCLUDED.expires_at,
+			scope = COALESCE(EXCLUDED.scope, spotify_tokens.scope),
+			updated_at = NOW()
+	`, userID, accessToken, refreshToken, expiresAt, scope)
+	if err != nil {
+		return fmt.Errorf("failed to save spotify token: %w", err)
+	}
+	return nil
+}
+
+// Se o usuário concedeu o scope no último login. Tokens salvos antes de os scopes serem gravados contam
+// como sem o scope: o login da época não pedia nenhum de escrita
+func (s *SpotifyTokenStore) HasScope(ctx context.Context, userID, scope string) (bool, error) {
+	if s.db == nil {
+		return false, fmt.Errorf("database not available")
+	}
+
+	var granted sql.NullString
+	err := s.db.QueryRowContext(ctx, `SELECT scope FROM spotify_tokens WHERE user_id = $1`, userID).Scan(&granted)
+	if err == sql.ErrNoRows {
+		return false, ErrNoStoredToken
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to load spotify token scopes: %w", err)
+	}
+
+	return slices.Contains(strings.Fields(granted.String), scope), nil
+}
+
+// Token válido do usuário, renovando (e salvando) se estiver expirado ou perto de expirar.
+// ErrNoStoredToken quando o usuário ainda não fez login com o token salvo no servidor
+func (s *SpotifyTokenStore) Token(ctx context.Context, userID string) (*oauth2.Token, error) {
+	if s.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	token, err := s.load(ctx, userID)
+	if err != nil || !s.needsRefresh(token) {
+		return token, err
+	}
+
+	// Uma renovação por usuário: requisições simultâneas reaproveitam o token que a primeira salvou, sem
+	// segurar as renovações dos outros usuários
+	unlock := s.refreshLocks.lock(userID)
+	defer unlock()
+
+	token, err = s.load(ctx, userID)
+	if err != nil || !s.needsRefresh(token) {
+		return token, err
+	}
+
+	if token.RefreshToken == "" {
+		return nil, fmt.Errorf("stored spotify token expired and has no refresh token")
+	}
+
+	refreshed, err := s.authService.RefreshSpotifyToken(token.RefreshToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to refresh spotify token: %w", err)
+	}
+
+	if err := s.Save(ctx, userID, refreshed); err != nil {
+		log.Printf("Error saving refreshed spotify token for user %s: %v", userID, err)
+	}
+
+	log.Printf("Refreshed spotify token for user %s (expires at %v)", userID, refreshed.Expiry)
+	return refreshed, nil
+}
+
+func (s *SpotifyTokenStore) load(ctx context.Context, userID string) (*oauth2.Token, error) {
+	var accessToken string
+	var refreshToken sql.NullString
+	var expiresAt sql.NullTime
+
+	err := s.db.QueryRowContext(ctx, `
+		SELECT access_token, refresh_token, expires_at
+		FROM spotify_tokens
+		WHERE user_id = $1
+	`, userID).Scan(&accessToken, &refreshToken, &expiresAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoStoredToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load spotify token: %w", err)
+	}
+
+	accessToken, err = s.authService.OpenToken(accessToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt spotify token: %w", err)
+	}
+	refresh, err := s.authService.OpenToken(refreshToken.String)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt spotify token: %w", err)
+	}
+
+	token := &oauth2.Token{
+		AccessToken:  accessToken,
+		RefreshToken: refresh,
+		TokenType:    "Bearer",
+	}
+	if expiresAt.Valid {
+		token.Expiry = expiresAt.Time
+	}
+	return token, nil
+}
+
+// Sem expires_at o token é tratado como válido; se o Spotify recusar, o handler devolve spotify_token_expired
+func (s *SpotifyTokenStore) needsRefresh(token *oauth2.Token) bool {
+	return !token.Expiry.IsZero() && time.Now().Add(tokenRefreshMargin).After(token.Expiry)
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const top5CardSize = 5
+
+const placeholderImagePath = "/api/v1/images/placeholder"
+
+// Item do card: ImageURL é a imagem gravada e pode faltar (HasImage false, comum em imports); ImageProxyURL
+// aponta para /images/*, que sempre devolve uma imagem, com placeholder quando não há (faixas sem álbum apontam
+// direto para o placeholder)
+type Top5CardItem struct {
+	Rank          int      `json:"rank"`
+	ID            string   `json:"id"`
+	Name          string   `json:"name"`
+	Artists       []string `json:"artists,omitempty"` // só nas faixas
+	PlayCount     int      `json:"play_count"`
+	ImageURL      string   `json:"image_url"`
+	HasImage      bool     `json:"has_image"`
+	ImageProxyURL string   `json:"image_proxy_url"`
+}
+
+// Dados mínimos para o card compartilhável de 1080x1080, separado dos analytics completos
+type Top5Card struct {
+	DisplayName  string         `json:"display_name"`
+	TimeFilter   string         `json:"time_filter"`
+	From         string         `json:"from"` // primeiro dia do período (ou da primeira escuta em alltime)
+	To           string         `json:"to"`
+	TotalMinutes float64        `json:"total_minutes"`
+	TotalPlays   int            `json:"total_plays"`
+	Tracks       []Top5CardItem `json:"tracks"`
+	Artists      []Top5CardItem `json:"artists"`
+}
+
+func (a *AnalyticsService) GetTop5Card(ctx context.Context, userID, timeFilter string, loc *time.Location) (*Top5Card, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	startDate := timeFilterStartDate(timeFilter)
+	card := &Top5Card{
+		TimeFilter: timeFilter,
+		To:         time.Now().In(loc).Format("2006-01-02"),
+		Tracks:     []Top5CardItem{},
+		Artists:    []Top5CardItem{},
+	}
+
+	var displayName sql.NullString
+	var firstPlay sql.NullTime
+	var totalMs int64
+	err := a.db.QueryRowContext(ctx, `
+		SELECT
+			(SELECT display_name FROM users WHERE id = $1),
+			MIN(lh.played_at),
+			COUNT(*),
+			COALESCE(SUM(lh.listened_duration_ms), 0)
+		FROM listening_history lh
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2
+	`, userID, startDate).Scan(&displayName, &firstPlay, &card.TotalPlays, &totalMs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query card totals: %w", err)
+	}
+	if card.TotalPlays == 0 {
+		return nil, ErrNoListeningHistory
+	}
+	card.DisplayName = displayName.String
+	card.TotalMinutes = roundMinutes(float64(totalMs) / 60000)
+	switch {
+	case timeFilter != "alltime":
+		card.From = startDate.In(loc).Format("2006-01-02")
+	case firstPlay.Valid:
+		card.From = firstPlay.Time.In(loc).Format("2006-01-02")
+	default:
+		card.From = card.To
+	}
+
+	trackRows, err := a.db.QueryContext(ctx, `
+		WITH plays AS (
+			SELECT lh.track_id, COUNT(*) as play_count
+			FROM listening_history lh
+			WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2
+			GROUP BY lh.track_id
+			ORDER BY play_count DESC, lh.track_id
+			LIMIT $3
+		)
+		SELECT
+			t.id,
+			t.name,
+			ARRAY_REMOVE(ARRAY_AGG(ar.name ORDER BY ar.name), NULL) as artists,
+			p.play_count,
+			COALESCE(t.album_id, ''),
+			COALESCE(al.image_url, '')
+		FROM plays p
+		JOIN tracks t ON t.id = p.track_id
+		LEFT JOIN albums al ON al.id = t.album_id
+		LEFT JOIN track_artists ta ON ta.track_id = t.id
+		LEFT JOIN artists ar ON ar.id = ta.artist_id
+		GROUP BY t.id, t.name, p.play_count, t.album_id, al.image_url
+		ORDER BY p.play_count DESC, t.id
+	`, userID, startDate, top5CardSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query card tracks: %w", err)
+	}
+	defer trackRows.Close()
+
+	for trackRows.Next() {
+		var item Top5CardItem
+		var artists pq.StringArray
+		var albumID string
+		if err := trackRows.Scan(&item.ID, &item.Name, &artists, &item.PlayCount, &albumID, &item.ImageURL); err != nil {
+			continue
+		}
+		item.Rank = len(card.Tracks) + 1
+		item.Artists = []string(artists)
+		item.HasImage = strings.HasPrefix(item.ImageURL, "https://")
+		item.ImageProxyURL = placeholderImagePath
+		if albumID != "" {
+			item.ImageProxyURL = "/api/v1/images/album/" + albumID
+		}
+		card.Tracks = append(card.Tracks, item)
+	}
+	if err := trackRows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read card tracks: %w", err)
+	}
+
+	artistRows, err := a.db.QueryContext(ctx, `
+		SELECT ar.id, ar.name, COUNT(*) as play_count, COALESCE(ar.image_url, '')
+		FROM listening_history lh
+		JOIN track_artists ta ON ta.track_id = lh.track_id
+		JOIN artists ar ON ar.id = ta.artist_id
+		WHERE lh.user_id = $1 AND lh.deleted_at IS NULL AND lh.played_at >= $2
+		GROUP BY ar.id, ar.name, ar.image_url
+		ORDER BY play_count DESC, ar.id
+		LIMIT $3
+	`, userID, startDate, top5CardSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query card artists: %w", err)
+	}
+	defer artistRows.Close()
+
+	for artistRows.Next() {
+		var item Top5CardItem
+		if err := artistRows.Scan(&item.ID, &item.Name, &item.PlayCount, &item.ImageURL); err != nil {
+			continue
+		}
+		item.Rank = len(card.Artists) + 1
+		item.HasImage = strings.HasPrefix(item.ImageURL, "https://")
+		item.ImageProxyURL = "/api/v1/images/artist/" + item.ID
+		card.Artists = append(card.Artists, item)
+	}
+
+	return card, artistRows.Err()
+}
@@ -0,0 +1,25 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTop5CardTrackWithoutAlbumUsesPlaceholder(t *testing.T) {
+	db := openTestDB(t)
+	userID := createTestUser(t, db)
+	trackID, artistID := createTestTrack(t, db, "rock") // sem álbum
+	insertTestPlay(t, db, userID, trackID, "import", time.Now().Add(-time.Hour))
+
+	card, err := NewAnalyticsService(testConfig(), db).GetTop5Card(context.Background(), userID, "6months", time.UTC)
+	if err != nil {
+		t.Fatalf("GetTop5Card: %v", err)
+	}
+	if len(card.Tracks) != 1 || card.Tracks[0].ImageProxyURL != placeholderImagePath {
+		t.Errorf("tracks = %+v, want the placeholder image_proxy_url", card.Tracks)
+	}
+	if len(card.Artists) != 1 || card.Artists[0].ImageProxyURL != "/api/v1/images/artist/"+artistID {
+		t.Errorf("artists = %+v, want the artist image_proxy_url", card.Artists)
+	}
+}
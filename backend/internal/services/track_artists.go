@@ -0,0 +1,36 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Vínculo faixa-artista (alias de track_artists) com um artista real do Spotify. Os IDs sintéticos do import
+// (artist_<nome>) e de outras fontes sem ID do Spotify não casam. Mesma definição no import e na reconciliação
+func ResolvedArtistCondition(alias string) string {
+	return alias + ".artist_id ~ '" + spotifyIDPattern + "'"
+}
+
+// Remove os vínculos sintéticos (artist_<nome> do import) das faixas do usuário que já têm os artistas reais
+// do Spotify. O ID sintético sai do nome, então artistas diferentes com o mesmo nome colidem e poluem a lista
+// de artistas da faixa; quando o Spotify já resolveu os artistas, a lista dele vale. Faixas só com artistas
+// sintéticos ficam como estão até serem enriquecidas. Devolve quantos vínculos foram corrigidos
+func ReconcileTrackArtists(ctx context.Context, db *sql.DB, userID string) (int64, error) {
+	result, err := db.ExecContext(ctx, `
+		DELETE FROM track_artists ta
+		WHERE NOT `+ResolvedArtistCondition("ta")+`
+			AND ta.track_id IN (
+				SELECT DISTINCT lh.track_id FROM listening_history lh WHERE lh.user_id = $1
+			)
+			AND EXISTS (
+				SELECT 1 FROM track_artists resolved
+				WHERE resolved.track_id = ta.track_id AND `+ResolvedArtistCondition("resolved")+`
+			)
+	`, userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reconcile track artists: %w", err)
+	}
+
+	return result.RowsAffected()
+}
@@ -4,9 +4,12 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -14,6 +17,7 @@ import (
 	"musike-backend/internal/config"
 
 	"github.com/lib/pq"
+	"golang.org/x/oauth2"
 )
 
 type TrackingService struct {
@@ -22,17 +26,39 @@ type TrackingService struct {
 	httpClient     *http.Client
 	activeTracking map[string]*UserTracking
 	trackingMutex  sync.RWMutex
-	stopChannel    chan bool
+	stopChannel    chan struct{} // fechado pelo StopPeriodicTracking: todas as esperas do tracker enxergam a parada
+	stopOnce       sync.Once
+	cache          *ResponseCache
+	webhook        *WebhookNotifier
+	spotifyLimiter *tokenBucket // limite global das chamadas do tracker (TRACKING_RATE_LIMIT)
+
+	// Grava a escuta que terminou; saveListeningSession, trocado nos testes da máquina de estados
+	saveSession func(tracking *UserTracking)
+
+	// Verificação de marcos fora do caminho de gravação (scheduleMilestoneCheck); checkMilestones, trocado nos
+	// testes. milestonePending marca os usuários com verificação rodando e se outra foi pedida nesse meio tempo
+	checkUserMilestones func(userID string)
+	milestoneMutex      sync.Mutex
+	milestonePending    map[string]bool
 }
 
 type UserTracking struct {
-	UserID        string
-	SpotifyToken  string
-	LastTrack     *CurrentlyPlayingTrack
-	SessionStart  time.Time
-	TotalPlayTime int64
-	LastUpdated   time.Time
-	IsActive      bool
+	UserID           string
+	SpotifyAccount   string // spotify_id de uma conta vinculada; vazio na conta principal
+	SpotifyToken     string
+	LastTrack        *CurrentlyPlayingTrack
+	SessionStart     time.Time
+	TotalPlayTime    int64
+	LastUpdated      time.Time
+	LastPlaybackSeen time.Time
+	LastProgressMs   int       // progresso informado pelo Spotify na última consulta
+	PausedSince      time.Time // início da pausa atual; zero enquanto toca
+	PauseFlushed     bool      // escuta já gravada por pausa longa; retomar a faixa começa outra escuta
+	IsActive         bool
+
+	tokenSource oauth2.TokenSource // renova o token das contas vinculadas; nil na conta principal
+
+	lastRecentlyPlayedSync time.Time // última busca do recently-played pelo tracker; só o dispatcher usa
 }
 
 type CurrentlyPlayingTrack struct {
@@ -45,7 +71,20 @@ type CurrentlyPlayingTrack struct {
 	IsPlaying  bool             `json:"is_playing"`
 	Popularity int              `json:"popularity"`
 	PreviewURL string           `json:"preview_url"`
+	Explicit   bool             `json:"explicit"`
 	Context    *PlaybackContext `json:"context"`
+
+	// Estado do player (só vem do endpoint /me/player, não do recently-played)
+	Device       *PlaybackDevice `json:"device,omitempty"`
+	ShuffleState bool            `json:"shuffle_state"`
+	RepeatState  string          `json:"repeat_state,omitempty"` // off, track, context
+}
+
+type PlaybackDevice struct {
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	Type          string `json:"type"` // Computer, Smartphone, Speaker, etc.
+	VolumePercent *int   `json:"volume_percent"`
 }
 
 type PlaybackContext struct {
@@ -53,14 +92,38 @@ type PlaybackContext struct {
 	URI  string `json:"uri"`
 }
 
+var ErrUserNotTracked = errors.New("user is not being tracked")
+
+// O tracker parou (StopPeriodicTracking) antes da primeira chamada ao Spotify
+var ErrTrackingStopped = errors.New("tracking service stopped")
+
+// A mesma faixa iniciada em duas contas do usuário com até esse intervalo é tratada como a mesma
+// reprodução (ex.: Spotify Connect) e só é gravada uma vez
+const crossAccountDuplicateWindow = 90 * time.Second
+
+// Chave em activeTracking: o userID na conta principal, userID|spotify_id nas contas vinculadas
+func trackingKey(userID, spotifyAccount string) string {
+	if spotifyAccount == "" {
+		return userID
+	}
+	return userID + "|" + spotifyAccount
+}
+
 func NewTrackingService(cfg *config.Config, db *sql.DB) *TrackingService {
-	return &TrackingService{
+	s := &TrackingService{
 		config:         cfg,
 		db:             db,
 		httpClient:     &http.Client{Timeout: 30 * time.Second},
 		activeTracking: make(map[string]*UserTracking),
-		stopChannel:    make(chan bool),
-	}
+		stopChannel:    make(chan struct{}),
+		cache:          NewResponseCache(cfg),
+		webhook:        NewWebhookNotifier(cfg),
+		spotifyLimiter: newTokenBucket(cfg.TrackingRateLimit),
+	}
+	s.saveSession = s.saveListeningSession
+	s.checkUserMilestones = s.checkMilestones
+	s.milestonePending = make(map[string]bool)
+	return s
 }
 
 func (s *TrackingService) StartTracking(userID, spotifyToken string) error {
@@ -70,36 +133,107 @@ func (s *TrackingService) StartTracking(userID, spotifyToken string) error {
 	log.Printf("Starting tracking for user: %s", userID)
 
 	s.activeTracking[userID] = &UserTracking{
-		UserID:       userID,
-		SpotifyToken: spotifyToken,
-		SessionStart: time.Now(),
-		LastUpdated:  time.Now(),
-		IsActive:     true,
+		UserID:           userID,
+		SpotifyToken:     spotifyToken,
+		SessionStart:     time.Now(),
+		LastUpdated:      time.Now(),
+		LastPlaybackSeen: time.Now(),
+		IsActive:         true,
 	}
 
 	return nil
 }
 
-func (s *TrackingService) StopTracking(userID string) error {
+// Acompanha uma conta vinculada do usuário em paralelo à principal; as escutas vão para o mesmo usuário.
+// O token vem da fonte a cada rodada, então a conta continua sendo acompanhada depois que o token expira
+func (s *TrackingService) StartLinkedTracking(userID, spotifyAccount string, tokens oauth2.TokenSource) error {
+	token, err := tokens.Token()
+	if err != nil {
+		return fmt.Errorf("failed to get token for linked account %s: %w", spotifyAccount, err)
+	}
+
 	s.trackingMutex.Lock()
 	defer s.trackingMutex.Unlock()
 
-	if tracking, exists := s.activeTracking[userID]; exists {
-		tracking.IsActive = false
-		log.Printf("Stopped tracking for user: %s", userID)
+	log.Printf("Starting tracking for user %s (linked account %s)", userID, spotifyAccount)
 
-		if tracking.LastTrack != nil {
-			s.saveListeningSession(tracking)
+	s.activeTracking[trackingKey(userID, spotifyAccount)] = &UserTracking{
+		UserID:           userID,
+		SpotifyAccount:   spotifyAccount,
+		SpotifyToken:     token.AccessToken,
+		SessionStart:     time.Now(),
+		LastUpdated:      time.Now(),
+		LastPlaybackSeen: time.Now(),
+		IsActive:         true,
+		tokenSource:      tokens,
+	}
+	return nil
+}
+
+// Para o tracking da conta principal e das contas vinculadas do usuário
+func (s *TrackingService) StopTracking(userID string) error {
+	s.trackingMutex.Lock()
+	defer s.trackingMutex.Unlock()
+
+	for key, tracking := range s.activeTracking {
+		if tracking.UserID == userID {
+			s.stopTrackingLocked(key, tracking)
 		}
+	}
+
+	return nil
+}
+
+func (s *TrackingService) StopLinkedTracking(userID, spotifyAccount string) {
+	s.trackingMutex.Lock()
+	defer s.trackingMutex.Unlock()
 
-		delete(s.activeTracking, userID)
+	key := trackingKey(userID, spotifyAccount)
+	if tracking, exists := s.activeTracking[key]; exists {
+		s.stopTrackingLocked(key, tracking)
 	}
+}
+
+// Deve ser chamado com trackingMutex travado
+func (s *TrackingService) stopTrackingLocked(key string, tracking *UserTracking) {
+	tracking.IsActive = false
+	log.Printf("Stopped tracking for user: %s", key)
+
+	if tracking.LastTrack != nil {
+		s.saveSession(tracking)
+	}
+
+	delete(s.activeTracking, key)
+}
 
+func (s *TrackingService) UpdateSpotifyToken(userID, spotifyToken string) error {
+	s.trackingMutex.Lock()
+	defer s.trackingMutex.Unlock()
+
+	tracking, exists := s.activeTracking[userID]
+	if !exists || !tracking.IsActive {
+		return ErrUserNotTracked
+	}
+
+	tracking.SpotifyToken = spotifyToken
+	log.Printf("Updated Spotify token for tracked user: %s", userID)
 	return nil
 }
 
+func (s *TrackingService) GetUserSpotifyID(userID string) (string, error) {
+	var spotifyID string
+	err := s.db.QueryRowContext(context.Background(), `
+		SELECT spotify_id FROM users WHERE id = $1
+	`, userID).Scan(&spotifyID)
+	if err != nil {
+		return "", fmt.Errorf("failed to query user spotify id: %w", err)
+	}
+	return spotifyID, nil
+}
+
 func (s *TrackingService) GetCurrentTrack(spotifyToken string) (*CurrentlyPlayingTrack, error) {
-	req, err := http.NewRequest("GET", "https://api.spotify.com/v1/me/player/currently-playing", nil)
+	// /me/player traz, além da faixa atual, o dispositivo e o estado de shuffle/repeat
+	req, err := http.NewRequest("GET", s.config.SpotifyAPIBaseURL+"/v1/me/player", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -117,7 +251,7 @@ func (s *TrackingService) GetCurrentTrack(spotifyToken string) (*CurrentlyPlayin
 	}
 
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("spotify API error: %d", resp.StatusCode)
+		return nil, &SpotifyAPIError{StatusCode: resp.StatusCode}
 	}
 
 	var response struct {
@@ -125,6 +259,9 @@ func (s *TrackingService) GetCurrentTrack(spotifyToken string) (*CurrentlyPlayin
 		IsPlaying  bool                   `json:"is_playing"`
 		ProgressMs int                    `json:"progress_ms"`
 		Context    *PlaybackContext       `json:"context"`
+		Device     *PlaybackDevice        `json:"device"`
+		Shuffle    bool                   `json:"shuffle_state"`
+		Repeat     string                 `json:"repeat_state"`
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
@@ -138,6 +275,9 @@ func (s *TrackingService) GetCurrentTrack(spotifyToken string) (*CurrentlyPlayin
 	response.Item.IsPlaying = response.IsPlaying
 	response.Item.ProgressMs = response.ProgressMs
 	response.Item.Context = response.Context
+	response.Item.Device = response.Device
+	response.Item.ShuffleState = response.Shuffle
+	response.Item.RepeatState = response.Repeat
 
 	return response.Item, nil
 }
@@ -148,51 +288,75 @@ type RecentlyPlayedTrack struct {
 }
 
 type RecentlyPlayedResponseCustom struct {
-	Items []RecentlyPlayedTrack `json:"items"`
+	Items   []RecentlyPlayedTrack `json:"items"`
+	Next    string                `json:"next"`
+	Cursors *struct {
+		After  string `json:"after"`
+		Before string `json:"before"`
+	} `json:"cursors"`
 }
 
-func (s *TrackingService) GetRecentlyPlayed(spotifyToken string, limit int, after int64) (*RecentlyPlayedResponseCustom, error) {
-	url := fmt.Sprintf("https://api.spotify.com/v1/me/player/recently-played?limit=%d", limit)
+// Só um dos cursores pode ser enviado: after traz o que veio depois do timestamp, before o que veio antes.
+// Em ambos os casos os itens chegam do mais recente para o mais antigo
+func (s *TrackingService) GetRecentlyPlayed(spotifyToken string, limit int, after, before int64) (*RecentlyPlayedResponseCustom, error) {
+	url := fmt.Sprintf("%s/v1/me/player/recently-played?limit=%d", s.config.SpotifyAPIBaseURL, limit)
 	if after > 0 {
 		url += fmt.Sprintf("&after=%d", after)
+	} else if before > 0 {
+		url += fmt.Sprintf("&before=%d", before)
 	}
 
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
+	var response RecentlyPlayedResponseCustom
+	if err := s.getJSON(spotifyToken, url, &response); err != nil {
 		return nil, err
 	}
 
+	return &response, nil
+}
+
+// GET autenticado com o token do tracking; status não-200 vira SpotifyAPIError
+func (s *TrackingService) getJSON(spotifyToken, apiURL string, out interface{}) error {
+	req, err := http.NewRequest("GET", apiURL, nil)
+	if err != nil {
+		return err
+	}
+
 	req.Header.Set("Authorization", "Bearer "+spotifyToken)
 
 	resp, err := s.httpClient.Do(req)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("spotify API error: %d", resp.StatusCode)
+	if resp.StatusCode != http.StatusOK {
+		return &SpotifyAPIError{StatusCode: resp.StatusCode}
 	}
 
-	var response RecentlyPlayedResponseCustom
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return nil, err
-	}
-
-	return &response, nil
+	return json.NewDecoder(resp.Body).Decode(out)
 }
 
+// Verificar a cada 15 segundos para ser mais responsivo
+const trackingTickInterval = 15 * time.Second
+
+// O recently-played só serve para pegar o que o currently-playing não viu (faixas curtas entre dois ticks,
+// outro dispositivo); até 50 escutas por página cobrem bem mais que esse intervalo, então não precisa
+// ser buscado a cada tick
+const recentlyPlayedSyncInterval = 5 * time.Minute
+
 func (s *TrackingService) StartPeriodicTracking() {
 	log.Println("Starting periodic tracking service...")
 
-	ticker := time.NewTicker(15 * time.Second) // Verificar a cada 15 segundos para ser mais responsivo
+	ticker := time.NewTicker(trackingTickInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			s.updateAllActiveTracking()
-			s.syncRecentlyPlayedTracks()
+			if s.dispatchTrackingTick() {
+				log.Println("Stopping periodic tracking service...")
+				return
+			}
 		case <-s.stopChannel:
 			log.Println("Stopping periodic tracking service...")
 			return
@@ -200,25 +364,105 @@ func (s *TrackingService) StartPeriodicTracking() {
 	}
 }
 
-func (s *TrackingService) updateAllActiveTracking() {
+// Espalha as atualizações dos usuários ativos ao longo do tick em vez de disparar todas de uma vez, e cada
+// chamada ao Spotify passa pelo limite global. Se o limite não couber no tick, a rodada passa do intervalo e
+// os ticks perdidos são descartados pelo ticker, o que segura a próxima rodada. Entre um usuário e outro
+// atende o StopPeriodicTracking; devolve true se a parada chegou no meio da rodada
+func (s *TrackingService) dispatchTrackingTick() bool {
+	activeUsers := s.activeUsers()
+	if len(activeUsers) == 0 {
+		return false
+	}
+
+	start := time.Now()
+	spacing := trackingTickInterval / time.Duration(len(activeUsers))
+	for i, tracking := range activeUsers {
+		if s.waitOrStop(time.Until(start.Add(time.Duration(i) * spacing))) {
+			return true
+		}
+
+		if !s.refreshTrackingToken(tracking) {
+			continue
+		}
+
+		// Parada durante a espera pelo limite: o resto da rodada é descartado
+		if s.spotifyLimiter.wait(s.stopChannel) {
+			return true
+		}
+		s.updateUserTracking(tracking)
+
+		if now := time.Now(); now.Sub(tracking.lastRecentlyPlayedSync) >= recentlyPlayedSyncInterval {
+			tracking.lastRecentlyPlayedSync = now
+			s.syncUserRecentlyPlayed(tracking)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed > trackingTickInterval {
+		log.Printf("Tracking round for %d users took %v, longer than the %v tick (TRACKING_RATE_LIMIT)",
+			len(activeUsers), elapsed.Round(time.Second), trackingTickInterval)
+	}
+	return false
+}
+
+// Espera delay (ou nada, se delay <= 0) a menos que chegue um StopPeriodicTracking; devolve true nesse caso
+func (s *TrackingService) waitOrStop(delay time.Duration) bool {
+	if delay <= 0 {
+		return isStopped(s.stopChannel)
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return false
+	case <-s.stopChannel:
+		return true
+	}
+}
+
+// Pega o token atual das contas vinculadas antes da rodada (a conta principal recebe o token renovado pelo
+// frontend via UpdateSpotifyToken). Devolve false se o token não pôde ser renovado; a conta tenta de novo na
+// próxima rodada
+func (s *TrackingService) refreshTrackingToken(tracking *UserTracking) bool {
+	if tracking.tokenSource == nil {
+		return true
+	}
+
+	token, err := tracking.tokenSource.Token()
+	if err != nil {
+		log.Printf("Error refreshing token for user %s (linked account %s): %v", tracking.UserID, tracking.SpotifyAccount, err)
+		return false
+	}
+
+	s.trackingMutex.Lock()
+	tracking.SpotifyToken = token.AccessToken
+	s.trackingMutex.Unlock()
+	return true
+}
+
+func (s *TrackingService) activeUsers() []*UserTracking {
 	s.trackingMutex.RLock()
+	defer s.trackingMutex.RUnlock()
+
 	activeUsers := make([]*UserTracking, 0, len(s.activeTracking))
 	for _, tracking := range s.activeTracking {
 		if tracking.IsActive {
 			activeUsers = append(activeUsers, tracking)
 		}
 	}
-	s.trackingMutex.RUnlock()
-
-	for _, tracking := range activeUsers {
-		s.updateUserTracking(tracking)
-	}
+	return activeUsers
 }
 
 func (s *TrackingService) updateUserTracking(tracking *UserTracking) {
 	currentTrack, err := s.GetCurrentTrack(tracking.SpotifyToken)
 	if err != nil {
 		log.Printf("Error getting current track for user %s: %v", tracking.UserID, err)
+
+		// Token expirado ou Spotify inacessível também conta como inatividade
+		s.trackingMutex.Lock()
+		s.stopIfIdle(tracking, time.Now())
+		s.trackingMutex.Unlock()
 		return
 	}
 
@@ -229,21 +473,49 @@ func (s *TrackingService) updateUserTracking(tracking *UserTracking) {
 
 	if currentTrack == nil {
 		if tracking.LastTrack != nil {
-			s.saveListeningSession(tracking)
+			s.saveSession(tracking)
 			tracking.LastTrack = nil
 		}
 		tracking.LastUpdated = now
+		s.stopIfIdle(tracking, now)
 		return
 	}
 
-	if tracking.LastTrack == nil || tracking.LastTrack.ID != currentTrack.ID {
-		if tracking.LastTrack != nil {
-			s.saveListeningSession(tracking)
+	tracking.LastPlaybackSeen = now
+
+	s.advanceTracking(tracking, currentTrack, now)
+}
+
+// Deve ser chamado com trackingMutex travado. Aplica a faixa atual ao estado do tracking: troca de faixa
+// grava a anterior, tempo só conta enquanto toca e pausa maior que TrackingPauseFlush grava a escuta
+// mesmo com a faixa ainda "atual", para a retomada (ou a troca) uma hora depois não virar a mesma escuta
+func (s *TrackingService) advanceTracking(tracking *UserTracking, currentTrack *CurrentlyPlayingTrack, now time.Time) {
+	// Mesma faixa: device, shuffle e repeat gravados com a escuta são os da última consulta, não os do início
+	if tracking.LastTrack != nil && tracking.LastTrack.ID == currentTrack.ID {
+		tracking.LastTrack.Device = currentTrack.Device
+		tracking.LastTrack.ShuffleState = currentTrack.ShuffleState
+		tracking.LastTrack.RepeatState = currentTrack.RepeatState
+	}
+
+	// Caso mais comum: a mesma faixa continua tocando. Só soma o tempo, sem passar pela lógica de sessão
+	if s.isSteadyPlayback(tracking, currentTrack, now) {
+		tracking.TotalPlayTime += now.Sub(tracking.LastUpdated).Milliseconds()
+		tracking.LastProgressMs = currentTrack.ProgressMs
+		tracking.LastUpdated = now
+		return
+	}
+
+	trackChanged := tracking.LastTrack == nil || tracking.LastTrack.ID != currentTrack.ID
+	if trackChanged {
+		if tracking.LastTrack != nil && !tracking.PauseFlushed {
+			s.saveSession(tracking)
 		}
 
 		tracking.LastTrack = currentTrack
 		tracking.SessionStart = now
 		tracking.TotalPlayTime = 0
+		tracking.PausedSince = time.Time{}
+		tracking.PauseFlushed = false
 
 		log.Printf("User %s started playing: %s by %s",
 			tracking.UserID, currentTrack.Name,
@@ -251,21 +523,119 @@ func (s *TrackingService) updateUserTracking(tracking *UserTracking) {
 	}
 
 	if currentTrack.IsPlaying {
-		timeDiff := now.Sub(tracking.LastUpdated)
-		if timeDiff > 0 && timeDiff < 2*time.Minute { // Evitar valores absurdos
-			tracking.TotalPlayTime += int64(timeDiff.Milliseconds())
+		if tracking.PauseFlushed {
+			// Retomada depois de uma pausa longa: nova escuta a partir de agora
+			tracking.SessionStart = now
+			tracking.TotalPlayTime = 0
+			tracking.PauseFlushed = false
+		} else {
+			timeDiff := now.Sub(tracking.LastUpdated)
+			if trackChanged {
+				// Parte do intervalo desde a última consulta foi da faixa anterior (tocando ou pausada)
+				timeDiff = min(timeDiff, time.Duration(currentTrack.ProgressMs)*time.Millisecond)
+			}
+			if timeDiff > 0 && timeDiff < 2*time.Minute { // Evitar valores absurdos
+				tracking.TotalPlayTime += int64(timeDiff.Milliseconds())
+			}
+		}
+		tracking.PausedSince = time.Time{}
+	} else {
+		if tracking.PausedSince.IsZero() {
+			tracking.PausedSince = now
+		}
+		flushAfter := s.config.TrackingPauseFlush
+		if flushAfter > 0 && !tracking.PauseFlushed && now.Sub(tracking.PausedSince) >= flushAfter {
+			s.saveSession(tracking)
+			tracking.PauseFlushed = true
+			log.Printf("Flushed listening session for user %s after %v paused on %s",
+				tracking.UserID, flushAfter, currentTrack.Name)
 		}
 	}
 
+	tracking.LastProgressMs = currentTrack.ProgressMs
 	tracking.LastUpdated = now
 }
 
+// Diferença aceita entre o quanto o progresso andou e o tempo desde a última consulta (latência da API)
+const steadyProgressTolerance = 5 * time.Second
+
+// Mesma faixa, tocando desde a consulta anterior, com o progresso andando o mesmo que o relógio. Seek, repetição
+// da faixa, pausa e retomada não passam e seguem pela lógica completa
+func (s *TrackingService) isSteadyPlayback(tracking *UserTracking, currentTrack *CurrentlyPlayingTrack, now time.Time) bool {
+	if tracking.LastTrack == nil || tracking.LastTrack.ID != currentTrack.ID || !currentTrack.IsPlaying ||
+		!tracking.PausedSince.IsZero() || tracking.PauseFlushed {
+		return false
+	}
+
+	elapsed := now.Sub(tracking.LastUpdated)
+	if elapsed <= 0 || elapsed >= 2*time.Minute {
+		return false
+	}
+
+	drift := time.Duration(currentTrack.ProgressMs-tracking.LastProgressMs)*time.Millisecond - elapsed
+	return drift.Abs() <= steadyProgressTolerance
+}
+
+// Deve ser chamado com trackingMutex travado. Para o tracking se o usuário está
+// sem reprodução há mais tempo que TrackingIdleTimeout (0 desativa)
+func (s *TrackingService) stopIfIdle(tracking *UserTracking, now time.Time) bool {
+	timeout := s.config.TrackingIdleTimeout
+	if timeout <= 0 || !tracking.IsActive || now.Sub(tracking.LastPlaybackSeen) < timeout {
+		return false
+	}
+
+	if tracking.LastTrack != nil {
+		s.saveSession(tracking)
+		tracking.LastTrack = nil
+	}
+
+	tracking.IsActive = false
+	key := trackingKey(tracking.UserID, tracking.SpotifyAccount)
+	if current, exists := s.activeTracking[key]; exists && current == tracking {
+		delete(s.activeTracking, key)
+	}
+
+	log.Printf("Auto-stopped tracking for user %s after %v without playback", tracking.UserID, timeout)
+	return true
+}
+
+// Varredura do janitor: remove entradas já paradas que ficaram no mapa e para as ociosas, caso o ciclo do
+// tracker não tenha passado por elas (ex.: rodada atrasada pelo limite de chamadas). Devolve quantas saíram
+func (s *TrackingService) SweepIdleTracking(now time.Time) int {
+	s.trackingMutex.Lock()
+	defer s.trackingMutex.Unlock()
+
+	swept := 0
+	for key, tracking := range s.activeTracking {
+		if !tracking.IsActive {
+			delete(s.activeTracking, key)
+			swept++
+			continue
+		}
+		if s.stopIfIdle(tracking, now) {
+			swept++
+		}
+	}
+	return swept
+}
+
+// Tempo mínimo ouvido (ms) para a escuta ser gravada. No modo fixed é sempre SessionSaveMinPlayed; no modo
+// percentage é SessionSaveMinPercent % da faixa ou SessionSaveMinPlayed, o que for menor: com 40% e 30s, uma
+// vinheta de 45s conta a partir de 18s e faixas a partir de 75s continuam exigindo 30s
+func (s *TrackingService) minPlayTimeToSave(durationMs int) int64 {
+	minPlayed := s.config.SessionSaveMinPlayed.Milliseconds()
+	if s.config.SessionSaveMode != "percentage" || durationMs <= 0 {
+		return minPlayed
+	}
+	return min(int64(durationMs)*int64(s.config.SessionSaveMinPercent)/100, minPlayed)
+}
+
 func (s *TrackingService) saveListeningSession(tracking *UserTracking) {
 	if tracking.LastTrack == nil {
 		return
 	}
 
-	if tracking.TotalPlayTime < 30000 {
+	if tracking.TotalPlayTime < s.minPlayTimeToSave(tracking.LastTrack.DurationMs) {
 		return
 	}
 
@@ -289,25 +659,16 @@ func (s *TrackingService) saveListeningSession(tracking *UserTracking) {
 	}
 
 	// Handle release date - Spotify sometimes gives just year ("1986") or partial date
-	var releaseDate interface{}
-	if album.ReleaseDate != "" {
-		// If it's just a year (4 digits), convert to date
-		if len(album.ReleaseDate) == 4 {
-			releaseDate = album.ReleaseDate + "-01-01"
-		} else {
-			releaseDate = album.ReleaseDate
-		}
-	} else {
-		releaseDate = nil
-	}
+	releaseDate := normalizeReleaseDate(album.ReleaseDate)
 
 	_, err = tx.ExecContext(ctx, `
-		INSERT INTO albums (id, name, release_date, image_url, created_at) 
-		VALUES ($1, $2, $3, $4, NOW()) 
+		INSERT INTO albums (id, name, release_date, image_url, total_tracks, created_at) 
+		VALUES ($1, $2, $3, $4, NULLIF($5, 0), NOW()) 
 		ON CONFLICT (id) DO UPDATE SET 
 			name = EXCLUDED.name,
-			image_url = EXCLUDED.image_url
-	`, album.ID, album.Name, releaseDate, imageURL)
+			image_url = EXCLUDED.image_url,
+			total_tracks = COALESCE(EXCLUDED.total_tracks, albums.total_tracks)
+	`, album.ID, album.Name, releaseDate, imageURL, album.TotalTracks)
 
 	if err != nil {
 		log.Printf("Error saving album: %v", err)
@@ -315,15 +676,16 @@ func (s *TrackingService) saveListeningSession(tracking *UserTracking) {
 	}
 
 	_, err = tx.ExecContext(ctx, `
-		INSERT INTO tracks (id, name, album_id, duration_ms, popularity, preview_url, created_at) 
-		VALUES ($1, $2, $3, $4, $5, $6, NOW()) 
+		INSERT INTO tracks (id, name, album_id, duration_ms, popularity, preview_url, explicit, created_at) 
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW()) 
 		ON CONFLICT (id) DO UPDATE SET 
 			name = EXCLUDED.name,
 			duration_ms = EXCLUDED.duration_ms,
 			popularity = EXCLUDED.popularity,
-			preview_url = EXCLUDED.preview_url
+			preview_url = EXCLUDED.preview_url,
+			explicit = EXCLUDED.explicit
 	`, tracking.LastTrack.ID, tracking.LastTrack.Name, album.ID,
-		tracking.LastTrack.DurationMs, tracking.LastTrack.Popularity, tracking.LastTrack.PreviewURL)
+		tracking.LastTrack.DurationMs, tracking.LastTrack.Popularity, tracking.LastTrack.PreviewURL, tracking.LastTrack.Explicit)
 
 	if err != nil {
 		log.Printf("Error saving track: %v", err)
@@ -349,6 +711,15 @@ func (s *TrackingService) saveListeningSession(tracking *UserTracking) {
 		contextURI = tracking.LastTrack.Context.URI
 	}
 
+	// Dispositivo pode não vir (ex.: sessão privada); nesse caso fica NULL
+	var deviceType, repeatState sql.NullString
+	if tracking.LastTrack.Device != nil && tracking.LastTrack.Device.Type != "" {
+		deviceType = sql.NullString{String: tracking.LastTrack.Device.Type, Valid: true}
+	}
+	if tracking.LastTrack.RepeatState != "" {
+		repeatState = sql.NullString{String: tracking.LastTrack.RepeatState, Valid: true}
+	}
+
 	// Calcular porcentagem escutada baseado no tempo de duração da música
 	listeningPercentage := float64(0)
 	if tracking.LastTrack.DurationMs > 0 {
@@ -358,38 +729,52 @@ func (s *TrackingService) saveListeningSession(tracking *UserTracking) {
 		}
 	}
 
-	_, err = tx.ExecContext(ctx, `
-		INSERT INTO listening_history (user_id, track_id, played_at, context_type, context_uri, listened_duration_ms, listening_percentage, created_at) 
-		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
-	`, tracking.UserID, tracking.LastTrack.ID, tracking.SessionStart, contextType, contextURI, tracking.TotalPlayTime, listeningPercentage)
+	// O sync periódico pode ter gravado a mesma escuta ao mesmo tempo: o índice único decide quem fica.
+	// A mesma reprodução vista por outra conta do usuário também não é gravada de novo
+	if err := lockUserHistory(ctx, tx, tracking.UserID); err != nil {
+		log.Printf("Error saving listening history: %v", err)
+		return
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO listening_history (user_id, track_id, played_at, context_type, context_uri, listened_duration_ms, listening_percentage, device_type, shuffle, repeat_state, spotify_account, source, created_at) 
+		SELECT $1::uuid, $2, $3::timestamp, $4, $5, $6::int, $7::numeric, $8, $9::boolean, $10, NULLIF($11, ''), 'tracking', NOW()
+		WHERE NOT EXISTS (`+crossAccountDuplicateCondition(11, 12)+`)
+		ON CONFLICT (user_id, track_id, played_at) DO NOTHING
+	`, tracking.UserID, tracking.LastTrack.ID, tracking.SessionStart, contextType, contextURI, tracking.TotalPlayTime, listeningPercentage,
+		deviceType, tracking.LastTrack.ShuffleState, repeatState, tracking.SpotifyAccount, int(crossAccountDuplicateWindow.Seconds()))
 
 	if err != nil {
 		log.Printf("Error saving listening history: %v", err)
 		return
 	}
 
+	inserted, _ := result.RowsAffected()
+	if inserted > 0 {
+		if err := applyPlayAggregates(ctx, tx, tracking.UserID, tracking.LastTrack.ID, "tracking", tracking.TotalPlayTime, 1); err != nil {
+			log.Printf("Error updating listening aggregates: %v", err)
+			return
+		}
+		if err := invalidateAnalyticsCache(ctx, tx, tracking.UserID); err != nil {
+			log.Printf("Error saving listening history: %v", err)
+			return
+		}
+	}
+
 	if err = tx.Commit(); err != nil {
 		log.Printf("Error committing transaction: %v", err)
 		return
 	}
 
+	if inserted == 0 {
+		log.Printf("Listening session for user %s (%s) was already recorded", tracking.UserID, tracking.LastTrack.Name)
+		return
+	}
+
 	log.Printf("Saved listening session for user %s: %s (%.1f seconds)",
 		tracking.UserID, tracking.LastTrack.Name, float64(tracking.TotalPlayTime)/1000)
-}
-
-func (s *TrackingService) syncRecentlyPlayedTracks() {
-	s.trackingMutex.RLock()
-	activeUsers := make([]*UserTracking, 0, len(s.activeTracking))
-	for _, tracking := range s.activeTracking {
-		if tracking.IsActive {
-			activeUsers = append(activeUsers, tracking)
-		}
-	}
-	s.trackingMutex.RUnlock()
 
-	for _, tracking := range activeUsers {
-		s.syncUserRecentlyPlayed(tracking)
-	}
+	s.scheduleMilestoneCheck(tracking.UserID)
 }
 
 func (s *TrackingService) ForceFullSync(userID string) error {
@@ -411,27 +796,57 @@ func (s *TrackingService) ForceFullSync(userID string) error {
 }
 
 func (s *TrackingService) syncUserRecentlyPlayed(tracking *UserTracking) {
-	ctx := context.Background()
-	log.Printf("Starting full sync for user %s - fetching up to 100 recent tracks...", tracking.UserID)
+	maxTracks := s.syncMaxTracks()
+	log.Printf("Starting full sync for user %s - fetching up to %d recent tracks...", tracking.UserID, maxTracks)
+
+	allTracks, _ := s.fetchRecentlyPlayedWindow(tracking.UserID, tracking.SpotifyToken, maxTracks)
+	log.Printf("Sync completed for user %s: %d unique tracks found", tracking.UserID, len(allTracks))
 
+	newTracksSaved := s.saveRecentlyPlayedWindow(tracking.UserID, tracking.SpotifyAccount, tracking.SpotifyToken, allTracks)
+	log.Printf("Sync finished for user %s: %d new tracks saved to database", tracking.UserID, newTracksSaved)
+
+	if newTracksSaved > 0 {
+		s.scheduleMilestoneCheck(tracking.UserID)
+	}
+}
+
+// Escutas do sync periódico (SYNC_MAX_TRACKS, padrão 100)
+func (s *TrackingService) syncMaxTracks() int {
+	if s.config.SyncMaxTracks <= 0 {
+		return 100
+	}
+	return s.config.SyncMaxTracks
+}
+
+// Até maxTracks escutas mais recentes do recently-played, sem repetições, paginando para trás até o Spotify
+// parar de devolver páginas. O erro só vem quando nem a primeira página pôde ser buscada
+func (s *TrackingService) fetchRecentlyPlayedWindow(userID, spotifyToken string, maxTracks int) ([]RecentlyPlayedTrack, error) {
 	allTracks := []RecentlyPlayedTrack{}
 	processedTracks := make(map[string]bool) // Para evitar duplicatas usando track_id + played_at
 
-	// Buscar em lotes de 50 (máximo da API do Spotify)
-	var afterTimestamp int64 = 0
+	// Buscar em lotes de 50 (máximo da API do Spotify), do mais recente para trás: cada página
+	// pede o que veio antes de cursors.before da página anterior
+	var beforeCursor int64 = 0
 	totalFetched := 0
 
-	for totalFetched < 100 {
-		limit := 50
-		if totalFetched+limit > 100 {
-			limit = 100 - totalFetched
-		}
+	for totalFetched < maxTracks {
+		limit := min(50, maxTracks-totalFetched)
 
-		log.Printf("Fetching batch: limit=%d, after=%d, totalFetched=%d", limit, afterTimestamp, totalFetched)
+		log.Printf("Fetching batch: limit=%d, before=%d, totalFetched=%d", limit, beforeCursor, totalFetched)
 
-		recent, err := s.GetRecentlyPlayed(tracking.SpotifyToken, limit, afterTimestamp)
+		// Cada página conta no limite global, inclusive no backfill; com o tracker parando, a busca para aqui
+		if s.spotifyLimiter.wait(s.stopChannel) {
+			if totalFetched == 0 {
+				return nil, ErrTrackingStopped
+			}
+			break
+		}
+		recent, err := s.GetRecentlyPlayed(spotifyToken, limit, 0, beforeCursor)
 		if err != nil {
-			log.Printf("Error getting recently played for user %s: %v", tracking.UserID, err)
+			log.Printf("Error getting recently played for user %s: %v", userID, err)
+			if totalFetched == 0 {
+				return nil, err
+			}
 			break
 		}
 
@@ -466,17 +881,27 @@ func (s *TrackingService) syncUserRecentlyPlayed(tracking *UserTracking) {
 			break
 		}
 
-		// Usar o timestamp da última música como "after" para próximo lote
-		if len(recent.Items) > 0 {
-			lastTrack := recent.Items[len(recent.Items)-1]
-			playedAt, err := time.Parse(time.RFC3339, lastTrack.PlayedAt)
-			if err == nil {
-				afterTimestamp = playedAt.UnixMilli()
-			}
+		// Sem next/cursors o Spotify não tem mais páginas
+		if recent.Next == "" || recent.Cursors == nil || recent.Cursors.Before == "" {
+			log.Printf("No next page returned, reached end of history")
+			break
 		}
+
+		cursor, err := strconv.ParseInt(recent.Cursors.Before, 10, 64)
+		if err != nil || (beforeCursor > 0 && cursor >= beforeCursor) {
+			// Cursor inválido ou que não anda para trás: parar em vez de buscar a mesma janela de novo
+			log.Printf("Invalid before cursor %q, stopping sync", recent.Cursors.Before)
+			break
+		}
+		beforeCursor = cursor
 	}
 
-	log.Printf("Sync completed for user %s: %d unique tracks found", tracking.UserID, len(allTracks))
+	return allTracks, nil
+}
+
+// Grava as escutas que ainda não estão no banco, das mais antigas para as mais recentes. Devolve quantas gravou
+func (s *TrackingService) saveRecentlyPlayedWindow(userID, spotifyAccount, spotifyToken string, allTracks []RecentlyPlayedTrack) int {
+	ctx := context.Background()
 
 	// Processar todas as músicas em ordem cronológica (mais antigas primeiro)
 	newTracksSaved := 0
@@ -493,32 +918,32 @@ func (s *TrackingService) syncUserRecentlyPlayed(tracking *UserTracking) {
 		err = s.db.QueryRowContext(ctx, `
 			SELECT COUNT(*) FROM listening_history 
 			WHERE user_id = $1 AND track_id = $2 AND played_at = $3
-		`, tracking.UserID, item.Track.ID, playedAt).Scan(&count)
+		`, userID, item.Track.ID, playedAt).Scan(&count)
 
 		if err != nil {
 			log.Printf("Error checking existing track: %v", err)
 			continue
 		}
 
-		if count == 0 {
-			s.saveRecentlyPlayedTrack(tracking.UserID, tracking.SpotifyToken, &item)
+		if count == 0 && s.saveRecentlyPlayedTrack(userID, spotifyAccount, spotifyToken, &item) {
 			newTracksSaved++
 		}
 	}
 
-	log.Printf("Sync finished for user %s: %d new tracks saved to database", tracking.UserID, newTracksSaved)
+	return newTracksSaved
 }
 
-func (s *TrackingService) saveRecentlyPlayedTrack(userID, spotifyToken string, recentTrack *RecentlyPlayedTrack) {
+// Devolve true se a escuta foi gravada agora (false se já existia ou se deu erro)
+func (s *TrackingService) saveRecentlyPlayedTrack(userID, spotifyAccount, spotifyToken string, recentTrack *RecentlyPlayedTrack) bool {
 	if recentTrack.Track == nil {
-		return
+		return false
 	}
 
 	// Parse do timestamp
 	playedAt, err := time.Parse(time.RFC3339, recentTrack.PlayedAt)
 	if err != nil {
 		log.Printf("Error parsing played_at time: %v", err)
-		return
+		return false
 	}
 
 	// Verificar se já existe no banco
@@ -531,22 +956,89 @@ func (s *TrackingService) saveRecentlyPlayedTrack(userID, spotifyToken string, r
 
 	if err != nil {
 		log.Printf("Error checking existing track: %v", err)
-		return
+		return false
 	}
 
 	if count > 0 {
-		return // Já existe
+		return false // Já existe
 	}
 
-	track := recentTrack.Track
-
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		log.Printf("Error starting transaction: %v", err)
-		return
+		return false
 	}
 	defer tx.Rollback()
 
+	inserted, err := s.insertRecentlyPlayedTrack(ctx, tx, userID, spotifyAccount, spotifyToken, recentTrack.Track, playedAt)
+	if err != nil {
+		log.Printf("Error saving listening history: %v", err)
+		return false
+	}
+	if !inserted {
+		return false // gravada por outra execução entre a verificação e o INSERT
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("Error committing transaction: %v", err)
+		return false
+	}
+
+	log.Printf("Synced recently played track for user %s: %s by %s (played at %s)",
+		userID, recentTrack.Track.Name, strings.Join(getArtistNames(recentTrack.Track.Artists), ", "), playedAt.Format("15:04:05"))
+	return true
+}
+
+// Catálogo, escuta e agregados de uma faixa do recently-played dentro da transação do chamador. Devolve
+// false se a escuta já existia (mesmo played_at ou vista por outra conta do usuário)
+func (s *TrackingService) insertRecentlyPlayedTrack(ctx context.Context, tx *sql.Tx, userID, spotifyAccount, spotifyToken string, track *CurrentlyPlayingTrack, playedAt time.Time) (bool, error) {
+	if err := s.saveTrackCatalog(ctx, tx, spotifyToken, track); err != nil {
+		return false, fmt.Errorf("failed to save track catalog: %w", err)
+	}
+
+	// Salvar histórico
+	contextType := ""
+	contextURI := ""
+	if track.Context != nil {
+		contextType = track.Context.Type
+		contextURI = track.Context.URI
+	}
+
+	// Para músicas do recently-played, assumir que foi escutada completamente (100%)
+	// pois o Spotify só reporta no recently-played se foi tocada substancialmente
+	listeningPercentage := 100.0
+	listenedDuration := int64(track.DurationMs)
+
+	if err := lockUserHistory(ctx, tx, userID); err != nil {
+		return false, err
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO listening_history (user_id, track_id, played_at, context_type, context_uri, listened_duration_ms, listening_percentage, spotify_account, source, created_at) 
+		SELECT $1::uuid, $2, $3::timestamp, $4, $5, $6::int, $7::numeric, NULLIF($8, ''), 'tracking', NOW()
+		WHERE NOT EXISTS (`+crossAccountDuplicateCondition(8, 9)+`)
+		ON CONFLICT (user_id, track_id, played_at) DO NOTHING
+	`, userID, track.ID, playedAt, contextType, contextURI, listenedDuration, listeningPercentage, spotifyAccount,
+		int(crossAccountDuplicateWindow.Seconds()))
+	if err != nil {
+		return false, fmt.Errorf("failed to insert listening history: %w", err)
+	}
+
+	if inserted, _ := result.RowsAffected(); inserted == 0 {
+		return false, nil
+	}
+
+	if err := applyPlayAggregates(ctx, tx, userID, track.ID, "tracking", listenedDuration, 1); err != nil {
+		return false, err
+	}
+	if err := invalidateAnalyticsCache(ctx, tx, userID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Artistas (com detalhes), álbum, faixa e relações faixa-artista, dentro da transação do chamador
+func (s *TrackingService) saveTrackCatalog(ctx context.Context, tx *sql.Tx, spotifyToken string, track *CurrentlyPlayingTrack) error {
 	// Salvar artistas com detalhes completos
 	for _, artist := range track.Artists {
 		s.saveArtistWithDetails(ctx, tx, spotifyToken, artist.ID, artist.Name, track.Popularity)
@@ -559,44 +1051,35 @@ func (s *TrackingService) saveRecentlyPlayedTrack(userID, spotifyToken string, r
 		imageURL = album.Images[0].URL
 	}
 
-	var releaseDate interface{}
-	if album.ReleaseDate != "" {
-		if len(album.ReleaseDate) == 4 {
-			releaseDate = album.ReleaseDate + "-01-01"
-		} else {
-			releaseDate = album.ReleaseDate
-		}
-	} else {
-		releaseDate = nil
-	}
+	releaseDate := normalizeReleaseDate(album.ReleaseDate)
 
-	_, err = tx.ExecContext(ctx, `
-		INSERT INTO albums (id, name, release_date, image_url, created_at) 
-		VALUES ($1, $2, $3, $4, NOW()) 
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO albums (id, name, release_date, image_url, total_tracks, created_at) 
+		VALUES ($1, $2, $3, $4, NULLIF($5, 0), NOW()) 
 		ON CONFLICT (id) DO UPDATE SET 
 			name = EXCLUDED.name,
-			image_url = EXCLUDED.image_url
-	`, album.ID, album.Name, releaseDate, imageURL)
+			image_url = EXCLUDED.image_url,
+			total_tracks = COALESCE(EXCLUDED.total_tracks, albums.total_tracks)
+	`, album.ID, album.Name, releaseDate, imageURL, album.TotalTracks)
 
 	if err != nil {
-		log.Printf("Error saving album: %v", err)
-		return
+		return fmt.Errorf("failed to save album: %w", err)
 	}
 
 	// Salvar track
 	_, err = tx.ExecContext(ctx, `
-		INSERT INTO tracks (id, name, album_id, duration_ms, popularity, preview_url, created_at) 
-		VALUES ($1, $2, $3, $4, $5, $6, NOW()) 
+		INSERT INTO tracks (id, name, album_id, duration_ms, popularity, preview_url, explicit, created_at) 
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW()) 
 		ON CONFLICT (id) DO UPDATE SET 
 			name = EXCLUDED.name,
 			duration_ms = EXCLUDED.duration_ms,
 			popularity = EXCLUDED.popularity,
-			preview_url = EXCLUDED.preview_url
-	`, track.ID, track.Name, album.ID, track.DurationMs, track.Popularity, track.PreviewURL)
+			preview_url = EXCLUDED.preview_url,
+			explicit = EXCLUDED.explicit
+	`, track.ID, track.Name, album.ID, track.DurationMs, track.Popularity, track.PreviewURL, track.Explicit)
 
 	if err != nil {
-		log.Printf("Error saving track: %v", err)
-		return
+		return fmt.Errorf("failed to save track: %w", err)
 	}
 
 	// Salvar relações track-artist
@@ -612,60 +1095,42 @@ func (s *TrackingService) saveRecentlyPlayedTrack(userID, spotifyToken string, r
 		}
 	}
 
-	// Salvar histórico
-	contextType := ""
-	contextURI := ""
-	if track.Context != nil {
-		contextType = track.Context.Type
-		contextURI = track.Context.URI
-	}
-
-	// Para músicas do recently-played, assumir que foi escutada completamente (100%)
-	// pois o Spotify só reporta no recently-played se foi tocada substancialmente
-	listeningPercentage := 100.0
-	listenedDuration := int64(track.DurationMs)
-
-	_, err = tx.ExecContext(ctx, `
-		INSERT INTO listening_history (user_id, track_id, played_at, context_type, context_uri, listened_duration_ms, listening_percentage, created_at) 
-		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
-	`, userID, track.ID, playedAt, contextType, contextURI, listenedDuration, listeningPercentage)
-
-	if err != nil {
-		log.Printf("Error saving listening history: %v", err)
-		return
-	}
-
-	if err = tx.Commit(); err != nil {
-		log.Printf("Error committing transaction: %v", err)
-		return
-	}
-
-	log.Printf("Synced recently played track for user %s: %s by %s (played at %s)",
-		userID, track.Name, strings.Join(getArtistNames(track.Artists), ", "), playedAt.Format("15:04:05"))
+	return nil
 }
 
 func (s *TrackingService) GetArtistDetails(spotifyToken, artistID string) (*SpotifyArtist, error) {
-	url := fmt.Sprintf("https://api.spotify.com/v1/artists/%s", artistID)
+	url := fmt.Sprintf("%s/v1/artists/%s", s.config.SpotifyAPIBaseURL, artistID)
 
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
+	// Detalhes de artista não dependem do usuário: a chave do cache é só a URL
+	body, cached := s.cache.Get(url, s.config.ArtistCacheTTL)
+	if !cached {
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
 
-	req.Header.Set("Authorization", "Bearer "+spotifyToken)
+		req.Header.Set("Authorization", "Bearer "+spotifyToken)
 
-	resp, err := s.httpClient.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
+		resp, err := s.httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("spotify API error: %d", resp.StatusCode)
+		if resp.StatusCode != 200 {
+			return nil, &SpotifyAPIError{StatusCode: resp.StatusCode}
+		}
+
+		body, err = io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		s.cache.Set(url, body)
 	}
 
 	var artist SpotifyArtist
-	if err := json.NewDecoder(resp.Body).Decode(&artist); err != nil {
+	if err := json.Unmarshal(body, &artist); err != nil {
 		return nil, err
 	}
 
@@ -743,22 +1208,57 @@ func (s *TrackingService) saveArtistWithDetails(ctx context.Context, tx *sql.Tx,
 }
 
 func (s *TrackingService) StopPeriodicTracking() {
-	s.stopChannel <- true
+	s.stopOnce.Do(func() { close(s.stopChannel) })
+}
+
+func (s *TrackingService) GetActiveUserTokens() map[string]string {
+	s.trackingMutex.RLock()
+	defer s.trackingMutex.RUnlock()
+
+	tokens := make(map[string]string, len(s.activeTracking))
+	for _, tracking := range s.activeTracking {
+		if tracking.IsActive && tracking.SpotifyAccount == "" {
+			tokens[tracking.UserID] = tracking.SpotifyToken
+		}
+	}
+	return tokens
 }
 
 func (s *TrackingService) GetActiveTrackingCount() int {
 	s.trackingMutex.RLock()
 	defer s.trackingMutex.RUnlock()
 
+	// Usuários, não contas: as contas vinculadas não contam de novo
 	count := 0
 	for _, tracking := range s.activeTracking {
-		if tracking.IsActive {
+		if tracking.IsActive && tracking.SpotifyAccount == "" {
 			count++
 		}
 	}
 	return count
 }
 
+// Mesma faixa do mesmo usuário gravada por outra conta, começando a menos de crossAccountDuplicateWindow.
+// Usa $1 (user_id), $2 (track_id) e $3 (played_at), como nos INSERTs do tracking. Não há constraint por
+// trás da janela: os INSERTs do usuário rodam depois de lockUserHistory para que o NOT EXISTS de uma conta
+// veja o que a outra gravou
+func crossAccountDuplicateCondition(accountParam, windowParam int) string {
+	return fmt.Sprintf(`
+			SELECT 1 FROM listening_history dup
+			WHERE dup.user_id = $1 AND dup.track_id = $2 AND dup.deleted_at IS NULL
+				AND dup.spotify_account IS DISTINCT FROM NULLIF($%d, '')
+				AND dup.played_at BETWEEN $3 - $%d::int * INTERVAL '1 second' AND $3 + $%d::int * INTERVAL '1 second'
+		`, accountParam, windowParam, windowParam)
+}
+
+// Serializa as gravações do tracking de um mesmo usuário até o fim da transação (advisory lock do Postgres)
+func lockUserHistory(ctx context.Context, tx *sql.Tx, userID string) error {
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtextextended($1::text, 0))`, userID); err != nil {
+		return fmt.Errorf("failed to lock listening history: %w", err)
+	}
+	return nil
+}
+
 func getArtistNames(artists []SpotifyArtist) []string {
 	names := make([]string, len(artists))
 	for i, artist := range artists {
@@ -0,0 +1,588 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"musike-backend/internal/config"
+
+	"golang.org/x/oauth2"
+)
+
+// Recently-played falso: itens do mais recente para o mais antigo, paginados por ?before= como o Spotify
+type fakeRecentlyPlayed struct {
+	mu      sync.Mutex
+	items   []RecentlyPlayedTrack
+	befores []int64 // cursor recebido em cada página
+}
+
+func newFakeRecentlyPlayed(total int, newest time.Time) *fakeRecentlyPlayed {
+	fake := &fakeRecentlyPlayed{}
+	for i := 0; i < total; i++ {
+		fake.items = append(fake.items, RecentlyPlayedTrack{
+			Track: &CurrentlyPlayingTrack{
+				ID:         fmt.Sprintf("track%03d", i%40), // faixas repetem, escutas não
+				Name:       fmt.Sprintf("Track %d", i%40),
+				Artists:    []SpotifyArtist{{ID: "artist1", Name: "Artist"}},
+				Album:      SpotifyAlbum{ID: "album1", Name: "Album"},
+				DurationMs: 180000,
+			},
+			PlayedAt: newest.Add(-time.Duration(i) * 4 * time.Minute).UTC().Format(time.RFC3339),
+		})
+	}
+	return fake
+}
+
+func (f *fakeRecentlyPlayed) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/me/player/recently-played" {
+		http.NotFound(w, r)
+		return
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	before, _ := strconv.ParseInt(r.URL.Query().Get("before"), 10, 64)
+
+	f.mu.Lock()
+	f.befores = append(f.befores, before)
+	f.mu.Unlock()
+
+	response := RecentlyPlayedResponseCustom{Items: []RecentlyPlayedTrack{}}
+	remaining := 0
+	for _, item := range f.items {
+		playedAt, _ := time.Parse(time.RFC3339, item.PlayedAt)
+		if before > 0 && playedAt.UnixMilli() >= before {
+			continue
+		}
+		if len(response.Items) < limit {
+			response.Items = append(response.Items, item)
+		} else {
+			remaining++
+		}
+	}
+
+	if len(response.Items) > 0 && remaining > 0 {
+		last, _ := time.Parse(time.RFC3339, response.Items[len(response.Items)-1].PlayedAt)
+		response.Next = "next"
+		response.Cursors = &struct {
+			After  string `json:"after"`
+			Before string `json:"before"`
+		}{Before: strconv.FormatInt(last.UnixMilli(), 10)}
+	}
+
+	json.NewEncoder(w).Encode(response)
+}
+
+func newTestTrackingService(cfg *config.Config) *TrackingService {
+	if cfg.SessionSaveMode == "" {
+		cfg.SessionSaveMode = "fixed"
+	}
+	return NewTrackingService(cfg, nil)
+}
+
+func TestFetchRecentlyPlayedWindowFollowsBeforeCursor(t *testing.T) {
+	fake := newFakeRecentlyPlayed(120, time.Now().Add(-time.Hour))
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	s := newTestTrackingService(&config.Config{SpotifyAPIBaseURL: server.URL})
+
+	tracks, err := s.fetchRecentlyPlayedWindow("user", "token", 200)
+	if err != nil {
+		t.Fatalf("fetchRecentlyPlayedWindow: %v", err)
+	}
+
+	if len(fake.befores) != 3 {
+		t.Fatalf("fetched %d pages, want 3 (befores %v)", len(fake.befores), fake.befores)
+	}
+	if fake.befores[0] != 0 {
+		t.Errorf("first page should not send a cursor, got before=%d", fake.befores[0])
+	}
+	for i := 1; i < len(fake.befores); i++ {
+		if fake.befores[i] <= 0 || (i > 1 && fake.befores[i] >= fake.befores[i-1]) {
+			t.Errorf("page %d cursor %d does not move backwards (befores %v)", i, fake.befores[i], fake.befores)
+		}
+	}
+
+	if len(tracks) != len(fake.items) {
+		t.Fatalf("got %d plays, want %d", len(tracks), len(fake.items))
+	}
+	seen := make(map[string]bool)
+	for _, item := range tracks {
+		key := item.Track.ID + "|" + item.PlayedAt
+		if seen[key] {
+			t.Errorf("play %s returned twice", key)
+		}
+		seen[key] = true
+	}
+}
+
+func TestSyncUserRecentlyPlayedSavesEveryPageOnce(t *testing.T) {
+	db := openTestDB(t)
+	userID := createTestUser(t, db)
+
+	fake := newFakeRecentlyPlayed(120, time.Now().Add(-time.Hour))
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	s := NewTrackingService(&config.Config{
+		SpotifyAPIBaseURL: server.URL,
+		SyncMaxTracks:     200,
+		SessionSaveMode:   "fixed",
+	}, db)
+	tracking := &UserTracking{UserID: userID, SpotifyToken: "token", IsActive: true}
+
+	s.syncUserRecentlyPlayed(tracking)
+	if got := countHistory(t, db, userID); got != len(fake.items) {
+		t.Fatalf("saved %d plays after first sync, want %d", got, len(fake.items))
+	}
+
+	// Rodar de novo busca as mesmas páginas e não pode duplicar nada
+	s.syncUserRecentlyPlayed(tracking)
+	if got := countHistory(t, db, userID); got != len(fake.items) {
+		t.Fatalf("saved %d plays after second sync, want %d", got, len(fake.items))
+	}
+}
+
+func TestConcurrentSaveListeningSessionKeepsOneRow(t *testing.T) {
+	db := openTestDB(t)
+	userID := createTestUser(t, db)
+
+	// Detalhes de artista indisponíveis: o artista é gravado só com os dados básicos
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	s := NewTrackingService(&config.Config{
+		SpotifyAPIBaseURL:    server.URL,
+		SessionSaveMode:      "fixed",
+		SessionSaveMinPlayed: 30 * time.Second,
+	}, db)
+
+	track := &CurrentlyPlayingTrack{
+		ID:         "concurrent-track",
+		Name:       "Concurrent",
+		Artists:    []SpotifyArtist{{ID: "concurrent-artist", Name: "Artist"}},
+		Album:      SpotifyAlbum{ID: "concurrent-album", Name: "Album"},
+		DurationMs: 200000,
+	}
+	sessionStart := time.Now().Add(-5 * time.Minute).UTC().Truncate(time.Second)
+
+	// Fim de sessão do tracker e sync periódico gravando a mesma escuta ao mesmo tempo
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.saveListeningSession(&UserTracking{
+				UserID:        userID,
+				SpotifyToken:  "token",
+				LastTrack:     track,
+				SessionStart:  sessionStart,
+				TotalPlayTime: 120000,
+			})
+		}()
+	}
+	wg.Wait()
+
+	if got := countHistory(t, db, userID); got != 1 {
+		t.Fatalf("saved %d rows for the same session, want 1", got)
+	}
+}
+
+func TestMinPlayTimeToSave(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       string
+		durationMs int
+		want       time.Duration
+	}{
+		{"fixed short track", "fixed", 45000, 30 * time.Second},
+		{"fixed long track", "fixed", 600000, 30 * time.Second},
+		{"fixed unknown duration", "fixed", 0, 30 * time.Second},
+		{"percentage 45s track", "percentage", 45000, 18 * time.Second},
+		{"percentage 75s track", "percentage", 75000, 30 * time.Second},
+		{"percentage long track", "percentage", 600000, 30 * time.Second},
+		{"percentage unknown duration", "percentage", 0, 30 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestTrackingService(&config.Config{
+				SessionSaveMode:       tt.mode,
+				SessionSaveMinPlayed:  30 * time.Second,
+				SessionSaveMinPercent: 40,
+			})
+			if got := s.minPlayTimeToSave(tt.durationMs); got != tt.want.Milliseconds() {
+				t.Errorf("minPlayTimeToSave(%d) = %dms, want %dms", tt.durationMs, got, tt.want.Milliseconds())
+			}
+		})
+	}
+}
+
+// Escuta gravada pela máquina de estados, com o estado no momento da gravação
+type savedSession struct {
+	TrackID       string
+	SessionStart  time.Time
+	TotalPlayTime int64
+}
+
+// Tracker sem banco que registra as gravações em vez de fazê-las
+func newRecordingTracker(pauseFlush time.Duration) (*TrackingService, *[]savedSession) {
+	s := newTestTrackingService(&config.Config{TrackingPauseFlush: pauseFlush})
+	saved := &[]savedSession{}
+	s.saveSession = func(tracking *UserTracking) {
+		*saved = append(*saved, savedSession{
+			TrackID:       tracking.LastTrack.ID,
+			SessionStart:  tracking.SessionStart,
+			TotalPlayTime: tracking.TotalPlayTime,
+		})
+	}
+	return s, saved
+}
+
+// Uma consulta ao /me/player: a faixa, se está tocando e o progresso, vista em start+offset
+type playerPoll struct {
+	offset   time.Duration
+	trackID  string
+	playing  bool
+	progress time.Duration
+}
+
+func drivePolls(s *TrackingService, tracking *UserTracking, start time.Time, polls []playerPoll) {
+	for _, poll := range polls {
+		s.advanceTracking(tracking, &CurrentlyPlayingTrack{
+			ID:         poll.trackID,
+			Name:       poll.trackID,
+			DurationMs: 600000,
+			IsPlaying:  poll.playing,
+			ProgressMs: int(poll.progress.Milliseconds()),
+		}, start.Add(poll.offset))
+	}
+}
+
+func newTestTracking(start time.Time) *UserTracking {
+	return &UserTracking{UserID: "user", IsActive: true, LastUpdated: start, LastPlaybackSeen: start}
+}
+
+func TestAdvanceTrackingPauseThenResume(t *testing.T) {
+	s, saved := newRecordingTracker(10 * time.Minute)
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tracking := newTestTracking(start)
+
+	// Pausa curta (abaixo do flush): a mesma escuta continua e o tempo pausado não conta
+	drivePolls(s, tracking, start, []playerPoll{
+		{0, "a", true, 0},
+		{15 * time.Second, "a", true, 15 * time.Second},
+		{30 * time.Second, "a", false, 30 * time.Second},
+		{3 * time.Minute, "a", false, 30 * time.Second},
+		{5 * time.Minute, "a", true, 30 * time.Second},
+		{5*time.Minute + 15*time.Second, "a", true, 45 * time.Second},
+		{5*time.Minute + 30*time.Second, "b", true, 0},
+	})
+
+	if len(*saved) != 1 {
+		t.Fatalf("saved %d sessions, want 1: %+v", len(*saved), *saved)
+	}
+	got := (*saved)[0]
+	if got.TrackID != "a" || !got.SessionStart.Equal(start) {
+		t.Errorf("saved %+v, want track a started at %v", got, start)
+	}
+	if got.TotalPlayTime != 30000 {
+		t.Errorf("TotalPlayTime = %dms, want 30000ms (paused time must not count)", got.TotalPlayTime)
+	}
+}
+
+func TestAdvanceTrackingLongPauseThenResume(t *testing.T) {
+	s, saved := newRecordingTracker(10 * time.Minute)
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tracking := newTestTracking(start)
+
+	// Pausa acima do flush grava a escuta; a retomada começa outra
+	drivePolls(s, tracking, start, []playerPoll{
+		{0, "a", true, 0},
+		{15 * time.Second, "a", true, 15 * time.Second},
+		{30 * time.Second, "a", false, 30 * time.Second},
+		{11 * time.Minute, "a", false, 30 * time.Second},
+		{12 * time.Minute, "a", true, 30 * time.Second},
+		{12*time.Minute + 15*time.Second, "a", true, 45 * time.Second},
+		{12*time.Minute + 30*time.Second, "b", true, 0},
+	})
+
+	if len(*saved) != 2 {
+		t.Fatalf("saved %d sessions, want 2: %+v", len(*saved), *saved)
+	}
+	if first := (*saved)[0]; first.TrackID != "a" || first.TotalPlayTime != 15000 || !first.SessionStart.Equal(start) {
+		t.Errorf("flushed session = %+v, want track a, 15000ms, started at %v", first, start)
+	}
+	resumedAt := start.Add(12 * time.Minute)
+	if second := (*saved)[1]; second.TrackID != "a" || second.TotalPlayTime != 15000 || !second.SessionStart.Equal(resumedAt) {
+		t.Errorf("resumed session = %+v, want track a, 15000ms, started at %v", second, resumedAt)
+	}
+}
+
+func TestAdvanceTrackingPauseThenSwitch(t *testing.T) {
+	tests := []struct {
+		name       string
+		pauseFlush time.Duration
+		switchAt   time.Duration
+	}{
+		// Troca depois de uma pausa curta grava a escuta na troca
+		{"short pause", 10 * time.Minute, 2 * time.Minute},
+		// Faixa esquecida pausada por uma hora: gravada no flush, a troca não grava de novo
+		{"long pause", 10 * time.Minute, time.Hour},
+		// Sem flush a escuta só é gravada na troca, sem a hora pausada
+		{"flush disabled", 0, time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, saved := newRecordingTracker(tt.pauseFlush)
+			start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+			tracking := newTestTracking(start)
+
+			drivePolls(s, tracking, start, []playerPoll{
+				{0, "a", true, 0},
+				{15 * time.Second, "a", true, 15 * time.Second},
+				{30 * time.Second, "a", false, 30 * time.Second},
+				{tt.switchAt - 15*time.Second, "a", false, 30 * time.Second},
+				{tt.switchAt, "b", true, 0},
+			})
+
+			if len(*saved) != 1 {
+				t.Fatalf("saved %d sessions, want 1: %+v", len(*saved), *saved)
+			}
+			if got := (*saved)[0]; got.TrackID != "a" || got.TotalPlayTime != 15000 {
+				t.Errorf("saved %+v, want track a with 15000ms", got)
+			}
+			if tracking.LastTrack.ID != "b" || tracking.TotalPlayTime != 0 || !tracking.SessionStart.Equal(start.Add(tt.switchAt)) {
+				t.Errorf("after switch tracking = %s/%dms/%v, want b/0ms/%v",
+					tracking.LastTrack.ID, tracking.TotalPlayTime, tracking.SessionStart, start.Add(tt.switchAt))
+			}
+		})
+	}
+}
+
+func TestSteadyPlaybackProducesOneSession(t *testing.T) {
+	s, saved := newRecordingTracker(10 * time.Minute)
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tracking := newTestTracking(start)
+
+	// 20 consultas a cada 15s com o progresso acompanhando o relógio (com a latência da API)
+	const steadyPolls = 20
+	var polls []playerPoll
+	for i := 0; i < steadyPolls; i++ {
+		jitter := time.Duration(i%3-1) * 800 * time.Millisecond
+		polls = append(polls, playerPoll{
+			offset:   time.Duration(i) * trackingTickInterval,
+			trackID:  "a",
+			playing:  true,
+			progress: max(time.Duration(i)*trackingTickInterval+jitter, 0),
+		})
+	}
+	drivePolls(s, tracking, start, polls)
+
+	if len(*saved) != 0 {
+		t.Fatalf("steady playback saved %d sessions before the track changed", len(*saved))
+	}
+
+	drivePolls(s, tracking, start, []playerPoll{{steadyPolls * trackingTickInterval, "b", true, 0}})
+
+	if len(*saved) != 1 {
+		t.Fatalf("saved %d sessions, want 1: %+v", len(*saved), *saved)
+	}
+	want := ((steadyPolls - 1) * trackingTickInterval).Milliseconds()
+	if got := (*saved)[0]; got.TrackID != "a" || got.TotalPlayTime != want || !got.SessionStart.Equal(start) {
+		t.Errorf("saved %+v, want track a with %dms started at %v", got, want, start)
+	}
+}
+
+// Troca de device, shuffle e repeat no meio da faixa sem sair do caminho rápido: a escuta grava o estado mais recente
+func TestSteadyPlaybackKeepsLatestPlayerState(t *testing.T) {
+	s := newTestTrackingService(&config.Config{TrackingPauseFlush: 10 * time.Minute})
+	var savedState CurrentlyPlayingTrack
+	s.saveSession = func(tracking *UserTracking) { savedState = *tracking.LastTrack }
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tracking := newTestTracking(start)
+
+	s.advanceTracking(tracking, &CurrentlyPlayingTrack{
+		ID: "a", DurationMs: 600000, IsPlaying: true,
+		Device: &PlaybackDevice{Type: "Computer"}, RepeatState: "off",
+	}, start)
+	s.advanceTracking(tracking, &CurrentlyPlayingTrack{
+		ID: "a", DurationMs: 600000, IsPlaying: true, ProgressMs: 15000,
+		Device: &PlaybackDevice{Type: "Speaker"}, ShuffleState: true, RepeatState: "track",
+	}, start.Add(trackingTickInterval))
+	s.advanceTracking(tracking, &CurrentlyPlayingTrack{ID: "b", DurationMs: 600000, IsPlaying: true}, start.Add(2*trackingTickInterval))
+
+	if savedState.ID != "a" || savedState.Device == nil || savedState.Device.Type != "Speaker" ||
+		!savedState.ShuffleState || savedState.RepeatState != "track" {
+		t.Errorf("saved player state = device %+v, shuffle %t, repeat %q; want Speaker, true, track",
+			savedState.Device, savedState.ShuffleState, savedState.RepeatState)
+	}
+}
+
+// Fonte de tokens falsa: cada chamada devolve o próximo token, ou o erro se houver
+type fakeTokenSource struct {
+	tokens []string
+	err    error
+	calls  int
+}
+
+func (f *fakeTokenSource) Token() (*oauth2.Token, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	token := f.tokens[min(f.calls, len(f.tokens)-1)]
+	f.calls++
+	return &oauth2.Token{AccessToken: token}, nil
+}
+
+func TestRefreshTrackingTokenUsesLinkedTokenSource(t *testing.T) {
+	s := newTestTrackingService(&config.Config{})
+	source := &fakeTokenSource{tokens: []string{"first", "refreshed"}}
+	if err := s.StartLinkedTracking("user", "linked", source); err != nil {
+		t.Fatalf("StartLinkedTracking: %v", err)
+	}
+	tracking := s.activeTracking[trackingKey("user", "linked")]
+	if tracking.SpotifyToken != "first" {
+		t.Fatalf("token after start = %q, want first", tracking.SpotifyToken)
+	}
+
+	if !s.refreshTrackingToken(tracking) || tracking.SpotifyToken != "refreshed" {
+		t.Errorf("token after refresh = %q, want refreshed", tracking.SpotifyToken)
+	}
+
+	source.err = fmt.Errorf("refresh token revoked")
+	if s.refreshTrackingToken(tracking) {
+		t.Error("refreshTrackingToken succeeded with a failing token source")
+	}
+	if tracking.SpotifyToken != "refreshed" {
+		t.Errorf("token after failed refresh = %q, want the previous token kept", tracking.SpotifyToken)
+	}
+
+	primary := &UserTracking{UserID: "user", SpotifyToken: "primary"}
+	if !s.refreshTrackingToken(primary) || primary.SpotifyToken != "primary" {
+		t.Errorf("primary account token changed to %q", primary.SpotifyToken)
+	}
+}
+
+// Spotify falso para a rodada do tracker: nada tocando e recently-played vazio, contando as chamadas de cada um
+type fakePlayerAPI struct {
+	mu             sync.Mutex
+	player         int
+	recentlyPlayed int
+}
+
+func (f *fakePlayerAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.URL.Path == "/v1/me/player" {
+		f.player++
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	f.recentlyPlayed++
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"items": []}`))
+}
+
+func (f *fakePlayerAPI) counts() (int, int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.player, f.recentlyPlayed
+}
+
+func addActiveTracking(s *TrackingService, userID string, lastRecentlyPlayedSync time.Time) *UserTracking {
+	tracking := &UserTracking{UserID: userID, IsActive: true, LastPlaybackSeen: time.Now(), lastRecentlyPlayedSync: lastRecentlyPlayedSync}
+	s.activeTracking[trackingKey(userID, "")] = tracking
+	return tracking
+}
+
+func TestDispatchTrackingTickSyncsRecentlyPlayedAtLowerCadence(t *testing.T) {
+	fake := &fakePlayerAPI{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	s := newTestTrackingService(&config.Config{SpotifyAPIBaseURL: server.URL})
+	synced := addActiveTracking(s, "user", time.Now().Add(-time.Minute))
+
+	s.dispatchTrackingTick()
+	if player, recent := fake.counts(); player != 1 || recent != 0 {
+		t.Fatalf("first round: %d player and %d recently-played calls, want 1 and 0", player, recent)
+	}
+
+	synced.lastRecentlyPlayedSync = time.Now().Add(-recentlyPlayedSyncInterval)
+	s.dispatchTrackingTick()
+	if player, recent := fake.counts(); player != 2 || recent != 1 {
+		t.Fatalf("after the interval: %d player and %d recently-played calls, want 2 and 1", player, recent)
+	}
+	if time.Since(synced.lastRecentlyPlayedSync) > time.Minute {
+		t.Errorf("lastRecentlyPlayedSync not updated after the sync")
+	}
+}
+
+func TestStopPeriodicTrackingInterruptsRound(t *testing.T) {
+	fake := &fakePlayerAPI{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	s := newTestTrackingService(&config.Config{SpotifyAPIBaseURL: server.URL})
+	// Dois usuários: o segundo só seria atendido na metade do tick de 15s
+	addActiveTracking(s, "first", time.Now())
+	addActiveTracking(s, "second", time.Now())
+
+	stopped := make(chan bool, 1)
+	go func() { stopped <- s.dispatchTrackingTick() }()
+
+	sent := make(chan struct{})
+	go func() {
+		s.StopPeriodicTracking()
+		close(sent)
+	}()
+
+	select {
+	case <-sent:
+	case <-time.After(2 * time.Second):
+		t.Fatal("StopPeriodicTracking blocked until the end of the round")
+	}
+	if !<-stopped {
+		t.Error("dispatchTrackingTick did not report the stop")
+	}
+	if player, _ := fake.counts(); player > 1 {
+		t.Errorf("%d player calls, want at most 1 (round should stop before the second user)", player)
+	}
+}
+
+func TestStopPeriodicTrackingInterruptsLimiterWait(t *testing.T) {
+	fake := &fakePlayerAPI{}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	// Limite de uma chamada por minuto já esgotado: a rodada fica presa na espera do limite
+	s := newTestTrackingService(&config.Config{SpotifyAPIBaseURL: server.URL, TrackingRateLimit: 1})
+	s.spotifyLimiter.wait(nil)
+	addActiveTracking(s, "first", time.Now())
+
+	stopped := make(chan bool, 1)
+	go func() { stopped <- s.dispatchTrackingTick() }()
+	time.Sleep(50 * time.Millisecond)
+	s.StopPeriodicTracking()
+
+	select {
+	case got := <-stopped:
+		if !got {
+			t.Error("dispatchTrackingTick did not report the stop")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("dispatchTrackingTick kept waiting for the limiter after the stop")
+	}
+	if player, _ := fake.counts(); player != 0 {
+		t.Errorf("%d player calls, want 0 (the tick should be skipped)", player)
+	}
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Janela depois da primeira escuta que define o "arranque" da faixa
+const velocityWindow = 7 * 24 * time.Hour
+
+type TrackVelocity struct {
+	TrackID        string    `json:"track_id"`
+	TrackName      string    `json:"track_name"`
+	Artists        []string  `json:"artists"`
+	ImageURL       string    `json:"image_url,omitempty"`
+	DiscoveredAt   time.Time `json:"discovered_at"` // primeira escuta no histórico todo
+	FirstWeekPlays int       `json:"first_week_plays"`
+	LaterPlays     int       `json:"later_plays"`
+	TotalPlays     int       `json:"total_plays"`
+	Velocity       float64   `json:"velocity"`         // escutas por dia na primeira semana
+	LaterDailyRate float64   `json:"later_daily_rate"` // escutas por dia depois da primeira semana até agora
+	FirstWeekShare float64   `json:"first_week_share"` // fração das escutas na primeira semana
+	Pattern        string    `json:"pattern"`          // instant_obsession, slow_burn, steady ou new (menos de uma semana)
+}
+
+// Faixas descobertas no período (primeira escuta dentro dele) com pelo menos minPlays escutas, das que mais
+// rápido engrenaram para as mais lentas. Uma obsessão instantânea concentra as escutas na primeira semana;
+// uma descoberta lenta ganha escutas depois
+func (a *AnalyticsService) GetTrackVelocity(ctx context.Context, userID, timeFilter string, minPlays, limit int) ([]TrackVelocity, error) {
+	if a.db == nil {
+		return nil, fmt.Errorf("database not available")
+	}
+
+	ctx, cancel := a.queryContext(ctx)
+	defer cancel()
+
+	// A primeira escuta olha o histórico inteiro; só depois filtra pelas descobertas do período
+	rows, err := a.db.QueryContext(ctx, `
+		WITH plays AS (
+			SELECT
+				lh.track_id,
+				MIN(lh.played_at) as discovered_at,
+				COUNT(*) as total_plays
+			FROM listening_history lh
+			WHERE lh.user_id = $1 AND lh.deleted_at IS NULL
+			GROUP BY lh.track_id
+			HAVING MIN(lh.played_at) >= $2 AND COUNT(*) >= $3
+		),
+		windowed AS (
+			SELECT p.track_id, p.discovered_at, p.total_plays, COUNT(*) as first_week_plays
+			FROM plays p
+			JOIN listening_history lh ON lh.track_id = p.track_id
+				AND lh.user_id = $1 AND lh.deleted_at IS NULL
+				AND lh.played_at < p.discovered_at + $4::int * INTERVAL '1 second'
+			GROUP BY p.track_id, p.discovered_at, p.total_plays
+			ORDER BY first_week_plays DESC, p.total_plays DESC, p.track_id
+			LIMIT $5
+		)
+		SELECT
+			t.id,
+			t.name,
+			ARRAY_REMOVE(ARRAY_AGG(ar.name ORDER BY ar.name), NULL) as artists,
+			COALESCE(al.image_url, ''),
+			w.discovered_at,
+			w.first_week_plays,
+			w.total_plays
+		FROM windowed w
+		JOIN tracks t ON t.id = w.track_id
+		LEFT JOIN albums al ON al.id = t.album_id
+		LEFT JOIN track_artists ta ON ta.track_id = t.id
+		LEFT JOIN artists ar ON ar.id = ta.artist_id
+		GROUP BY t.id, t.name, al.image_url, w.discovered_at, w.first_week_plays, w.total_plays
+		ORDER BY w.first_week_plays DESC, w.total_plays DESC, t.id
+	`, userID, timeFilterStartDate(timeFilter), minPlays, int(velocityWindow.Seconds()), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query track velocity: %w", err)
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	tracks := make([]TrackVelocity, 0)
+	for rows.Next() {
+		var track TrackVelocity
+		var artists pq.StringArray
+		if err := rows.Scan(&track.TrackID, &track.TrackName, &artists, &track.ImageURL, &track.DiscoveredAt,
+			&track.FirstWeekPlays, &track.TotalPlays); err != nil {
+			continue
+		}
+		track.Artists = []string(artists)
+		track.LaterPlays = track.TotalPlays - track.FirstWeekPlays
+		track.FirstWeekShare = roundFraction(float64(track.FirstWeekPlays) / float64(track.TotalPlays))
+
+		velocityDays := velocityWindow.Hours() / 24
+		track.Velocity = math.Round(float64(track.FirstWeekPlays)/velocityDays*100) / 100
+
+		sinceWindow := now.Sub(track.DiscoveredAt.Add(velocityWindow))
+		if sinceWindow > 0 {
+			track.LaterDailyRate = math.Round(float64(track.LaterPlays)/math.Max(sinceWindow.Hours()/24, 1)*100) / 100
+		}
+		track.Pattern = velocityPattern(track.FirstWeekShare, sinceWindow > 0)
+
+		tracks = append(tracks, track)
+	}
+
+	return tracks, rows.Err()
+}
+
+func velocityPattern(firstWeekShare float64, pastFirstWeek bool) string {
+	switch {
+	case !pastFirstWeek:
+		return "new"
+	case firstWeekShare >= 0.6:
+		return "instant_obsession"
+	case firstWeekShare <= 0.3:
+		return "slow_burn"
+	default:
+		return "steady"
+	}
+}
@@ -0,0 +1,120 @@
+package services
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"musike-backend/internal/config"
+)
+
+type MilestoneEvent struct {
+	Event     string    `json:"event"` // milestone.reached
+	UserID    string    `json:"user_id"`
+	Milestone string    `json:"milestone"` // plays, tracks, artists, minutes
+	Threshold int64     `json:"threshold"`
+	Current   int64     `json:"current"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Envia eventos para um webhook externo. Sem URL configurada, Notify não faz nada
+type WebhookNotifier struct {
+	url     string
+	secret  string
+	retries int
+	client  *http.Client
+}
+
+func NewWebhookNotifier(cfg *config.Config) *WebhookNotifier {
+	return &WebhookNotifier{
+		url:     cfg.MilestoneWebhookURL,
+		secret:  cfg.MilestoneWebhookSecret,
+		retries: max(cfg.MilestoneWebhookRetries, 0),
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (w *WebhookNotifier) Enabled() bool {
+	return w != nil && w.url != ""
+}
+
+// Fire-and-forget: a entrega (com retry e backoff exponencial) roda em background
+func (w *WebhookNotifier) Notify(event interface{}) {
+	if !w.Enabled() {
+		return
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error encoding webhook payload: %v", err)
+		return
+	}
+
+	go w.deliver(body)
+}
+
+func (w *WebhookNotifier) deliver(body []byte) {
+	backoff := time.Second
+	for attempt := 0; attempt <= w.retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		retryable, err := w.post(body)
+		if err == nil {
+			return
+		}
+
+		log.Printf("Webhook delivery attempt %d/%d failed: %v", attempt+1, w.retries+1, err)
+		if !retryable {
+			return
+		}
+	}
+
+	log.Printf("Giving up on webhook delivery to %s", w.url)
+}
+
+// Erros de rede, 429 e 5xx podem ser repetidos; outros 4xx não
+func (w *WebhookNotifier) post(body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Musike-Timestamp", timestamp)
+	if w.secret != "" {
+		req.Header.Set("X-Musike-Signature", "sha256="+signWebhook(w.secret, timestamp, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	retryable := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retryable, fmt.Errorf("webhook returned status %d", resp.StatusCode)
+}
+
+// HMAC-SHA256 de "<timestamp>.<body>"; o timestamp assinado impede replay de payloads antigos
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
+	"errors"
 	"log"
 	"net/http"
+	"os/signal"
+	"syscall"
+	"time"
+	_ "time/tzdata"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -20,11 +26,14 @@ func main() {
 	}
 
 	cfg := config.Load()
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
 
 	db, err := database.Connect(cfg)
 	if err != nil {
 		log.Printf("Warning: Failed to connect to database: %v", err)
-		log.Println("Continuing without database-dependent features...")
+		log.Println("Continuing without database-dependent features (tracking, history, imports)...")
 	}
 	if db != nil {
 		defer db.Close()
@@ -33,22 +42,55 @@ func main() {
 	spotifyService := services.NewSpotifyService(cfg)
 	authService := services.NewAuthService(cfg)
 	analyticsService := services.NewAnalyticsService(cfg, db)
+	preferencesService := services.NewPreferencesService(cfg, db)
+	linkedAccounts := services.NewLinkedAccountsService(db, authService)
 
 	var trackingService *services.TrackingService
 	var trackingHandler *handlers.TrackingHandler
+	var tokenStore *services.SpotifyTokenStore
+	// Paradas das goroutines de fundo, chamadas depois que o servidor HTTP terminar de responder
+	var stops []func()
 	if db != nil {
+		tokenStore = services.NewSpotifyTokenStore(db, authService)
 		trackingService = services.NewTrackingService(cfg, db)
-		trackingHandler = handlers.NewTrackingHandler(trackingService, authService)
+		trackingHandler = handlers.NewTrackingHandler(trackingService, authService, spotifyService, preferencesService, linkedAccounts)
 
 		go trackingService.StartPeriodicTracking()
+		stops = append(stops, trackingService.StopPeriodicTracking)
 		log.Println("🎵 Spotify tracking service started")
+
+		if cfg.AnalyticsPrecomputeEnabled {
+			precomputeJob := services.NewAnalyticsPrecomputeJob(cfg, analyticsService, spotifyService, trackingService)
+			go precomputeJob.Start()
+			stops = append(stops, precomputeJob.Stop)
+			log.Println("📊 Analytics precompute job started")
+		}
 	} else {
 		log.Println("⚠️  Database not available - tracking service disabled")
 	}
 
-	authHandler := handlers.NewAuthHandler(authService, spotifyService, db, trackingService)
-	analyticsHandler := handlers.NewAnalyticsHandler(analyticsService, spotifyService)
-	importHandler := handlers.NewImportHandler(db)
+	authHandler := handlers.NewAuthHandler(authService, spotifyService, db, trackingService, tokenStore, preferencesService, linkedAccounts)
+	analyticsHandler := handlers.NewAnalyticsHandler(analyticsService, spotifyService, tokenStore)
+	importHandler := handlers.NewImportHandler(db, spotifyService, trackingService, cfg.ImportMaxConcurrent)
+	imageHandler := handlers.NewImageHandler(db, cfg)
+	preferencesHandler := handlers.NewPreferencesHandler(preferencesService)
+	linkedAccountsHandler := handlers.NewLinkedAccountsHandler(linkedAccounts, trackingService)
+	playlistHandler := handlers.NewPlaylistHandler(spotifyService, authService, tokenStore, cfg.PlaylistScope())
+	adminHandler := handlers.NewAdminHandler(services.NewStatsRecomputeJob(analyticsService))
+
+	if cfg.JanitorInterval > 0 {
+		janitor := services.NewJanitor(cfg.JanitorInterval)
+		janitor.Register("expired OAuth codes", authHandler.SweepProcessedCodes)
+		if cfg.SpotifyCacheEnabled {
+			janitor.Register("expired Spotify cache entries", services.NewResponseCache(cfg).Sweep)
+		}
+		if trackingService != nil {
+			janitor.Register("stale tracking sessions", trackingService.SweepIdleTracking)
+		}
+		go janitor.Start()
+		stops = append(stops, janitor.Stop)
+		log.Printf("🧹 Janitor started (every %v)", cfg.JanitorInterval)
+	}
 
 	r := gin.Default()
 
@@ -75,56 +117,181 @@ func main() {
 		public.GET("/auth/spotify", authHandler.SpotifyAuth)
 		public.GET("/auth/callback", authHandler.SpotifyCallback)
 		public.POST("/auth/refresh", authHandler.RefreshToken)
-		public.POST("/import/spotify-final", importHandler.ImportSpotifyData)
+		public.GET("/images/artist/:id", imageHandler.GetArtistImage)
+		public.GET("/images/album/:id", imageHandler.GetAlbumImage)
+		public.GET("/images/placeholder", imageHandler.GetPlaceholderImage)
+		public.GET("/user/history.ics", analyticsHandler.GetHistoryFeed)
+		public.GET("/insights/global", analyticsHandler.GetGlobalInsights)
 	}
 
 	protected := r.Group("/api/v1")
 	protected.Use(middleware.Auth(authService))
+	protected.Use(middleware.RequestDefaults(preferencesService))
+
+	// ?units= só nas rotas que devolvem campos *_ms
+	durationUnits := middleware.DurationUnits()
+
+	// Funcionalidades experimentais desligadas em FEATURES ficam registradas fora do Auth respondendo 404, para
+	// não pedir login num endpoint que não existe
+	feature := func(method, path, name string, handlerChain ...gin.HandlerFunc) {
+		if cfg.FeatureEnabled(name) {
+			protected.Handle(method, path, handlerChain...)
+			return
+		}
+		public.Handle(method, path, handlers.FeatureDisabled)
+	}
 	{
 		protected.GET("/user/profile", analyticsHandler.GetUserProfile)
-		protected.GET("/user/top-tracks", analyticsHandler.GetTopTracks)
+		protected.GET("/user/top-tracks", durationUnits, analyticsHandler.GetTopTracks)
 		protected.GET("/user/top-artists", analyticsHandler.GetTopArtists)
-		protected.GET("/user/listening-history", analyticsHandler.GetListeningHistory)
-		protected.GET("/user/recently-played", analyticsHandler.GetRecentlyPlayed)
-		protected.GET("/user/analytics", analyticsHandler.GetUserAnalytics)
-		protected.GET("/user/recommendations", analyticsHandler.GetRecommendations)
+		protected.GET("/user/top-artists/enriched", durationUnits, analyticsHandler.GetEnrichedTopArtists)
+		protected.GET("/user/unenriched-artists", analyticsHandler.GetUnenrichedArtists)
+		protected.PUT("/artists/:id/genres", analyticsHandler.SetArtistGenres)
+		protected.DELETE("/artists/:id/genres", analyticsHandler.DeleteArtistGenres)
+		protected.GET("/user/exclusions", analyticsHandler.GetExclusions)
+		protected.PUT("/user/exclusions", analyticsHandler.UpdateExclusions)
+		protected.GET("/user/listening-history", durationUnits, analyticsHandler.GetListeningHistory)
+		protected.GET("/user/recently-played", durationUnits, analyticsHandler.GetRecentlyPlayed)
+		protected.GET("/user/analytics", durationUnits, analyticsHandler.GetUserAnalytics)
+		protected.GET("/user/stats/summary", analyticsHandler.GetStatsSummary)
+		protected.GET("/user/recommendations", durationUnits, analyticsHandler.GetRecommendations)
+		protected.GET("/user/history", durationUnits, analyticsHandler.GetHistory)
+		protected.GET("/user/history/since", durationUnits, analyticsHandler.GetHistorySince)
+		protected.GET("/user/history/date/:date", durationUnits, analyticsHandler.GetHistoryByDate)
+		protected.GET("/user/on-this-day", analyticsHandler.GetOnThisDay)
+		protected.GET("/user/history/by-genre/:genre", durationUnits, analyticsHandler.GetHistoryByGenre)
+		protected.DELETE("/user/history/:historyID", analyticsHandler.DeleteHistoryEntry)
+		protected.POST("/user/history/:historyID/restore", analyticsHandler.RestoreHistoryEntry)
+		protected.GET("/user/calendar", analyticsHandler.GetCalendar)
+		protected.GET("/user/timeseries", analyticsHandler.GetTimeSeries)
+		feature(http.MethodGet, "/user/momentum", "momentum", analyticsHandler.GetMomentum)
+		protected.GET("/user/gaps", analyticsHandler.GetListeningGaps)
+		feature(http.MethodGet, "/user/top5-card", "top5_card", analyticsHandler.GetTop5Card)
+		protected.GET("/user/fun-facts", analyticsHandler.GetFunFacts)
+		protected.GET("/user/records", analyticsHandler.GetListeningRecords)
+		protected.GET("/user/eras", analyticsHandler.GetMusicEras)
+		protected.GET("/user/release-years", analyticsHandler.GetReleaseYears)
+		protected.GET("/user/full-album-listens", analyticsHandler.GetFullAlbumListens)
+		protected.GET("/user/milestones", analyticsHandler.GetMilestones)
+		protected.GET("/user/estimate", analyticsHandler.GetListeningEstimate)
+		protected.GET("/user/this-week", analyticsHandler.GetWeeklyComparison)
+		feature(http.MethodGet, "/user/period-overlap", "period_overlap", analyticsHandler.GetPeriodOverlap)
+		protected.GET("/user/artists/:id/top-tracks", durationUnits, analyticsHandler.GetArtistTopTracks)
+		protected.GET("/user/diversity/timeline", analyticsHandler.GetDiversityTimeline)
+		protected.GET("/user/daily-diversity", analyticsHandler.GetDailyDiversity)
+		protected.GET("/user/entropy", analyticsHandler.GetListeningEntropy)
+		feature(http.MethodGet, "/user/soundtrack", "soundtrack", durationUnits, analyticsHandler.GetSoundtrack)
+		feature(http.MethodPost, "/user/playlists/create", "playlists", playlistHandler.CreatePlaylist)
+		feature(http.MethodGet, "/user/workout-tracks", "workout", analyticsHandler.GetWorkoutTracks)
+		protected.GET("/user/routine", analyticsHandler.GetListeningRoutine)
+		protected.GET("/user/peak-by-weekday", analyticsHandler.GetPeakByWeekday)
+		protected.GET("/user/consistency", analyticsHandler.GetListeningConsistency)
+		protected.GET("/user/sessions", analyticsHandler.GetListeningSessions)
+		protected.POST("/user/sessions/:id/tag", analyticsHandler.TagListeningSession)
+		feature(http.MethodGet, "/user/binges", "binges", analyticsHandler.GetTopBinges)
+		protected.GET("/user/shuffle", analyticsHandler.GetShuffleSummary)
+		protected.GET("/user/shuffle-stats", analyticsHandler.GetShuffleStats)
+		protected.GET("/user/explicit-ratio", analyticsHandler.GetExplicitRatio)
+		protected.GET("/user/neglected", analyticsHandler.GetNeglectedFavorites)
+		protected.GET("/user/track-velocity", analyticsHandler.GetTrackVelocity)
+		protected.GET("/user/one-hit-artists", analyticsHandler.GetOneHitArtists)
+		protected.GET("/user/genres/all", analyticsHandler.GetAllGenres)
+		protected.GET("/user/genre-network", analyticsHandler.GetGenreNetwork)
+		protected.GET("/user/mainstream-score", analyticsHandler.GetMainstreamScore)
+		protected.GET("/user/affinity", analyticsHandler.GetArtistAffinity)
+		protected.GET("/user/duration-distribution", durationUnits, analyticsHandler.GetDurationDistribution)
+		protected.GET("/user/completion-funnel", analyticsHandler.GetCompletionFunnel)
+		protected.GET("/user/monthly-favorites", analyticsHandler.GetMonthlyFavorites)
+		feature(http.MethodGet, "/user/loyalty", "loyalty", analyticsHandler.GetLoyalty)
+		protected.GET("/user/genre-timeline/dominant", analyticsHandler.GetDominantGenreTimeline)
+		protected.POST("/user/feed-token", analyticsHandler.RotateFeedToken)
+		protected.GET("/user/preferences", preferencesHandler.GetPreferences)
+		protected.PUT("/user/preferences", preferencesHandler.UpdatePreferences)
+		protected.GET("/user/spotify-accounts", linkedAccountsHandler.ListAccounts)
+		protected.POST("/user/spotify-accounts/link", linkedAccountsHandler.StartLink)
+		protected.DELETE("/user/spotify-accounts/:spotifyID", linkedAccountsHandler.Unlink)
+		protected.GET("/search", durationUnits, analyticsHandler.Search)
 
 		protected.POST("/import/spotify", importHandler.ImportSpotifyData)
+		protected.POST("/import/lastfm", importHandler.ImportLastfmData)
+		protected.POST("/import/validate", importHandler.ValidateImportFiles)
 
 		if trackingHandler != nil {
 			protected.POST("/tracking/start", trackingHandler.StartTracking)
 			protected.POST("/tracking/stop", trackingHandler.StopTracking)
-			protected.GET("/tracking/current", trackingHandler.GetCurrentTrack)
+			protected.POST("/tracking/token", trackingHandler.ReplaceToken)
+			protected.POST("/tracking/resync-full", trackingHandler.ResyncFull)
+			protected.POST("/tracking/backfill", trackingHandler.BackfillHistory)
+			protected.GET("/tracking/current", durationUnits, trackingHandler.GetCurrentTrack)
+			protected.GET("/tracking/now-playing/group", durationUnits, trackingHandler.GetNowPlayingGroup)
 			protected.GET("/tracking/status", trackingHandler.GetTrackingStatus)
 			protected.GET("/tracking/history", trackingHandler.GetRecentListeningHistory)
+
+			protected.POST("/user/history", trackingHandler.AddManualPlay)
+			protected.POST("/user/enrich", trackingHandler.EnrichCatalog)
+			protected.POST("/artists/:id/enrich", trackingHandler.EnrichArtist)
 		}
 	}
 
+	admin := r.Group("/api/v1/admin")
+	admin.Use(middleware.AdminAuth(cfg.AdminAPIToken))
+	{
+		admin.POST("/recompute-stats", adminHandler.StartStatsRecompute)
+		admin.GET("/recompute-stats", adminHandler.GetStatsRecomputeProgress)
+	}
+
 	// Rota pública para sync forçado (apenas para debug)
 	public.POST("/tracking/force-sync/:userID", func(c *gin.Context) {
 		if trackingHandler != nil {
 			trackingHandler.ForceFullSync(c)
 		} else {
-			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Tracking service not available"})
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Tracking service not available", "code": handlers.ErrCodeServiceUnavailable})
 		}
 	})
 
-	if cfg.UseHTTPS {
-		log.Printf("🔐 Starting HTTPS server on port %s", cfg.Port)
+	server := &http.Server{
+		Addr:    ":" + cfg.Port,
+		Handler: r,
+	}
 
-		tlsConfig := &tls.Config{
-			MinVersion: tls.VersionTLS12,
+	serverErrors := make(chan error, 1)
+	go func() {
+		if cfg.UseHTTPS {
+			log.Printf("🔐 Starting HTTPS server on port %s", cfg.Port)
+			server.TLSConfig = &tls.Config{
+				MinVersion: tls.VersionTLS12,
+			}
+			serverErrors <- server.ListenAndServeTLS(cfg.SSLCertPath, cfg.SSLKeyPath)
+		} else {
+			log.Printf("🌐 Starting HTTP server on port %s", cfg.Port)
+			serverErrors <- server.ListenAndServe()
 		}
+	}()
 
-		server := &http.Server{
-			Addr:      ":" + cfg.Port,
-			Handler:   r,
-			TLSConfig: tlsConfig,
+	// SIGINT/SIGTERM (Ctrl+C, docker stop): para de aceitar conexões, espera as requisições em andamento e
+	// só então para as goroutines de fundo, antes do defer que fecha o banco
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var serverErr error
+	select {
+	case err := <-serverErrors:
+		if !errors.Is(err, http.ErrServerClosed) {
+			serverErr = err
+		}
+	case <-ctx.Done():
+		log.Println("Shutting down...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Error shutting down server: %v", err)
 		}
+	}
 
-		log.Fatal(server.ListenAndServeTLS(cfg.SSLCertPath, cfg.SSLKeyPath))
-	} else {
-		log.Printf("🌐 Starting HTTP server on port %s", cfg.Port)
-		log.Fatal(r.Run(":" + cfg.Port))
+	for _, stopBackground := range stops {
+		stopBackground()
+	}
+	if serverErr != nil {
+		log.Fatalf("Server error: %v", serverErr)
 	}
 }
@@ -29,4 +29,225 @@ COMMENT ON COLUMN listening_history.skipped IS 'Indica se a música foi pulada';
 COMMENT ON COLUMN listening_history.offline IS 'Indica se estava em modo offline';
 COMMENT ON COLUMN listening_history.incognito_mode IS 'Indica se estava em modo incógnito';
 COMMENT ON COLUMN listening_history.reason_start IS 'Motivo do início da reprodução';
-COMMENT ON COLUMN listening_history.reason_end IS 'Motivo do fim da reprodução';
\ No newline at end of file
+COMMENT ON COLUMN listening_history.reason_end IS 'Motivo do fim da reprodução';
+
+-- Cache dos analytics completos pré-calculados (job noturno / recálculo sob demanda)
+CREATE TABLE IF NOT EXISTS user_analytics_cache (
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    time_filter VARCHAR(20) NOT NULL,
+    payload JSONB NOT NULL,
+    computed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, time_filter)
+);
+
+-- Estado do player capturado pelo tracking ao vivo
+ALTER TABLE listening_history
+ADD COLUMN IF NOT EXISTS device_type VARCHAR(50),
+ADD COLUMN IF NOT EXISTS repeat_state VARCHAR(10);
+
+COMMENT ON COLUMN listening_history.device_type IS 'Tipo do dispositivo de reprodução (Computer, Smartphone, Speaker, etc.)';
+COMMENT ON COLUMN listening_history.repeat_state IS 'Estado do repeat durante a reprodução (off, track, context)';
+
+-- Soft delete de escutas individuais
+ALTER TABLE listening_history
+ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+
+COMMENT ON COLUMN listening_history.deleted_at IS 'Quando o usuário removeu a escuta (NULL = ativa)';
+
+-- Token somente leitura para o feed iCalendar do histórico
+ALTER TABLE users
+ADD COLUMN IF NOT EXISTS feed_token_hash VARCHAR(64) UNIQUE;
+
+-- Maiores contagens já notificadas por usuário, para disparar o webhook de marcos só ao cruzar um limite
+CREATE TABLE IF NOT EXISTS user_milestone_state (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    plays BIGINT NOT NULL DEFAULT 0,
+    tracks BIGINT NOT NULL DEFAULT 0,
+    artists BIGINT NOT NULL DEFAULT 0,
+    minutes BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Score mainstream gravado pelo recálculo administrativo (POST /api/v1/admin/recompute-stats)
+ALTER TABLE user_analytics
+ADD COLUMN IF NOT EXISTS mainstream_score DECIMAL(5,2);
+
+-- Número de faixas do álbum, usado para detectar álbuns escutados do começo ao fim
+ALTER TABLE albums
+ADD COLUMN IF NOT EXISTS total_tracks INTEGER;
+
+-- Um token do Spotify por usuário: o login faz upsert e os handlers renovam com o refresh token
+CREATE UNIQUE INDEX IF NOT EXISTS idx_spotify_tokens_user_id_unique ON spotify_tokens(user_id);
+
+-- Uma escuta por (usuário, faixa, instante): alvo do ON CONFLICT do import, do sync e do tracking ao vivo.
+-- Remove duplicatas antigas (mantém a primeira gravada) antes de criar o índice
+DELETE FROM listening_history a
+USING listening_history b
+WHERE a.user_id = b.user_id
+  AND a.track_id = b.track_id
+  AND a.played_at = b.played_at
+  AND (a.created_at, a.id) > (b.created_at, b.id);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_listening_history_unique_play ON listening_history(user_id, track_id, played_at);
+
+-- Paginação por cursor do histórico: (played_at, id) em ordem decrescente por usuário
+CREATE INDEX IF NOT EXISTS idx_listening_history_user_played_id ON listening_history(user_id, played_at DESC, id DESC);
+
+-- Tags de sessões de escuta (ex.: workout, estudo). session_id é a primeira escuta da sessão; as escutas
+-- que entram nos analytics filtrados pela tag são calculadas na leitura a partir dela
+CREATE TABLE IF NOT EXISTS session_tags (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    session_id UUID NOT NULL REFERENCES listening_history(id) ON DELETE CASCADE,
+    tag VARCHAR(32) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (user_id, session_id, tag)
+);
+
+CREATE INDEX IF NOT EXISTS idx_session_tags_user_tag ON session_tags(user_id, tag);
+
+-- Preferências do usuário; auto_start_tracking NULL segue AUTO_START_TRACKING
+CREATE TABLE IF NOT EXISTS user_preferences (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    timezone VARCHAR(64),
+    auto_start_tracking BOOLEAN,
+    weekly_report BOOLEAN,
+    default_time_filter VARCHAR(20),
+    units VARCHAR(20),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Várias contas do Spotify por usuário: contas vinculadas e a conta de origem de cada escuta
+CREATE TABLE IF NOT EXISTS linked_spotify_accounts (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    spotify_id VARCHAR(255) UNIQUE NOT NULL,
+    display_name VARCHAR(255),
+    access_token TEXT NOT NULL,
+    refresh_token TEXT,
+    expires_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_linked_spotify_accounts_user_id ON linked_spotify_accounts(user_id);
+
+ALTER TABLE listening_history ADD COLUMN IF NOT EXISTS spotify_account VARCHAR(255);
+
+-- Origem de cada escuta: só as gravadas pelo tracking são apagadas no resync completo.
+-- Linhas antigas: import tem reason_start preenchido (ou vazio, nunca NULL) e manual tem context_type 'manual'
+ALTER TABLE listening_history ADD COLUMN IF NOT EXISTS source VARCHAR(20) NOT NULL DEFAULT 'tracking';
+
+UPDATE listening_history SET source = 'import' WHERE source = 'tracking' AND reason_start IS NOT NULL;
+UPDATE listening_history SET source = 'manual' WHERE source = 'tracking' AND context_type = 'manual';
+
+-- Scrobbles do Last.fm entravam como import; diferente do export do Spotify, não trazem platform nem reason_start
+UPDATE listening_history SET source = 'scrobble'
+WHERE source = 'import' AND COALESCE(platform, '') = '' AND COALESCE(reason_start, '') = '';
+
+-- Consentimento para aparecer no GET /tracking/now-playing/group de outros usuários
+ALTER TABLE user_preferences ADD COLUMN IF NOT EXISTS share_now_playing BOOLEAN;
+
+-- Gêneros corrigidos pelo usuário (PUT /artists/:id/genres)
+CREATE TABLE IF NOT EXISTS artist_genre_overrides (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    artist_id VARCHAR(255) NOT NULL REFERENCES artists(id) ON DELETE CASCADE,
+    genres TEXT[] NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, artist_id)
+);
+
+-- Sincronização incremental (GET /user/history/since) pela transação que mudou a escuta (created_at
+-- não segue a ordem de commit); remoções e restaurações também contam como mudança
+ALTER TABLE listening_history ADD COLUMN IF NOT EXISTS change_xid XID8 NOT NULL DEFAULT pg_current_xact_id();
+CREATE INDEX IF NOT EXISTS idx_listening_history_user_change_id ON listening_history(user_id, change_xid, id);
+
+CREATE OR REPLACE FUNCTION touch_listening_history_change()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.deleted_at IS DISTINCT FROM OLD.deleted_at THEN
+        NEW.change_xid = pg_current_xact_id();
+    END IF;
+    RETURN NEW;
+END;
+$$ language 'plpgsql';
+
+DROP TRIGGER IF EXISTS touch_listening_history_change ON listening_history;
+CREATE TRIGGER touch_listening_history_change BEFORE UPDATE ON listening_history
+    FOR EACH ROW EXECUTE FUNCTION touch_listening_history_change();
+
+-- Artistas e gêneros que o usuário tirou dos analytics (GET/PUT /user/exclusions)
+CREATE TABLE IF NOT EXISTS user_exclusions (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    artist_ids TEXT[] NOT NULL DEFAULT '{}',
+    genres TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Backfill único do recently-played (POST /tracking/backfill)
+ALTER TABLE users ADD COLUMN IF NOT EXISTS history_backfilled_at TIMESTAMP;
+
+-- Scopes concedidos no login; tokens antigos ficam NULL e precisam de novo consentimento para criar playlists
+ALTER TABLE spotify_tokens ADD COLUMN IF NOT EXISTS scope TEXT;
+
+-- Audio features do Spotify por faixa (etapa do POST /user/enrich); tempo NULL = o Spotify não tem features
+CREATE TABLE IF NOT EXISTS track_audio_features (
+    track_id VARCHAR(255) PRIMARY KEY REFERENCES tracks(id) ON DELETE CASCADE,
+    tempo REAL,
+    energy REAL,
+    danceability REAL,
+    valence REAL,
+    fetched_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Flag explicit das faixas (GET /user/explicit-ratio); NULL até o tracking ou o POST /user/enrich preencher
+ALTER TABLE tracks ADD COLUMN IF NOT EXISTS explicit BOOLEAN;
+
+-- Agregados das escutas por usuário (GET /user/stats/summary), atualizados a cada escuta gravada ou removida.
+-- Sem linha em user_listening_totals o usuário ainda não foi reconstruído e os incrementos são ignorados
+CREATE TABLE IF NOT EXISTS user_listening_totals (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    total_plays BIGINT NOT NULL DEFAULT 0,
+    total_listened_ms BIGINT NOT NULL DEFAULT 0,
+    rebuilt_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS user_genre_plays (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    genre TEXT NOT NULL,
+    plays BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id, genre)
+);
+
+-- weekly_report (opt-in do relatório semanal) volta para bancos em que a coluna chegou a ser removida
+ALTER TABLE user_preferences ADD COLUMN IF NOT EXISTS weekly_report BOOLEAN;
+
+-- Escutas por origem nos agregados (GET /user/stats/summary). Os agregados passam a ignorar escutas
+-- excluídas: na migração que cria a tabela (só nela) os totais são descartados e a próxima leitura do
+-- resumo de cada usuário reconstrói tudo. Rodar o migrate de novo não joga fora os agregados
+DO $$
+BEGIN
+    IF to_regclass('user_source_plays') IS NULL THEN
+        CREATE TABLE user_source_plays (
+            user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+            source VARCHAR(20) NOT NULL,
+            plays BIGINT NOT NULL DEFAULT 0,
+            PRIMARY KEY (user_id, source)
+        );
+        DELETE FROM user_listening_totals;
+    END IF;
+END
+$$;
+
+-- Import: busca de faixas sem URI do Spotify pelo nome normalizado e pelo ISRC sem varrer as tabelas
+CREATE INDEX IF NOT EXISTS idx_tracks_normalized_name ON tracks ((LOWER(BTRIM(REGEXP_REPLACE(name, '\s+', ' ', 'g')))));
+CREATE INDEX IF NOT EXISTS idx_artists_normalized_name ON artists ((LOWER(BTRIM(REGEXP_REPLACE(name, '\s+', ' ', 'g')))));
+CREATE INDEX IF NOT EXISTS idx_tracks_isrc_upper ON tracks (UPPER(isrc));
+
+-- Enriquecimento: artistas que o Spotify não resolve esperam cada vez mais antes de uma nova busca
+ALTER TABLE artists ADD COLUMN IF NOT EXISTS enrich_attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE artists ADD COLUMN IF NOT EXISTS enrich_attempted_at TIMESTAMP;
@@ -10,6 +10,8 @@ CREATE TABLE users (
     country VARCHAR(10),
     followers_count INTEGER DEFAULT 0,
     profile_image_url TEXT,
+    feed_token_hash VARCHAR(64) UNIQUE, -- sha256 do token do feed .ics (somente leitura)
+    history_backfilled_at TIMESTAMP, -- backfill único do recently-played (POST /tracking/backfill)
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
@@ -17,10 +19,11 @@ CREATE TABLE users (
 -- Tabela de tokens do Spotify
 CREATE TABLE spotify_tokens (
     id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
-    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    user_id UUID UNIQUE REFERENCES users(id) ON DELETE CASCADE, -- um token ativo por usuário
     access_token TEXT NOT NULL,
     refresh_token TEXT,
     expires_at TIMESTAMP,
+    scope TEXT, -- scopes concedidos no último login, separados por espaço
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
@@ -32,6 +35,8 @@ CREATE TABLE artists (
     genres TEXT[], -- Array de gêneros
     popularity INTEGER,
     image_url TEXT,
+    enrich_attempts INTEGER NOT NULL DEFAULT 0, -- buscas no enriquecimento que não trouxeram gêneros nem imagem
+    enrich_attempted_at TIMESTAMP,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
@@ -41,6 +46,7 @@ CREATE TABLE albums (
     name VARCHAR(255) NOT NULL,
     release_date DATE,
     image_url TEXT,
+    total_tracks INTEGER, -- número de faixas informado pelo Spotify
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
@@ -53,6 +59,7 @@ CREATE TABLE tracks (
     popularity INTEGER,
     preview_url TEXT,
     isrc VARCHAR(50),
+    explicit BOOLEAN, -- NULL enquanto a faixa não vem do Spotify (import sem enriquecimento)
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
@@ -73,6 +80,13 @@ CREATE TABLE listening_history (
     listening_percentage DECIMAL(5,2) DEFAULT 0, -- calculado quando disponível
     context_type VARCHAR(50), -- playlist, album, artist, etc.
     context_uri VARCHAR(255),
+    device_type VARCHAR(50), -- Computer, Smartphone, Speaker, etc. (tracking ao vivo)
+    shuffle BOOLEAN DEFAULT FALSE,
+    repeat_state VARCHAR(10), -- off, track, context
+    spotify_account VARCHAR(255), -- conta vinculada que gravou a escuta; NULL na conta principal
+    source VARCHAR(20) NOT NULL DEFAULT 'tracking', -- tracking, import, manual ou scrobble (Last.fm); só tracking é apagado no resync
+    deleted_at TIMESTAMP, -- soft delete: escutas removidas pelo usuário ficam fora dos analytics
+    change_xid XID8 NOT NULL DEFAULT pg_current_xact_id(), -- transação que gravou, removeu ou restaurou a escuta (sincronização incremental)
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
 
@@ -83,6 +97,7 @@ CREATE TABLE user_analytics (
     time_range VARCHAR(20) NOT NULL, -- short_term, medium_term, long_term
     total_listening_time_ms BIGINT DEFAULT 0,
     diversity_score DECIMAL(5,2) DEFAULT 0,
+    mainstream_score DECIMAL(5,2), -- gravado pelo recálculo administrativo
     top_genres JSONB,
     listening_patterns JSONB,
     monthly_stats JSONB,
@@ -95,8 +110,128 @@ CREATE INDEX idx_users_spotify_id ON users(spotify_id);
 CREATE INDEX idx_spotify_tokens_user_id ON spotify_tokens(user_id);
 CREATE INDEX idx_listening_history_user_id ON listening_history(user_id);
 CREATE INDEX idx_listening_history_played_at ON listening_history(played_at);
+CREATE INDEX idx_listening_history_user_played_id ON listening_history(user_id, played_at DESC, id DESC); -- paginação por cursor
+CREATE INDEX idx_listening_history_user_change_id ON listening_history(user_id, change_xid, id); -- sincronização incremental
+-- Casamento de escutas sem URI do Spotify no import pelo nome normalizado (findTrackByName)
+CREATE INDEX idx_tracks_normalized_name ON tracks ((LOWER(BTRIM(REGEXP_REPLACE(name, '\s+', ' ', 'g')))));
+CREATE INDEX idx_artists_normalized_name ON artists ((LOWER(BTRIM(REGEXP_REPLACE(name, '\s+', ' ', 'g')))));
+CREATE INDEX idx_tracks_isrc_upper ON tracks (UPPER(isrc));
+CREATE UNIQUE INDEX idx_listening_history_unique_play ON listening_history(user_id, track_id, played_at); -- dedupe entre import, sync e tracking ao vivo
 CREATE INDEX idx_user_analytics_user_id ON user_analytics(user_id);
 
+-- Cache dos analytics completos pré-calculados (job noturno / recálculo sob demanda)
+CREATE TABLE user_analytics_cache (
+    user_id UUID REFERENCES users(id) ON DELETE CASCADE,
+    time_filter VARCHAR(20) NOT NULL,
+    payload JSONB NOT NULL,
+    computed_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, time_filter)
+);
+
+-- Maiores contagens já notificadas por usuário, para disparar o webhook de marcos só ao cruzar um limite
+CREATE TABLE user_milestone_state (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    plays BIGINT NOT NULL DEFAULT 0,
+    tracks BIGINT NOT NULL DEFAULT 0,
+    artists BIGINT NOT NULL DEFAULT 0,
+    minutes BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Tags de sessões de escuta (ex.: workout, estudo). session_id é a primeira escuta da sessão; as escutas
+-- que entram nos analytics filtrados pela tag são calculadas na leitura a partir dela
+CREATE TABLE session_tags (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    session_id UUID NOT NULL REFERENCES listening_history(id) ON DELETE CASCADE,
+    tag VARCHAR(32) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (user_id, session_id, tag)
+);
+
+CREATE INDEX idx_session_tags_user_tag ON session_tags(user_id, tag);
+
+-- Preferências do usuário; NULL em um campo usa o padrão do backend
+CREATE TABLE user_preferences (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    timezone VARCHAR(64),
+    auto_start_tracking BOOLEAN, -- NULL segue AUTO_START_TRACKING
+    weekly_report BOOLEAN, -- opt-in do relatório semanal; NULL = não
+    default_time_filter VARCHAR(20),
+    units VARCHAR(20),
+    share_now_playing BOOLEAN, -- aparece no now-playing em grupo de outros usuários; NULL = não
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Contas do Spotify extras vinculadas a um usuário (escutas gravadas no mesmo user_id)
+CREATE TABLE linked_spotify_accounts (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    spotify_id VARCHAR(255) UNIQUE NOT NULL,
+    display_name VARCHAR(255),
+    access_token TEXT NOT NULL,
+    refresh_token TEXT,
+    expires_at TIMESTAMP,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_linked_spotify_accounts_user_id ON linked_spotify_accounts(user_id);
+
+-- Gêneros corrigidos pelo usuário; nos analytics dele valem no lugar de artists.genres
+CREATE TABLE artist_genre_overrides (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    artist_id VARCHAR(255) NOT NULL REFERENCES artists(id) ON DELETE CASCADE,
+    genres TEXT[] NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, artist_id)
+);
+
+-- Artistas e gêneros que o usuário tirou dos analytics (GET/PUT /user/exclusions)
+CREATE TABLE user_exclusions (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    artist_ids TEXT[] NOT NULL DEFAULT '{}',
+    genres TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Audio features do Spotify por faixa (etapa do POST /user/enrich); tempo NULL = o Spotify não tem features
+CREATE TABLE track_audio_features (
+    track_id VARCHAR(255) PRIMARY KEY REFERENCES tracks(id) ON DELETE CASCADE,
+    tempo REAL,
+    energy REAL,
+    danceability REAL,
+    valence REAL,
+    fetched_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Agregados das escutas por usuário (GET /user/stats/summary), atualizados a cada escuta gravada ou removida.
+-- Sem linha em user_listening_totals o usuário ainda não foi reconstruído e os incrementos são ignorados
+CREATE TABLE user_listening_totals (
+    user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+    total_plays BIGINT NOT NULL DEFAULT 0,
+    total_listened_ms BIGINT NOT NULL DEFAULT 0,
+    rebuilt_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE user_genre_plays (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    genre TEXT NOT NULL,
+    plays BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id, genre)
+);
+
+CREATE TABLE user_source_plays (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    source VARCHAR(20) NOT NULL,
+    plays BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (user_id, source)
+);
+
 -- Função para atualizar updated_at automaticamente
 CREATE OR REPLACE FUNCTION update_updated_at_column()
 RETURNS TRIGGER AS $$
@@ -107,6 +242,20 @@ END;
 $$ language 'plpgsql';
 
 -- Triggers para atualizar updated_at
+-- Remover ou restaurar uma escuta conta como mudança para a sincronização incremental
+CREATE OR REPLACE FUNCTION touch_listening_history_change()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF NEW.deleted_at IS DISTINCT FROM OLD.deleted_at THEN
+        NEW.change_xid = pg_current_xact_id();
+    END IF;
+    RETURN NEW;
+END;
+$$ language 'plpgsql';
+
+CREATE TRIGGER touch_listening_history_change BEFORE UPDATE ON listening_history
+    FOR EACH ROW EXECUTE FUNCTION touch_listening_history_change();
+
 CREATE TRIGGER update_users_updated_at BEFORE UPDATE ON users
     FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
 